@@ -19,11 +19,12 @@ import (
 
 const (
 	ConfigFile = "gocoin.conf"
-	Version    = uint32(70015)
-	Services   = uint64(0x00000009)
+	Version    = uint32(70016) // BIP339 (wtxidrelay)
 )
 
 var (
+	Services uint64 = 0x00000009 // NODE_NETWORK | NODE_WITNESS (NODE_COMPACT_FILTERS and NODE_P2P_V2 added in host_init)
+
 	LogBuffer             = new(bytes.Buffer)
 	Log       *log.Logger = log.New(LogBuffer, "", 0)
 
@@ -49,33 +49,53 @@ var (
 			ServerMode  bool
 		}
 		RPC struct {
-			Enabled  bool
-			Username string
-			Password string
-			TCPPort  uint32
+			Enabled   bool
+			Username  string // leave it (or Password) empty to only allow .cookie and Auth based access
+			Password  string
+			TCPPort   uint32
+			Auth      []string // "user:salt$hash" - hashed passwords, as created by tools/rpcauth.go
+			Whitelist []string // "user:method1,method2,..." - methods allowed for the user (all if none)
 		}
 		Net struct {
 			ListenTCP      bool
 			TCPPort        uint16
 			BindToIF       string
 			MaxOutCons     uint32
+			BlockRelayCons uint32 // additional outgoing connections, for blocks only (no txs nor addrs)
+			Feelers        bool   // make short-lived connections to check the peers from the database
 			MaxInCons      uint32
 			MaxUpKBps      uint
 			MaxDownKBps    uint
 			MaxBlockAtOnce uint32
 			MinSegwitCons  uint32
 			ExternalIP     string
+			BlockFilters   bool // build BIP158 filters index (needs -r to cover the existing chain) and serve them (BIP157)
+			Proxy          string // SOCKS5 proxy "host:port" for all outgoing connections (e.g. Tor's "127.0.0.1:9050")
+			ProxyRandomize bool   // use random proxy credentials for each connection (Tor stream isolation)
+			OnlyOnion      bool   // connect only to onion peers (needs Proxy)
+			TorControl     string // Tor control port "host:port" to publish the node as an onion service (empty to disable)
+			TorPassword    string // password for Tor control port (if empty, cookie authentication is used)
+			V2Transport    bool   // BIP324 encrypted P2P transport (advertised with NODE_P2P_V2 service bit)
+		}
+		Notify struct {
+			Listen string // "host:port" for TCP, or "unix:/path/to/socket" (empty to disable)
 		}
 		TXPool struct {
-			Enabled        bool // Global on/off swicth
-			AllowMemInputs bool
-			FeePerByte     float64
-			MaxTxSize      uint32
-			MaxSizeMB      uint
-			MaxRejectMB    uint
-			MaxRejectCnt   uint
-			SaveOnDisk     bool
-			Debug          bool
+			Enabled          bool // Global on/off swicth
+			AllowMemInputs   bool
+			FeePerByte       float64
+			MaxTxSize        uint32
+			MaxSizeMB        uint
+			MaxRejectMB      uint
+			MaxRejectCnt     uint
+			SaveOnDisk       bool
+			Debug            bool
+			SendMempool      bool   // send BIP35 "mempool" to special (friends) peers
+			FullRBF          bool   // allow to replace txs that do not signal RBF (BIP125)
+			MaxAncestors     uint32 // max number of unconfirmed ancestors of a tx (itself included)
+			MaxAncestorsKB   uint32 // max virtual size of a tx with its unconfirmed ancestors
+			MaxDescendants   uint32 // max number of descendants of a mempool tx (itself included)
+			MaxDescendantsKB uint32 // max virtual size of a mempool tx with its descendants
 		}
 		TXRoute struct {
 			Enabled    bool // Global on/off swicth
@@ -131,10 +151,14 @@ func InitConfig() {
 	// Fill in default values
 	CFG.Net.ListenTCP = true
 	CFG.Net.MaxOutCons = 9
+	CFG.Net.BlockRelayCons = 2
+	CFG.Net.Feelers = true
 	CFG.Net.MaxInCons = 10
 	CFG.Net.MaxBlockAtOnce = 3
 	CFG.Net.MinSegwitCons = 4
 	CFG.Net.BindToIF = "0.0.0.0"
+	CFG.Net.ProxyRandomize = true
+	CFG.Net.V2Transport = true
 
 	CFG.TextUI_Enabled = true
 
@@ -145,9 +169,6 @@ func InitConfig() {
 	CFG.WebUI.Title = "Gocoin"
 	CFG.WebUI.PayCmdName = "pay_cmd.txt"
 
-	CFG.RPC.Username = "gocoinrpc"
-	CFG.RPC.Password = "gocoinpwd"
-
 	CFG.TXPool.Enabled = true
 	CFG.TXPool.AllowMemInputs = true
 	CFG.TXPool.FeePerByte = 1.0
@@ -156,6 +177,11 @@ func InitConfig() {
 	CFG.TXPool.MaxRejectMB = 25
 	CFG.TXPool.MaxRejectCnt = 5000
 	CFG.TXPool.SaveOnDisk = true
+	CFG.TXPool.SendMempool = true
+	CFG.TXPool.MaxAncestors = 25
+	CFG.TXPool.MaxAncestorsKB = 101
+	CFG.TXPool.MaxDescendants = 25
+	CFG.TXPool.MaxDescendantsKB = 101
 
 	CFG.TXRoute.Enabled = true
 	CFG.TXRoute.FeePerByte = 0.0
@@ -486,6 +512,12 @@ func SetMinFeePerKB(val uint64) bool {
 	return true
 }
 
+// MinMinFeePerKB returns the configured minimum fee, that does not grow with the mempool size.
+// It is also used as the incremental relay fee (BIP125).
+func MinMinFeePerKB() uint64 {
+	return atomic.LoadUint64(&minminFeePerKB)
+}
+
 func RouteMinFeePerKB() uint64 {
 	return atomic.LoadUint64(&routeMinFeePerKB)
 }
@@ -9,6 +9,7 @@ import (
 	"github.com/piotrnar/gocoin/lib/btc"
 	"github.com/piotrnar/gocoin/lib/chain"
 	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/client/network"
 	"github.com/piotrnar/gocoin/lib/others/sys"
 )
 
@@ -72,7 +73,8 @@ func host_init() {
 	ext := &chain.NewChanOpts{
 		UTXOVolatileMode : common.FLAG.VolatileUTXO,
 		UndoBlocks : common.FLAG.UndoBlocks,
-		BlockMinedCB : blockMined, DoNotRescan : true}
+		BlockMinedCB : blockMined, BlockUndoneCB : blockUndone, DoNotRescan : true,
+		CompactFilters : common.CFG.Net.BlockFilters}
 
 	sta := time.Now()
 	common.BlockChain = chain.NewChainExt(common.GocoinHomeDir, common.GenesisBlock, common.FLAG.Rescan, ext,
@@ -88,6 +90,19 @@ func host_init() {
 		os.Exit(1)
 	}
 
+	if common.BlockChain.Filters != nil {
+		if common.BlockChain.FiltersSynced() {
+			common.Services |= network.SERVICE_COMPACT_FILTERS
+		} else {
+			fmt.Println("Compact filters index is at block", common.BlockChain.Filters.Count()-1,
+				"- restart the node with -r to build it for the entire chain")
+		}
+	}
+
+	if common.CFG.Net.V2Transport {
+		common.Services |= network.SERVICE_P2P_V2
+	}
+
 	if lb, _ := common.BlockChain.BlockTreeRoot.FindFarthestNode(); lb.Height > common.BlockChain.LastBlock().Height {
 		common.Last.ParseTill = lb
 	}
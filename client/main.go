@@ -5,6 +5,7 @@ import (
 	"github.com/piotrnar/gocoin"
 	"github.com/piotrnar/gocoin/client/common"
 	"github.com/piotrnar/gocoin/client/network"
+	"github.com/piotrnar/gocoin/client/notify"
 	"github.com/piotrnar/gocoin/client/rpcapi"
 	"github.com/piotrnar/gocoin/client/usif"
 	"github.com/piotrnar/gocoin/client/usif/textui"
@@ -52,6 +53,11 @@ func blockMined(bl *btc.Block) {
 	if int(bl.LastKnownHeight)-int(bl.Height) < 144 { // do not run it when syncing chain
 		usif.ProcessBlockFees(bl.Height, bl)
 	}
+	notify.BlockConnected(bl)
+}
+
+func blockUndone(bl *btc.Block) {
+	notify.BlockDisconnected(bl)
 }
 
 func LocalAcceptBlock(newbl *network.BlockRcvd) (e error) {
@@ -396,7 +402,16 @@ func main() {
 		peersdb.Testnet = common.Testnet
 		peersdb.ConnectOnly = common.CFG.ConnectOnly
 		peersdb.Services = common.Services
+		peersdb.UseOnion = common.CFG.Net.Proxy != ""
+		peersdb.OnlyOnion = common.CFG.Net.OnlyOnion
+		peersdb.NoDnsSeeds = common.CFG.Net.Proxy != ""
 		peersdb.InitPeers(common.GocoinHomeDir)
+		if common.CFG.Net.OnlyOnion && common.CFG.Net.Proxy == "" {
+			println("WARNING: Net.OnlyOnion needs Net.Proxy - no outgoing connections will be made")
+		}
+		if common.CFG.Net.TorControl != "" {
+			network.StartOnionService()
+		}
 		if common.FLAG.UnbanAllPeers {
 			var keys []qdb.KeyType
 			var vals [][]byte
@@ -411,7 +426,7 @@ func main() {
 				return 0
 			})
 			for i := range keys {
-				peersdb.PeerDB.Put(keys[i], vals[i])
+				peersdb.NewPeer(vals[i]).Save() // puts it back to the address manager
 			}
 
 			fmt.Println(len(keys), "peers un-baned")
@@ -430,6 +445,7 @@ func main() {
 			network.LastCommitedHeader = common.Last.Block
 		}
 
+		network.FeeEstLoad()
 		if common.CFG.TXPool.SaveOnDisk {
 			network.MempoolLoad2()
 		}
@@ -447,6 +463,14 @@ func main() {
 			go rpcapi.StartServer(common.RPCPort())
 		}
 
+		if common.CFG.Notify.Listen != "" {
+			if e := notify.Start(common.CFG.Notify.Listen); e != nil {
+				println("ERROR: Cannot start notifications:", e.Error())
+			} else {
+				fmt.Println("Notifications published at", common.CFG.Notify.Listen)
+			}
+		}
+
 		usif.LoadBlockFees()
 
 		wallet.FetchingBalanceTick = func() bool {
@@ -604,6 +628,7 @@ func main() {
 		common.BlockChain.Unspent.HurryUp()
 		wallet.UpdateMapSizes()
 		network.NetCloseAll()
+		notify.Stop()
 	}
 
 	sta := time.Now()
@@ -614,6 +639,8 @@ func main() {
 	fmt.Println("Blockchain closed in", time.Now().Sub(sta).String())
 	peersdb.ClosePeerDB()
 	usif.SaveBlockFees()
+	network.FeeEstSave()
+	rpcapi.RemoveCookie()
 	sys.UnlockDatabaseDir()
 	os.RemoveAll(common.TempBlocksDir())
 }
@@ -7,7 +7,6 @@ import (
 	"github.com/piotrnar/gocoin/client/common"
 	"github.com/piotrnar/gocoin/lib/btc"
 	"github.com/piotrnar/gocoin/lib/others/peersdb"
-	"github.com/piotrnar/gocoin/lib/others/qdb"
 	"github.com/piotrnar/gocoin/lib/others/sys"
 	"sort"
 	"sync"
@@ -101,8 +100,11 @@ func BestExternalAddr() []byte {
 	return res
 }
 
+// SendAddr sends the best peers from our database.
+// Peers that sent us "sendaddrv2" get "addrv2" message, which includes TorV3, I2P and CJDNS addresses.
 func (c *OneConnection) SendAddr() {
-	pers := peersdb.GetBestPeers(MaxAddrsPerMessage, nil)
+	addrv2 := c.Node.SendAddrV2
+	pers := peersdb.GetAddrPeers(MaxAddrsPerMessage, addrv2)
 	maxtime := uint32(time.Now().Unix() + 3600)
 	if len(pers) > 0 {
 		buf := new(bytes.Buffer)
@@ -113,19 +115,49 @@ func (c *OneConnection) SendAddr() {
 				pers[i].Time = maxtime - 7200
 			}
 			binary.Write(buf, binary.LittleEndian, pers[i].Time)
-			buf.Write(pers[i].NetAddr.Bytes())
+			if addrv2 {
+				buf.Write(pers[i].NetAddr.AddrV2Bytes())
+			} else {
+				buf.Write(pers[i].NetAddr.Bytes())
+			}
+		}
+		if addrv2 {
+			c.SendRawMsg("addrv2", buf.Bytes())
+		} else {
+			c.SendRawMsg("addr", buf.Bytes())
 		}
-		c.SendRawMsg("addr", buf.Bytes())
 	}
 }
 
+// SendOwnAddr advertises our onion service (to addrv2 peers) and our external IP.
+// The IP is not advertised if we use a proxy.
 func (c *OneConnection) SendOwnAddr() {
-	if ExternalAddrLen() > 0 {
+	var cnt uint64
+	addrs := new(bytes.Buffer)
+	now := uint32(time.Now().Unix())
+	if na := OnionAddr(); na != nil && c.Node.SendAddrV2 {
+		binary.Write(addrs, binary.LittleEndian, now)
+		addrs.Write(na.AddrV2Bytes())
+		cnt++
+	}
+	if ExternalAddrLen() > 0 && !UsingProxy() {
+		binary.Write(addrs, binary.LittleEndian, now)
+		if c.Node.SendAddrV2 {
+			addrs.Write(btc.NewNetAddr(BestExternalAddr()).AddrV2Bytes())
+		} else {
+			addrs.Write(BestExternalAddr())
+		}
+		cnt++
+	}
+	if cnt > 0 {
 		buf := new(bytes.Buffer)
-		btc.WriteVlen(buf, uint64(1))
-		binary.Write(buf, binary.LittleEndian, uint32(time.Now().Unix()))
-		buf.Write(BestExternalAddr())
-		c.SendRawMsg("addr", buf.Bytes())
+		btc.WriteVlen(buf, cnt)
+		buf.Write(addrs.Bytes())
+		if c.Node.SendAddrV2 {
+			c.SendRawMsg("addrv2", buf.Bytes())
+		} else {
+			c.SendRawMsg("addr", buf.Bytes())
+		}
 	}
 }
 
@@ -142,32 +174,64 @@ func (c *OneConnection) ParseAddr(pl []byte) {
 			//println("ParseAddr:", n, e)
 			break
 		}
-		a := peersdb.NewPeer(buf[:])
-		if !sys.ValidIp4(a.Ip4[:]) {
-			common.CountSafe("AddrInvalid")
-			/*if c.Misbehave("AddrLocal", 1) {
-				break
-			}*/
-			//print(c.PeerAddr.Ip(), " ", c.Node.Agent, " ", c.Node.Version, " addr local ", a.String(), "\n> ")
-		} else if time.Unix(int64(a.Time), 0).Before(time.Now().Add(time.Hour)) {
-			if time.Now().Before(time.Unix(int64(a.Time), 0).Add(peersdb.ExpirePeerAfter)) {
-				k := qdb.KeyType(a.UniqID())
-				v := peersdb.PeerDB.Get(k)
-				if v != nil {
-					a.Banned = peersdb.NewPeer(v[:]).Banned
+		if c.store_addr(peersdb.NewPeer(buf[:])) {
+			break
+		}
+	}
+}
+
+// ParseAddrV2 parses the network's "addrv2" message (BIP155).
+func (c *OneConnection) ParseAddrV2(pl []byte) {
+	b := bytes.NewReader(pl)
+	cnt, _ := btc.ReadVLen(b)
+	for i := 0; i < int(cnt); i++ {
+		var tim uint32
+		e := binary.Read(b, binary.LittleEndian, &tim)
+		if e == nil {
+			var na *btc.NetAddr
+			if na, e = btc.ReadNetAddrV2(b); e == nil {
+				if na == nil {
+					common.CountSafe("AddrV2Unknown")
+					continue
 				}
-				a.Time = uint32(time.Now().Add(-5 * time.Minute).Unix()) // add new peers as not just alive
-				if a.Time > uint32(time.Now().Unix()) {
-					println("wtf", a.Time, time.Now().Unix())
+				a := peersdb.NewEmptyPeer()
+				a.NetAddr = *na
+				a.Time = tim
+				if c.store_addr(a) {
+					break
 				}
-				peersdb.PeerDB.Put(k, a.Bytes())
-			} else {
-				common.CountSafe("AddrStale")
+				continue
 			}
-		} else {
-			if c.Misbehave("AddrFuture", 50) {
-				break
+		}
+		common.CountSafe("AddrV2Error")
+		c.DoS("AddrV2Error")
+		break
+	}
+}
+
+// store_addr puts the address received from the peer in the address manager.
+// It returns true if the peer has been banned and the parsing should stop.
+func (c *OneConnection) store_addr(a *peersdb.PeerAddr) bool {
+	if a.NetID == 0 && !sys.ValidIp4(a.Ip4[:]) {
+		common.CountSafe("AddrInvalid")
+		/*if c.Misbehave("AddrLocal", 1) {
+			break
+		}*/
+		//print(c.PeerAddr.Ip(), " ", c.Node.Agent, " ", c.Node.Version, " addr local ", a.String(), "\n> ")
+	} else if time.Unix(int64(a.Time), 0).Before(time.Now().Add(time.Hour)) {
+		if time.Now().Before(time.Unix(int64(a.Time), 0).Add(peersdb.ExpirePeerAfter)) {
+			a.Time = uint32(time.Now().Add(-5 * time.Minute).Unix()) // add new peers as not just alive
+			if !peersdb.AddAddr(a, &c.PeerAddr.NetAddr) {
+				common.CountSafe("AddrNoRoom")
+			}
+			if a.NetID != 0 {
+				common.CountSafe(fmt.Sprint("AddrV2Net", a.NetID))
 			}
+		} else {
+			common.CountSafe("AddrStale")
 		}
+	} else {
+		return c.Misbehave("AddrFuture", 50)
 	}
+	return false
 }
@@ -318,7 +318,7 @@ func (c *OneConnection) ProcessCmpctBlock(pl []byte) {
 		}
 		var hash2take *btc.Uint256
 		if c.Node.SendCmpctVer == 2 {
-			hash2take = v.WTxID()
+			hash2take = v.Tx.WTxID()
 		} else {
 			hash2take = &v.Hash
 		}
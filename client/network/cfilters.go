@@ -0,0 +1,137 @@
+package network
+
+import (
+	"bytes"
+	"encoding/binary"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/lib/btc"
+	"github.com/piotrnar/gocoin/lib/chain"
+)
+
+// BIP157 - serving compact block filters to light clients
+
+const (
+	SERVICE_COMPACT_FILTERS = 0x40
+
+	MAX_GETCFILTERS_SIZE  = 1000
+	MAX_GETCFHEADERS_SIZE = 2000
+	CFCHECKPT_INTERVAL    = 1000
+)
+
+// cfilter_stop_node returns the active chain's block with the given hash, if its filter is in the index.
+func cfilter_stop_node(hash []byte) (node *chain.BlockTreeNode) {
+	common.BlockChain.BlockIndexAccess.Lock()
+	node = common.BlockChain.BlockIndex[btc.NewUint256(hash).BIdx()]
+	common.BlockChain.BlockIndexAccess.Unlock()
+	if node == nil || node.Height >= common.BlockChain.Filters.Count() || !common.BlockChain.OnActiveBranch(node) {
+		return nil
+	}
+	return
+}
+
+// parse_getcfilters parses getcfilters and getcfheaders messages.
+// It returns nil if the request should be ignored.
+func (c *OneConnection) parse_getcfilters(pl []byte, max_size uint32) (start uint32, stop *chain.BlockTreeNode) {
+	if len(pl) != 37 || pl[0] != btc.BASIC_FILTER_TYPE {
+		c.DoS("BadGetCFilter")
+		return
+	}
+	if common.BlockChain.Filters == nil {
+		common.CountSafe("GetCFilterOff")
+		return
+	}
+	start = binary.LittleEndian.Uint32(pl[1:5])
+	if stop = cfilter_stop_node(pl[5:37]); stop == nil {
+		common.CountSafe("GetCFilterUnkn")
+		return
+	}
+	if start > stop.Height || stop.Height-start >= max_size {
+		c.DoS("BadGetCFilterRange")
+		stop = nil
+	}
+	return
+}
+
+func (c *OneConnection) ProcessGetCFilters(pl []byte) {
+	start, stop := c.parse_getcfilters(pl, MAX_GETCFILTERS_SIZE)
+	if stop == nil {
+		return
+	}
+	for h := start; h <= stop.Height; h++ {
+		bhash, filter, e := common.BlockChain.Filters.GetFilter(h)
+		if e != nil {
+			println("ProcessGetCFilters:", e.Error())
+			return
+		}
+		out := new(bytes.Buffer)
+		out.WriteByte(btc.BASIC_FILTER_TYPE)
+		out.Write(bhash[:])
+		btc.WriteVlen(out, uint64(len(filter)))
+		out.Write(filter)
+		c.SendRawMsg("cfilter", out.Bytes())
+	}
+	common.CountSafe("SentCFilters")
+}
+
+func (c *OneConnection) ProcessGetCFHeaders(pl []byte) {
+	var prev_header [32]byte
+	var e error
+
+	start, stop := c.parse_getcfilters(pl, MAX_GETCFHEADERS_SIZE)
+	if stop == nil {
+		return
+	}
+	if start > 0 {
+		if _, _, prev_header, e = common.BlockChain.Filters.GetHeader(start - 1); e != nil {
+			println("ProcessGetCFHeaders:", e.Error())
+			return
+		}
+	}
+	out := new(bytes.Buffer)
+	out.WriteByte(btc.BASIC_FILTER_TYPE)
+	out.Write(stop.BlockHash.Hash[:])
+	out.Write(prev_header[:])
+	btc.WriteVlen(out, uint64(stop.Height-start+1))
+	for h := start; h <= stop.Height; h++ {
+		_, fhash, _, e := common.BlockChain.Filters.GetHeader(h)
+		if e != nil {
+			println("ProcessGetCFHeaders:", e.Error())
+			return
+		}
+		out.Write(fhash[:])
+	}
+	c.SendRawMsg("cfheaders", out.Bytes())
+	common.CountSafe("SentCFHeaders")
+}
+
+func (c *OneConnection) ProcessGetCFCheckpt(pl []byte) {
+	if len(pl) != 33 || pl[0] != btc.BASIC_FILTER_TYPE {
+		c.DoS("BadGetCFCheckpt")
+		return
+	}
+	if common.BlockChain.Filters == nil {
+		common.CountSafe("GetCFilterOff")
+		return
+	}
+	stop := cfilter_stop_node(pl[1:33])
+	if stop == nil {
+		common.CountSafe("GetCFilterUnkn")
+		return
+	}
+	cnt := stop.Height / CFCHECKPT_INTERVAL
+	out := new(bytes.Buffer)
+	out.WriteByte(btc.BASIC_FILTER_TYPE)
+	out.Write(stop.BlockHash.Hash[:])
+	btc.WriteVlen(out, uint64(cnt))
+	for i := uint32(1); i <= cnt; i++ {
+		_, _, hdr, e := common.BlockChain.Filters.GetHeader(i * CFCHECKPT_INTERVAL)
+		if e != nil {
+			println("ProcessGetCFCheckpt:", e.Error())
+			return
+		}
+		out.Write(hdr[:])
+	}
+	c.SendRawMsg("cfcheckpt", out.Bytes())
+	common.CountSafe("SentCFCheckpt")
+}
@@ -13,8 +13,10 @@ import (
 	"encoding/hex"
 	"encoding/binary"
 	"github.com/piotrnar/gocoin/lib/btc"
+	"github.com/piotrnar/gocoin/lib/chain"
 	"github.com/piotrnar/gocoin/client/common"
 	"github.com/piotrnar/gocoin/lib/others/peersdb"
+	"github.com/piotrnar/gocoin/lib/others/bip324"
 )
 
 
@@ -45,7 +47,9 @@ const (
 
 	MAX_INV_HISTORY = 500
 
+	SERVICE_BLOOM = 0x4 // BIP111 - needed by Core to serve "mempool"
 	SERVICE_SEGWIT = 0x8
+	SERVICE_P2P_V2 = 0x800 // BIP324
 
 	TxsCounterPeriod = 6*time.Second // how long for one tick
 	TxsCounterBufLen = 60 // how many ticks
@@ -55,6 +59,11 @@ const (
 	InvsFlushPeriod = 10*time.Millisecond // send all the pending invs to the peer not more often than this
 
 	MAX_GETMP_TXS = 1e6
+
+	MempoolReqInterval = 10*time.Minute // serve BIP35 "mempool" to one peer not more often than this
+
+	FeelerInterval = 2*time.Minute // make a feeler connection not more often than this
+	FeelerRetryAfter = time.Hour // do not check the same peer again within this time
 )
 
 
@@ -62,6 +71,7 @@ var (
 	Mutex_net sync.Mutex
 	OpenCons map[uint64]*OneConnection = make(map[uint64]*OneConnection)
 	InConsActive, OutConsActive uint32
+	BlockRelayConsActive, FeelerConsActive uint32 // not included in OutConsActive
 	LastConnId uint32
 	nonce [8]byte
 
@@ -85,6 +95,8 @@ type NetworkNodeStruct struct {
 	DoNotRelayTxs bool
 	ReportedIp4 uint32
 	SendHeaders bool
+	SendAddrV2 bool // BIP155
+	WTxIDRelay bool // BIP339
 	Nonce [8]byte
 
 	// BIP152:
@@ -109,6 +121,7 @@ type ConnectionStatus struct {
 	GetHeadersSentAtPingCnt uint64
 	LastHeadersHeightAsk uint32
 	GetBlocksDataNow bool
+	PresyncHeight uint32 // height of the headers presync (zero if not in progress)
 
 	LastSent time.Time
 	MaxSentBufSize int
@@ -124,6 +137,7 @@ type ConnectionStatus struct {
 	MinFeeSPKB int64  // BIP 133
 
 	TxsReceived int // During last hour
+	LastTxRcvd time.Time // when the peer sent us a new tx that we accepted
 
 	IsSpecial bool // Special connections get more debgs and are not being automatically dropped
 	IsGocoin bool
@@ -137,6 +151,18 @@ type ConnectionStatus struct {
 
 	PingSentCnt uint64
 	BlocksExpired uint64
+
+	// Block download statistics (see dlwindow.go)
+	BlocksDelivered uint32
+	BlockLatency time.Duration // moving average of the time between getdata and the block
+	BlockThroughput uint64 // moving average in bytes per second
+	BlockStalls uint32
+	LastBlockStall time.Time
+
+	V2Transport bool // BIP324 encrypted connection
+
+	BlockRelayOnly bool // outgoing connection for blocks only (no txs nor addrs)
+	IsFeeler bool // short-lived connection to check if the peer is alive
 }
 
 type ConnInfo struct {
@@ -173,6 +199,7 @@ type OneConnection struct {
 	misbehave int // When it reaches 1000, ban it
 
 	net.Conn
+	v2 *bip324.Cipher // set for BIP324 connections, after the handshake
 
 	// TCP connection data:
 	X ConnectionStatus
@@ -225,6 +252,12 @@ type OneConnection struct {
 	writing_thread_push chan bool
 
 	GetMP chan bool
+
+	// BIP35:
+	lastMempoolReq time.Time // when the peer asked us for "mempool" last time
+	mempoolReqSent bool // we have sent "mempool" to this peer
+
+	hdrsync *chain.HeadersSync // anti-DoS headers presync from this peer (protected by MutexRcv)
 }
 
 type BIDX [btc.Uint256IdxLen]byte
@@ -332,11 +365,18 @@ func (c *OneConnection) SendRawMsg(cmd string, pl []byte) (e error) {
 	}*/
 
 	if !c.broken {
+		var v2pkt []byte
+		msg_size := len(pl) + 24
+		if c.v2 != nil {
+			v2pkt = c.v2.Encrypt(nil, bip324.EncodeMsg(cmd, pl), nil, false)
+			msg_size = len(v2pkt)
+		}
+
 		// we never allow the buffer to be totally full because then producer would be equal consumer
-		if bytes_left := SendBufSize - c.BytesToSent(); bytes_left <= len(pl) + 24 {
+		if bytes_left := SendBufSize - c.BytesToSent(); bytes_left <= msg_size {
 			c.Mutex.Unlock()
 			println(c.PeerAddr.Ip(), c.Node.Version, c.Node.Agent, "Peer Send Buffer Overflow @",
-				cmd, bytes_left, len(pl)+24, c.SendBufProd, c.SendBufCons, c.BytesToSent())
+				cmd, bytes_left, msg_size, c.SendBufProd, c.SendBufCons, c.BytesToSent())
 			c.Disconnect("SendBufferOverflow")
 			common.CountSafe("PeerSendOverflow")
 			return errors.New("Send buffer overflow")
@@ -352,16 +392,20 @@ func (c *OneConnection) SendRawMsg(cmd string, pl []byte) (e error) {
 		c.X.LastCmdSent = cmd
 		c.X.LastBtsSent = uint32(len(pl))
 
-		binary.LittleEndian.PutUint32(sbuf[0:4], common.Version)
-		copy(sbuf[0:4], common.Magic[:])
-		copy(sbuf[4:16], cmd)
-		binary.LittleEndian.PutUint32(sbuf[16:20], uint32(len(pl)))
+		if v2pkt != nil {
+			c.append_to_send_buffer(v2pkt)
+		} else {
+			binary.LittleEndian.PutUint32(sbuf[0:4], common.Version)
+			copy(sbuf[0:4], common.Magic[:])
+			copy(sbuf[4:16], cmd)
+			binary.LittleEndian.PutUint32(sbuf[16:20], uint32(len(pl)))
 
-		sh := btc.Sha2Sum(pl[:])
-		copy(sbuf[20:24], sh[:4])
+			sh := btc.Sha2Sum(pl[:])
+			copy(sbuf[20:24], sh[:4])
 
-		c.append_to_send_buffer(sbuf[:])
-		c.append_to_send_buffer(pl)
+			c.append_to_send_buffer(sbuf[:])
+			c.append_to_send_buffer(pl)
+		}
 
 		if x:=c.BytesToSent(); x>c.X.MaxSentBufSize {
 			c.X.MaxSentBufSize = x
@@ -460,6 +504,10 @@ func (c *OneConnection) FetchMessage() (ret *BCmsg, timeout_or_data bool) {
 	var e error
 	var n int
 
+	if c.v2 != nil {
+		return c.fetch_v2_message()
+	}
+
 	for c.recv.hdr_len < 24 {
 		n, e = common.SockRead(c.Conn, c.recv.hdr[c.recv.hdr_len:24])
 		if n < 0 {
@@ -558,9 +606,16 @@ func (c *OneConnection) FetchMessage() (ret *BCmsg, timeout_or_data bool) {
 		return
 	}
 
+	ret = c.message_received(c.recv.cmd, c.recv.dat)
+	return
+}
+
+
+// message_received resets the reception state machine and updates the stats.
+func (c *OneConnection) message_received(cmd string, pl []byte) (ret *BCmsg) {
 	ret = new(BCmsg)
-	ret.cmd = c.recv.cmd
-	ret.pl = c.recv.dat
+	ret.cmd = cmd
+	ret.pl = pl
 
 	c.Mutex.Lock()
 	c.recv.hdr_len = 0
@@ -653,6 +708,7 @@ func maxmsgsize(cmd string) uint32 {
 		case "inv": return 9+50000*36 // the spec says "max 50000 entries"
 		case "tx": return 500e3 // max segwit tx size 500KB
 		case "addr": return 9+1000*30 // max 1000 addrs
+		case "addrv2": return 9+1000*(4+9+1+3+btc.MAX_ADDRV2_SIZE+2) // max 1000 addrs
 		case "block": return 4e6 // max segwit block size 4MB
 		case "getblocks": return 4+9+101*32+32 // MAX_LOCATOR_SZ = 101
 		case "getdata": return 9+50000*36 // core: MAX_INV_SZ = 50000
@@ -99,6 +99,17 @@ func (c *OneConnection) ProcessGetData(pl []byte) {
 				TxMutex.Unlock()
 				//notfound = append(notfound, h[:]...)
 			}
+		} else if typ == MSG_WTX {
+			common.CountSafe("GetdataWTx")
+			TxMutex.Lock()
+			if tx, ok := WTxIDs[btc.NewUint256(h[4:]).BIdx()]; ok && tx.Blocked==0 {
+				tx.SentCnt++
+				tx.Lastsent = time.Now()
+				TxMutex.Unlock()
+				c.SendRawMsg("tx", tx.Raw)
+			} else {
+				TxMutex.Unlock()
+			}
 		} else if typ == MSG_CMPCT_BLOCK {
 			common.CountSafe("GetdataCmpctBlk")
 			if !c.SendCmpctBlk(btc.NewUint256(h[4:])) {
@@ -217,6 +228,7 @@ func netBlockReceived(conn *OneConnection, b []byte) {
 		delete(conn.GetBlockInProgress, idx)
 		conn.counters["NewBlock"]++
 		orb.TxMissing = -1
+		conn.update_dl_stats(conn.LastMsgTime.Sub(bip.start), len(b))
 	}
 	conn.blocksreceived = append(conn.blocksreceived, time.Now())
 	conn.Mutex.Unlock()
@@ -312,9 +324,10 @@ func (c *OneConnection) GetBlockData() (yes bool) {
 		return
 	}
 	cbip := len(c.GetBlockInProgress)
+	max_cbip := c.max_blocks_in_progress()
 	c.Mutex.Unlock()
 
-	if cbip >= MAX_PEERS_BLOCKS_IN_PROGRESS {
+	if cbip >= max_cbip {
 		c.IncCnt("FetchMaxCountInProgress", 1)
 		// wake up in a few seconds, maybe some blocks will complete by then
 		c.nextGetData = time.Now().Add(1*time.Second)
@@ -344,12 +357,7 @@ func (c *OneConnection) GetBlockData() (yes bool) {
 	// We can issue getdata for this peer
 	// Let's look for the lowest height block in BlocksToGet that isn't being downloaded yet
 
-	common.Last.Mutex.Lock()
-	max_height := common.Last.Block.Height + uint32(MAX_BLOCKS_FORWARD_SIZ/avg_block_size)
-	if max_height > common.Last.Block.Height + MAX_BLOCKS_FORWARD_CNT {
-		max_height = common.Last.Block.Height + MAX_BLOCKS_FORWARD_CNT
-	}
-	common.Last.Mutex.Unlock()
+	_, max_height := DownloadWindow()
 	if max_height > c.Node.Height {
 		max_height = c.Node.Height
 	}
@@ -382,7 +390,7 @@ func (c *OneConnection) GetBlockData() (yes bool) {
 					v := BlocksToGet[idx]
 					if v.InProgress==cnt_in_progress && (lowest_found==nil || v.Block.Height < lowest_found.Block.Height) {
 							c.Mutex.Lock()
-							if _, ok := c.GetBlockInProgress[idx]; !ok {
+							if _, ok := c.GetBlockInProgress[idx]; !ok && !c.skip_block(bh) {
 								lowest_found = v
 							}
 							c.Mutex.Unlock()
@@ -410,8 +418,8 @@ func (c *OneConnection) GetBlockData() (yes bool) {
 		cbip = len(c.GetBlockInProgress)
 		c.Mutex.Unlock()
 
-		if cbip>=MAX_PEERS_BLOCKS_IN_PROGRESS {
-			break  // no more than 2000 blocks in progress / peer
+		if cbip>=max_cbip {
+			break  // no more than 500 blocks in progress / peer
 		}
 		block_data_in_progress += avg_block_size
 		if block_data_in_progress > MAX_GETDATA_FORWARD {
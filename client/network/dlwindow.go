@@ -0,0 +1,154 @@
+package network
+
+import (
+	"time"
+
+	"github.com/piotrnar/gocoin/client/common"
+)
+
+/*
+Blocks are downloaded within a moving window, that starts just above our last block.
+If the lowest block of the window does not arrive for BlockStallTimeout, while some peer
+has it in progress, that peer is stalling the download: all its blocks in progress are
+given back to be fetched from other peers. The timeout doubles at each stall and slowly
+goes back down when the window moves. Peers stalling MaxBlockStalls times get disconnected
+(unless they are special, then they are only asked for less blocks at once).
+The stalls of a peer that has not stalled for BlockStallForgive are forgotten.
+*/
+
+const (
+	BlockStallTimeoutMin = 2 * time.Second
+	BlockStallTimeoutMax = 64 * time.Second
+	MaxBlockStalls       = 3
+	BlockStallForgive    = 10 * time.Minute
+)
+
+var (
+	DlWindowLow       uint32    // the lowest height of the blocks still to be fetched
+	DlWindowMoved     time.Time // when DlWindowLow changed last time
+	BlockStallTimeout = BlockStallTimeoutMin
+)
+
+// DownloadWindow returns the range of block heights that we can fetch now.
+func DownloadWindow() (start, end uint32) {
+	avg_block_size := common.AverageBlockSize.Get()
+	common.Last.Mutex.Lock()
+	start = common.Last.Block.Height + 1
+	end = common.Last.Block.Height + uint32(MAX_BLOCKS_FORWARD_SIZ/avg_block_size)
+	if end > common.Last.Block.Height+MAX_BLOCKS_FORWARD_CNT {
+		end = common.Last.Block.Height + MAX_BLOCKS_FORWARD_CNT
+	}
+	common.Last.Mutex.Unlock()
+	return
+}
+
+// update_dl_stats records a block received from the peer, in response to our getdata.
+// Call it with c.Mutex locked.
+func (c *OneConnection) update_dl_stats(latency time.Duration, size int) {
+	var bps uint64
+	if latency > 0 {
+		bps = uint64(float64(size) / latency.Seconds())
+	}
+	if c.X.BlocksDelivered == 0 {
+		c.X.BlockLatency = latency
+		c.X.BlockThroughput = bps
+	} else {
+		c.X.BlockLatency = (7*c.X.BlockLatency + latency) / 8
+		c.X.BlockThroughput = (7*c.X.BlockThroughput + bps) / 8
+	}
+	c.X.BlocksDelivered++
+}
+
+// skip_block tells if the peer should not be asked for the block at the given height.
+// The peer that stalled the download recently does not get the lowest block of the window.
+// Call it with MutexRcv and c.Mutex locked.
+func (c *OneConnection) skip_block(height uint32) bool {
+	return height == DlWindowLow && c.X.BlockStalls > 0 && time.Since(c.X.LastBlockStall) < BlockStallTimeout
+}
+
+// max_blocks_in_progress returns how many blocks we can fetch from the peer at once.
+// Less from the peers that have stalled. Call it with c.Mutex locked.
+func (c *OneConnection) max_blocks_in_progress() int {
+	stalls := c.X.BlockStalls
+	if stalls > MaxBlockStalls {
+		stalls = MaxBlockStalls
+	}
+	return MAX_PEERS_BLOCKS_IN_PROGRESS >> stalls
+}
+
+// check_block_stall looks for the peer that stalls the download window. Call it once a second.
+func check_block_stall(now time.Time) {
+	Mutex_net.Lock()
+	conns := make([]*OneConnection, 0, len(OpenCons))
+	for _, c := range OpenCons {
+		conns = append(conns, c)
+	}
+	Mutex_net.Unlock()
+
+	var disconnect []*OneConnection
+	MutexRcv.Lock()
+	if LowestIndexToBlocksToGet != DlWindowLow {
+		DlWindowLow = LowestIndexToBlocksToGet
+		DlWindowMoved = now
+		if BlockStallTimeout = BlockStallTimeout * 85 / 100; BlockStallTimeout < BlockStallTimeoutMin {
+			BlockStallTimeout = BlockStallTimeoutMin
+		}
+		for _, c := range conns {
+			c.Mutex.Lock()
+			if c.X.BlockStalls > 0 && now.Sub(c.X.LastBlockStall) >= BlockStallForgive {
+				c.X.BlockStalls = 0
+			}
+			c.Mutex.Unlock()
+		}
+		MutexRcv.Unlock()
+		return
+	}
+	if DlWindowLow == 0 || now.Sub(DlWindowMoved) < BlockStallTimeout {
+		MutexRcv.Unlock()
+		return
+	}
+
+	var stalled bool
+	for _, idx := range IndexToBlocksToGet[DlWindowLow] {
+		for _, c := range conns {
+			c.Mutex.Lock()
+			if bip := c.GetBlockInProgress[idx]; bip != nil && bip.col == nil && now.Sub(bip.start) >= BlockStallTimeout {
+				// give all its blocks back to be fetched from other peers
+				for k, v := range c.GetBlockInProgress {
+					if v.col != nil {
+						continue
+					}
+					if b2g, ok := BlocksToGet[k]; ok && b2g.InProgress > 0 {
+						b2g.InProgress--
+					}
+					delete(c.GetBlockInProgress, k)
+				}
+				c.X.BlockStalls++
+				c.X.LastBlockStall = now
+				c.counters["BlockStall"]++
+				if c.X.BlockStalls >= MaxBlockStalls && !c.X.IsSpecial {
+					disconnect = append(disconnect, c)
+				}
+				stalled = true
+			}
+			c.Mutex.Unlock()
+		}
+	}
+	if stalled {
+		common.CountSafe("BlockStall")
+		DlWindowMoved = now // give the other peers some time
+		if BlockStallTimeout *= 2; BlockStallTimeout > BlockStallTimeoutMax {
+			BlockStallTimeout = BlockStallTimeoutMax
+		}
+	}
+	MutexRcv.Unlock()
+
+	if stalled {
+		for _, c := range conns {
+			c.MutexSetBool(&c.X.GetBlocksDataNow, true)
+		}
+	}
+	for _, c := range disconnect {
+		c.Disconnect("BlockStall")
+	}
+}
@@ -0,0 +1,164 @@
+package network
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"sort"
+	"time"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/lib/others/peersdb"
+	"github.com/piotrnar/gocoin/lib/others/utils"
+)
+
+/*
+When all the incoming slots are taken, a new incoming connection can still get in,
+by evicting the most expendable of the current incoming peers (like Bitcoin Core does).
+An attacker would need to beat the honest peers at each of the protected categories:
+ - EvictProtectNetGroup peers from distinct netgroups (chosen by a secret key)
+ - EvictProtectPing peers with the lowest ping
+ - EvictProtectTxs peers that sent us new transactions most recently
+ - EvictProtectBlocks peers that sent us new blocks most recently
+ - half of the remaining ones, that have been connected for the longest time
+Special and authorized peers are never evicted. From the peers that are left,
+we evict the youngest one from the netgroup that has the most connections.
+*/
+
+const (
+	EvictProtectNetGroup = 4
+	EvictProtectPing     = 8
+	EvictProtectTxs      = 4
+	EvictProtectBlocks   = 4
+)
+
+var evict_key [32]byte
+
+func init() {
+	rand.Read(evict_key[:])
+}
+
+type evict_candidate struct {
+	conn        *OneConnection
+	connected   time.Time
+	ping        int
+	last_tx     time.Time
+	last_block  time.Time
+	group       [utils.SOURCE_GROUP_LEN]byte
+	group_order []byte // keyed hash of the netgroup
+}
+
+// protect_peers removes up to cnt candidates that are best, according to the less function.
+func protect_peers(list []*evict_candidate, cnt int, less func(a, b *evict_candidate) bool,
+	skip func(e *evict_candidate) bool) []*evict_candidate {
+	sort.SliceStable(list, func(i, j int) bool { return less(list[i], list[j]) })
+	res := list[:0]
+	for _, e := range list {
+		if cnt > 0 && (skip == nil || !skip(e)) {
+			cnt--
+			continue
+		}
+		res = append(res, e)
+	}
+	return res
+}
+
+// select_peer_to_evict returns the incoming connection that we can drop,
+// in order to make room for a new one (or nil if all of them are protected).
+func select_peer_to_evict() *OneConnection {
+	var list []*evict_candidate
+
+	Mutex_net.Lock()
+	for _, c := range OpenCons {
+		if !c.X.Incomming {
+			continue
+		}
+		c.Mutex.Lock()
+		if !c.broken && !c.X.IsSpecial && !c.X.Authorized {
+			e := &evict_candidate{conn: c, connected: c.X.ConnectedAt, ping: c.GetAveragePing(),
+				last_tx: c.X.LastTxRcvd, group: peersdb.Group(&c.PeerAddr.NetAddr)}
+			if len(c.blocksreceived) > 0 {
+				e.last_block = c.blocksreceived[len(c.blocksreceived)-1]
+			}
+			sha := sha256.New()
+			sha.Write(evict_key[:])
+			sha.Write(e.group[:])
+			e.group_order = sha.Sum(nil)
+			list = append(list, e)
+		}
+		c.Mutex.Unlock()
+	}
+	Mutex_net.Unlock()
+
+	// peers from distinct netgroups
+	groups := make(map[[utils.SOURCE_GROUP_LEN]byte]bool)
+	list = protect_peers(list, EvictProtectNetGroup, func(a, b *evict_candidate) bool {
+		return string(a.group_order) > string(b.group_order)
+	}, func(e *evict_candidate) bool {
+		if groups[e.group] {
+			return true
+		}
+		groups[e.group] = true
+		return false
+	})
+
+	// peers with the lowest ping (zero means that we do not know it yet)
+	list = protect_peers(list, EvictProtectPing, func(a, b *evict_candidate) bool {
+		return a.ping != 0 && (b.ping == 0 || a.ping < b.ping)
+	}, func(e *evict_candidate) bool { return e.ping == 0 })
+
+	// peers that relayed new transactions and blocks most recently
+	list = protect_peers(list, EvictProtectTxs, func(a, b *evict_candidate) bool {
+		return a.last_tx.After(b.last_tx)
+	}, func(e *evict_candidate) bool { return e.last_tx.IsZero() })
+	list = protect_peers(list, EvictProtectBlocks, func(a, b *evict_candidate) bool {
+		return a.last_block.After(b.last_block)
+	}, func(e *evict_candidate) bool { return e.last_block.IsZero() })
+
+	// half of the rest, that have been connected for the longest
+	list = protect_peers(list, len(list)/2, func(a, b *evict_candidate) bool {
+		return a.connected.Before(b.connected)
+	}, nil)
+
+	if len(list) == 0 {
+		return nil
+	}
+
+	// find the netgroup with the most connections (the youngest connection breaks a tie)
+	type group_rec struct {
+		cnt      int
+		youngest *evict_candidate
+	}
+	var worst *group_rec
+	group_cnt := make(map[[utils.SOURCE_GROUP_LEN]byte]*group_rec)
+	for _, e := range list {
+		g := group_cnt[e.group]
+		if g == nil {
+			g = new(group_rec)
+			group_cnt[e.group] = g
+		}
+		g.cnt++
+		if g.youngest == nil || e.connected.After(g.youngest.connected) {
+			g.youngest = e
+		}
+	}
+	for _, g := range group_cnt {
+		if worst == nil || g.cnt > worst.cnt ||
+			g.cnt == worst.cnt && g.youngest.connected.After(worst.youngest.connected) {
+			worst = g
+		}
+	}
+	return worst.youngest.conn
+}
+
+// evict_incoming drops one of the incoming connections, to make room for a new one.
+// Returns false if all the incoming peers are protected.
+func evict_incoming() bool {
+	c := select_peer_to_evict()
+	if c == nil {
+		common.CountSafe("InConnNoEvict")
+		return false
+	}
+	common.CountSafe("InConnEvicted")
+	c.Disconnect("Evicted")
+	return true
+}
@@ -0,0 +1,310 @@
+package network
+
+import (
+	"bufio"
+	"encoding/gob"
+	"math"
+	"os"
+	"sync"
+
+	"github.com/piotrnar/gocoin/client/common"
+)
+
+/*
+Fee estimator, based on how long the mempool txs needed to get confirmed.
+For each tx accepted into the mempool we remember the block height at which it came.
+When the tx gets mined, we record (in the bucket of its fee rate) after how many blocks it happened.
+When it leaves the mempool unmined (replaced, evicted, expired), we count it as a failure.
+The stats decay with each new block, so the recent blocks matter the most.
+The estimate for N blocks is the lowest fee rate range, where at least X% of the txs
+got confirmed within N blocks (like Bitcoin Core's CBlockPolicyEstimator does).
+*/
+
+const (
+	FEE_EST_FILE_NAME   = "feeest.gob"
+	FEE_EST_VERSION     = 1
+	FEE_EST_MAX_TARGET  = 1008     // the longest confirmation target (in blocks)
+	FEE_EST_MIN_SPKB    = 100      // the lowest fee rate bucket
+	FEE_EST_MAX_SPKB    = 10000000 // the highest fee rate bucket
+	FEE_EST_BUCKET_STEP = 1.1      // each bucket's fee rate is this much higher than the previous one
+	FEE_EST_DECAY       = 0.998    // applied to the stats with each new block
+	FEE_EST_SUFFICIENT  = 0.1      // min number of confirmed txs per block (before decay) in a range
+)
+
+type fee_est_stats struct {
+	Version int
+	Height  uint32      // the last block that the stats have been updated with
+	Buckets []float64   // the lower bound of each bucket's fee rate (SPKB)
+	TxCnt   []float64   // [bucket] - confirmed txs
+	FeeSum  []float64   // [bucket] - sum of the fee rates of the confirmed txs
+	Conf    [][]float64 // [blocks-1][bucket] - txs confirmed after so many blocks
+	Fail    [][]float64 // [blocks-1][bucket] - txs that left the mempool unconfirmed after so many blocks
+}
+
+var (
+	FeeEstMutex sync.Mutex
+	fee_est     *fee_est_stats   = new_fee_est_stats()
+	fee_est_mem map[uint32][]int = make(map[uint32][]int) // entry height -> [bucket] of txs being tracked
+)
+
+func new_fee_est_stats() (st *fee_est_stats) {
+	st = &fee_est_stats{Version: FEE_EST_VERSION}
+	for fr := float64(FEE_EST_MIN_SPKB); fr <= FEE_EST_MAX_SPKB; fr *= FEE_EST_BUCKET_STEP {
+		st.Buckets = append(st.Buckets, fr)
+	}
+	st.TxCnt = make([]float64, len(st.Buckets))
+	st.FeeSum = make([]float64, len(st.Buckets))
+	st.Conf = make([][]float64, FEE_EST_MAX_TARGET)
+	st.Fail = make([][]float64, FEE_EST_MAX_TARGET)
+	for i := range st.Conf {
+		st.Conf[i] = make([]float64, len(st.Buckets))
+		st.Fail[i] = make([]float64, len(st.Buckets))
+	}
+	return
+}
+
+// fee_bucket returns index of the bucket for the given fee rate.
+func (st *fee_est_stats) fee_bucket(spkb float64) (b int) {
+	if spkb <= FEE_EST_MIN_SPKB {
+		return 0
+	}
+	b = int(math.Log(spkb/FEE_EST_MIN_SPKB) / math.Log(FEE_EST_BUCKET_STEP))
+	if b >= len(st.Buckets) {
+		b = len(st.Buckets) - 1
+	}
+	return
+}
+
+func (t2s *OneTxToSend) fee_est_spkb() float64 {
+	return 1000 * float64(t2s.Fee) / float64(t2s.VSize())
+}
+
+// fee_est_track starts tracking a tx that has just been accepted into the mempool.
+// We only do it if the estimator is up to date with the chain.
+// Make sure to call it with locked TxMutex.
+func (t2s *OneTxToSend) fee_est_track() {
+	height := common.Last.BlockHeight()
+	FeeEstMutex.Lock()
+	if fee_est.Height == height {
+		b := fee_est.fee_bucket(t2s.fee_est_spkb())
+		cnts := fee_est_mem[height]
+		if cnts == nil {
+			cnts = make([]int, len(fee_est.Buckets))
+			fee_est_mem[height] = cnts
+		}
+		cnts[b]++
+		t2s.EntryHeight = height
+	}
+	FeeEstMutex.Unlock()
+}
+
+// fee_est_untrack stops tracking the tx that is leaving the mempool.
+// If it was mined in a block that the estimator has been updated with, mined_height should be its height.
+// Txs removed for other reasons (mined == false) are recorded as failures.
+// Make sure to call it with locked TxMutex.
+func (t2s *OneTxToSend) fee_est_untrack(mined bool, mined_height uint32) {
+	if t2s.EntryHeight == 0 {
+		return
+	}
+	FeeEstMutex.Lock()
+	spkb := t2s.fee_est_spkb()
+	b := fee_est.fee_bucket(spkb)
+	if cnts := fee_est_mem[t2s.EntryHeight]; cnts != nil && cnts[b] > 0 {
+		cnts[b]--
+		empty := true
+		for _, c := range cnts {
+			if c != 0 {
+				empty = false
+				break
+			}
+		}
+		if empty {
+			delete(fee_est_mem, t2s.EntryHeight)
+		}
+	}
+	if mined {
+		if mined_height > t2s.EntryHeight {
+			blocks := mined_height - t2s.EntryHeight
+			if blocks > FEE_EST_MAX_TARGET {
+				blocks = FEE_EST_MAX_TARGET
+			}
+			fee_est.Conf[blocks-1][b]++
+			fee_est.TxCnt[b]++
+			fee_est.FeeSum[b] += spkb
+		}
+	} else if fee_est.Height > t2s.EntryHeight {
+		blocks := fee_est.Height - t2s.EntryHeight
+		if blocks > FEE_EST_MAX_TARGET {
+			blocks = FEE_EST_MAX_TARGET
+		}
+		fee_est.Fail[blocks-1][b]++
+	}
+	FeeEstMutex.Unlock()
+	t2s.EntryHeight = 0
+}
+
+// fee_est_block updates the estimator with a new block (before its txs are removed from the mempool).
+// It returns false if the block cannot be used (we are syncing or it is a re-org).
+func fee_est_block(height uint32, synced bool) bool {
+	FeeEstMutex.Lock()
+	defer FeeEstMutex.Unlock()
+	if !synced || height <= fee_est.Height {
+		return false
+	}
+	for b := range fee_est.Buckets {
+		fee_est.TxCnt[b] *= FEE_EST_DECAY
+		fee_est.FeeSum[b] *= FEE_EST_DECAY
+	}
+	for i := range fee_est.Conf {
+		for b := range fee_est.Buckets {
+			fee_est.Conf[i][b] *= FEE_EST_DECAY
+			fee_est.Fail[i][b] *= FEE_EST_DECAY
+		}
+	}
+	fee_est.Height = height
+	return true
+}
+
+// FeeEstRange describes the range of fee rate buckets that an estimate was based on.
+type FeeEstRange struct {
+	StartRange, EndRange uint64  // fee rates in SPKB
+	WithinTarget         float64 // txs confirmed within the target
+	TotalConfirmed       float64 // txs confirmed at all
+	InMempool            float64 // txs still in the mempool for at least the target
+	LeftMempool          float64 // txs that left the mempool unconfirmed, after at least the target
+	fee_sum              float64
+}
+
+func (r *FeeEstRange) success() float64 {
+	return r.WithinTarget / (r.TotalConfirmed + r.InMempool + r.LeftMempool)
+}
+
+// FeeEstimate is the result of EstimateFee.
+type FeeEstimate struct {
+	FeeSPKB   uint64 // zero if we do not have enough data
+	Target    int    // in blocks
+	Threshold float64
+	Decay     float64
+	Pass      *FeeEstRange // the lowest fee rate range that met the threshold
+	Fail      *FeeEstRange // the highest fee rate range that did not meet it
+}
+
+// EstimateFee returns the fee rate needed for a tx to get confirmed within the target number of blocks,
+// with the given probability (0.0 - 1.0).
+func EstimateFee(target int, threshold float64) (res *FeeEstimate) {
+	if target < 1 {
+		target = 1
+	} else if target > FEE_EST_MAX_TARGET {
+		target = FEE_EST_MAX_TARGET
+	}
+	res = &FeeEstimate{Target: target, Threshold: threshold, Decay: FEE_EST_DECAY}
+
+	FeeEstMutex.Lock()
+	defer FeeEstMutex.Unlock()
+
+	sufficient := FEE_EST_SUFFICIENT / (1 - FEE_EST_DECAY)
+	cur := new(FeeEstRange)
+	// go from the highest fee rates down, till a range of buckets fails to meet the threshold
+	for b := len(fee_est.Buckets) - 1; b >= 0; b-- {
+		if cur.EndRange == 0 {
+			if b == len(fee_est.Buckets)-1 {
+				cur.EndRange = FEE_EST_MAX_SPKB
+			} else {
+				cur.EndRange = uint64(fee_est.Buckets[b+1])
+			}
+		}
+		cur.StartRange = uint64(fee_est.Buckets[b])
+		for i := 0; i < target; i++ {
+			cur.WithinTarget += fee_est.Conf[i][b]
+		}
+		for i := target - 1; i < FEE_EST_MAX_TARGET; i++ {
+			cur.LeftMempool += fee_est.Fail[i][b]
+		}
+		cur.TotalConfirmed += fee_est.TxCnt[b]
+		cur.fee_sum += fee_est.FeeSum[b]
+		for h, cnts := range fee_est_mem {
+			if fee_est.Height >= h+uint32(target) {
+				cur.InMempool += float64(cnts[b])
+			}
+		}
+		if cur.TotalConfirmed < sufficient {
+			continue
+		}
+		if cur.success() < threshold {
+			res.Fail = cur
+			break
+		}
+		res.Pass = cur
+		cur = new(FeeEstRange)
+	}
+	if res.Fail == nil && cur.EndRange != 0 && cur.TotalConfirmed+cur.InMempool+cur.LeftMempool > 0 {
+		res.Fail = cur // what was left at the bottom
+	}
+	if res.Pass != nil {
+		res.FeeSPKB = uint64(res.Pass.fee_sum / res.Pass.TotalConfirmed)
+	}
+	return
+}
+
+// FeeEstStats returns the last block that the estimator was updated with and the number of the txs being tracked.
+func FeeEstStats() (height uint32, tracked int) {
+	FeeEstMutex.Lock()
+	height = fee_est.Height
+	for _, cnts := range fee_est_mem {
+		for _, c := range cnts {
+			tracked += c
+		}
+	}
+	FeeEstMutex.Unlock()
+	return
+}
+
+// FeeEstSave stores the estimator's stats on disk.
+func FeeEstSave() {
+	f, er := os.Create(common.GocoinHomeDir + FEE_EST_FILE_NAME)
+	if er != nil {
+		println("FeeEstSave:", er.Error())
+		return
+	}
+	buf := bufio.NewWriter(f)
+	FeeEstMutex.Lock()
+	er = gob.NewEncoder(buf).Encode(fee_est)
+	FeeEstMutex.Unlock()
+	if er != nil {
+		println("FeeEstSave:", er.Error())
+	}
+	buf.Flush()
+	f.Close()
+}
+
+// FeeEstLoad restores the estimator's stats from disk.
+func FeeEstLoad() {
+	f, er := os.Open(common.GocoinHomeDir + FEE_EST_FILE_NAME)
+	if er != nil {
+		println("FeeEstLoad:", er.Error())
+		return
+	}
+	defer f.Close()
+
+	st := new(fee_est_stats)
+	if er = gob.NewDecoder(bufio.NewReader(f)).Decode(st); er != nil {
+		println("FeeEstLoad:", er.Error())
+		return
+	}
+	empty := new_fee_est_stats()
+	if st.Version != FEE_EST_VERSION || len(st.Buckets) != len(empty.Buckets) || len(st.TxCnt) != len(empty.Buckets) ||
+		len(st.FeeSum) != len(empty.Buckets) || len(st.Conf) != FEE_EST_MAX_TARGET || len(st.Fail) != FEE_EST_MAX_TARGET {
+		println("FeeEstLoad:", FEE_EST_FILE_NAME, "has incompatible format - ignored")
+		return
+	}
+	for i := range st.Conf {
+		if len(st.Conf[i]) != len(empty.Buckets) || len(st.Fail[i]) != len(empty.Buckets) {
+			println("FeeEstLoad:", FEE_EST_FILE_NAME, "is corrupt - ignored")
+			return
+		}
+	}
+	FeeEstMutex.Lock()
+	fee_est = st
+	fee_est_mem = make(map[uint32][]int)
+	FeeEstMutex.Unlock()
+}
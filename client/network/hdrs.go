@@ -15,6 +15,8 @@ const (
 	PH_STATUS_OLD   = 3
 	PH_STATUS_ERROR = 4
 	PH_STATUS_FATAL = 5
+
+	MaxHeadersCnt = 2000 // max number of headers in one message
 )
 
 func (c *OneConnection) ProcessNewHeader(hdr []byte) (int, *OneBlockToGet) {
@@ -94,7 +96,7 @@ func (c *OneConnection) HandleHeaders(pl []byte) (new_headers_got int) {
 		return
 	}
 
-	if cnt > 2000 {
+	if cnt > MaxHeadersCnt {
 		println("HandleHeaders: too many headers", cnt, c.PeerAddr.Ip(), c.Node.Agent)
 		c.DoS("HdrErrX")
 		return
@@ -102,14 +104,17 @@ func (c *OneConnection) HandleHeaders(pl []byte) (new_headers_got int) {
 
 	HeadersReceived.Add(1)
 
+	var presync bool
+
 	if cnt > 0 {
 		MutexRcv.Lock()
 		defer MutexRcv.Unlock()
 
-		for i := 0; i < int(cnt); i++ {
-			hdr := make([]byte, 80)
+		hdrs := make([][]byte, cnt)
+		for i := range hdrs {
+			hdrs[i] = make([]byte, 80)
 
-			if n, _ := b.Read(hdr); n != 80 {
+			if n, _ := b.Read(hdrs[i]); n != 80 {
 				println("HandleHeaders: pl too short 1", c.PeerAddr.Ip(), c.Node.Agent)
 				c.DoS("HdrErr1")
 				return
@@ -120,7 +125,13 @@ func (c *OneConnection) HandleHeaders(pl []byte) (new_headers_got int) {
 				c.DoS("HdrErr2")
 				return
 			}
+		}
 
+		if hdrs, presync = c.presyncHeaders(hdrs, cnt == MaxHeadersCnt); c.IsBroken() {
+			return
+		}
+
+		for _, hdr := range hdrs {
 			sta, b2g := c.ProcessNewHeader(hdr)
 			if b2g == nil {
 				if sta == PH_STATUS_FATAL {
@@ -160,7 +171,11 @@ func (c *OneConnection) HandleHeaders(pl []byte) (new_headers_got int) {
 	c.Mutex.Lock()
 	c.X.LastHeadersEmpty = highest_block_found <= c.X.LastHeadersHeightAsk
 	c.X.TotalNewHeadersCount += new_headers_got
-	if new_headers_got == 0 {
+	if presync {
+		// keep asking this peer for more headers
+		c.X.LastHeadersEmpty = false
+		c.X.AllHeadersReceived = false
+	} else if new_headers_got == 0 {
 		c.X.AllHeadersReceived = true
 	}
 	c.Mutex.Unlock()
@@ -168,6 +183,83 @@ func (c *OneConnection) HandleHeaders(pl []byte) (new_headers_got int) {
 	return
 }
 
+// presyncHeaders passes the headers through the anti-DoS headers presync, if they need it.
+// It returns the headers that should be accepted into the block tree and if the presync is in progress.
+// Call it with MutexRcv locked.
+func (c *OneConnection) presyncHeaders(hdrs [][]byte, full bool) (res [][]byte, in_progress bool) {
+	common.BlockChain.BlockIndexAccess.Lock()
+	defer common.BlockChain.BlockIndexAccess.Unlock()
+
+	hs := c.hdrsync
+	if hs == nil {
+		// find the first header that we do not know yet
+		var start *chain.BlockTreeNode
+		var idx int
+		for idx = range hdrs {
+			if nd := common.BlockChain.BlockIndex[btc.NewSha2Hash(hdrs[idx]).BIdx()]; nd == nil {
+				break
+			} else if idx == len(hdrs)-1 {
+				return hdrs, false // all known
+			} else {
+				start = nd
+			}
+		}
+		if start == nil {
+			if start = common.BlockChain.BlockIndex[btc.NewUint256(hdrs[0][4:36]).BIdx()]; start == nil {
+				return hdrs, false // not connecting - ProcessNewHeader() will handle it
+			}
+		}
+		work := common.BlockChain.ChainWork(start)
+		for _, h := range hdrs[idx:] {
+			work.Add(work, chain.BlockWork(binary.LittleEndian.Uint32(h[72:76])))
+		}
+		min_work := common.BlockChain.AntiDoSWorkThreshold()
+		if work.Cmp(min_work) >= 0 {
+			return hdrs, false
+		}
+		if !full {
+			common.CountSafe("HdrLowWorkIgnored") // the peer has nothing more to offer
+			return
+		}
+		common.CountSafe("HdrPresyncStart")
+		hs = chain.NewHeadersSync(common.BlockChain, start, min_work)
+		hdrs = hdrs[idx:]
+	}
+
+	res, er, dos := hs.ProcessHeaders(hdrs, full)
+	if er != nil {
+		common.CountSafe("HdrPresyncAbort")
+		if dos {
+			c.DoS("HdrPresyncBad")
+		} else if er == chain.ErrHeadersNotContinuous {
+			// e.g. a new block's announcement - forget the presync and handle them the usual way
+			res = hdrs
+		}
+	}
+	c.Mutex.Lock()
+	if hs.State == chain.HDRS_FINAL {
+		c.hdrsync = nil
+		c.X.PresyncHeight = 0
+		if er == nil {
+			common.CountSafe("HdrPresyncDone")
+		}
+	} else {
+		c.hdrsync = hs
+		c.X.PresyncHeight = hs.PresyncHeight
+		in_progress = true
+	}
+	c.Mutex.Unlock()
+	return
+}
+
+// presyncInProgress tells if we are in the middle of the headers presync with this peer.
+func (c *OneConnection) presyncInProgress() (res bool) {
+	MutexRcv.Lock()
+	res = c.hdrsync != nil
+	MutexRcv.Unlock()
+	return
+}
+
 func (c *OneConnection) ReceiveHeadersNow() {
 	c.Mutex.Lock()
 	if c.X.Debug {
@@ -261,7 +353,13 @@ func (c *OneConnection) GetHeaders(pl []byte) {
 func (c *OneConnection) sendGetHeaders() {
 	MutexRcv.Lock()
 	lb := LastCommitedHeader
+	hs := c.hdrsync
 	MutexRcv.Unlock()
+
+	if hs != nil {
+		c.sendGetHeadersPresync(hs)
+		return
+	}
 	min_height := int(lb.Height) - chain.MovingCheckopintDepth
 	if min_height < 0 {
 		min_height = 0
@@ -305,3 +403,25 @@ func (c *OneConnection) sendGetHeaders() {
 		println(c.ConnID, "- GetHeadersSentAtPingCnt", c.X.GetHeadersSentAtPingCnt)
 	}*/
 }
+
+// sendGetHeadersPresync asks the peer for the next headers of its chain that is being presynced.
+func (c *OneConnection) sendGetHeadersPresync(hs *chain.HeadersSync) {
+	MutexRcv.Lock()
+	locator := hs.Locator()
+	height := hs.Height
+	MutexRcv.Unlock()
+
+	bmsg := bytes.NewBuffer(make([]byte, 0, 4+9+len(locator)*32+32))
+	binary.Write(bmsg, binary.LittleEndian, common.Version)
+	btc.WriteVlen(bmsg, uint64(len(locator)))
+	for _, h := range locator {
+		bmsg.Write(h.Hash[:])
+	}
+	bmsg.Write(make([]byte, 32)) // null_stop
+
+	c.SendRawMsg("getheaders", bmsg.Bytes())
+	c.X.LastHeadersHeightAsk = height
+	c.MutexSetBool(&c.X.GetHeadersInProgress, true)
+	c.X.GetHeadersTimeOutAt = time.Now().Add(GetHeadersTimeout)
+	c.X.GetHeadersSentAtPingCnt = c.X.PingSentCnt
+}
@@ -17,6 +17,7 @@ const (
 	MSG_TX = 1
 	MSG_BLOCK = 2
 	MSG_CMPCT_BLOCK = 4
+	MSG_WTX = 5 // BIP339
 	MSG_WITNESS_TX = MSG_TX | MSG_WITNESS_FLAG
 	MSG_WITNESS_BLOCK = MSG_BLOCK | MSG_WITNESS_FLAG
 )
@@ -99,8 +100,13 @@ func (c *OneConnection) ProcessInv(pl []byte) {
 					common.CountSafe("InvBlockOld")
 				}
 			}
-		} else if typ==MSG_TX {
-			if common.AcceptTx() {
+		} else if typ==MSG_TX || typ==MSG_WTX {
+			if c.X.BlockRelayOnly {
+				common.CountSafe("InvTxBlockRelayOnly")
+			} else if (typ==MSG_WTX) != c.Node.WTxIDRelay {
+				// BIP339 - after wtxidrelay negotiation only MSG_WTX shall be used
+				common.CountSafe("InvTxWrongType")
+			} else if common.AcceptTx() {
 				c.TxInvNotify(pl[of+4:of+36])
 			} else {
 				common.CountSafe("InvTxIgnored")
@@ -115,21 +121,24 @@ func (c *OneConnection) ProcessInv(pl []byte) {
 
 func NetRouteInv(typ uint32, h *btc.Uint256, fromConn *OneConnection) uint32 {
 	var fee_spkb uint64
+	var wh *btc.Uint256
 	if typ == MSG_TX {
 		TxMutex.Lock()
 		if tx, ok := TransactionsToSend[h.BIdx()]; ok {
 			fee_spkb = ( 1000 * tx.Fee ) / uint64(tx.VSize())
+			wh = tx.WTxID()
 		} else {
 			println("NetRouteInv: txid", h.String(), "not in mempool")
 		}
 		TxMutex.Unlock()
 	}
-	return NetRouteInvExt(typ, h, fromConn, fee_spkb)
+	return NetRouteInvExt(typ, h, wh, fromConn, fee_spkb)
 }
 
 
 // NetRouteInvExt is called from the main thread (or from a UI).
-func NetRouteInvExt(typ uint32, h *btc.Uint256, fromConn *OneConnection, fee_spkb uint64) (cnt uint32) {
+// For MSG_TX, wh is the tx's wtxid, to be sent to the peers that negotiated wtxidrelay (BIP339).
+func NetRouteInvExt(typ uint32, h, wh *btc.Uint256, fromConn *OneConnection, fee_spkb uint64) (cnt uint32) {
 	common.CountSafe(fmt.Sprint("NetRouteInv", typ))
 
 	// Prepare the inv
@@ -137,6 +146,16 @@ func NetRouteInvExt(typ uint32, h *btc.Uint256, fromConn *OneConnection, fee_spk
 	binary.LittleEndian.PutUint32(inv[0:4], typ)
 	copy(inv[4:36], h.Bytes())
 
+	var winv *[36]byte
+	if typ==MSG_TX {
+		winv = new([36]byte)
+		binary.LittleEndian.PutUint32(winv[0:4], MSG_WTX)
+		if wh == nil {
+			wh = h
+		}
+		copy(winv[4:36], wh.Bytes())
+	}
+
 	// Append it to PendingInvs in each open connection
 	Mutex_net.Lock()
 	for _, v := range OpenCons {
@@ -144,7 +163,7 @@ func NetRouteInvExt(typ uint32, h *btc.Uint256, fromConn *OneConnection, fee_spk
 			send_inv := true
 			v.Mutex.Lock()
 			if typ==MSG_TX {
-				if v.Node.DoNotRelayTxs {
+				if v.Node.DoNotRelayTxs || v.X.BlockRelayOnly {
 					send_inv = false
 					common.CountSafe("SendInvNoTxNode")
 				} else if v.X.MinFeeSPKB>0 && uint64(v.X.MinFeeSPKB)>fee_spkb {
@@ -160,11 +179,15 @@ func NetRouteInvExt(typ uint32, h *btc.Uint256, fromConn *OneConnection, fee_spk
 				*/
 			}
 			if send_inv {
+				the_inv := inv
+				if winv != nil && v.Node.WTxIDRelay {
+					the_inv = winv
+				}
 				if len(v.PendingInvs) < 500 {
-					if typ, ok := v.InvDone.Map[hash2invid(inv[4:36])]; ok {
+					if typ, ok := v.InvDone.Map[hash2invid(the_inv[4:36])]; ok {
 						common.CountSafe(fmt.Sprint("SendInvSame-", typ))
 					} else {
-						v.PendingInvs = append(v.PendingInvs, inv)
+						v.PendingInvs = append(v.PendingInvs, the_inv)
 						cnt++
 					}
 				} else {
@@ -168,6 +168,9 @@ func drop_worst_peer() bool {
 		if v.Special {
 			continue
 		}
+		if v.Conn.X.BlockRelayOnly || v.Conn.X.IsFeeler {
+			continue // these have their own slots
+		}
 		if common.CFG.Net.MinSegwitCons > 0 && segwit_cnt <= int(common.CFG.Net.MinSegwitCons) &&
 			(v.Conn.Node.Services&SERVICE_SEGWIT) != 0 {
 			continue
@@ -8,6 +8,7 @@ import (
 	"github.com/piotrnar/gocoin/client/common"
 	"github.com/piotrnar/gocoin/lib/btc"
 	"github.com/piotrnar/gocoin/lib/others/peersdb"
+	"github.com/piotrnar/gocoin/lib/others/utils"
 	"math/rand"
 	"net"
 	"os"
@@ -22,6 +23,7 @@ var (
 	next_clean_hammers time.Time
 
 	NextConnectFriends time.Time = time.Now()
+	next_feeler        time.Time
 	AuthPubkeys        [][]byte
 
 	GetMPInProgressTicket = make(chan bool, 1)
@@ -138,6 +140,16 @@ func (c *OneConnection) Tick(now time.Time) {
 		default:
 			// failed to get the ticket - just do nothing
 		}
+
+		// See if to send BIP35 "mempool" command (gocoin peers use "getmp" instead)
+		// Core serves it only with NODE_BLOOM, unless we are whitelisted with "mempool" permission (else it disconnects us)
+		if !c.mempoolReqSent && c.X.IsSpecial && !c.X.IsGocoin && !c.Node.DoNotRelayTxs && !c.X.BlockRelayOnly &&
+			(c.Node.Services&SERVICE_BLOOM) != 0 &&
+			common.GetBool(&common.CFG.TXPool.SendMempool) && common.GetBool(&common.CFG.TXPool.Enabled) {
+			c.mempoolReqSent = true
+			common.CountSafe("MempoolReqSent")
+			c.SendRawMsg("mempool", nil)
+		}
 	}
 
 	// Tick the recent transactions counter
@@ -155,7 +167,7 @@ func (c *OneConnection) Tick(now time.Time) {
 
 	if mfpb := common.MinFeePerKB(); mfpb != c.X.LastMinFeePerKByte {
 		c.X.LastMinFeePerKByte = mfpb
-		if c.Node.Version >= 70013 {
+		if c.Node.Version >= 70013 && !c.X.BlockRelayOnly {
 			c.SendFeeFilter()
 		}
 	}
@@ -166,7 +178,7 @@ func (c *OneConnection) Tick(now time.Time) {
 	}
 
 	// Ask node for new addresses...?
-	if !c.X.OurGetAddrDone && peersdb.PeerDB.Count() < common.MaxPeersNeeded {
+	if !c.X.OurGetAddrDone && !c.X.BlockRelayOnly && peersdb.PeerDB.Count() < common.MaxPeersNeeded {
 		common.CountSafe("AddrWanted")
 		c.SendRawMsg("getaddr", nil)
 		c.X.OurGetAddrDone = true
@@ -199,7 +211,21 @@ func (c *OneConnection) Tick(now time.Time) {
 }
 
 func DoNetwork(ad *peersdb.PeerAddr) {
+	DoNetworkExt(ad, false, false)
+}
+
+// DoNetworkExt opens an outgoing connection.
+// Block-relay-only and feeler connections are not counted in OutConsActive.
+func DoNetworkExt(ad *peersdb.PeerAddr, block_relay_only, feeler bool) {
 	conn := NewConnection(ad)
+	conn.X.BlockRelayOnly = block_relay_only
+	conn.X.IsFeeler = feeler
+	cons_active := &OutConsActive
+	if feeler {
+		cons_active = &FeelerConsActive
+	} else if block_relay_only {
+		cons_active = &BlockRelayConsActive
+	}
 	Mutex_net.Lock()
 	if _, ok := OpenCons[ad.UniqID()]; ok {
 		common.CountSafe("ConnectingAgain")
@@ -210,18 +236,19 @@ func DoNetwork(ad *peersdb.PeerAddr) {
 		conn.MutexSetBool(&conn.X.IsSpecial, true)
 	}
 	OpenCons[ad.UniqID()] = conn
-	OutConsActive++
+	*cons_active++
 	Mutex_net.Unlock()
+	ad.Attempt()
 	go func() {
 		var con net.Conn
 		var e error
 		con_done := make(chan bool, 1)
 
-		go func(addr string) {
+		go func() {
 			// we do net.Dial() in paralell routine, so we can abort quickly upon request
-			con, e = net.DialTimeout("tcp4", addr, TCPDialTimeout)
+			con, e = dial_peer(&ad.NetAddr)
 			con_done <- true
-		}(fmt.Sprintf("%d.%d.%d.%d:%d", ad.Ip4[0], ad.Ip4[1], ad.Ip4[2], ad.Ip4[3], ad.Port))
+		}()
 
 		for {
 			select {
@@ -243,12 +270,43 @@ func DoNetwork(ad *peersdb.PeerAddr) {
 
 		Mutex_net.Lock()
 		delete(OpenCons, ad.UniqID())
-		OutConsActive--
+		*cons_active--
 		Mutex_net.Unlock()
-		ad.Dead()
+		if !feeler {
+			ad.Dead()
+		}
 	}()
 }
 
+// connect_feeler makes a short-lived connection to a peer from the "new" table,
+// to find out if it is still alive. On success the peer gets moved to the "tried" table.
+func connect_feeler() {
+	min_try := uint32(time.Now().Add(-FeelerRetryAfter).Unix())
+	ad := peersdb.SelectPeer(true, func(ad *peersdb.PeerAddr) bool {
+		return ad.LastTry >= min_try || ConnectionActive(ad)
+	})
+	if ad == nil {
+		common.CountSafe("FeelerNoPeers")
+		return
+	}
+	common.CountSafe("FeelerConnect")
+	DoNetworkExt(ad, false, true)
+}
+
+// outgoing_groups returns the netgroups of our outgoing connections.
+// We do not want more than one outgoing connection to the same netgroup.
+func outgoing_groups() (res map[[utils.SOURCE_GROUP_LEN]byte]bool) {
+	res = make(map[[utils.SOURCE_GROUP_LEN]byte]bool)
+	Mutex_net.Lock()
+	for _, c := range OpenCons {
+		if !c.X.Incomming && !c.X.IsFeeler {
+			res[peersdb.Group(&c.PeerAddr.NetAddr)] = true
+		}
+	}
+	Mutex_net.Unlock()
+	return
+}
+
 // TCP server
 func tcp_server() {
 	var ad net.TCPAddr
@@ -273,7 +331,8 @@ func tcp_server() {
 		Mutex_net.Lock()
 		ica := InConsActive
 		Mutex_net.Unlock()
-		if ica < common.GetUint32(&common.CFG.Net.MaxInCons) {
+		// when all the incoming slots are taken, we still accept a new peer if we can evict another one
+		if max_in := common.GetUint32(&common.CFG.Net.MaxInCons); max_in > 0 {
 			lis.SetDeadline(time.Now().Add(100 * time.Millisecond))
 			tc, e := lis.AcceptTCP()
 			if e == nil && common.IsListenTCP() {
@@ -284,7 +343,9 @@ func tcp_server() {
 				if e == nil {
 					// Hammering protection
 					HammeringMutex.Lock()
-					if rd := RecentlyDisconencted[ad.NetAddr.Ip4]; rd != nil {
+					if onion_inbound(ad) {
+						// connections to our onion service all come from the local Tor
+					} else if rd := RecentlyDisconencted[ad.NetAddr.Ip4]; rd != nil {
 						rd.Count++
 						terminate = rd.Count > HammeringMaxAllowedCount
 					}
@@ -293,6 +354,9 @@ func tcp_server() {
 					if terminate {
 						common.CountSafe("BanHammerIn")
 						ad.Ban()
+					} else if ica >= max_in && !ConnectionActive(ad) && !evict_incoming() {
+						common.CountSafe("InConnFull")
+						terminate = true
 					} else {
 						// Incoming IP passed all the initial checks - talk to it
 						conn := NewConnection(ad)
@@ -441,6 +505,8 @@ func NetworkTick() {
 
 	now := time.Now()
 
+	check_block_stall(now)
+
 	// Push GetHeaders if not in progress
 	Mutex_net.Lock()
 	var cnt_headers_in_progress int
@@ -459,6 +525,8 @@ func NetworkTick() {
 		v.Mutex.Unlock()
 	}
 	conn_cnt := OutConsActive
+	block_relay_cnt := BlockRelayConsActive
+	feeler_cnt := FeelerConsActive
 	Mutex_net.Unlock()
 
 	if cnt_headers_in_progress == 0 {
@@ -528,24 +596,42 @@ func NetworkTick() {
 			Mutex_net.Unlock()
 		}
 
-		adrs := peersdb.GetBestPeers(128, func(ad *peersdb.PeerAddr) bool {
+		groups := outgoing_groups()
+		ad := peersdb.SelectPeer(false, func(ad *peersdb.PeerAddr) bool {
 			if segwit_conns < common.CFG.Net.MinSegwitCons && (ad.Services&SERVICE_SEGWIT) == 0 {
 				return true
 			}
-			return ConnectionActive(ad)
+			return groups[peersdb.Group(&ad.NetAddr)] || ConnectionActive(ad)
 		})
-		if len(adrs) == 0 && segwit_conns < common.CFG.Net.MinSegwitCons {
+		if ad == nil && segwit_conns < common.CFG.Net.MinSegwitCons {
 			// we have only non-segwit peers in the database - take them
-			adrs = peersdb.GetBestPeers(128, func(ad *peersdb.PeerAddr) bool {
-				return ConnectionActive(ad)
+			ad = peersdb.SelectPeer(false, func(ad *peersdb.PeerAddr) bool {
+				return groups[peersdb.Group(&ad.NetAddr)] || ConnectionActive(ad)
 			})
 		}
-		if len(adrs) != 0 {
-			DoNetwork(adrs[rand.Int31n(int32(len(adrs)))])
+		if ad != nil {
+			DoNetwork(ad)
 			Mutex_net.Lock()
 			conn_cnt = OutConsActive
 			Mutex_net.Unlock()
 		}
+	} else if block_relay_cnt < common.GetUint32(&common.CFG.Net.BlockRelayCons) {
+		// open block-relay-only connections once all the full-relay ones are established
+		groups := outgoing_groups()
+		ad := peersdb.SelectPeer(false, func(ad *peersdb.PeerAddr) bool {
+			if (ad.Services & SERVICE_SEGWIT) == 0 {
+				return true
+			}
+			return groups[peersdb.Group(&ad.NetAddr)] || ConnectionActive(ad)
+		})
+		if ad != nil {
+			common.CountSafe("BlockRelayConnect")
+			DoNetworkExt(ad, true, false)
+		}
+	} else if common.GetBool(&common.CFG.Net.Feelers) && feeler_cnt == 0 && now.After(next_feeler) {
+		// all the outgoing connections established - check some other peers from the database
+		next_feeler = now.Add(FeelerInterval/2 + time.Duration(rand.Int63n(int64(FeelerInterval))))
+		connect_feeler()
 	}
 
 	if expireTxsNow {
@@ -599,6 +685,7 @@ func (c *OneConnection) Run() {
 
 	c.writing_thread_push = make(chan bool, 1)
 
+	c.v2_handshake()
 	c.SendVersion()
 
 	c.Mutex.Lock()
@@ -675,6 +762,16 @@ func (c *OneConnection) Run() {
 					f.Close()
 				}
 			}
+
+			if c.X.IsFeeler {
+				// the peer is alive - that is all we wanted to know
+				c.PeerAddr.Services = c.Node.Services
+				c.PeerAddr.Connected()
+				common.CountSafe("FeelerAlive")
+				c.Disconnect("Feeler")
+				continue
+			}
+
 			c.X.LastMinFeePerKByte = common.MinFeePerKB()
 
 			if c.X.IsGocoin {
@@ -684,7 +781,7 @@ func (c *OneConnection) Run() {
 			if c.Node.Version >= 70012 {
 				c.SendRawMsg("sendheaders", nil)
 				if c.Node.Version >= 70013 {
-					if c.X.LastMinFeePerKByte != 0 {
+					if c.X.LastMinFeePerKByte != 0 && !c.X.BlockRelayOnly {
 						c.SendFeeFilter()
 					}
 					if c.Node.Version >= 70014 && common.GetBool(&common.CFG.TXPool.Enabled) {
@@ -702,9 +799,13 @@ func (c *OneConnection) Run() {
 				}
 			}
 			c.PeerAddr.Services = c.Node.Services
-			c.PeerAddr.Save()
+			if c.X.Incomming {
+				c.PeerAddr.Save()
+			} else {
+				c.PeerAddr.Connected()
+			}
 
-			if common.IsListenTCP() {
+			if common.IsListenTCP() && !c.X.BlockRelayOnly {
 				c.SendOwnAddr()
 			}
 			continue
@@ -716,12 +817,33 @@ func (c *OneConnection) Run() {
 			c.ProcessInv(cmd.pl)
 
 		case "tx":
-			if common.AcceptTx() {
+			if c.X.BlockRelayOnly {
+				common.CountSafe("TxBlockRelayOnly")
+			} else if common.AcceptTx() {
 				c.ParseTxNet(cmd.pl)
 			}
 
 		case "addr":
-			c.ParseAddr(cmd.pl)
+			if !c.X.BlockRelayOnly {
+				c.ParseAddr(cmd.pl)
+			}
+
+		case "addrv2":
+			if !c.X.BlockRelayOnly {
+				c.ParseAddrV2(cmd.pl)
+			}
+
+		case "sendaddrv2":
+			c.Mutex.Lock()
+			c.Node.SendAddrV2 = true
+			c.Mutex.Unlock()
+
+		case "wtxidrelay":
+			if c.Node.Version >= 70016 {
+				c.Mutex.Lock()
+				c.Node.WTxIDRelay = true
+				c.Mutex.Unlock()
+			}
 
 		case "block": //block received
 			netBlockReceived(c, cmd.pl)
@@ -735,7 +857,9 @@ func (c *OneConnection) Run() {
 			c.ProcessGetData(cmd.pl)
 
 		case "getaddr":
-			if !c.X.GetAddrDone {
+			if c.X.BlockRelayOnly {
+				common.CountSafe("GetAddrBlockRelayOnly")
+			} else if !c.X.GetAddrDone {
 				c.SendAddr()
 				c.X.GetAddrDone = true
 			} else {
@@ -762,7 +886,7 @@ func (c *OneConnection) Run() {
 			common.CountSafe("NotFound")
 
 		case "headers":
-			if c.HandleHeaders(cmd.pl) > 0 {
+			if c.HandleHeaders(cmd.pl) > 0 || c.presyncInProgress() {
 				c.sendGetHeaders()
 			}
 
@@ -812,6 +936,11 @@ func (c *OneConnection) Run() {
 				c.ProcessGetMP(cmd.pl)
 			}
 
+		case "mempool":
+			if !c.X.BlockRelayOnly {
+				c.ProcessMempool()
+			}
+
 		case "auth":
 			c.AuthRvcd(cmd.pl)
 			if c.X.AuthAckGot {
@@ -828,6 +957,15 @@ func (c *OneConnection) Run() {
 		case "filterload", "filteradd", "filterclear", "merkleblock":
 			c.DoS("SPV")
 
+		case "getcfilters":
+			c.ProcessGetCFilters(cmd.pl)
+
+		case "getcfheaders":
+			c.ProcessGetCFHeaders(cmd.pl)
+
+		case "getcfcheckpt":
+			c.ProcessGetCFCheckpt(cmd.pl)
+
 		default:
 		}
 	}
@@ -854,7 +992,7 @@ func (c *OneConnection) Run() {
 	if c.PeerAddr.Friend || c.X.Authorized {
 		common.CountSafe(fmt.Sprint("FDisconnect-", ban))
 	} else {
-		if ban {
+		if ban && !(c.X.Incomming && onion_inbound(c.PeerAddr)) {
 			c.PeerAddr.Ban()
 			common.CountSafe("PeersBanned")
 		} else if c.X.Incomming && !c.MutexGetBool(&c.X.IsSpecial) {
@@ -0,0 +1,144 @@
+package network
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/lib/btc"
+	"github.com/piotrnar/gocoin/lib/others/peersdb"
+	"github.com/piotrnar/gocoin/lib/others/socks5"
+	"github.com/piotrnar/gocoin/lib/others/torctl"
+)
+
+const (
+	ONION_KEY_FILE      = "onion_v3_private_key"
+	ONION_RETRY_TIMEOUT = time.Minute
+)
+
+var (
+	onion_mutex sync.Mutex
+	onion_addr  *btc.NetAddr // address of our onion service, once it is published
+)
+
+// UsingProxy returns true if the outgoing connections go through SOCKS5 proxy.
+// In such case we should not reveal our IP to peers.
+func UsingProxy() bool {
+	common.LockCfg()
+	defer common.UnlockCfg()
+	return common.CFG.Net.Proxy != ""
+}
+
+// dial_peer connects to the peer, directly or through the proxy.
+func dial_peer(ad *btc.NetAddr) (net.Conn, error) {
+	common.LockCfg()
+	proxy, randomize, only_onion := common.CFG.Net.Proxy, common.CFG.Net.ProxyRandomize, common.CFG.Net.OnlyOnion
+	common.UnlockCfg()
+
+	if only_onion && ad.NetID != btc.NET_TORV3 {
+		return nil, errors.New("only onion peers allowed")
+	}
+	if proxy == "" {
+		if ad.NetID != 0 {
+			return nil, errors.New("proxy needed to connect to " + ad.String())
+		}
+		return net.DialTimeout("tcp4", fmt.Sprintf("%d.%d.%d.%d:%d", ad.Ip4[0], ad.Ip4[1], ad.Ip4[2], ad.Ip4[3], ad.Port), TCPDialTimeout)
+	}
+	var auth *socks5.Auth
+	if randomize {
+		auth = socks5.RandomAuth()
+	}
+	return socks5.Dial(proxy, fmt.Sprint(ad.Host(), ":", ad.Port), auth, TCPDialTimeout)
+}
+
+// OnionAddr returns the address of our onion service, or nil if it is not published.
+func OnionAddr() (res *btc.NetAddr) {
+	onion_mutex.Lock()
+	res = onion_addr
+	onion_mutex.Unlock()
+	return
+}
+
+// onion_inbound returns true for connections that came through our onion service.
+// We must not ban nor hammering-check them, as they all have the address of the local Tor.
+func onion_inbound(ad *peersdb.PeerAddr) bool {
+	return ad.NetID == 0 && ad.Ip4[0] == 127 && OnionAddr() != nil
+}
+
+// onion_target returns the address where Tor should forward the incoming connections.
+func onion_target() string {
+	common.LockCfg()
+	ip := net.ParseIP(common.CFG.Net.BindToIF)
+	common.UnlockCfg()
+	if ip == nil || ip.IsUnspecified() {
+		ip = net.IPv4(127, 0, 0, 1)
+	}
+	return fmt.Sprint(ip.String(), ":", common.DefaultTcpPort())
+}
+
+// publish_onion adds our onion service and keeps it until the control connection is closed.
+func publish_onion() (e error) {
+	common.LockCfg()
+	control, password := common.CFG.Net.TorControl, common.CFG.Net.TorPassword
+	common.UnlockCfg()
+
+	c, e := torctl.Dial(control, TCPDialTimeout)
+	if e != nil {
+		return
+	}
+	defer c.Close()
+	if e = c.Authenticate(password); e != nil {
+		return
+	}
+
+	key_file := common.GocoinHomeDir + ONION_KEY_FILE
+	key, _ := ioutil.ReadFile(key_file)
+	port := common.DefaultTcpPort()
+	service_id, new_key, e := c.AddOnion(strings.TrimSpace(string(key)), port, onion_target())
+	if e != nil {
+		return
+	}
+	if len(key) == 0 {
+		if e = ioutil.WriteFile(key_file, []byte(new_key), 0600); e != nil {
+			return
+		}
+	}
+
+	netid, addr, e := btc.ParseNonIPHost(service_id + ".onion")
+	if e != nil {
+		return
+	}
+	na := &btc.NetAddr{Services: common.Services, Port: port}
+	if e = na.SetAddr(netid, addr); e != nil {
+		return
+	}
+	onion_mutex.Lock()
+	onion_addr = na
+	onion_mutex.Unlock()
+	fmt.Println("Published as Tor onion service", na.String())
+
+	c.Wait()
+	return errors.New("control connection closed")
+}
+
+// StartOnionService publishes the node as Tor onion service, using CFG.Net.TorControl.
+func StartOnionService() {
+	go func() {
+		for !common.NetworkClosed.Get() {
+			e := publish_onion()
+			onion_mutex.Lock()
+			onion_addr = nil
+			onion_mutex.Unlock()
+			if common.NetworkClosed.Get() {
+				break
+			}
+			println("Tor onion service:", e.Error())
+			time.Sleep(ONION_RETRY_TIMEOUT)
+		}
+	}()
+}
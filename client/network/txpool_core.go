@@ -4,9 +4,11 @@ import (
 	"encoding/binary"
 	"fmt"
 	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/client/notify"
 	"github.com/piotrnar/gocoin/lib/btc"
 	"github.com/piotrnar/gocoin/lib/chain"
 	"github.com/piotrnar/gocoin/lib/script"
+	"sort"
 	"sync"
 	"sync/atomic"
 	"time"
@@ -19,19 +21,28 @@ const (
 	TX_REJECTED_FORMAT       = 102
 	TX_REJECTED_LEN_MISMATCH = 103
 	TX_REJECTED_EMPTY_INPUT  = 104
+	TX_REJECTED_PACKAGE      = 105 // only returned for packages (never stored)
 
 	TX_REJECTED_OVERSPEND = 154
 	TX_REJECTED_BAD_INPUT = 157
 
 	// Anything from the list below might eventually get mined
-	TX_REJECTED_NO_TXOU     = 202
-	TX_REJECTED_LOW_FEE     = 205
-	TX_REJECTED_NOT_MINED   = 208
-	TX_REJECTED_CB_INMATURE = 209
-	TX_REJECTED_RBF_LOWFEE  = 210
-	TX_REJECTED_RBF_FINAL   = 211
-	TX_REJECTED_RBF_100     = 212
-	TX_REJECTED_REPLACED    = 213
+	TX_REJECTED_NO_TXOU      = 202
+	TX_REJECTED_LOW_FEE      = 205
+	TX_REJECTED_NOT_MINED    = 208
+	TX_REJECTED_CB_INMATURE  = 209
+	TX_REJECTED_RBF_LOWFEE   = 210
+	TX_REJECTED_RBF_FINAL    = 211
+	TX_REJECTED_RBF_100      = 212
+	TX_REJECTED_REPLACED     = 213
+	TX_REJECTED_RBF_NEWINPUT = 214
+	TX_REJECTED_RBF_INCRFEE  = 215
+	TX_REJECTED_RBF_FEERATE  = 216
+	TX_REJECTED_RBF_CONFLICT = 217
+	TX_REJECTED_ANC_COUNT    = 218
+	TX_REJECTED_ANC_SIZE     = 219
+	TX_REJECTED_DESC_COUNT   = 220
+	TX_REJECTED_DESC_SIZE    = 221
 )
 
 var (
@@ -42,6 +53,9 @@ var (
 	TransactionsToSendSize   uint64
 	TransactionsToSendWeight uint64
 
+	// wTXID index of TransactionsToSend (BIP339):
+	WTxIDs map[BIDX]*OneTxToSend = make(map[BIDX]*OneTxToSend)
+
 	// All the outputs that are currently spent in TransactionsToSend:
 	SpentOutputs map[uint64]BIDX = make(map[uint64]BIDX)
 
@@ -49,6 +63,9 @@ var (
 	TransactionsRejected     map[BIDX]*OneTxRejected = make(map[BIDX]*OneTxRejected)
 	TransactionsRejectedSize uint64                  // only include those that have *Tx pointer set
 
+	// wTXID index of TransactionsRejected (points to the record's key):
+	RejectedWTxIDs map[BIDX]BIDX = make(map[BIDX]BIDX)
+
 	// Transactions that are received from network (via "tx"), but not yet processed:
 	TransactionsPending map[BIDX]bool = make(map[BIDX]bool)
 
@@ -68,17 +85,25 @@ type OneTxToSend struct {
 	MemInputs   []bool // transaction is spending inputs from other unconfirmed tx(s)
 	MemInputCnt int
 	SigopsCost  uint64
-	Final       bool // if true RFB will not work on it
+	Final       bool // if true, it does not signal RBF itself (see SignalsRBF)
 	VerifyTime  time.Duration
+	PkgFeeSPKB  uint64  // if it was accepted as a part of a package, this is the package's fee per kB
+	Ancestors   TxStats // the tx with all its unconfirmed ancestors
+	Descendants TxStats // the tx with all its descendants
+	EntryHeight uint32  // block height when the tx came to the mempool (zero if the fee estimator does not track it)
+	FeeDelta    int64   // see PrioritiseTx
 }
 
 type OneTxRejected struct {
-	Id *btc.Uint256
+	Id    *btc.Uint256
+	WTxID *btc.Uint256 // the witness variant that was rejected
 	time.Time
 	Size     uint32
 	Reason   byte
 	Waiting4 *btc.Uint256
 	*btc.Tx
+	PkgTries  uint32 // how many times it has been tried as a package's parent (for LOW_FEE)
+	ScriptsOK bool   // the scripts have been verified, when it was in a package
 }
 
 type OneWaitingList struct {
@@ -101,6 +126,8 @@ func ReasonToString(reason byte) string {
 		return "LEN_MISMATCH"
 	case TX_REJECTED_EMPTY_INPUT:
 		return "EMPTY_INPUT"
+	case TX_REJECTED_PACKAGE:
+		return "PACKAGE"
 	case TX_REJECTED_OVERSPEND:
 		return "OVERSPEND"
 	case TX_REJECTED_BAD_INPUT:
@@ -121,6 +148,22 @@ func ReasonToString(reason byte) string {
 		return "RBF_100"
 	case TX_REJECTED_REPLACED:
 		return "REPLACED"
+	case TX_REJECTED_RBF_NEWINPUT:
+		return "RBF_NEWINPUT"
+	case TX_REJECTED_RBF_INCRFEE:
+		return "RBF_INCRFEE"
+	case TX_REJECTED_RBF_FEERATE:
+		return "RBF_FEERATE"
+	case TX_REJECTED_RBF_CONFLICT:
+		return "RBF_CONFLICT"
+	case TX_REJECTED_ANC_COUNT:
+		return "ANC_COUNT"
+	case TX_REJECTED_ANC_SIZE:
+		return "ANC_SIZE"
+	case TX_REJECTED_DESC_COUNT:
+		return "DESC_COUNT"
+	case TX_REJECTED_DESC_SIZE:
+		return "DESC_SIZE"
 	}
 	return fmt.Sprint("UNKNOWN_", reason)
 }
@@ -152,26 +195,60 @@ func NeedThisTxExt(id *btc.Uint256, cb func()) (why_not int) {
 	return
 }
 
+// NeedThisWTx is NeedThisTx for wtxid based invs (BIP339).
+// It only returns false for the rejected txs that had the same witness data.
+func NeedThisWTx(wid *btc.Uint256) (res bool) {
+	bidx := wid.BIdx()
+	TxMutex.Lock()
+	_, in_pool := WTxIDs[bidx]
+	_, rejected := RejectedWTxIDs[bidx]
+	_, pending := TransactionsPending[bidx] // only works for non-segwit txs (wtxid == txid)
+	res = !in_pool && !rejected && !pending
+	TxMutex.Unlock()
+	return
+}
+
 // TxInvNotify handles tx-inv notifications.
+// For peers that negotiated wtxidrelay, the hash is wtxid.
 func (c *OneConnection) TxInvNotify(hash []byte) {
-	if NeedThisTx(btc.NewUint256(hash), nil) {
-		var b [1 + 4 + 32]byte
-		b[0] = 1 // One inv
-		if (c.Node.Services & SERVICE_SEGWIT) != 0 {
-			binary.LittleEndian.PutUint32(b[1:5], MSG_WITNESS_TX) // SegWit Tx
-			//println(c.ConnID, "getdata", btc.NewUint256(hash).String())
-		} else {
-			b[1] = MSG_TX // Tx
+	if c.Node.WTxIDRelay {
+		if NeedThisWTx(btc.NewUint256(hash)) {
+			var b [1 + 4 + 32]byte
+			b[0] = 1 // One inv
+			b[1] = MSG_WTX
+			copy(b[5:37], hash)
+			c.SendRawMsg("getdata", b[:])
 		}
-		copy(b[5:37], hash)
-		c.SendRawMsg("getdata", b[:])
+		return
 	}
+	if NeedThisTx(btc.NewUint256(hash), nil) {
+		c.send_getdata_tx(hash)
+	}
+}
+
+// send_getdata_tx requests the tx with the given txid.
+func (c *OneConnection) send_getdata_tx(hash []byte) {
+	var b [1 + 4 + 32]byte
+	b[0] = 1 // One inv
+	if (c.Node.Services & SERVICE_SEGWIT) != 0 {
+		binary.LittleEndian.PutUint32(b[1:5], MSG_WITNESS_TX) // SegWit Tx
+		//println(c.ConnID, "getdata", btc.NewUint256(hash).String())
+	} else {
+		b[1] = MSG_TX // Tx
+	}
+	copy(b[5:37], hash)
+	c.SendRawMsg("getdata", b[:])
 }
 
 // RejectTx adds a transaction to the rejected list or not, if it has been mined already.
 // Make sure to call it with locked TxMutex.
 // Returns the OneTxRejected or nil if it has not been added.
 func RejectTx(tx *btc.Tx, why byte) *OneTxRejected {
+	bidx := tx.Hash.BIdx()
+	if _, ok := TransactionsRejected[bidx]; ok {
+		deleteRejected(bidx)
+	}
+
 	rec := new(OneTxRejected)
 	rec.Time = time.Now()
 	rec.Size = uint32(len(tx.Raw))
@@ -186,9 +263,11 @@ func RejectTx(tx *btc.Tx, why byte) *OneTxRejected {
 		rec.Id = new(btc.Uint256)
 		rec.Id.Hash = tx.Hash.Hash
 	}
+	rec.WTxID = new(btc.Uint256)
+	rec.WTxID.Hash = tx.WTxID().Hash
 
-	bidx := tx.Hash.BIdx()
 	TransactionsRejected[bidx] = rec
+	RejectedWTxIDs[rec.WTxID.BIdx()] = bidx
 
 	return rec
 }
@@ -219,6 +298,14 @@ func (c *OneConnection) ParseTxNet(pl []byte) {
 		return
 	}
 
+	TxMutex.Lock()
+	if rej, ok := TransactionsRejected[tx.Hash.BIdx()]; ok && rej.WTxID != nil && !rej.WTxID.Equal(tx.WTxID()) {
+		// we have rejected a different witness variant of this tx - give it another chance
+		deleteRejected(tx.Hash.BIdx())
+		common.CountSafe("TxRejectedOtherWTxID")
+	}
+	TxMutex.Unlock()
+
 	NeedThisTx(&tx.Hash, func() {
 		// This body is called with a locked TxMutex
 		tx.Raw = pl
@@ -238,7 +325,7 @@ func HandleNetTx(ntx *TxRcvd, retry bool) (accepted bool) {
 
 	tx := ntx.Tx
 	start_time := time.Now()
-	var final bool // set to true if any of the inpits has a final sequence
+	final := true // set to false if any of the inputs signals RBF (BIP125)
 
 	var totinp, totout uint64
 	var frommem []bool
@@ -263,58 +350,18 @@ func HandleNetTx(ntx *TxRcvd, retry bool) (accepted bool) {
 	pos := make([]*btc.TxOut, len(tx.TxIn))
 	spent := make([]uint64, len(tx.TxIn))
 
-	var rbf_tx_list map[*OneTxToSend]bool
+	var conflicts bool
 
 	// Check if all the inputs exist in the chain
 	for i := range tx.TxIn {
-		if !final && tx.TxIn[i].Sequence >= 0xfffffffe {
-			final = true
+		if final && tx.TxIn[i].Sequence < 0xfffffffe {
+			final = false
 		}
 
 		spent[i] = tx.TxIn[i].Input.UIdx()
 
-		if so, ok := SpentOutputs[spent[i]]; ok {
-			// Can only be accepted as RBF...
-
-			if rbf_tx_list == nil {
-				rbf_tx_list = make(map[*OneTxToSend]bool)
-			}
-
-			ctx := TransactionsToSend[so]
-
-			if !ntx.trusted && ctx.Final {
-				RejectTx(ntx.Tx, TX_REJECTED_RBF_FINAL)
-				TxMutex.Unlock()
-				common.CountSafe("TxRejectedRBFFinal")
-				return
-			}
-
-			rbf_tx_list[ctx] = true
-			if !ntx.trusted && len(rbf_tx_list) > 100 {
-				RejectTx(ntx.Tx, TX_REJECTED_RBF_100)
-				TxMutex.Unlock()
-				common.CountSafe("TxRejectedRBF100+")
-				return
-			}
-
-			chlds := ctx.GetAllChildren()
-			for _, ctx = range chlds {
-				if !ntx.trusted && ctx.Final {
-					RejectTx(ntx.Tx, TX_REJECTED_RBF_FINAL)
-					TxMutex.Unlock()
-					common.CountSafe("TxRejectedRBF_Final")
-					return
-				}
-
-				rbf_tx_list[ctx] = true
-
-				if !ntx.trusted && len(rbf_tx_list) > 100 {
-					RejectTx(ntx.Tx, TX_REJECTED_RBF_100)
-					TxMutex.Unlock()
-					common.CountSafe("TxRejectedRBF100+")
-					return
-				}
-			}
+		if _, ok := SpentOutputs[spent[i]]; ok {
+			conflicts = true // can only be accepted as RBF...
 		}
 
 		if txinmem, ok := TransactionsToSend[btc.BIdx(tx.TxIn[i].Input.Hash[:])]; ok {
@@ -352,6 +399,22 @@ func HandleNetTx(ntx *TxRcvd, retry bool) (accepted bool) {
 				}
 
 				if rej, ok := TransactionsRejected[btc.BIdx(tx.TxIn[i].Input.Hash[:])]; ok {
+					if rej.Reason == TX_REJECTED_LOW_FEE && rej.Tx != nil && !ntx.in_pkg {
+						// this tx may pay for its parent(s) - try them as a package
+						if pkg := low_fee_parents(ntx); pkg != nil {
+							TxMutex.Unlock()
+							common.CountSafe("TxPkgFromChild")
+							if _, reason := HandleTxPackage(pkg); reason == 0 {
+								return true
+							}
+							TxMutex.Lock()
+						}
+						if _, ok := TransactionsToSend[tx.Hash.BIdx()]; !ok {
+							RejectTx(ntx.Tx, TX_REJECTED_NO_TXOU)
+						}
+						TxMutex.Unlock()
+						return
+					}
 					if rej.Reason != TX_REJECTED_NO_TXOU || rej.Waiting4 == nil {
 						RejectTx(ntx.Tx, TX_REJECTED_NO_TXOU)
 						TxMutex.Unlock()
@@ -386,6 +449,9 @@ func HandleNetTx(ntx *TxRcvd, retry bool) (accepted bool) {
 				TxMutex.Unlock()
 				if newone {
 					common.CountSafe("TxRejectedNoInpNew")
+					if ntx.conn != nil {
+						ntx.conn.ask_for_parent(missingid) // the peer should have it
+					}
 				} else {
 					common.CountSafe("TxRejectedNoInpOld")
 				}
@@ -421,38 +487,67 @@ func HandleNetTx(ntx *TxRcvd, retry bool) (accepted bool) {
 
 	// Check for a proper fee
 	fee := totinp - totout
-	if !ntx.local && fee < (uint64(tx.VSize())*common.MinFeePerKB()/1000)  { // do not check minimum fee for locally loaded txs
-		RejectTx(ntx.Tx, TX_REJECTED_LOW_FEE)
-		TxMutex.Unlock()
-		common.CountSafe("TxRejectedLowFee")
-		return
+	if !ntx.local && fee < (uint64(tx.VSize())*common.MinFeePerKB()/1000) { // do not check minimum fee for locally loaded txs
+		if ntx.in_pkg && ntx.pkg_fee_spkb >= common.MinFeePerKB() {
+			common.CountSafe("TxLowFeeInPackage") // its children pay for it
+		} else {
+			RejectTx(ntx.Tx, TX_REJECTED_LOW_FEE)
+			var children []*btc.Tx
+			if wtg := WaitingForInputs[tx.Hash.BIdx()]; wtg != nil && !ntx.in_pkg {
+				for k := range wtg.Ids {
+					if rej := TransactionsRejected[k]; rej != nil && rej.Tx != nil {
+						children = append(children, rej.Tx)
+					}
+				}
+			}
+			TxMutex.Unlock()
+			common.CountSafe("TxRejectedLowFee")
+			if children != nil {
+				// maybe one of the children waiting for it, pays for it
+				retry_waiting_as_packages(ntx, children)
+				TxMutex.Lock()
+				_, accepted = TransactionsToSend[tx.Hash.BIdx()]
+				TxMutex.Unlock()
+			}
+			return
+		}
 	}
 
-	if rbf_tx_list != nil {
-		var totweight int
-		var totfees uint64
-
-		for ctx, _ := range rbf_tx_list {
-			totweight += ctx.Weight()
-			totfees += ctx.Fee
+	var rbf *RBFResult
+	if conflicts {
+		if rbf = CheckReplacement(tx, fee, ntx.trusted, ntx.local); rbf.Reason != 0 {
+			RejectTx(ntx.Tx, rbf.Reason)
+			TxMutex.Unlock()
+			common.CountSafe("TxRejected" + ReasonToString(rbf.Reason))
+			if common.GetBool(&common.CFG.TXPool.Debug) {
+				println("RBF", tx.Hash.String(), "rejected - rule", rbf.Rule)
+			}
+			return
 		}
+	}
 
-		if !ntx.local && totfees*uint64(tx.Weight()) >= fee*uint64(totweight) {
-			RejectTx(ntx.Tx, TX_REJECTED_RBF_LOWFEE)
+	if frommem != nil && !ntx.trusted {
+		var evict []*OneTxToSend
+		if rbf != nil {
+			evict = rbf.Evict // the replaced txs do not count against the limits
+		}
+		if reason := check_tx_limits(tx, frommem, evict); reason != 0 {
+			RejectTx(ntx.Tx, reason)
 			TxMutex.Unlock()
-			common.CountSafe("TxRejectedRBFLowFee")
+			common.CountSafe("TxRejected" + ReasonToString(reason))
 			return
 		}
 	}
 
 	sigops := btc.WITNESS_SCALE_FACTOR * tx.GetLegacySigOpCount()
 
-	if !ntx.trusted { // Verify scripts
+	if !ntx.trusted && !ntx.scripts_ok { // Verify scripts
 		var wg sync.WaitGroup
 		var ver_err_cnt uint32
 
 		prev_dbg_err := script.DBG_ERR
 		script.DBG_ERR = false // keep quiet for incorrect txs
+		tx.Spent_outputs = pos
 		for i := range tx.TxIn {
 			wg.Add(1)
 			go func(prv []byte, amount uint64, i int, tx *btc.Tx) {
@@ -472,7 +567,7 @@ func HandleNetTx(ntx *TxRcvd, retry bool) (accepted bool) {
 			if ntx.conn != nil {
 				ntx.conn.DoS("TxScriptFail")
 			}
-			if len(rbf_tx_list) > 0 {
+			if rbf != nil {
 				fmt.Println("RBF try", ver_err_cnt, "script(s) failed!")
 				fmt.Print("> ")
 			}
@@ -487,19 +582,36 @@ func HandleNetTx(ntx *TxRcvd, retry bool) (accepted bool) {
 		sigops += uint(tx.CountWitnessSigOps(i, pos[i].Pk_script))
 	}
 
-	if rbf_tx_list != nil {
-		for ctx, _ := range rbf_tx_list {
-			// we dont remove with children because we have all of them on the list
+	if rbf != nil {
+		// We dont remove with children because we have all of them on the list,
+		// but the descendants must go first, so the ancestors' stats get updated.
+		evict := append([]*OneTxToSend{}, rbf.Evict...)
+		sort.Slice(evict, func(i, j int) bool {
+			return evict[i].Ancestors.Cnt > evict[j].Ancestors.Cnt
+		})
+		for _, ctx := range evict {
 			ctx.Delete(false, TX_REJECTED_REPLACED)
 			common.CountSafe("TxRemovedByRBF")
 		}
 	}
 
-	rec := &OneTxToSend{Spent: spent, Volume: totinp, Local : ntx.local,
+	rec := &OneTxToSend{Spent: spent, Volume: totinp, Local: ntx.local,
 		Fee: fee, Firstseen: time.Now(), Tx: tx, MemInputs: frommem, MemInputCnt: frommemcnt,
 		SigopsCost: uint64(sigops), Final: final, VerifyTime: time.Now().Sub(start_time)}
+	if ntx.in_pkg {
+		rec.PkgFeeSPKB = ntx.pkg_fee_spkb
+	}
+	if fd := FeeDeltas[tx.Hash.BIdx()]; fd != nil {
+		rec.FeeDelta = fd.Delta
+	}
 
 	TransactionsToSend[tx.Hash.BIdx()] = rec
+	rec.add_tx_stats()
+	if !ntx.in_pkg {
+		rec.fee_est_track() // txs accepted thanks to their children would mislead the estimator
+	}
+	WTxIDs[tx.WTxID().BIdx()] = rec
+	notify.TxAdded(tx)
 
 	if maxpoolsize := common.MaxMempoolSize(); maxpoolsize != 0 {
 		newsize := TransactionsToSendSize + uint64(len(rec.Raw))
@@ -530,7 +642,11 @@ func HandleNetTx(ntx *TxRcvd, retry bool) (accepted bool) {
 		common.CountSafe("TxRouteNotMined")
 	} else if !ntx.trusted && rec.isRoutable() {
 		// do not automatically route loacally loaded txs
-		rec.Invsentcnt += NetRouteInvExt(1, &tx.Hash, ntx.conn, 1000*fee/uint64(len(ntx.Raw)))
+		fee_spkb := 1000 * fee / uint64(len(ntx.Raw))
+		if rec.PkgFeeSPKB > fee_spkb {
+			fee_spkb = rec.PkgFeeSPKB // let the peers get the parent of a CPFP package
+		}
+		rec.Invsentcnt += NetRouteInvExt(MSG_TX, &tx.Hash, tx.WTxID(), ntx.conn, fee_spkb)
 		common.CountSafe("TxRouteOK")
 	}
 
@@ -538,6 +654,7 @@ func HandleNetTx(ntx *TxRcvd, retry bool) (accepted bool) {
 		ntx.conn.Mutex.Lock()
 		ntx.conn.txsCur++
 		ntx.conn.X.TxsReceived++
+		ntx.conn.X.LastTxRcvd = time.Now()
 		ntx.conn.Mutex.Unlock()
 	}
 
@@ -556,7 +673,7 @@ func (rec *OneTxToSend) isRoutable() bool {
 		rec.Blocked = TX_REJECTED_TOO_BIG
 		return false
 	}
-	if rec.Fee < (uint64(rec.VSize()) * common.RouteMinFeePerKB() / 1000) {
+	if rec.Fee < (uint64(rec.VSize())*common.RouteMinFeePerKB()/1000) && rec.PkgFeeSPKB < common.RouteMinFeePerKB() {
 		common.CountSafe("TxRouteLowFee")
 		rec.Blocked = TX_REJECTED_LOW_FEE
 		return false
@@ -580,6 +697,12 @@ func RetryWaitingForInput(wtg *OneWaitingList) {
 // If reason is not zero, add the deleted txs to the rejected list.
 // Make sure to call it with locked TxMutex.
 func (tx *OneTxToSend) Delete(with_children bool, reason byte) {
+	tx.remove(with_children, reason)
+	notify.TxRemoved(&tx.Hash)
+}
+
+// remove does the job of Delete(), but without notifying about it (used for mined txs).
+func (tx *OneTxToSend) remove(with_children bool, reason byte) {
 	if with_children {
 		// remove all the children that are spending from tx
 		var po btc.TxPrevOut
@@ -593,6 +716,8 @@ func (tx *OneTxToSend) Delete(with_children bool, reason byte) {
 		}
 	}
 
+	tx.del_tx_stats()
+	tx.fee_est_untrack(false, 0)
 	for i := range tx.Spent {
 		delete(SpentOutputs, tx.Spent[i])
 	}
@@ -600,6 +725,7 @@ func (tx *OneTxToSend) Delete(with_children bool, reason byte) {
 	TransactionsToSendSize -= uint64(len(tx.Raw))
 	TransactionsToSendWeight -= uint64(tx.Weight())
 	delete(TransactionsToSend, tx.Hash.BIdx())
+	delete(WTxIDs, tx.WTxID().BIdx())
 	if reason != 0 {
 		RejectTx(tx.Tx, reason)
 	}
@@ -642,6 +768,11 @@ func deleteRejected(bidx BIDX) {
 		if tr.Tx != nil {
 			TransactionsRejectedSize -= uint64(TransactionsRejected[bidx].Size)
 		}
+		if tr.WTxID != nil {
+			if k, ok := RejectedWTxIDs[tr.WTxID.BIdx()]; ok && k == bidx {
+				delete(RejectedWTxIDs, tr.WTxID.BIdx())
+			}
+		}
 		delete(TransactionsRejected, bidx)
 	}
 }
@@ -652,6 +783,27 @@ func RemoveFromRejected(hash *btc.Uint256) {
 	TxMutex.Unlock()
 }
 
+// FetchSpentOutputs sets tx.Spent_outputs (needed to verify taproot inputs),
+// looking for the inputs in the memory pool first and then in the UTXO set.
+// If any of the inputs is unknown, tx.Spent_outputs is left nil.
+func FetchSpentOutputs(tx *btc.Tx) {
+	outs := make([]*btc.TxOut, len(tx.TxIn))
+	for i := range tx.TxIn {
+		inp := &tx.TxIn[i].Input
+		if txinmem, ok := TransactionsToSend[btc.BIdx(inp.Hash[:])]; ok {
+			if int(inp.Vout) < len(txinmem.TxOut) {
+				outs[i] = txinmem.TxOut[inp.Vout]
+			}
+		} else {
+			outs[i] = common.BlockChain.Unspent.UnspentGet(inp)
+		}
+		if outs[i] == nil {
+			return
+		}
+	}
+	tx.Spent_outputs = outs
+}
+
 func SubmitLocalTx(tx *btc.Tx, rawtx []byte) bool {
 	return HandleNetTx(&TxRcvd{Tx: tx, trusted: true, local: true}, true)
 }
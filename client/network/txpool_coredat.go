@@ -0,0 +1,183 @@
+package network
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+/*
+Import and export of Bitcoin Core's mempool.dat, to move the mempool between the nodes.
+Its format (all the numbers little endian):
+ - uint64 version: 1, or 2 followed by var_int length and an XOR key, that all the rest is obfuscated with
+   (each byte is XORed with the key's byte at the file offset modulo the key length)
+ - uint64 number of txs, followed by each tx: raw tx (with witness), int64 entry time, int64 fee delta
+ - var_int number of fee deltas of txs that are not in the mempool, each as: txid and int64 delta
+ - var_int number of unbroadcast txids, each as 32 bytes txid
+*/
+
+const (
+	CORE_MEMPOOL_VERSION_NO_XOR = 1
+	CORE_MEMPOOL_VERSION        = 2
+	CORE_MEMPOOL_XOR_KEY_LEN    = 8
+)
+
+// xor_writer obfuscates the data written to the file with the key (like Core's AutoFile).
+type xor_writer struct {
+	wr  io.Writer
+	key []byte
+	pos int // current offset in the file
+}
+
+func (x *xor_writer) Write(p []byte) (n int, er error) {
+	buf := make([]byte, len(p))
+	for i := range p {
+		buf[i] = p[i] ^ x.key[(x.pos+i)%len(x.key)]
+	}
+	n, er = x.wr.Write(buf)
+	x.pos += n
+	return
+}
+
+// MempoolExportCore saves the mempool in Bitcoin Core's mempool.dat format (version 1 if v1 is true).
+func MempoolExportCore(fname string, v1 bool) (cnt int, er error) {
+	TxMutex.Lock()
+	defer TxMutex.Unlock()
+
+	er = write_file_atomic(fname, func(fwr io.Writer) (er error) {
+		ew := &err_writer{Writer: fwr}
+		var wr io.Writer = ew
+		if v1 {
+			binary.Write(wr, binary.LittleEndian, uint64(CORE_MEMPOOL_VERSION_NO_XOR))
+		} else {
+			key := make([]byte, CORE_MEMPOOL_XOR_KEY_LEN)
+			rand.Read(key)
+			binary.Write(wr, binary.LittleEndian, uint64(CORE_MEMPOOL_VERSION))
+			btc.WriteVlen(wr, uint64(len(key)))
+			wr.Write(key)
+			wr = &xor_writer{wr: ew, key: key, pos: 8 + btc.VLenSize(uint64(len(key))) + len(key)}
+		}
+
+		binary.Write(wr, binary.LittleEndian, uint64(len(TransactionsToSend)))
+		for _, t2s := range GetSortedMempool() {
+			wr.Write(t2s.Raw)
+			binary.Write(wr, binary.LittleEndian, t2s.Firstseen.Unix())
+			binary.Write(wr, binary.LittleEndian, t2s.FeeDelta)
+			cnt++
+		}
+
+		var deltas []*OneFeeDelta
+		for k, fd := range FeeDeltas {
+			if _, ok := TransactionsToSend[k]; !ok {
+				deltas = append(deltas, fd)
+			}
+		}
+		btc.WriteVlen(wr, uint64(len(deltas)))
+		for _, fd := range deltas {
+			wr.Write(fd.Id.Hash[:])
+			binary.Write(wr, binary.LittleEndian, fd.Delta)
+		}
+
+		wr.Write([]byte{0}) // no unbroadcast txs
+		return ew.er
+	})
+	return
+}
+
+// read_core_mempool parses Bitcoin Core's mempool.dat file.
+func read_core_mempool(fname string) (txs []*OneTxToSend, deltas []*OneFeeDelta, er error) {
+	var version, cnt uint64
+	var tm, delta int64
+
+	d, er := ioutil.ReadFile(fname)
+	if er != nil {
+		return
+	}
+	rd := bytes.NewReader(d)
+	if er = binary.Read(rd, binary.LittleEndian, &version); er != nil {
+		return
+	}
+	switch version {
+	case CORE_MEMPOOL_VERSION_NO_XOR:
+	case CORE_MEMPOOL_VERSION:
+		if cnt, er = btc.ReadVLen(rd); er != nil {
+			return
+		}
+		if cnt == 0 || cnt > 64 {
+			er = errors.New("bad XOR key length")
+			return
+		}
+		key := make([]byte, int(cnt))
+		if _, er = io.ReadFull(rd, key); er != nil {
+			return
+		}
+		for pos := len(d) - rd.Len(); pos < len(d); pos++ {
+			d[pos] ^= key[pos%len(key)]
+		}
+	default:
+		er = errors.New(fmt.Sprint("unsupported version ", version))
+		return
+	}
+
+	if er = binary.Read(rd, binary.LittleEndian, &cnt); er != nil {
+		return
+	}
+	for ; cnt > 0; cnt-- {
+		pos := len(d) - rd.Len()
+		tx, le := btc.NewTx(d[pos:])
+		if tx == nil {
+			er = errors.New(fmt.Sprint("tx parse error at offset ", pos))
+			return
+		}
+		tx.SetHash(d[pos : pos+le])
+		rd.Seek(int64(le), io.SeekCurrent)
+		if er = binary.Read(rd, binary.LittleEndian, &tm); er != nil {
+			return
+		}
+		if er = binary.Read(rd, binary.LittleEndian, &delta); er != nil {
+			return
+		}
+		txs = append(txs, &OneTxToSend{Tx: tx, Firstseen: time.Unix(tm, 0), FeeDelta: delta})
+	}
+
+	if cnt, er = btc.ReadVLen(rd); er != nil {
+		return
+	}
+	for ; cnt > 0; cnt-- {
+		fd := &OneFeeDelta{Id: new(btc.Uint256)}
+		if _, er = io.ReadFull(rd, fd.Id.Hash[:]); er != nil {
+			return
+		}
+		if er = binary.Read(rd, binary.LittleEndian, &fd.Delta); er != nil {
+			return
+		}
+		deltas = append(deltas, fd)
+	}
+	// we do not need the unbroadcast txids that follow
+	return
+}
+
+// MempoolImportCore loads txs (with their entry times and fee deltas) from Bitcoin Core's mempool.dat.
+// It must be called from the chain's thread, with TxMutex unlocked.
+func MempoolImportCore(fname string, abort *bool) (er error) {
+	txs, deltas, er := read_core_mempool(fname)
+	if er != nil {
+		return
+	}
+	TxMutex.Lock()
+	for _, fd := range deltas {
+		if FeeDeltas[fd.Id.BIdx()] == nil {
+			set_fee_delta(fd.Id, fd.Delta)
+		}
+	}
+	TxMutex.Unlock()
+	submit_loaded_txs(txs, fname, abort)
+	return
+}
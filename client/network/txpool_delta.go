@@ -0,0 +1,50 @@
+package network
+
+import (
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+/*
+Fee deltas (like Bitcoin Core's prioritisetransaction) make a tx look as if it paid more
+(or less) fee, when choosing txs for a new block and when evicting them from a full mempool.
+A delta can be set before the tx comes to the mempool and it is kept till the tx gets mined.
+*/
+
+// OneFeeDelta is a fee modification of the given txid.
+type OneFeeDelta struct {
+	Id    *btc.Uint256
+	Delta int64 // in satoshis
+}
+
+var (
+	FeeDeltas map[BIDX]*OneFeeDelta = make(map[BIDX]*OneFeeDelta)
+)
+
+// set_fee_delta adds the given delta to the tx's one (removing it, if it gets to zero).
+// Make sure to call it with locked TxMutex.
+func set_fee_delta(id *btc.Uint256, delta int64) {
+	fd := FeeDeltas[id.BIdx()]
+	if fd == nil {
+		fd = &OneFeeDelta{Id: id}
+		FeeDeltas[id.BIdx()] = fd
+	}
+	fd.Delta += delta
+	if fd.Delta == 0 {
+		delete(FeeDeltas, id.BIdx())
+	}
+	if t2s := TransactionsToSend[id.BIdx()]; t2s != nil {
+		t2s.FeeDelta = fd.Delta
+	}
+}
+
+// PrioritiseTx adds the delta (in satoshis) to the fee of the given tx, as used by mining and eviction.
+func PrioritiseTx(id *btc.Uint256, delta int64) {
+	TxMutex.Lock()
+	set_fee_delta(id, delta)
+	TxMutex.Unlock()
+}
+
+// ModifiedFee returns the tx's fee with its delta applied.
+func (t2s *OneTxToSend) ModifiedFee() int64 {
+	return int64(t2s.Fee) + t2s.FeeDelta
+}
@@ -3,238 +3,466 @@ package network
 import (
 	"bufio"
 	"bytes"
+	"crypto/sha256"
 	"encoding/binary"
 	"errors"
 	"fmt"
-	"github.com/piotrnar/gocoin/client/common"
-	"github.com/piotrnar/gocoin/lib/btc"
 	"io"
+	"io/ioutil"
 	"os"
 	"time"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/lib/btc"
 )
 
+/*
+Format of the mempool file (version 2), all the numbers little endian:
+ - MEMPOOL_FILE_MAGIC and uint32 version
+ - hash of the last block that the mempool is valid for
+ - var_int number of txs, followed by each tx's record (parents before their children):
+   - var_int length and the raw tx
+   - int64 entry time (unix seconds) and int64 fee delta (see PrioritiseTx)
+   - uint64 fee, uint64 sigops cost, int64 verify time (ns)
+   - uint32 inv sent count, uint32 sent count, int64 last sent time (unix seconds)
+   - uint64 package fee per kB
+   - byte flags (1 - local, 2 - final), byte blocked reason
+ - var_int number of fee deltas of txs that are not in the mempool, each as: txid and int64 delta
+ - END_MARKER
+ - SHA256 of all the above
+The file is first written as a temporary one and then renamed, so a crash cannot leave it half written.
+Version 1 files (raw layout of OneTxToSend without any header) can still be loaded.
+*/
+
 var (
 	END_MARKER = []byte("END_OF_FILE")
 )
 
 const (
-	MEMPOOL_FILE_NAME2 = "mempool.dmp"
+	MEMPOOL_FILE_NAME2   = "mempool.dmp"
+	MEMPOOL_FILE_MAGIC   = "GOCNMPDT"
+	MEMPOOL_FILE_VERSION = 2
+
+	MEMPOOL_FLAG_LOCAL = 1
+	MEMPOOL_FLAG_FINAL = 2
 )
 
-func bool2byte(v bool) byte {
-	if v {
-		return 1
-	} else {
-		return 0
-	}
+// mempool_dump is the content of a mempool file.
+type mempool_dump struct {
+	version    uint32
+	last_block [32]byte
+	txs        []*OneTxToSend // only the fields stored in the file are set
+	deltas     []*OneFeeDelta // of the txs that are not in the mempool
 }
 
+// WriteBytes stores the tx's record in the mempool file format.
 func (t2s *OneTxToSend) WriteBytes(wr io.Writer) {
 	btc.WriteVlen(wr, uint64(len(t2s.Raw)))
 	wr.Write(t2s.Raw)
 
-	btc.WriteVlen(wr, uint64(len(t2s.Spent)))
-	binary.Write(wr, binary.LittleEndian, t2s.Spent[:])
-
-	binary.Write(wr, binary.LittleEndian, t2s.Invsentcnt)
-	binary.Write(wr, binary.LittleEndian, t2s.SentCnt)
-	binary.Write(wr, binary.LittleEndian, uint32(t2s.Firstseen.Unix()))
-	binary.Write(wr, binary.LittleEndian, uint32(t2s.Lastsent.Unix()))
-	binary.Write(wr, binary.LittleEndian, t2s.Volume)
+	binary.Write(wr, binary.LittleEndian, t2s.Firstseen.Unix())
+	binary.Write(wr, binary.LittleEndian, t2s.FeeDelta)
 	binary.Write(wr, binary.LittleEndian, t2s.Fee)
 	binary.Write(wr, binary.LittleEndian, t2s.SigopsCost)
 	binary.Write(wr, binary.LittleEndian, t2s.VerifyTime)
-	wr.Write([]byte{bool2byte(t2s.Local), t2s.Blocked, bool2byte(t2s.MemInputs != nil), bool2byte(t2s.Final)})
+	binary.Write(wr, binary.LittleEndian, t2s.Invsentcnt)
+	binary.Write(wr, binary.LittleEndian, t2s.SentCnt)
+	binary.Write(wr, binary.LittleEndian, t2s.Lastsent.Unix())
+	binary.Write(wr, binary.LittleEndian, t2s.PkgFeeSPKB)
+	var flags byte
+	if t2s.Local {
+		flags |= MEMPOOL_FLAG_LOCAL
+	}
+	if t2s.Final {
+		flags |= MEMPOOL_FLAG_FINAL
+	}
+	wr.Write([]byte{flags, t2s.Blocked})
 }
 
-func MempoolSave(force bool) {
-	if !force && !common.CFG.TXPool.SaveOnDisk {
-		os.Remove(common.GocoinHomeDir + MEMPOOL_FILE_NAME2)
+// read_raw_tx reads var_int length, followed by a raw tx.
+func read_raw_tx(rd io.Reader) (tx *btc.Tx, er error) {
+	var le uint64
+	var i int
+	if le, er = btc.ReadVLen(rd); er != nil {
 		return
 	}
-
-	f, er := os.Create(common.GocoinHomeDir + MEMPOOL_FILE_NAME2)
-	if er != nil {
-		println(er.Error())
+	if le > btc.MAX_BLOCK_WEIGHT {
+		er = errors.New("tx too big")
 		return
 	}
-
-	fmt.Println("Saving", MEMPOOL_FILE_NAME2)
-	wr := bufio.NewWriter(f)
-
-	wr.Write(common.Last.Block.BlockHash.Hash[:])
-
-	btc.WriteVlen(wr, uint64(len(TransactionsToSend)))
-	for _, t2s := range TransactionsToSend {
-		t2s.WriteBytes(wr)
+	raw := make([]byte, int(le))
+	if _, er = io.ReadFull(rd, raw); er != nil {
+		return
+	}
+	if tx, i = btc.NewTx(raw); tx == nil || i != len(raw) {
+		tx = nil
+		er = errors.New("tx parse error")
+		return
 	}
+	tx.SetHash(raw)
+	return
+}
 
-	btc.WriteVlen(wr, uint64(len(SpentOutputs)))
-	for k, v := range SpentOutputs {
-		binary.Write(wr, binary.LittleEndian, k)
-		binary.Write(wr, binary.LittleEndian, v)
+// read_tx_record reads one tx's record written by WriteBytes.
+func read_tx_record(rd io.Reader) (t2s *OneTxToSend, er error) {
+	var v struct {
+		Firstseen  int64
+		FeeDelta   int64
+		Fee        uint64
+		SigopsCost uint64
+		VerifyTime int64
+		Invsentcnt uint32
+		SentCnt    uint32
+		Lastsent   int64
+		PkgFeeSPKB uint64
+		Flags      byte
+		Blocked    byte
+	}
+	t2s = new(OneTxToSend)
+	if t2s.Tx, er = read_raw_tx(rd); er != nil {
+		return
+	}
+	if er = binary.Read(rd, binary.LittleEndian, &v); er != nil {
+		return
 	}
+	t2s.Firstseen = time.Unix(v.Firstseen, 0)
+	t2s.FeeDelta = v.FeeDelta
+	t2s.Fee = v.Fee
+	t2s.SigopsCost = v.SigopsCost
+	t2s.VerifyTime = time.Duration(v.VerifyTime)
+	t2s.Invsentcnt = v.Invsentcnt
+	t2s.SentCnt = v.SentCnt
+	t2s.Lastsent = time.Unix(v.Lastsent, 0)
+	t2s.PkgFeeSPKB = v.PkgFeeSPKB
+	t2s.Local = (v.Flags & MEMPOOL_FLAG_LOCAL) != 0
+	t2s.Final = (v.Flags & MEMPOOL_FLAG_FINAL) != 0
+	t2s.Blocked = v.Blocked
+	return
+}
 
-	wr.Write(END_MARKER[:])
-	wr.Flush()
-	f.Close()
+// read_tx_record_v1 reads one tx's record from a version 1 file.
+func read_tx_record_v1(rd io.Reader) (t2s *OneTxToSend, er error) {
+	var le uint64
+	var tina uint32
+	var tmp [4]byte
+	t2s = new(OneTxToSend)
+	if t2s.Tx, er = read_raw_tx(rd); er != nil {
+		return
+	}
+	if le, er = btc.ReadVLen(rd); er != nil {
+		return
+	}
+	if le > uint64(len(t2s.TxIn)) {
+		er = errors.New("spent records mismatch")
+		return
+	}
+	if _, er = io.CopyN(ioutil.Discard, rd, int64(8*le)); er != nil {
+		return // we rebuild it from the tx inputs
+	}
+	if er = binary.Read(rd, binary.LittleEndian, &t2s.Invsentcnt); er != nil {
+		return
+	}
+	if er = binary.Read(rd, binary.LittleEndian, &t2s.SentCnt); er != nil {
+		return
+	}
+	if er = binary.Read(rd, binary.LittleEndian, &tina); er != nil {
+		return
+	}
+	t2s.Firstseen = time.Unix(int64(tina), 0)
+	if er = binary.Read(rd, binary.LittleEndian, &tina); er != nil {
+		return
+	}
+	t2s.Lastsent = time.Unix(int64(tina), 0)
+	if er = binary.Read(rd, binary.LittleEndian, &t2s.Volume); er != nil {
+		return
+	}
+	if er = binary.Read(rd, binary.LittleEndian, &t2s.Fee); er != nil {
+		return
+	}
+	if er = binary.Read(rd, binary.LittleEndian, &t2s.SigopsCost); er != nil {
+		return
+	}
+	if er = binary.Read(rd, binary.LittleEndian, &t2s.VerifyTime); er != nil {
+		return
+	}
+	if _, er = io.ReadFull(rd, tmp[:4]); er != nil {
+		return
+	}
+	t2s.Local = tmp[0] != 0
+	t2s.Blocked = tmp[1]
+	t2s.Final = tmp[3] != 0
+	return
 }
 
-func MempoolLoad2() bool {
+// read_mempool_dump reads and verifies the mempool file of any version.
+func read_mempool_dump(fname string) (md *mempool_dump, er error) {
+	var cnt uint64
 	var t2s *OneTxToSend
-	var totcnt, le uint64
-	var tmp [32]byte
-	var bi BIDX
-	var tina uint32
-	var i int
-	var cnt1, cnt2 uint
+	var magic [8]byte
 
-	f, er := os.Open(common.GocoinHomeDir + MEMPOOL_FILE_NAME2)
+	f, er := os.Open(fname)
 	if er != nil {
-		fmt.Println("MempoolLoad:", er.Error())
-		return false
+		return
 	}
 	defer f.Close()
 
-	rd := bufio.NewReader(f)
-	if _, er = io.ReadFull(rd, tmp[:32]); er != nil {
-		goto fatal_error
+	md = new(mempool_dump)
+	frd := bufio.NewReader(f)
+	if _, er = io.ReadFull(frd, magic[:]); er != nil {
+		return
 	}
-	if !bytes.Equal(tmp[:32], common.Last.Block.BlockHash.Hash[:]) {
-		er = errors.New(MEMPOOL_FILE_NAME2 + " is for different last block hash (try to load it with 'mpl' command)")
-		goto fatal_error
+
+	if string(magic[:]) != MEMPOOL_FILE_MAGIC {
+		// version 1 - it starts with the last block hash
+		md.version = 1
+		rd := io.MultiReader(bytes.NewReader(magic[:]), frd)
+		if _, er = io.ReadFull(rd, md.last_block[:]); er != nil {
+			return
+		}
+		if cnt, er = btc.ReadVLen(rd); er != nil {
+			return
+		}
+		for ; cnt > 0; cnt-- {
+			if t2s, er = read_tx_record_v1(rd); er != nil {
+				return
+			}
+			md.txs = append(md.txs, t2s)
+		}
+		if cnt, er = btc.ReadVLen(rd); er != nil {
+			return
+		}
+		if _, er = io.CopyN(ioutil.Discard, rd, int64(16*cnt)); er != nil {
+			return // SpentOutputs - we rebuild it from the txs
+		}
+		var tmp [32]byte
+		if _, er = io.ReadFull(rd, tmp[:len(END_MARKER)]); er != nil || !bytes.Equal(tmp[:len(END_MARKER)], END_MARKER) {
+			er = errors.New("marker missing")
+		}
+		return
 	}
 
-	if totcnt, er = btc.ReadVLen(rd); er != nil {
-		goto fatal_error
+	sha := sha256.New()
+	sha.Write(magic[:])
+	rd := io.TeeReader(frd, sha)
+	if er = binary.Read(rd, binary.LittleEndian, &md.version); er != nil {
+		return
+	}
+	if md.version != MEMPOOL_FILE_VERSION {
+		er = errors.New(fmt.Sprint("unsupported version ", md.version))
+		return
+	}
+	if _, er = io.ReadFull(rd, md.last_block[:]); er != nil {
+		return
 	}
 
-	TransactionsToSend = make(map[BIDX]*OneTxToSend, int(totcnt))
-	for ; totcnt > 0; totcnt-- {
-		le, er = btc.ReadVLen(rd)
-		if er != nil {
-			goto fatal_error
+	if cnt, er = btc.ReadVLen(rd); er != nil {
+		return
+	}
+	for ; cnt > 0; cnt-- {
+		if t2s, er = read_tx_record(rd); er != nil {
+			return
 		}
+		md.txs = append(md.txs, t2s)
+	}
 
-		t2s = new(OneTxToSend)
-		raw := make([]byte, int(le))
-
-		_, er = io.ReadFull(rd, raw)
-		if er != nil {
-			goto fatal_error
+	if cnt, er = btc.ReadVLen(rd); er != nil {
+		return
+	}
+	for ; cnt > 0; cnt-- {
+		fd := &OneFeeDelta{Id: new(btc.Uint256)}
+		if _, er = io.ReadFull(rd, fd.Id.Hash[:]); er != nil {
+			return
 		}
-
-		t2s.Tx, i = btc.NewTx(raw)
-		if t2s.Tx == nil || i != len(raw) {
-			er = errors.New(fmt.Sprint("Error parsing tx from ", MEMPOOL_FILE_NAME2, " at idx", len(TransactionsToSend)))
-			goto fatal_error
+		if er = binary.Read(rd, binary.LittleEndian, &fd.Delta); er != nil {
+			return
 		}
-		t2s.Tx.SetHash(raw)
+		md.deltas = append(md.deltas, fd)
+	}
 
-		le, er = btc.ReadVLen(rd)
-		if er != nil {
-			goto fatal_error
-		}
-		t2s.Spent = make([]uint64, int(le))
-		if er = binary.Read(rd, binary.LittleEndian, t2s.Spent[:]); er != nil {
-			goto fatal_error
-		}
+	var tmp [32]byte
+	if _, er = io.ReadFull(rd, tmp[:len(END_MARKER)]); er != nil {
+		return
+	}
+	if !bytes.Equal(tmp[:len(END_MARKER)], END_MARKER) {
+		er = errors.New("marker missing")
+		return
+	}
+	if _, er = io.ReadFull(frd, tmp[:]); er != nil {
+		return
+	}
+	if !bytes.Equal(tmp[:], sha.Sum(nil)) {
+		er = errors.New("checksum mismatch")
+	}
+	return
+}
 
-		if er = binary.Read(rd, binary.LittleEndian, &t2s.Invsentcnt); er != nil {
-			goto fatal_error
+// write_file_atomic writes the file via a temporary one, so there is never a partially written file.
+func write_file_atomic(fname string, write func(wr io.Writer) error) (er error) {
+	tmpname := fname + ".tmp"
+	f, er := os.Create(tmpname)
+	if er != nil {
+		return
+	}
+	wr := bufio.NewWriter(f)
+	if er = write(wr); er == nil {
+		if er = wr.Flush(); er == nil {
+			er = f.Sync()
 		}
+	}
+	if e := f.Close(); er == nil {
+		er = e
+	}
+	if er == nil {
+		er = os.Rename(tmpname, fname)
+	}
+	if er != nil {
+		os.Remove(tmpname)
+	}
+	return
+}
 
-		if er = binary.Read(rd, binary.LittleEndian, &t2s.SentCnt); er != nil {
-			goto fatal_error
-		}
+// err_writer remembers the first write error and fails all the following writes with it,
+// so the data written by functions ignoring the errors do not need to be checked one by one.
+type err_writer struct {
+	io.Writer
+	er error
+}
 
-		if er = binary.Read(rd, binary.LittleEndian, &tina); er != nil {
-			goto fatal_error
-		}
-		t2s.Firstseen = time.Unix(int64(tina), 0)
+func (w *err_writer) Write(p []byte) (n int, er error) {
+	if w.er != nil {
+		return 0, w.er
+	}
+	n, w.er = w.Writer.Write(p)
+	return n, w.er
+}
 
-		if er = binary.Read(rd, binary.LittleEndian, &tina); er != nil {
-			goto fatal_error
-		}
-		t2s.Lastsent = time.Unix(int64(tina), 0)
+// MempoolSave stores the mempool on disk (if force is false, only if TXPool.SaveOnDisk is set).
+func MempoolSave(force bool) (er error) {
+	if !force && !common.CFG.TXPool.SaveOnDisk {
+		os.Remove(common.GocoinHomeDir + MEMPOOL_FILE_NAME2)
+		return
+	}
 
-		if er = binary.Read(rd, binary.LittleEndian, &t2s.Volume); er != nil {
-			goto fatal_error
-		}
+	fmt.Println("Saving", MEMPOOL_FILE_NAME2)
+	TxMutex.Lock()
+	defer TxMutex.Unlock()
 
-		if er = binary.Read(rd, binary.LittleEndian, &t2s.Fee); er != nil {
-			goto fatal_error
-		}
+	er = write_file_atomic(common.GocoinHomeDir+MEMPOOL_FILE_NAME2, func(fwr io.Writer) error {
+		sha := sha256.New()
+		wr := &err_writer{Writer: io.MultiWriter(fwr, sha)}
 
-		if er = binary.Read(rd, binary.LittleEndian, &t2s.SigopsCost); er != nil {
-			goto fatal_error
-		}
+		wr.Write([]byte(MEMPOOL_FILE_MAGIC))
+		binary.Write(wr, binary.LittleEndian, uint32(MEMPOOL_FILE_VERSION))
+		wr.Write(common.Last.Block.BlockHash.Hash[:])
 
-		if er = binary.Read(rd, binary.LittleEndian, &t2s.VerifyTime); er != nil {
-			goto fatal_error
+		btc.WriteVlen(wr, uint64(len(TransactionsToSend)))
+		for _, t2s := range GetSortedMempool() {
+			t2s.WriteBytes(wr)
 		}
 
-		if _, er = io.ReadFull(rd, tmp[:4]); er != nil {
-			goto fatal_error
+		var deltas []*OneFeeDelta
+		for k, fd := range FeeDeltas {
+			if _, ok := TransactionsToSend[k]; !ok {
+				deltas = append(deltas, fd)
+			}
 		}
-		t2s.Local = tmp[0] != 0
-		t2s.Blocked = tmp[1]
-		if tmp[2] != 0 {
-			t2s.MemInputs = make([]bool, len(t2s.TxIn))
+		btc.WriteVlen(wr, uint64(len(deltas)))
+		for _, fd := range deltas {
+			wr.Write(fd.Id.Hash[:])
+			binary.Write(wr, binary.LittleEndian, fd.Delta)
 		}
-		t2s.Final = tmp[3] != 0
-
-		t2s.Tx.Fee = t2s.Fee
 
-		TransactionsToSend[t2s.Hash.BIdx()] = t2s
-		TransactionsToSendSize += uint64(len(t2s.Raw))
-		TransactionsToSendWeight += uint64(t2s.Weight())
+		wr.Write(END_MARKER[:])
+		if wr.er != nil {
+			return wr.er
+		}
+		_, er := fwr.Write(sha.Sum(nil))
+		return er
+	})
+	if er != nil {
+		println("MempoolSave:", er.Error())
 	}
+	return
+}
+
+// MempoolLoad2 restores the mempool saved by MempoolSave, if it is for the current last block.
+func MempoolLoad2() bool {
+	var er error
+	var cnt1, cnt2 uint
+	var md *mempool_dump
+
+	TxMutex.Lock()
+	defer TxMutex.Unlock()
 
-	if totcnt, er = btc.ReadVLen(rd); er != nil {
+	if md, er = read_mempool_dump(common.GocoinHomeDir + MEMPOOL_FILE_NAME2); er != nil {
+		if os.IsNotExist(er) {
+			fmt.Println("MempoolLoad:", er.Error())
+			return false
+		}
+		goto fatal_error
+	}
+	if !bytes.Equal(md.last_block[:], common.Last.Block.BlockHash.Hash[:]) {
+		er = errors.New(MEMPOOL_FILE_NAME2 + " is for different last block hash (try to load it with 'mpl' command)")
 		goto fatal_error
 	}
 
-	SpentOutputs = make(map[uint64]BIDX, int(totcnt))
-	for ; totcnt > 0; totcnt-- {
-		if er = binary.Read(rd, binary.LittleEndian, &le); er != nil {
+	TransactionsToSend = make(map[BIDX]*OneTxToSend, len(md.txs))
+	WTxIDs = make(map[BIDX]*OneTxToSend, len(md.txs))
+	SpentOutputs = make(map[uint64]BIDX, 4*len(md.txs))
+	FeeDeltas = make(map[BIDX]*OneFeeDelta)
+	TransactionsToSendSize = 0
+	TransactionsToSendWeight = 0
+	for _, t2s := range md.txs {
+		if _, ok := TransactionsToSend[t2s.Hash.BIdx()]; ok {
+			er = errors.New("duplicate tx " + t2s.Hash.String())
 			goto fatal_error
 		}
-
-		if er = binary.Read(rd, binary.LittleEndian, &bi); er != nil {
-			goto fatal_error
+		t2s.Spent = make([]uint64, len(t2s.TxIn))
+		for i := range t2s.TxIn {
+			t2s.Spent[i] = t2s.TxIn[i].Input.UIdx()
+			if _, ok := SpentOutputs[t2s.Spent[i]]; ok {
+				er = errors.New("double spend in " + t2s.Hash.String())
+				goto fatal_error
+			}
+			SpentOutputs[t2s.Spent[i]] = t2s.Hash.BIdx()
+		}
+		t2s.Volume = t2s.Fee
+		for i := range t2s.TxOut {
+			t2s.Volume += t2s.TxOut[i].Value
+		}
+		t2s.Tx.Fee = t2s.Fee
+		if t2s.FeeDelta != 0 {
+			FeeDeltas[t2s.Hash.BIdx()] = &OneFeeDelta{Id: &t2s.Hash, Delta: t2s.FeeDelta}
 		}
 
-		SpentOutputs[le] = bi
-	}
-
-	if _, er = io.ReadFull(rd, tmp[:len(END_MARKER)]); er != nil {
-		goto fatal_error
+		TransactionsToSend[t2s.Hash.BIdx()] = t2s
+		WTxIDs[t2s.WTxID().BIdx()] = t2s
+		TransactionsToSendSize += uint64(len(t2s.Raw))
+		TransactionsToSendWeight += uint64(t2s.Weight())
 	}
-	if !bytes.Equal(tmp[:len(END_MARKER)], END_MARKER) {
-		er = errors.New(MEMPOOL_FILE_NAME2 + " has marker missing")
-		goto fatal_error
+	for _, fd := range md.deltas {
+		FeeDeltas[fd.Id.BIdx()] = fd
 	}
 
 	// recover MemInputs
 	for _, t2s := range TransactionsToSend {
-		if t2s.MemInputs != nil {
-			cnt1++
-			for i := range t2s.TxIn {
-				if _, inmem := TransactionsToSend[btc.BIdx(t2s.TxIn[i].Input.Hash[:])]; inmem {
-					t2s.MemInputs[i] = true
-					t2s.MemInputCnt++
-					cnt2++
+		for i := range t2s.TxIn {
+			if _, inmem := TransactionsToSend[btc.BIdx(t2s.TxIn[i].Input.Hash[:])]; inmem {
+				if t2s.MemInputs == nil {
+					t2s.MemInputs = make([]bool, len(t2s.TxIn))
+					cnt1++
 				}
-			}
-			if t2s.MemInputCnt == 0 {
-				println("ERROR: MemInputs not nil but nothing found")
-				t2s.MemInputs = nil
+				t2s.MemInputs[i] = true
+				t2s.MemInputCnt++
+				cnt2++
 			}
 		}
 	}
 
-	fmt.Println(len(TransactionsToSend), "transactions taking", TransactionsToSendSize, "Bytes loaded from", MEMPOOL_FILE_NAME2)
+	RebuildTxStats()
+
+	fmt.Println(len(TransactionsToSend), "transactions taking", TransactionsToSendSize, "Bytes loaded from", MEMPOOL_FILE_NAME2,
+		"version", md.version)
 	fmt.Println(cnt1, "transactions use", cnt2, "memory inputs")
 
 	return true
@@ -242,44 +470,25 @@ func MempoolLoad2() bool {
 fatal_error:
 	fmt.Println("Error loading", MEMPOOL_FILE_NAME2, ":", er.Error())
 	TransactionsToSend = make(map[BIDX]*OneTxToSend)
+	WTxIDs = make(map[BIDX]*OneTxToSend)
 	TransactionsToSendSize = 0
 	TransactionsToSendWeight = 0
 	SpentOutputs = make(map[uint64]BIDX)
+	FeeDeltas = make(map[BIDX]*OneFeeDelta)
 	return false
 }
 
-
-// MempoolLoadNew is only called from TextUI.
-func MempoolLoadNew(fname string, abort *bool) bool {
-	var ntx *TxRcvd
-	var idx, totcnt, le, tmp64, oneperc, cntdwn, perc uint64
-	var tmp [32]byte
-	var tina uint32
-	var i int
+// submit_loaded_txs puts the txs loaded from a file into the mempool, the same way as the ones from the network.
+// Each one gets its fee delta and keeps its original entry time.
+// It must be called from the chain's thread, with TxMutex unlocked.
+func submit_loaded_txs(txs []*OneTxToSend, fname string, abort *bool) {
 	var cnt1, cnt2 uint
-	var t2s OneTxToSend
-
-	f, er := os.Open(fname)
-	if er != nil {
-		fmt.Println("MempoolLoad:", er.Error())
-		return false
-	}
-	defer f.Close()
-
-	rd := bufio.NewReader(f)
-	if _, er = io.ReadFull(rd, tmp[:32]); er != nil {
-		goto fatal_error
-	}
-
-	if totcnt, er = btc.ReadVLen(rd); er != nil {
-		goto fatal_error
-	}
-	fmt.Println("Loading", totcnt, "transactions from", fname)
-
-	oneperc = totcnt/100
+	var oneperc, cntdwn, perc int
 
-	for idx = 0; idx < totcnt; idx++ {
-		if cntdwn==0 {
+	fmt.Println("Loading", len(txs), "transactions from", fname)
+	oneperc = len(txs) / 100
+	for _, t2s := range txs {
+		if cntdwn == 0 {
 			fmt.Print("\r", perc, "% complete...")
 			perc++
 			cntdwn = oneperc
@@ -288,66 +497,48 @@ func MempoolLoadNew(fname string, abort *bool) bool {
 		if abort != nil && *abort {
 			break
 		}
-		le, er = btc.ReadVLen(rd)
-		if er != nil {
-			goto fatal_error
-		}
-
-		ntx = new(TxRcvd)
-		raw := make([]byte, int(le))
-
-		_, er = io.ReadFull(rd, raw)
-		if er != nil {
-			goto fatal_error
-		}
-
-		ntx.Tx, i = btc.NewTx(raw)
-		if ntx.Tx == nil || i != len(raw) {
-			er = errors.New(fmt.Sprint("Error parsing tx from ", fname, " at idx", idx))
-			goto fatal_error
-		}
-		ntx.SetHash(raw)
 
-		le, er = btc.ReadVLen(rd)
-		if er != nil {
-			goto fatal_error
-		}
-
-		for le > 0 {
-			if er = binary.Read(rd, binary.LittleEndian, &tmp64); er != nil {
-				goto fatal_error
+		if t2s.FeeDelta != 0 {
+			TxMutex.Lock()
+			if FeeDeltas[t2s.Hash.BIdx()] == nil {
+				set_fee_delta(&t2s.Hash, t2s.FeeDelta)
 			}
-			le--
-		}
-
-		// discard all the rest...
-		binary.Read(rd, binary.LittleEndian, &t2s.Invsentcnt)
-		binary.Read(rd, binary.LittleEndian, &t2s.SentCnt)
-		binary.Read(rd, binary.LittleEndian, &tina)
-		binary.Read(rd, binary.LittleEndian, &tina)
-		binary.Read(rd, binary.LittleEndian, &t2s.Volume)
-		binary.Read(rd, binary.LittleEndian, &t2s.Fee)
-		binary.Read(rd, binary.LittleEndian, &t2s.SigopsCost)
-		binary.Read(rd, binary.LittleEndian, &t2s.VerifyTime)
-		if _, er = io.ReadFull(rd, tmp[:4]); er != nil {
-			goto fatal_error
+			TxMutex.Unlock()
 		}
 
 		// submit tx if we dont have it yet...
-		if NeedThisTx(&ntx.Hash, nil) {
+		if NeedThisTx(&t2s.Hash, nil) {
 			cnt2++
-			if HandleNetTx(ntx, true) {
+			if HandleNetTx(&TxRcvd{Tx: t2s.Tx}, true) {
 				cnt1++
+				TxMutex.Lock()
+				if rec := TransactionsToSend[t2s.Hash.BIdx()]; rec != nil && !t2s.Firstseen.IsZero() {
+					rec.Firstseen = t2s.Firstseen
+				}
+				TxMutex.Unlock()
 			}
 		}
 	}
 
 	fmt.Print("\r                                    \r")
 	fmt.Println(cnt1, "out of", cnt2, "new transactions accepted into memory pool")
+}
 
+// MempoolLoadNew is only called from TextUI.
+// It loads txs from a file saved by MempoolSave (of any version and for any last block).
+func MempoolLoadNew(fname string, abort *bool) bool {
+	md, er := read_mempool_dump(fname)
+	if er != nil {
+		fmt.Println("Error loading", fname, ":", er.Error())
+		return false
+	}
+	TxMutex.Lock()
+	for _, fd := range md.deltas {
+		if FeeDeltas[fd.Id.BIdx()] == nil {
+			set_fee_delta(fd.Id, fd.Delta)
+		}
+	}
+	TxMutex.Unlock()
+	submit_loaded_txs(md.txs, fname, abort)
 	return true
-
-fatal_error:
-	fmt.Println("Error loading", fname, ":", er.Error())
-	return false
 }
@@ -0,0 +1,203 @@
+package network
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+/*
+Each mempool tx keeps the number, the virtual size and the fees of its unconfirmed
+ancestors and descendants (the tx itself included), like Bitcoin Core does.
+They are used to limit the chains of unconfirmed txs (see TXPool.MaxAncestors etc.)
+and to choose which txs to evict, when the mempool is full (descendant score).
+*/
+
+// TxStats holds the totals of a tx with all its ancestors, or all its descendants.
+type TxStats struct {
+	Cnt   int
+	VSize uint64
+	Fee   uint64
+}
+
+func (st *TxStats) add(t2s *OneTxToSend) {
+	st.Cnt++
+	st.VSize += uint64(t2s.VSize())
+	st.Fee += t2s.Fee
+}
+
+func (st *TxStats) sub(t2s *OneTxToSend) {
+	st.Cnt--
+	st.VSize -= uint64(t2s.VSize())
+	st.Fee -= t2s.Fee
+}
+
+// mempool_ancestors returns all the unconfirmed ancestors of the tx spending the given mempool inputs.
+// Unlike GetAllParents, it skips parents that are not in the mempool anymore.
+// Make sure to call it with locked TxMutex.
+func mempool_ancestors(tx *btc.Tx, mem_inputs []bool) (result []*OneTxToSend) {
+	already_in := make(map[*OneTxToSend]bool)
+	var do_one func(tx *btc.Tx, mem_inputs []bool)
+	do_one = func(tx *btc.Tx, mem_inputs []bool) {
+		for idx := range mem_inputs {
+			if !mem_inputs[idx] {
+				continue
+			}
+			if par := TransactionsToSend[btc.BIdx(tx.TxIn[idx].Input.Hash[:])]; par != nil && !already_in[par] {
+				already_in[par] = true
+				result = append(result, par)
+				do_one(par.Tx, par.MemInputs)
+			}
+		}
+	}
+	do_one(tx, mem_inputs)
+	return
+}
+
+// check_tx_limits checks if a new tx (of the given vsize) spending the given mempool inputs,
+// would not exceed the ancestor or the descendant limits.
+// The evict list (RBFResult.Evict) contains the txs that the new one replaces,
+// so they are not counted as the descendants of its ancestors.
+// Make sure to call it with locked TxMutex.
+func check_tx_limits(tx *btc.Tx, mem_inputs []bool, evict []*OneTxToSend) (reason byte) {
+	ancestors := mempool_ancestors(tx, mem_inputs)
+	vsize := uint64(tx.VSize())
+
+	anc := TxStats{Cnt: 1, VSize: vsize}
+	for _, a := range ancestors {
+		anc.add(a)
+	}
+	if anc.Cnt > int(common.GetUint32(&common.CFG.TXPool.MaxAncestors)) {
+		return TX_REJECTED_ANC_COUNT
+	}
+	if anc.VSize > 1000*uint64(common.GetUint32(&common.CFG.TXPool.MaxAncestorsKB)) {
+		return TX_REJECTED_ANC_SIZE
+	}
+
+	gone := make(map[*OneTxToSend]*TxStats)
+	for _, e := range evict {
+		for _, a := range mempool_ancestors(e.Tx, e.MemInputs) {
+			if gone[a] == nil {
+				gone[a] = new(TxStats)
+			}
+			gone[a].add(e)
+		}
+	}
+
+	for _, a := range ancestors {
+		desc := a.Descendants
+		if g := gone[a]; g != nil {
+			desc.Cnt -= g.Cnt
+			desc.VSize -= g.VSize
+		}
+		if desc.Cnt+1 > int(common.GetUint32(&common.CFG.TXPool.MaxDescendants)) {
+			return TX_REJECTED_DESC_COUNT
+		}
+		if desc.VSize+vsize > 1000*uint64(common.GetUint32(&common.CFG.TXPool.MaxDescendantsKB)) {
+			return TX_REJECTED_DESC_SIZE
+		}
+	}
+	return
+}
+
+// add_tx_stats sets the ancestor stats of a new mempool tx and updates the descendant stats of its ancestors.
+// Make sure to call it with locked TxMutex.
+func (t2s *OneTxToSend) add_tx_stats() {
+	t2s.Ancestors = TxStats{}
+	t2s.Ancestors.add(t2s)
+	t2s.Descendants = t2s.Ancestors
+	for _, a := range mempool_ancestors(t2s.Tx, t2s.MemInputs) {
+		t2s.Ancestors.add(a)
+		a.Descendants.add(t2s)
+	}
+}
+
+// del_tx_stats updates the stats of all the ancestors and descendants of the tx being removed from the mempool.
+// Make sure to call it with locked TxMutex, before the tx gets removed from SpentOutputs.
+func (t2s *OneTxToSend) del_tx_stats() {
+	for _, a := range mempool_ancestors(t2s.Tx, t2s.MemInputs) {
+		a.Descendants.sub(t2s)
+	}
+	for _, d := range t2s.GetAllChildren() {
+		d.Ancestors.sub(t2s)
+	}
+}
+
+// RebuildTxStats recalculates ancestor and descendant stats of all the mempool txs.
+// Make sure to call it with locked TxMutex.
+func RebuildTxStats() {
+	for _, t2s := range TransactionsToSend {
+		t2s.Ancestors = TxStats{}
+		t2s.Ancestors.add(t2s)
+		t2s.Descendants = t2s.Ancestors
+	}
+	for _, t2s := range TransactionsToSend {
+		for _, a := range mempool_ancestors(t2s.Tx, t2s.MemInputs) {
+			t2s.Ancestors.add(a)
+			a.Descendants.add(t2s)
+		}
+	}
+}
+
+// checkTxStats verifies the ancestor and descendant stats (used by MempoolCheck).
+func checkTxStats() (dupa bool) {
+	for _, t2s := range TransactionsToSend {
+		anc := TxStats{}
+		anc.add(t2s)
+		for _, a := range mempool_ancestors(t2s.Tx, t2s.MemInputs) {
+			anc.add(a)
+		}
+		desc := TxStats{}
+		desc.add(t2s)
+		for _, d := range t2s.GetAllChildren() {
+			desc.add(d)
+		}
+		if anc != t2s.Ancestors {
+			fmt.Println("Tx", t2s.Hash.String(), "has ancestor stats", t2s.Ancestors, "instead of", anc)
+			dupa = true
+		}
+		if desc != t2s.Descendants {
+			fmt.Println("Tx", t2s.Hash.String(), "has descendant stats", t2s.Descendants, "instead of", desc)
+			dupa = true
+		}
+	}
+	return
+}
+
+// DescendantScore returns the higher of: the tx's fee per kB and its fee per kB with all its descendants.
+// The tx's own fee delta (see PrioritiseTx) is taken into account.
+func (t2s *OneTxToSend) DescendantScore() uint64 {
+	var own, desc uint64
+	if fee := t2s.ModifiedFee(); fee > 0 {
+		own = 1000 * uint64(fee) / uint64(t2s.VSize())
+	}
+	if t2s.Descendants.VSize == 0 {
+		return own
+	}
+	if fee := int64(t2s.Descendants.Fee) + t2s.FeeDelta; fee > 0 {
+		desc = 1000 * uint64(fee) / t2s.Descendants.VSize
+	}
+	if desc > own {
+		return desc
+	}
+	return own
+}
+
+// GetSortedByDescendantScore returns the mempool txs with the lowest descendant score first.
+// Make sure to call it with locked TxMutex.
+func GetSortedByDescendantScore() (sorted []*OneTxToSend) {
+	sorted = make([]*OneTxToSend, 0, len(TransactionsToSend))
+	for _, t2s := range TransactionsToSend {
+		sorted = append(sorted, t2s)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		si, sj := sorted[i].DescendantScore(), sorted[j].DescendantScore()
+		if si != sj {
+			return si < sj
+		}
+		return sorted[i].Firstseen.After(sorted[j].Firstseen) // the newer goes first
+	})
+	return
+}
@@ -0,0 +1,74 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+// limits_test_tx makes a tx spending the given outputs and having n_out outputs.
+func limits_test_tx(inputs []btc.TxPrevOut, n_out int, seq uint32) (tx *btc.Tx) {
+	tx = new(btc.Tx)
+	tx.Version = 2
+	for _, inp := range inputs {
+		tx.TxIn = append(tx.TxIn, &btc.TxIn{Input: inp, Sequence: seq})
+	}
+	for i := 0; i < n_out; i++ {
+		tx.TxOut = append(tx.TxOut, &btc.TxOut{Value: 10000, Pk_script: []byte{0x51}})
+	}
+	tx.SetHash(tx.Serialize())
+	return
+}
+
+// limits_test_add puts the tx into the mempool, as if it was accepted.
+func limits_test_add(tx *btc.Tx, mem_inputs []bool, fee uint64) (t2s *OneTxToSend) {
+	t2s = &OneTxToSend{Tx: tx, MemInputs: mem_inputs, Fee: fee, Final: tx.TxIn[0].Sequence >= 0xfffffffe}
+	for i := range tx.TxIn {
+		uidx := tx.TxIn[i].Input.UIdx()
+		t2s.Spent = append(t2s.Spent, uidx)
+		SpentOutputs[uidx] = tx.Hash.BIdx()
+	}
+	TransactionsToSend[tx.Hash.BIdx()] = t2s
+	t2s.add_tx_stats()
+	return
+}
+
+func TestTxLimitsReplacement(t *testing.T) {
+	TransactionsToSend = make(map[BIDX]*OneTxToSend)
+	SpentOutputs = make(map[uint64]BIDX)
+	common.CFG.TXPool.MaxAncestors = 25
+	common.CFG.TXPool.MaxAncestorsKB = 101
+	common.CFG.TXPool.MaxDescendants = 3
+	common.CFG.TXPool.MaxDescendantsKB = 101
+
+	// the parent with two children reaches the descendant count limit
+	par := limits_test_add(limits_test_tx([]btc.TxPrevOut{{Vout: 0}}, 3, 0xffffffff), []bool{false}, 1000)
+	limits_test_add(limits_test_tx([]btc.TxPrevOut{{Hash: par.Hash.Hash, Vout: 0}}, 1, 0), []bool{true}, 1000)
+	limits_test_add(limits_test_tx([]btc.TxPrevOut{{Hash: par.Hash.Hash, Vout: 1}}, 1, 0), []bool{true}, 1000)
+	if par.Descendants.Cnt != 3 {
+		t.Fatal("Bad descendants count", par.Descendants.Cnt)
+	}
+
+	// a new child must be rejected
+	tx := limits_test_tx([]btc.TxPrevOut{{Hash: par.Hash.Hash, Vout: 2}}, 1, 0)
+	if CheckReplacement(tx, 100000, false, false) != nil {
+		t.Fatal("Unexpected conflict")
+	}
+	if reason := check_tx_limits(tx, []bool{true}, nil); reason != TX_REJECTED_DESC_COUNT {
+		t.Error("New child not rejected", reason)
+	}
+
+	// ... but a child replacing the first one must not
+	tx = limits_test_tx([]btc.TxPrevOut{{Hash: par.Hash.Hash, Vout: 0}}, 2, 0)
+	rbf := CheckReplacement(tx, 100000, false, false)
+	if rbf == nil || rbf.Reason != 0 || len(rbf.Evict) != 1 {
+		t.Fatal("Replacement not allowed", rbf)
+	}
+	if reason := check_tx_limits(tx, []bool{true}, rbf.Evict); reason != 0 {
+		t.Error("Replacement rejected", ReasonToString(reason))
+	}
+	if reason := check_tx_limits(tx, []bool{true}, nil); reason != TX_REJECTED_DESC_COUNT {
+		t.Error("Replaced tx not counted without evict list", reason)
+	}
+}
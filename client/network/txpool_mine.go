@@ -2,9 +2,11 @@ package network
 
 import (
 	"bytes"
+	"encoding/binary"
 	"fmt"
 	"github.com/piotrnar/gocoin/client/common"
 	"github.com/piotrnar/gocoin/lib/btc"
+	"time"
 )
 
 func (rec *OneTxToSend) IIdx(key uint64) int {
@@ -52,12 +54,14 @@ func (tx *OneTxToSend) UnMarkChildrenForMem() {
 }
 
 // tx_mined is called for each tx mined in a new block.
-func tx_mined(tx *btc.Tx) (wtg *OneWaitingList) {
+// The height should be zero, if the block is not to be used by the fee estimator.
+func tx_mined(tx *btc.Tx, height uint32) (wtg *OneWaitingList) {
 	h := tx.Hash
 	if rec, ok := TransactionsToSend[h.BIdx()]; ok {
 		common.CountSafe("TxMinedToSend")
+		rec.fee_est_untrack(true, height)
 		rec.UnMarkChildrenForMem()
-		rec.Delete(false, 0)
+		rec.remove(false, 0)
 	}
 	if mr, ok := TransactionsRejected[h.BIdx()]; ok {
 		if mr.Tx != nil {
@@ -67,6 +71,7 @@ func tx_mined(tx *btc.Tx) (wtg *OneWaitingList) {
 		}
 		deleteRejected(h.BIdx())
 	}
+	delete(FeeDeltas, h.BIdx())
 	if _, ok := TransactionsPending[h.BIdx()]; ok {
 		common.CountSafe("TxMinedPending")
 		delete(TransactionsPending, h.BIdx())
@@ -101,9 +106,13 @@ func tx_mined(tx *btc.Tx) (wtg *OneWaitingList) {
 func BlockMined(bl *btc.Block) {
 	wtgs := make([]*OneWaitingList, len(bl.Txs)-1)
 	var wtg_cnt int
+	var est_height uint32
 	TxMutex.Lock()
+	if fee_est_block(bl.Height, int(bl.LastKnownHeight)-int(bl.Height) < 144) {
+		est_height = bl.Height
+	}
 	for i := 1; i < len(bl.Txs); i++ {
-		wtg := tx_mined(bl.Txs[i])
+		wtg := tx_mined(bl.Txs[i], est_height)
 		if wtg != nil {
 			wtgs[wtg_cnt] = wtg
 			wtg_cnt++
@@ -189,3 +198,67 @@ func (c *OneConnection) ProcessGetMP(pl []byte) {
 
 	c.SendRawMsg("getmpdone", redo[:])
 }
+
+// ProcessMempool handles BIP35 "mempool" message.
+// It sends invs of our mempool txs, skipping those that do not pass the peer's feefilter.
+func (c *OneConnection) ProcessMempool() {
+	if !common.GetBool(&common.CFG.TXRoute.Enabled) {
+		common.CountSafe("MempoolReqNoRoute")
+		return
+	}
+
+	c.Mutex.Lock()
+	if !c.lastMempoolReq.IsZero() && time.Now().Sub(c.lastMempoolReq) < MempoolReqInterval {
+		c.Mutex.Unlock()
+		common.CountSafe("MempoolReqTooOften")
+		return
+	}
+	c.lastMempoolReq = time.Now()
+	wtxid_relay := c.Node.WTxIDRelay
+	min_fee_spkb := uint64(c.X.MinFeeSPKB)
+	c.Mutex.Unlock()
+
+	TxMutex.Lock()
+	sorted := GetSortedMempool()
+	invs := make([]byte, 0, 36*len(sorted))
+	var inv [36]byte
+	for _, t2s := range sorted {
+		if t2s.Blocked != 0 {
+			continue
+		}
+		if min_fee_spkb > 0 && 1000*t2s.Fee/uint64(t2s.VSize()) < min_fee_spkb {
+			continue
+		}
+		if wtxid_relay {
+			binary.LittleEndian.PutUint32(inv[0:4], MSG_WTX)
+			copy(inv[4:36], t2s.WTxID().Hash[:])
+		} else {
+			binary.LittleEndian.PutUint32(inv[0:4], MSG_TX)
+			copy(inv[4:36], t2s.Hash.Hash[:])
+		}
+		invs = append(invs, inv[:]...)
+	}
+	TxMutex.Unlock()
+
+	common.CountSafe("MempoolReqServed")
+	for len(invs) > 0 {
+		if c.BytesToSent() > SendBufSize/4 {
+			common.CountSafe("MempoolReqTruncated")
+			break
+		}
+		cnt := len(invs) / 36
+		if cnt > 50000 {
+			cnt = 50000 // max number of invs in one message
+		}
+		b := new(bytes.Buffer)
+		btc.WriteVlen(b, uint64(cnt))
+		b.Write(invs[:36*cnt])
+		c.Mutex.Lock()
+		for i := 0; i < cnt; i++ {
+			c.InvStore(binary.LittleEndian.Uint32(invs[36*i:36*i+4]), invs[36*i+4:36*i+36])
+		}
+		c.Mutex.Unlock()
+		c.SendRawMsg("inv", b.Bytes())
+		invs = invs[36*cnt:]
+	}
+}
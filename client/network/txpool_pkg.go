@@ -0,0 +1,327 @@
+package network
+
+import (
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+/*
+Package acceptance (child-pays-for-parent).
+A package is a child tx with its unconfirmed parents. Its txs are evaluated together,
+so a parent paying too low fee can still get into the mempool, if its child pays for both.
+Over P2P we do it opportunistically (like Bitcoin Core's 1p1c relay):
+ - a new tx spending a tx that we have rejected for LOW_FEE, is tried together with it
+ - a tx rejected for LOW_FEE is tried together with each of its children waiting for it
+ - we ask the peer that sent us an orphaned tx, for its missing parent
+The parents paying enough fee on their own are accepted first, so that they cannot pay
+for their low-fee siblings - only the rest of the parents and the child make the package.
+*/
+
+const (
+	MAX_PACKAGE_COUNT  = 25     // max number of txs in a package
+	MAX_PACKAGE_WEIGHT = 404000 // max total weight of txs in a package
+	MAX_PKG_PARENT_TRY = 8      // max number of packages tried with one parent rejected for LOW_FEE
+)
+
+// sort_package puts parents before their children and the child as the last one.
+// It returns nil if the package is not a child with its parents.
+func sort_package(pkg []*TxRcvd) (res []*TxRcvd) {
+	ids := make(map[BIDX]*TxRcvd, len(pkg))
+	for _, ntx := range pkg {
+		if _, ok := ids[ntx.Hash.BIdx()]; ok {
+			return nil // duplicate
+		}
+		ids[ntx.Hash.BIdx()] = ntx
+	}
+
+	// the child is the only one that is not spent by any other tx of the package
+	is_parent := make(map[BIDX]bool, len(pkg))
+	for _, ntx := range pkg {
+		for _, in := range ntx.TxIn {
+			if _, ok := ids[btc.BIdx(in.Input.Hash[:])]; ok {
+				is_parent[btc.BIdx(in.Input.Hash[:])] = true
+			}
+		}
+	}
+	if len(is_parent) != len(pkg)-1 {
+		return nil
+	}
+	var child *TxRcvd
+	for _, ntx := range pkg {
+		if !is_parent[ntx.Hash.BIdx()] {
+			child = ntx
+		}
+	}
+
+	// all the others must be the child's direct parents
+	spent_by_child := make(map[BIDX]bool, len(pkg))
+	for _, in := range child.TxIn {
+		spent_by_child[btc.BIdx(in.Input.Hash[:])] = true
+	}
+
+	res = make([]*TxRcvd, 0, len(pkg))
+	done := make(map[BIDX]bool, len(pkg))
+	for len(res) < len(pkg)-1 {
+		var progress bool
+		for _, ntx := range pkg {
+			if ntx == child || done[ntx.Hash.BIdx()] {
+				continue
+			}
+			if !spent_by_child[ntx.Hash.BIdx()] {
+				return nil
+			}
+			ready := true
+			for _, in := range ntx.TxIn {
+				bidx := btc.BIdx(in.Input.Hash[:])
+				if _, ok := ids[bidx]; ok && !done[bidx] {
+					ready = false
+					break
+				}
+			}
+			if ready {
+				res = append(res, ntx)
+				done[ntx.Hash.BIdx()] = true
+				progress = true
+			}
+		}
+		if !progress {
+			return nil // a loop
+		}
+	}
+	res = append(res, child)
+	return
+}
+
+// package_fee returns the fee and the virtual size of the package's txs that are not in the mempool yet.
+// Make sure to call it with locked TxMutex.
+func package_fee(pkg []*TxRcvd) (fee uint64, vsize int, reason byte) {
+	ids := make(map[BIDX]*TxRcvd, len(pkg))
+	for _, ntx := range pkg {
+		ids[ntx.Hash.BIdx()] = ntx
+	}
+	for _, ntx := range pkg {
+		if _, ok := TransactionsToSend[ntx.Hash.BIdx()]; ok {
+			continue // already in the mempool
+		}
+		var totinp, totout uint64
+		for _, in := range ntx.TxIn {
+			var out *btc.TxOut
+			bidx := btc.BIdx(in.Input.Hash[:])
+			if par, ok := ids[bidx]; ok {
+				if int(in.Input.Vout) >= len(par.TxOut) {
+					reason = TX_REJECTED_BAD_INPUT
+					return
+				}
+				out = par.TxOut[in.Input.Vout]
+			} else if txinmem, ok := TransactionsToSend[bidx]; ok {
+				if int(in.Input.Vout) >= len(txinmem.TxOut) {
+					reason = TX_REJECTED_BAD_INPUT
+					return
+				}
+				out = txinmem.TxOut[in.Input.Vout]
+			} else if out = common.BlockChain.Unspent.UnspentGet(&in.Input); out == nil {
+				reason = TX_REJECTED_NO_TXOU
+				return
+			}
+			totinp += out.Value
+		}
+		for _, out := range ntx.TxOut {
+			totout += out.Value
+		}
+		if totout > totinp {
+			reason = TX_REJECTED_OVERSPEND
+			return
+		}
+		fee += totinp - totout
+		vsize += ntx.VSize()
+	}
+	return
+}
+
+// accept_paying_parents puts into the mempool the parents that pay enough fee on their own.
+// It returns non-zero reason if any of them gets rejected.
+// It must be called from the chain's thread, with TxMutex unlocked.
+func accept_paying_parents(pkg []*TxRcvd) (reason byte) {
+	for _, ntx := range pkg[:len(pkg)-1] {
+		TxMutex.Lock()
+		// the parents spending other parents, that are not in the mempool, cannot be checked on their own
+		fee, vsize, er := package_fee([]*TxRcvd{ntx})
+		TxMutex.Unlock()
+		if er != 0 || vsize == 0 || 1000*fee/uint64(vsize) < common.MinFeePerKB() {
+			continue
+		}
+		if !HandleNetTx(ntx, true) {
+			TxMutex.Lock()
+			if rej, ok := TransactionsRejected[ntx.Hash.BIdx()]; ok {
+				reason = rej.Reason
+			} else {
+				reason = TX_REJECTED_PACKAGE
+			}
+			TxMutex.Unlock()
+			return
+		}
+		common.CountSafe("TxPkgParentOnItsOwn")
+	}
+	return
+}
+
+// HandleTxPackage tries to accept a child with its parents into the mempool,
+// checking the fee rate of the package as a whole, not of each tx separately.
+// It returns the package's fee per kB and zero reason, if all the txs are in the mempool now.
+// It must be called from the chain's thread, with TxMutex unlocked.
+func HandleTxPackage(pkg []*TxRcvd) (fee_spkb uint64, reason byte) {
+	common.CountSafe("TxPkgHandle")
+
+	var weight int
+	for _, ntx := range pkg {
+		weight += ntx.Weight()
+	}
+	if len(pkg) < 2 || len(pkg) > MAX_PACKAGE_COUNT || weight > MAX_PACKAGE_WEIGHT {
+		common.CountSafe("TxPkgRejectedSize")
+		reason = TX_REJECTED_PACKAGE
+		return
+	}
+	if pkg = sort_package(pkg); pkg == nil {
+		common.CountSafe("TxPkgRejectedTopology")
+		reason = TX_REJECTED_PACKAGE
+		return
+	}
+
+	if reason = accept_paying_parents(pkg); reason != 0 {
+		common.CountSafe("TxPkgRejectedParent")
+		return
+	}
+
+	TxMutex.Lock()
+	fee, vsize, reason := package_fee(pkg)
+	if reason != 0 {
+		TxMutex.Unlock()
+		common.CountSafe("TxPkgRejectedInputs")
+		return
+	}
+	if vsize == 0 {
+		TxMutex.Unlock()
+		common.CountSafe("TxPkgAlreadyIn")
+		return // all of them are in the mempool already
+	}
+	fee_spkb = 1000 * fee / uint64(vsize)
+	if !pkg[0].local && fee_spkb < common.MinFeePerKB() {
+		TxMutex.Unlock()
+		common.CountSafe("TxPkgRejectedLowFee")
+		reason = TX_REJECTED_LOW_FEE
+		return
+	}
+	TxMutex.Unlock()
+
+	var added []*TxRcvd
+	for _, ntx := range pkg {
+		TxMutex.Lock()
+		_, in_pool := TransactionsToSend[ntx.Hash.BIdx()]
+		TxMutex.Unlock()
+		if in_pool {
+			continue // e.g. a child that got retried after its parent was accepted
+		}
+		ntx.in_pkg = true
+		ntx.pkg_fee_spkb = fee_spkb
+		if HandleNetTx(ntx, true) {
+			added = append(added, ntx)
+			continue
+		}
+
+		// remove the parents that could not stay in the mempool on their own
+		TxMutex.Lock()
+		if rej, ok := TransactionsRejected[ntx.Hash.BIdx()]; ok {
+			reason = rej.Reason
+		} else {
+			reason = TX_REJECTED_PACKAGE
+		}
+		for i := len(added) - 1; i >= 0; i-- {
+			bidx := added[i].Hash.BIdx()
+			if t2s, ok := TransactionsToSend[bidx]; ok && t2s.Fee < uint64(t2s.VSize())*common.MinFeePerKB()/1000 {
+				t2s.Delete(true, TX_REJECTED_LOW_FEE)
+				if rej, ok := TransactionsRejected[bidx]; ok {
+					// so we do not verify its scripts again, with its next child
+					rej.ScriptsOK = true
+					rej.PkgTries = added[i].pkg_tries
+				}
+			}
+		}
+		TxMutex.Unlock()
+		common.CountSafe("TxPkgRejected")
+		return
+	}
+	common.CountSafe("TxPkgAccepted")
+	return
+}
+
+// pkg_parent returns the tx rejected for LOW_FEE, to be tried as a package's parent.
+// It returns nil if the tx has been tried too many times already.
+// Make sure to call it with locked TxMutex.
+func pkg_parent(rej *OneTxRejected) *TxRcvd {
+	if rej.PkgTries >= MAX_PKG_PARENT_TRY {
+		common.CountSafe("TxPkgParentTooManyTries")
+		return nil
+	}
+	rej.PkgTries++
+	return &TxRcvd{Tx: rej.Tx, pkg_tries: rej.PkgTries, scripts_ok: rej.ScriptsOK}
+}
+
+// low_fee_parents returns the package of the tx with all its parents that we rejected for LOW_FEE.
+// It returns nil if any of the parents cannot be tried again.
+// Make sure to call it with locked TxMutex.
+func low_fee_parents(ntx *TxRcvd) (pkg []*TxRcvd) {
+	for _, in := range ntx.TxIn {
+		if rej, ok := TransactionsRejected[btc.BIdx(in.Input.Hash[:])]; ok && rej.Reason == TX_REJECTED_LOW_FEE && rej.Tx != nil {
+			dup := false
+			for _, p := range pkg {
+				dup = dup || p.Tx == rej.Tx
+			}
+			if !dup {
+				par := pkg_parent(rej)
+				if par == nil {
+					return nil
+				}
+				pkg = append(pkg, par)
+			}
+		}
+	}
+	return append(pkg, ntx)
+}
+
+// retry_waiting_as_packages tries the low-fee tx with each of its children waiting for it, till one gets it in.
+// Call it without TxMutex locked.
+func retry_waiting_as_packages(ntx *TxRcvd, children []*btc.Tx) {
+	for _, child := range children {
+		var par *TxRcvd
+		TxMutex.Lock()
+		if rej, ok := TransactionsRejected[ntx.Hash.BIdx()]; ok && rej.Reason == TX_REJECTED_LOW_FEE && rej.Tx != nil {
+			par = pkg_parent(rej)
+		}
+		TxMutex.Unlock()
+		if par == nil {
+			return
+		}
+		par.conn = ntx.conn
+		if _, reason := HandleTxPackage([]*TxRcvd{par, &TxRcvd{Tx: child}}); reason == 0 {
+			common.CountSafe("TxPkgFromParent")
+			return
+		}
+	}
+}
+
+// ask_for_parent sends "getdata" for a missing parent of the tx that the peer has sent us.
+func (c *OneConnection) ask_for_parent(id *btc.Uint256) {
+	if NeedThisTx(id, nil) {
+		common.CountSafe("TxAskForParent")
+		c.send_getdata_tx(id.Hash[:])
+	}
+}
+
+// SubmitLocalPackage puts the given txs (a child with its parents) into the mempool, as the local ones.
+func SubmitLocalPackage(txs []*btc.Tx) (fee_spkb uint64, reason byte) {
+	pkg := make([]*TxRcvd, len(txs))
+	for i, tx := range txs {
+		pkg[i] = &TxRcvd{Tx: tx, trusted: true, local: true}
+	}
+	return HandleTxPackage(pkg)
+}
@@ -0,0 +1,29 @@
+package network
+
+import (
+	"testing"
+
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+func TestPkgParentTries(t *testing.T) {
+	TransactionsRejected = make(map[BIDX]*OneTxRejected)
+	RejectedWTxIDs = make(map[BIDX]BIDX)
+	par := limits_test_tx([]btc.TxPrevOut{{Vout: 0}}, 1, 0xffffffff)
+	rej := RejectTx(par, TX_REJECTED_LOW_FEE)
+	rej.ScriptsOK = true
+	child := &TxRcvd{Tx: limits_test_tx([]btc.TxPrevOut{{Hash: par.Hash.Hash, Vout: 0}}, 1, 0xffffffff)}
+
+	for i := 1; i <= MAX_PKG_PARENT_TRY; i++ {
+		pkg := low_fee_parents(child)
+		if len(pkg) != 2 || pkg[0].Tx != par || pkg[1] != child {
+			t.Fatal("Bad package", i, pkg)
+		}
+		if !pkg[0].scripts_ok || pkg[0].pkg_tries != uint32(i) {
+			t.Error("Bad parent", i, pkg[0].scripts_ok, pkg[0].pkg_tries)
+		}
+	}
+	if pkg := low_fee_parents(child); pkg != nil {
+		t.Error("Parent tried too many times")
+	}
+}
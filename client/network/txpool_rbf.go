@@ -0,0 +1,168 @@
+package network
+
+import (
+	"fmt"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+const (
+	MAX_REPLACEMENT_CANDIDATES = 100 // BIP125 rule #5
+)
+
+// RBFResult describes a replacement (BIP125) of the mempool txs that the new tx conflicts with.
+type RBFResult struct {
+	Fee        uint64 // fee of the new tx
+	VSize      int
+	Conflicts  []*OneTxToSend // mempool txs spending the same inputs as the new tx
+	Evict      []*OneTxToSend // all the txs that would be removed: the conflicts with all their descendants
+	EvictFee   uint64
+	EvictVSize int
+	Reason     byte   // zero if the replacement is allowed
+	Rule       string // which rule has failed
+}
+
+// SignalsRBF tells if the tx can be replaced, because it, or any of its unconfirmed parents,
+// has any input's sequence below 0xfffffffe (BIP125).
+// Make sure to call it with locked TxMutex.
+func (tx *OneTxToSend) SignalsRBF() bool {
+	if !tx.Final {
+		return true
+	}
+	for _, par := range tx.GetAllParents() {
+		if !par.Final {
+			return true
+		}
+	}
+	return false
+}
+
+func (res *RBFResult) reject(reason byte, rule string, args ...interface{}) *RBFResult {
+	res.Reason = reason
+	res.Rule = fmt.Sprintf(rule, args...)
+	return res
+}
+
+// CheckReplacement checks if the tx paying the given fee can replace the mempool txs it conflicts with.
+// Trusted txs do not need to follow rules #1 and #5, local ones the fee related rules.
+// It returns nil if the tx does not conflict with any mempool tx.
+// Make sure to call it with locked TxMutex.
+func CheckReplacement(tx *btc.Tx, fee uint64, trusted, local bool) (res *RBFResult) {
+	already := make(map[*OneTxToSend]bool)
+	for i := range tx.TxIn {
+		if so, ok := SpentOutputs[tx.TxIn[i].Input.UIdx()]; ok {
+			if ctx := TransactionsToSend[so]; !already[ctx] {
+				if res == nil {
+					res = &RBFResult{Fee: fee, VSize: tx.VSize()}
+				}
+				res.Conflicts = append(res.Conflicts, ctx)
+				already[ctx] = true
+			}
+		}
+	}
+	if res == nil {
+		return
+	}
+
+	// Rule #1 - the original txs must signal replaceability (unless we do full-RBF)
+	if !trusted && !common.GetBool(&common.CFG.TXPool.FullRBF) {
+		for _, ctx := range res.Conflicts {
+			if !ctx.SignalsRBF() {
+				return res.reject(TX_REJECTED_RBF_FINAL, "#1: %s does not signal replaceability", ctx.Hash.String())
+			}
+		}
+	}
+
+	// collect the conflicts with all their descendants
+	evict := make(map[*OneTxToSend]bool)
+	for _, ctx := range res.Conflicts {
+		for _, t2s := range append([]*OneTxToSend{ctx}, ctx.GetAllChildren()...) {
+			if !evict[t2s] {
+				evict[t2s] = true
+				res.Evict = append(res.Evict, t2s)
+				res.EvictFee += t2s.Fee
+				res.EvictVSize += t2s.VSize()
+			}
+		}
+	}
+
+	// Rule #5 - not too many txs to evict
+	if !trusted && len(res.Evict) > MAX_REPLACEMENT_CANDIDATES {
+		return res.reject(TX_REJECTED_RBF_100, "#5: it would evict %d txs (max %d)", len(res.Evict), MAX_REPLACEMENT_CANDIDATES)
+	}
+
+	// The new tx cannot spend any of the txs that it replaces
+	orig_parents := make(map[BIDX]bool)
+	for _, ctx := range res.Conflicts {
+		for i := range ctx.TxIn {
+			orig_parents[btc.BIdx(ctx.TxIn[i].Input.Hash[:])] = true
+		}
+	}
+	for i := range tx.TxIn {
+		bidx := btc.BIdx(tx.TxIn[i].Input.Hash[:])
+		if t2s, ok := TransactionsToSend[bidx]; ok {
+			if evict[t2s] {
+				return res.reject(TX_REJECTED_RBF_CONFLICT, "it spends %s that it replaces", t2s.Hash.String())
+			}
+			// Rule #2 - no new unconfirmed inputs
+			if !orig_parents[bidx] {
+				return res.reject(TX_REJECTED_RBF_NEWINPUT, "#2: new unconfirmed input %s", t2s.Hash.String())
+			}
+		}
+	}
+
+	if local {
+		return
+	}
+
+	// The new tx must pay higher fee rate than each of the txs that it directly replaces
+	for _, ctx := range res.Conflicts {
+		if fee*uint64(ctx.VSize()) <= ctx.Fee*uint64(res.VSize) {
+			return res.reject(TX_REJECTED_RBF_FEERATE, "#6: fee rate %.1f SPB not higher than %.1f SPB of %s",
+				float64(fee)/float64(res.VSize), float64(ctx.Fee)/float64(ctx.VSize()), ctx.Hash.String())
+		}
+	}
+
+	// Rule #3 - the new tx must pay at least the absolute fee of all the replaced ones
+	if fee < res.EvictFee {
+		return res.reject(TX_REJECTED_RBF_LOWFEE, "#3: fee %d lower than %d of the replaced txs", fee, res.EvictFee)
+	}
+
+	// Rule #4 - ... and pay for its own bandwidth, at the incremental relay fee rate
+	if incr := uint64(res.VSize) * common.MinMinFeePerKB() / 1000; fee-res.EvictFee < incr {
+		return res.reject(TX_REJECTED_RBF_INCRFEE, "#4: additional fee %d lower than %d (incremental relay fee)", fee-res.EvictFee, incr)
+	}
+	return
+}
+
+// TestReplace checks (without changing anything) if the tx can be accepted as a replacement.
+// It returns nil if the tx does not conflict with any mempool tx.
+// If any of the tx's inputs is unknown, the result's Reason is TX_REJECTED_NO_TXOU.
+func TestReplace(tx *btc.Tx) (res *RBFResult) {
+	TxMutex.Lock()
+	defer TxMutex.Unlock()
+	var totinp, totout uint64
+	for i := range tx.TxIn {
+		var out *btc.TxOut
+		inp := &tx.TxIn[i].Input
+		if txinmem, ok := TransactionsToSend[btc.BIdx(inp.Hash[:])]; ok {
+			if int(inp.Vout) < len(txinmem.TxOut) {
+				out = txinmem.TxOut[inp.Vout]
+			}
+		} else {
+			out = common.BlockChain.Unspent.UnspentGet(inp)
+		}
+		if out == nil {
+			return &RBFResult{VSize: tx.VSize(), Reason: TX_REJECTED_NO_TXOU, Rule: "unknown input " + inp.String()}
+		}
+		totinp += out.Value
+	}
+	for i := range tx.TxOut {
+		totout += tx.TxOut[i].Value
+	}
+	if totout > totinp {
+		return &RBFResult{VSize: tx.VSize(), Reason: TX_REJECTED_OVERSPEND, Rule: "outputs exceed inputs"}
+	}
+	return CheckReplacement(tx, totinp-totout, false, false)
+}
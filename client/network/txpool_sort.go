@@ -25,8 +25,8 @@ func GetSortedMempool() (result []*OneTxToSend) {
 	sort.Slice(all_txs, func(i, j int) bool {
 		rec_i := TransactionsToSend[all_txs[i]]
 		rec_j := TransactionsToSend[all_txs[j]]
-		rate_i := rec_i.Fee * uint64(rec_j.Weight())
-		rate_j := rec_j.Fee * uint64(rec_i.Weight())
+		rate_i := rec_i.ModifiedFee() * int64(rec_j.Weight())
+		rate_j := rec_j.ModifiedFee() * int64(rec_i.Weight())
 		if rate_i != rate_j {
 			return rate_i > rate_j
 		}
@@ -142,25 +142,32 @@ func LimitPoolSize(maxlen uint64) {
 
 	//sta := time.Now()
 
-	sorted := GetSortedMempoolNew()
-	idx := len(sorted)
+	// evict the txs with the lowest descendant score first (each one with all its descendants)
+	sorted := GetSortedByDescendantScore()
+	var idx, cnt int
+	var newspkb uint64
 
 	old_size := TransactionsToSendSize
 
 	maxlen -= ticklen
 
-	for idx > 0 && TransactionsToSendSize > maxlen {
-		idx--
+	for idx < len(sorted) && TransactionsToSendSize > maxlen {
 		tx := sorted[idx]
+		idx++
 		if _, ok := TransactionsToSend[tx.Hash.BIdx()]; !ok {
 			// this has already been rmoved
 			continue
 		}
+		if score := tx.DescendantScore(); score > newspkb {
+			newspkb = score
+		}
+		cnt += tx.Descendants.Cnt
 		tx.Delete(true, TX_REJECTED_LOW_FEE)
 	}
 
-	if cnt := len(sorted) - idx; cnt > 0 {
-		newspkb := uint64(float64(1000*sorted[idx].Fee) / float64(sorted[idx].VSize()))
+	if cnt > 0 {
+		// do not let the evicted txs come back, unless they pay the incremental relay fee more
+		newspkb += common.MinMinFeePerKB()
 		common.SetMinFeePerKB(newspkb)
 
 		/*fmt.Println("Mempool purged in", time.Now().Sub(sta).String(), "-",
@@ -328,11 +335,33 @@ func MempoolCheck() (dupa bool) {
 		dupa = true
 	}
 
+	if checkTxStats() {
+		dupa = true
+	}
+
 	if totsize != TransactionsToSendSize {
 		fmt.Println("TransactionsToSendSize mismatch", totsize, TransactionsToSendSize)
 		dupa = true
 	}
 
+	if len(WTxIDs) != len(TransactionsToSend) {
+		fmt.Println("WTxIDs length mismatch", len(WTxIDs), len(TransactionsToSend))
+		dupa = true
+	}
+	for _, t2s := range WTxIDs {
+		if TransactionsToSend[t2s.Hash.BIdx()] != t2s {
+			fmt.Println("WTxIDs record", t2s.Hash.String(), "not in mempool")
+			dupa = true
+		}
+	}
+
+	for k, bidx := range RejectedWTxIDs {
+		if tr, ok := TransactionsRejected[bidx]; !ok || tr.WTxID == nil || tr.WTxID.BIdx() != k {
+			fmt.Printf("RejectedWTxIDs record %x has no matching rejected tx\n", k[:])
+			dupa = true
+		}
+	}
+
 	totsize = 0
 	for _, tr := range TransactionsRejected {
 		totsize += uint64(tr.Size)
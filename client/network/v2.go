@@ -0,0 +1,167 @@
+package network
+
+import (
+	"bytes"
+	"io"
+	"time"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/lib/others/bip324"
+)
+
+// BIP324 - v2 encrypted P2P transport
+
+const (
+	V2HandshakeTimeout = 10 * time.Second // Timeout for the key exchange and the version packets
+)
+
+// v2_handshake sets up BIP324 encryption, if both sides support it.
+// Outgoing connections that fail the handshake are re-established with v1 protocol.
+// On error the connection is marked as broken.
+func (c *OneConnection) v2_handshake() {
+	var cipher *bip324.Cipher
+	var e error
+
+	if (common.Services & SERVICE_P2P_V2) == 0 {
+		return
+	}
+	if !c.X.Incomming && (c.PeerAddr.Services&SERVICE_P2P_V2) == 0 {
+		return
+	}
+
+	c.Conn.SetDeadline(time.Now().Add(V2HandshakeTimeout))
+	if c.X.Incomming {
+		var prefix [bip324.V1_PREFIX_LEN]byte
+		if _, e = io.ReadFull(c.Conn, prefix[:]); e != nil {
+			c.Disconnect("V2Handshake:" + e.Error())
+			return
+		}
+		if bytes.Equal(prefix[:], bip324.V1Prefix(common.Magic)) {
+			// v1 peer - FetchMessage will continue with the header we have already read
+			c.Conn.SetDeadline(time.Time{})
+			copy(c.recv.hdr[:], prefix[:])
+			c.recv.hdr_len = len(prefix)
+			return
+		}
+		cipher, e = bip324.Handshake(c.Conn, common.Magic, false, prefix[:])
+	} else {
+		cipher, e = bip324.Handshake(c.Conn, common.Magic, true, nil)
+		if e != nil {
+			// the peer may not support v2 after all - reconnect and use v1
+			common.CountSafe("V2Fallback")
+			c.Conn.Close()
+			con, er := dial_peer(&c.PeerAddr.NetAddr)
+			if er != nil {
+				c.Disconnect("V2Fallback:" + er.Error())
+				return
+			}
+			Mutex_net.Lock()
+			c.Mutex.Lock()
+			c.Conn = con
+			c.Mutex.Unlock()
+			Mutex_net.Unlock()
+			return
+		}
+	}
+	if e != nil {
+		common.CountSafe("V2HandshakeFail")
+		c.Disconnect("V2Handshake:" + e.Error())
+		return
+	}
+	c.Conn.SetDeadline(time.Time{})
+
+	c.Mutex.Lock()
+	c.v2 = cipher
+	c.X.V2Transport = true
+	c.Mutex.Unlock()
+	common.CountSafe("V2Connections")
+}
+
+// fetch_v2_message is FetchMessage for BIP324 connections.
+// The state machine first reads the encrypted length and then the rest of the packet.
+func (c *OneConnection) fetch_v2_message() (ret *BCmsg, timeout_or_data bool) {
+	var e error
+	var n int
+
+	if c.recv.hdr_len < bip324.LENGTH_SIZE {
+		n, e = common.SockRead(c.Conn, c.recv.hdr[c.recv.hdr_len:bip324.LENGTH_SIZE])
+		if n < 0 {
+			n = 0
+		} else {
+			timeout_or_data = true
+		}
+		c.Mutex.Lock()
+		if n > 0 {
+			c.X.BytesReceived += uint64(n)
+			c.X.LastDataGot = time.Now()
+			c.recv.hdr_len += n
+		}
+		c.Mutex.Unlock()
+		if e != nil {
+			c.HandleError(e)
+			return
+		}
+		if c.recv.hdr_len < bip324.LENGTH_SIZE {
+			return
+		}
+
+		c.recv.pl_len = c.v2.DecryptLength(c.recv.hdr[:bip324.LENGTH_SIZE])
+		if c.recv.pl_len > bip324.MAX_CONTENTS_LEN+bip324.HEADER_SIZE+bip324.POLY1305_TAG_SIZE {
+			c.DoS("V2BigPacket")
+			return
+		}
+		c.Mutex.Lock()
+		c.recv.dat = make([]byte, c.recv.pl_len)
+		c.recv.datlen = 0
+		c.Mutex.Unlock()
+	}
+
+	if c.recv.datlen < c.recv.pl_len {
+		n, e = common.SockRead(c.Conn, c.recv.dat[c.recv.datlen:])
+		if n < 0 {
+			n = 0
+		} else {
+			timeout_or_data = true
+		}
+		if n > 0 {
+			c.Mutex.Lock()
+			c.X.BytesReceived += uint64(n)
+			c.recv.datlen += uint32(n)
+			c.Mutex.Unlock()
+		}
+		if e != nil {
+			c.HandleError(e)
+			return
+		}
+		if c.MutexGetBool(&c.broken) || c.recv.datlen < c.recv.pl_len {
+			return
+		}
+	}
+
+	contents, ignore, e := c.v2.Decrypt(c.recv.dat, nil)
+	if e != nil {
+		c.DoS("V2BadPacket")
+		return
+	}
+	if ignore {
+		common.CountSafe("V2DecoyPacket")
+		c.Mutex.Lock()
+		c.recv.hdr_len = 0
+		c.recv.dat = nil
+		c.Mutex.Unlock()
+		return
+	}
+
+	cmd, pl, e := bip324.DecodeMsg(contents)
+	if e != nil {
+		c.DoS("V2BadMsg")
+		return
+	}
+	if uint32(len(pl)) > maxmsgsize(cmd) {
+		c.DoS("Big-" + cmd)
+		return
+	}
+
+	ret = c.message_received(cmd, pl)
+	return
+}
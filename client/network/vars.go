@@ -33,6 +33,10 @@ type TxRcvd struct {
 	conn *OneConnection
 	*btc.Tx
 	trusted, local bool
+	in_pkg         bool   // it is being accepted as a part of a package
+	pkg_fee_spkb   uint64 // the package's fee per kB
+	pkg_tries      uint32 // see OneTxRejected.PkgTries
+	scripts_ok     bool   // the scripts have been verified already
 }
 
 type OneBlockToGet struct {
@@ -24,7 +24,7 @@ func (c *OneConnection) SendVersion() {
 	binary.Write(b, binary.LittleEndian, uint64(time.Now().Unix()))
 
 	b.Write(c.PeerAddr.NetAddr.Bytes())
-	if ExternalAddrLen() > 0 {
+	if ExternalAddrLen() > 0 && !UsingProxy() {
 		b.Write(BestExternalAddr())
 	} else {
 		b.Write(bytes.Repeat([]byte{0}, 26))
@@ -38,7 +38,7 @@ func (c *OneConnection) SendVersion() {
 	common.UnlockCfg()
 
 	binary.Write(b, binary.LittleEndian, uint32(common.Last.BlockHeight()))
-	if !common.GetBool(&common.CFG.TXPool.Enabled) {
+	if !common.GetBool(&common.CFG.TXPool.Enabled) || c.X.BlockRelayOnly {
 		b.WriteByte(0) // don't notify me about txs
 	}
 
@@ -88,7 +88,7 @@ func (c *OneConnection) HandleVersion(pl []byte) error {
 		c.Node.Timestamp = binary.LittleEndian.Uint64(pl[12:20])
 		c.Node.ReportedIp4 = binary.BigEndian.Uint32(pl[40:44])
 
-		use_this_ip := sys.ValidIp4(pl[40:44])
+		use_this_ip := sys.ValidIp4(pl[40:44]) && !UsingProxy()
 
 		if len(pl) >= 82 {
 			le, of := btc.VLen(pl[80:])
@@ -172,6 +172,10 @@ func (c *OneConnection) HandleVersion(pl []byte) error {
 	} else {
 		return errors.New("version message too short")
 	}
+	if c.Node.Version >= 70016 {
+		c.SendRawMsg("wtxidrelay", nil) // BIP339 - must be sent before verack
+	}
+	c.SendRawMsg("sendaddrv2", nil) // BIP155 - must be sent before verack
 	c.SendRawMsg("verack", []byte{})
 	return nil
 }
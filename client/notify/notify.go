@@ -0,0 +1,258 @@
+// Package notify pushes notifications about new blocks and transactions to external processes.
+//
+// It works like bitcoind's ZMQ interface, but uses a plain TCP (or Unix) socket.
+// After connecting, the subscriber sends text lines to choose the topics it wants:
+//
+//	SUB <topic>
+//	UNSUB <topic>
+//
+// Available topics: rawblock, hashblock, rawtx, hashtx, sequence (or * for all of them).
+// Each notification is sent as:
+//
+//	[1 byte]  - topic length
+//	[n bytes] - topic
+//	[4 bytes] - body length (LSB)
+//	[n bytes] - body
+//	[4 bytes] - sequence number of the message, separate for each topic (LSB)
+//
+// Hashes are sent in the same byte order as they are displayed (reversed).
+// Body of the sequence topic is: 32 bytes of hash, followed by one character:
+//
+//	C - block connected
+//	D - block disconnected
+//	A - tx added to the mempool (followed by 8 bytes LSB of mempool sequence)
+//	R - tx removed from the mempool (followed by 8 bytes LSB of mempool sequence)
+//
+// A subscriber that does not read fast enough loses notifications (see the sequence numbers).
+package notify
+
+import (
+	"bufio"
+	"encoding/binary"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+const (
+	TOPIC_RAWBLOCK  = "rawblock"
+	TOPIC_HASHBLOCK = "hashblock"
+	TOPIC_RAWTX     = "rawtx"
+	TOPIC_HASHTX    = "hashtx"
+	TOPIC_SEQUENCE  = "sequence"
+
+	MAX_QUEUE_LEN = 1000 // messages waiting to be sent to one subscriber
+)
+
+var all_topics = []string{TOPIC_RAWBLOCK, TOPIC_HASHBLOCK, TOPIC_RAWTX, TOPIC_HASHTX, TOPIC_SEQUENCE}
+
+type subscriber struct {
+	conn   net.Conn
+	topics map[string]bool
+	queue  chan []byte
+}
+
+var (
+	mutex        sync.Mutex
+	listener     net.Listener
+	unix_path    string
+	subscribers  = make(map[*subscriber]bool)
+	topic_seq    = make(map[string]uint32)
+	mempool_seq  uint64
+	active_count int32 // number of subscribers, so we know if there is anyone to notify
+)
+
+// Start opens the listening socket. Address "unix:/path" is for a Unix socket, otherwise it is TCP.
+func Start(addr string) (e error) {
+	if strings.HasPrefix(addr, "unix:") {
+		unix_path = addr[5:]
+		os.Remove(unix_path)
+		listener, e = net.Listen("unix", unix_path)
+	} else {
+		listener, e = net.Listen("tcp", addr)
+	}
+	if e != nil {
+		return
+	}
+	go accept_thread(listener)
+	return
+}
+
+// Stop closes the listening socket and all the subscribers.
+func Stop() {
+	mutex.Lock()
+	defer mutex.Unlock()
+	if listener == nil {
+		return
+	}
+	listener.Close()
+	listener = nil
+	if unix_path != "" {
+		os.Remove(unix_path)
+	}
+	for s := range subscribers {
+		s.conn.Close()
+	}
+}
+
+func accept_thread(l net.Listener) {
+	for {
+		conn, e := l.Accept()
+		if e != nil {
+			return
+		}
+		s := &subscriber{conn: conn, topics: make(map[string]bool), queue: make(chan []byte, MAX_QUEUE_LEN)}
+		mutex.Lock()
+		subscribers[s] = true
+		mutex.Unlock()
+		atomic.AddInt32(&active_count, 1)
+		common.CountSafe("NotifySubscribed")
+		go s.write_thread()
+		go s.read_thread()
+	}
+}
+
+// read_thread processes SUB/UNSUB commands from the subscriber.
+func (s *subscriber) read_thread() {
+	rd := bufio.NewScanner(s.conn)
+	for rd.Scan() {
+		ss := strings.Fields(rd.Text())
+		if len(ss) != 2 {
+			continue
+		}
+		on := strings.ToUpper(ss[0]) == "SUB"
+		if !on && strings.ToUpper(ss[0]) != "UNSUB" {
+			continue
+		}
+		mutex.Lock()
+		for _, t := range all_topics {
+			if ss[1] == "*" || ss[1] == t {
+				s.topics[t] = on
+			}
+		}
+		mutex.Unlock()
+	}
+	mutex.Lock()
+	if subscribers[s] {
+		delete(subscribers, s)
+		close(s.queue)
+		atomic.AddInt32(&active_count, -1)
+	}
+	mutex.Unlock()
+}
+
+func (s *subscriber) write_thread() {
+	for msg := range s.queue {
+		if _, e := s.conn.Write(msg); e != nil {
+			break
+		}
+	}
+	s.conn.Close()
+	for range s.queue {
+		// drain the queue until read_thread closes it
+	}
+}
+
+// publish sends the message to all the subscribers of the topic.
+func publish(topic string, body []byte) {
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	seq := topic_seq[topic]
+	topic_seq[topic] = seq + 1
+
+	var msg []byte
+	for s := range subscribers {
+		if !s.topics[topic] {
+			continue
+		}
+		if msg == nil {
+			msg = make([]byte, 1+len(topic)+4+len(body)+4)
+			msg[0] = byte(len(topic))
+			copy(msg[1:], topic)
+			binary.LittleEndian.PutUint32(msg[1+len(topic):], uint32(len(body)))
+			copy(msg[5+len(topic):], body)
+			binary.LittleEndian.PutUint32(msg[5+len(topic)+len(body):], seq)
+		}
+		select {
+		case s.queue <- msg:
+		default:
+			common.CountSafe("NotifyDropped")
+		}
+	}
+}
+
+func active() bool {
+	return atomic.LoadInt32(&active_count) > 0
+}
+
+// reversed returns the hash in the byte order it is displayed.
+func reversed(h *btc.Uint256) []byte {
+	res := make([]byte, 32)
+	for i := range res {
+		res[i] = h.Hash[31-i]
+	}
+	return res
+}
+
+func sequence(h *btc.Uint256, label byte, with_mempool_seq bool) {
+	body := append(reversed(h), label)
+	if with_mempool_seq {
+		var tmp [8]byte
+		binary.LittleEndian.PutUint64(tmp[:], mempool_seq)
+		body = append(body, tmp[:]...)
+	}
+	publish(TOPIC_SEQUENCE, body)
+}
+
+func tx_notify(tx *btc.Tx) {
+	publish(TOPIC_RAWTX, tx.Raw)
+	publish(TOPIC_HASHTX, reversed(&tx.Hash))
+}
+
+// BlockConnected is called when a new block is applied to the chain.
+func BlockConnected(bl *btc.Block) {
+	if !active() {
+		return
+	}
+	publish(TOPIC_RAWBLOCK, bl.Raw)
+	publish(TOPIC_HASHBLOCK, reversed(bl.Hash))
+	for _, tx := range bl.Txs {
+		tx_notify(tx)
+	}
+	sequence(bl.Hash, 'C', false)
+}
+
+// BlockDisconnected is called when a block is undone (chain reorg).
+func BlockDisconnected(bl *btc.Block) {
+	if !active() {
+		return
+	}
+	sequence(bl.Hash, 'D', false)
+}
+
+// TxAdded is called when a new tx is accepted to the mempool.
+// Call it with network.TxMutex locked, so the mempool sequence is in order.
+func TxAdded(tx *btc.Tx) {
+	mempool_seq++
+	if !active() {
+		return
+	}
+	tx_notify(tx)
+	sequence(&tx.Hash, 'A', true)
+}
+
+// TxRemoved is called when a tx is removed from the mempool, for any reason but being mined.
+// Call it with network.TxMutex locked, so the mempool sequence is in order.
+func TxRemoved(h *btc.Uint256) {
+	mempool_seq++
+	if !active() {
+		return
+	}
+	sequence(h, 'R', true)
+}
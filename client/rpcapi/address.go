@@ -45,3 +45,15 @@ func ValidateAddress(addr string) (interface{}) {
 	//res.IsWatchOnly = false
 	//res.IsScript = false
 }
+
+func validateaddress(params []interface{}) (interface{}, error) {
+	addr, e := param_string(params, 0, "address")
+	if e != nil {
+		return nil, e
+	}
+	return ValidateAddress(addr), nil
+}
+
+func init() {
+	register("validateaddress", validateaddress, "address")
+}
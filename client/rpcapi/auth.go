@@ -0,0 +1,162 @@
+package rpcapi
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/piotrnar/gocoin/client/common"
+)
+
+const (
+	COOKIE_USER      = "__cookie__"
+	COOKIE_FILE_NAME = ".cookie"
+)
+
+var (
+	cookie_pass  string
+	cookie_mutex sync.Mutex
+)
+
+// rpc_user is an authenticated RPC user.
+type rpc_user struct {
+	name    string
+	methods map[string]bool // nil if all the methods are allowed
+}
+
+func (u *rpc_user) can_call(method string) bool {
+	return u.methods == nil || u.methods[method]
+}
+
+func CookiePath() string {
+	return common.GocoinHomeDir + COOKIE_FILE_NAME
+}
+
+// WriteCookie creates a new random password and stores it in the data folder,
+// the same way as bitcoind does it, for local tools that can read the file.
+func WriteCookie() error {
+	var rnd [32]byte
+	rand.Read(rnd[:])
+	pass := hex.EncodeToString(rnd[:])
+	cookie_mutex.Lock()
+	defer cookie_mutex.Unlock()
+	if e := ioutil.WriteFile(CookiePath(), []byte(COOKIE_USER+":"+pass), 0600); e != nil {
+		return e
+	}
+	cookie_pass = pass
+	return nil
+}
+
+// RemoveCookie is called when the node is closing.
+func RemoveCookie() {
+	cookie_mutex.Lock()
+	defer cookie_mutex.Unlock()
+	if cookie_pass != "" {
+		os.Remove(CookiePath())
+		cookie_pass = ""
+	}
+}
+
+// RpcAuthHash returns the value to be put after the "$" of an RPC.Auth entry.
+func RpcAuthHash(salt, password string) string {
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(password))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func equal_str(a, b string) bool {
+	return subtle.ConstantTimeCompare([]byte(a), []byte(b)) == 1
+}
+
+// check_rpcauth checks the password against RPC.Auth records ("user:salt$hash").
+func check_rpcauth(auth []string, user, pass string) (ok bool) {
+	for _, rec := range auth {
+		col := strings.Index(rec, ":")
+		if col < 0 || rec[:col] != user {
+			continue
+		}
+		ss := strings.SplitN(rec[col+1:], "$", 2)
+		if len(ss) != 2 {
+			println("ERROR: Incorrect RPC.Auth entry for user", user)
+			continue
+		}
+		if hmac.Equal([]byte(RpcAuthHash(ss[0], pass)), []byte(strings.ToLower(ss[1]))) {
+			ok = true
+		}
+	}
+	return
+}
+
+// user_methods returns the methods that the user is allowed to call, or nil for all of them.
+// If there is more than one RPC.Whitelist entry for the user, only methods present in each are allowed.
+func user_methods(whitelist []string, user string) (res map[string]bool) {
+	for _, rec := range whitelist {
+		col := strings.Index(rec, ":")
+		if col < 0 || strings.TrimSpace(rec[:col]) != user {
+			continue
+		}
+		these := make(map[string]bool)
+		for _, m := range strings.Split(rec[col+1:], ",") {
+			if m = strings.TrimSpace(m); m != "" {
+				these[m] = true
+			}
+		}
+		if res == nil {
+			res = these
+		} else {
+			for m := range res {
+				if !these[m] {
+					delete(res, m)
+				}
+			}
+		}
+	}
+	return
+}
+
+// authenticate returns nil if the request's credentials are not correct.
+func authenticate(r *http.Request) *rpc_user {
+	user, pass, ok := r.BasicAuth()
+	if !ok || pass == "" {
+		return nil
+	}
+
+	if user == COOKIE_USER {
+		cookie_mutex.Lock()
+		ok = cookie_pass != "" && equal_str(pass, cookie_pass)
+		cookie_mutex.Unlock()
+		if ok {
+			return &rpc_user{name: user}
+		}
+		return nil
+	}
+
+	common.LockCfg()
+	username, password := common.CFG.RPC.Username, common.CFG.RPC.Password
+	auth, whitelist := common.CFG.RPC.Auth, common.CFG.RPC.Whitelist
+	common.UnlockCfg()
+
+	ok = username != "" && password != "" && equal_str(user, username) && equal_str(pass, password)
+	if !ok {
+		ok = check_rpcauth(auth, user, pass)
+	}
+	if !ok {
+		return nil
+	}
+	return &rpc_user{name: user, methods: user_methods(whitelist, user)}
+}
+
+func unauthorized(w http.ResponseWriter, r *http.Request) {
+	println("RPC: incorrect password attempt from", r.RemoteAddr)
+	time.Sleep(250 * time.Millisecond) // to slow down brute force attacks
+	w.Header().Set("WWW-Authenticate", `Basic realm="jsonrpc"`)
+	http.Error(w, "Unauthorized", http.StatusUnauthorized)
+}
@@ -7,9 +7,7 @@ import (
 	"encoding/hex"
 	"github.com/piotrnar/gocoin/lib/btc"
 	"github.com/piotrnar/gocoin/client/network"
-	"encoding/json"
 	"io/ioutil"
-	"fmt"
 	"github.com/piotrnar/gocoin/client/common"
 )
 
@@ -22,46 +20,35 @@ type BlockSubmited struct {
 var RpcBlocks chan *BlockSubmited = make(chan *BlockSubmited, 1)
 
 
-func SubmitBlock(cmd *RpcCommand, resp *RpcResponse, b []byte) {
+func submitblock(params []interface{}) (interface{}, error) {
 	var bd []byte
 	var er error
 
-	switch uu := cmd.Params.(type) {
-		case []interface{}:
-			if len(uu)<1 {
-				resp.Error = RpcError{Code: -1, Message: "empty params array"}
-				return
-			}
-			str := uu[0].(string)
-			if str[0]=='@' {
-				/*
-					gocoin special case: if the string starts with @, it's a name of the file with block's binary data
-						curl --user gocoinrpc:gocoinpwd --data-binary \
-							'{"jsonrpc": "1.0", "id":"curltest", "method": "submitblock", "params": \
-								["@450529_000000000000000000cf208f521de0424677f7a87f2f278a1042f38d159565f5.bin"] }' \
-							-H 'content-type: text/plain;' http://127.0.0.1:8332/
-				*/
-				//println("jade z koksem", str[1:])
-				bd, er = ioutil.ReadFile(str[1:])
-			} else {
-				bd, er = hex.DecodeString(str)
-			}
-			if er != nil {
-				resp.Error = RpcError{Code: -3, Message: er.Error()}
-				return
-			}
-
-		default:
-			resp.Error = RpcError{Code: -2, Message: "incorrect params type"}
-			return
+	str, e := param_string(params, 0, "hexdata")
+	if e != nil {
+		return nil, e
+	}
+	if str != "" && str[0] == '@' {
+		/*
+			gocoin special case: if the string starts with @, it's a name of the file with block's binary data
+				curl --user $(cat ~/.bitcoin/gocoin/btcnet/.cookie) --data-binary \
+					'{"jsonrpc": "1.0", "id":"curltest", "method": "submitblock", "params": \
+						["@450529_000000000000000000cf208f521de0424677f7a87f2f278a1042f38d159565f5.bin"] }' \
+					-H 'content-type: text/plain;' http://127.0.0.1:8332/
+		*/
+		bd, er = ioutil.ReadFile(str[1:])
+	} else {
+		bd, er = hex.DecodeString(str)
+	}
+	if er != nil {
+		return nil, NewRpcError(RPC_DESERIALIZATION_ERROR, "Block decode failed ("+er.Error()+")")
 	}
 
 	bs := new(BlockSubmited)
 
 	bs.Block, er = btc.NewBlock(bd)
 	if er != nil {
-		resp.Error = RpcError{Code: -4, Message: er.Error()}
-		return
+		return nil, NewRpcError(RPC_DESERIALIZATION_ERROR, "Block decode failed ("+er.Error()+")")
 	}
 
 	network.MutexRcv.Lock()
@@ -73,15 +60,15 @@ func SubmitBlock(cmd *RpcCommand, resp *RpcResponse, b []byte) {
 	RpcBlocks <- bs
 	bs.Done.Wait()
 	if bs.Error != "" {
-		//resp.Error = RpcError{Code: -10, Message: bs.Error}
+		var result string
 		idx := strings.Index(bs.Error, "- RPC_Result:")
 		if idx == -1 {
-			resp.Result = "inconclusive"
+			result = "inconclusive"
 		} else {
-			resp.Result = bs.Error[idx+13:]
+			result = bs.Error[idx+13:]
 		}
 		println("submiting block error:", bs.Error)
-		println("submiting block result:", resp.Result.(string))
+		println("submiting block result:", result)
 
 		print("time_now:", time.Now().Unix())
 		print("  cur_block_ts:", bs.Block.BlockTime())
@@ -92,21 +79,14 @@ func SubmitBlock(cmd *RpcCommand, resp *RpcResponse, b []byte) {
 		common.Last.Mutex.Unlock()
 		println()
 
-		return
+		return result, nil
 	}
 
-	// cress check with bitcoind...
-	if false {
-		bitcoind_result := process_rpc(b)
-		json.Unmarshal(bitcoind_result, &resp)
-		switch cmd.Params.(type) {
-			case string:
-				println("\007Block rejected by bitcoind:", resp.Result.(string))
-				ioutil.WriteFile(fmt.Sprint(bs.Block.Height, "-", bs.Block.Hash.String()), bd, 0777)
-			default:
-				println("submiting block verified OK", bs.Error)
-		}
-	}
+	return nil, nil
 }
 
 var last_given_time, last_given_mintime uint32
+
+func init() {
+	register("submitblock", submitblock, "hexdata", "dummy")
+}
@@ -0,0 +1,250 @@
+package rpcapi
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/client/network"
+	"github.com/piotrnar/gocoin/lib/btc"
+	"github.com/piotrnar/gocoin/lib/chain"
+)
+
+type BlockHeaderJson struct {
+	Hash              string  `json:"hash"`
+	Confirmations     int     `json:"confirmations"`
+	Height            uint32  `json:"height"`
+	Version           uint32  `json:"version"`
+	VersionHex        string  `json:"versionHex"`
+	Merkleroot        string  `json:"merkleroot"`
+	Time              uint32  `json:"time"`
+	Mediantime        uint32  `json:"mediantime"`
+	Nonce             uint32  `json:"nonce"`
+	Bits              string  `json:"bits"`
+	Difficulty        float64 `json:"difficulty"`
+	NTx               uint32  `json:"nTx"`
+	Previousblockhash string  `json:"previousblockhash,omitempty"`
+	Nextblockhash     string  `json:"nextblockhash,omitempty"`
+}
+
+type BlockJson struct {
+	BlockHeaderJson
+	Size         int           `json:"size"`
+	Strippedsize int           `json:"strippedsize"`
+	Weight       uint          `json:"weight"`
+	Tx           []interface{} `json:"tx"`
+}
+
+func last_block() (res *chain.BlockTreeNode) {
+	common.Last.Mutex.Lock()
+	res = common.Last.Block
+	common.Last.Mutex.Unlock()
+	return
+}
+
+// find_block returns the block tree node for the given hash.
+func find_block(hash *btc.Uint256) *chain.BlockTreeNode {
+	common.BlockChain.BlockIndexAccess.Lock()
+	node := common.BlockChain.BlockIndex[hash.BIdx()]
+	common.BlockChain.BlockIndexAccess.Unlock()
+	return node
+}
+
+// active_chain[h] is the block of the active chain at height h (protected by BlockIndexAccess).
+// It gets updated from the current tip by node_at_height.
+var active_chain []*chain.BlockTreeNode
+
+// node_at_height returns the block of the active chain at the given height.
+func node_at_height(height uint32) (n *chain.BlockTreeNode) {
+	tip := last_block()
+	common.BlockChain.BlockIndexAccess.Lock()
+	defer common.BlockChain.BlockIndexAccess.Unlock()
+	if cnt := int(tip.Height) + 1; len(active_chain) != cnt || active_chain[tip.Height] != tip {
+		if len(active_chain) > cnt {
+			active_chain = active_chain[:cnt]
+		}
+		for len(active_chain) < cnt {
+			active_chain = append(active_chain, nil)
+		}
+		// after a reorg, only the blocks above the fork point need to be replaced
+		for n := tip; n != nil && active_chain[n.Height] != n; n = n.Parent {
+			active_chain[n.Height] = n
+		}
+	}
+	if height <= tip.Height {
+		n = active_chain[height]
+	}
+	return
+}
+
+func block_header_json(node *chain.BlockTreeNode) (res *BlockHeaderJson) {
+	last := last_block()
+	res = new(BlockHeaderJson)
+	res.Hash = node.BlockHash.String()
+	if node_at_height(node.Height) == node {
+		res.Confirmations = int(last.Height-node.Height) + 1
+		if next := node_at_height(node.Height + 1); next != nil {
+			res.Nextblockhash = next.BlockHash.String()
+		}
+	} else {
+		res.Confirmations = -1
+	}
+	res.Height = node.Height
+	res.Version = node.BlockVersion()
+	res.VersionHex = fmt.Sprintf("%08x", res.Version)
+	res.Merkleroot = btc.NewUint256(node.BlockHeader[36:68]).String()
+	res.Time = node.Timestamp()
+	res.Mediantime = node.GetMedianTimePast()
+	res.Nonce = binary.LittleEndian.Uint32(node.BlockHeader[76:80])
+	res.Bits = fmt.Sprintf("%08x", node.Bits())
+	res.Difficulty = btc.GetDifficulty(node.Bits())
+	res.NTx = node.TxCount
+	if node.Parent != nil {
+		res.Previousblockhash = node.Parent.BlockHash.String()
+	}
+	return
+}
+
+func getblockchaininfo(params []interface{}) (interface{}, error) {
+	var res struct {
+		Chain                string  `json:"chain"`
+		Blocks               uint32  `json:"blocks"`
+		Headers              uint32  `json:"headers"`
+		Bestblockhash        string  `json:"bestblockhash"`
+		Difficulty           float64 `json:"difficulty"`
+		Time                 uint32  `json:"time"`
+		Mediantime           uint32  `json:"mediantime"`
+		Verificationprogress float64 `json:"verificationprogress"`
+		Initialblockdownload bool    `json:"initialblockdownload"`
+		Pruned               bool    `json:"pruned"`
+		Warnings             string  `json:"warnings"`
+	}
+	if common.Testnet {
+		res.Chain = "test"
+	} else {
+		res.Chain = "main"
+	}
+	last := last_block()
+	res.Blocks = last.Height
+	res.Bestblockhash = last.BlockHash.String()
+	res.Difficulty = btc.GetDifficulty(last.Bits())
+	res.Time = last.Timestamp()
+	res.Mediantime = last.GetMedianTimePast()
+	network.MutexRcv.Lock()
+	res.Headers = network.LastCommitedHeader.Height
+	network.MutexRcv.Unlock()
+	if res.Headers > 0 {
+		res.Verificationprogress = float64(res.Blocks) / float64(res.Headers)
+	}
+	if res.Verificationprogress > 1 {
+		res.Verificationprogress = 1
+	}
+	res.Initialblockdownload = !common.GetBool(&common.BlockChainSynchronized)
+	return &res, nil
+}
+
+func getblockcount(params []interface{}) (interface{}, error) {
+	return last_block().Height, nil
+}
+
+func getbestblockhash(params []interface{}) (interface{}, error) {
+	return last_block().BlockHash.String(), nil
+}
+
+func getblockhash(params []interface{}) (interface{}, error) {
+	height, e := param_int(params, 0, "height", -1)
+	if e != nil {
+		return nil, e
+	}
+	if height < 0 {
+		return nil, NewRpcError(RPC_INVALID_PARAMETER, "Block height out of range")
+	}
+	node := node_at_height(uint32(height))
+	if node == nil {
+		return nil, NewRpcError(RPC_INVALID_PARAMETER, "Block height out of range")
+	}
+	return node.BlockHash.String(), nil
+}
+
+func getblockheader(params []interface{}) (interface{}, error) {
+	hash, e := param_hash(params, 0, "blockhash")
+	if e != nil {
+		return nil, e
+	}
+	verbose, e := param_bool(params, 1, "verbose", true)
+	if e != nil {
+		return nil, e
+	}
+	node := find_block(hash)
+	if node == nil {
+		return nil, NewRpcError(RPC_INVALID_ADDRESS_OR_KEY, "Block not found")
+	}
+	if !verbose {
+		return hex.EncodeToString(node.BlockHeader[:]), nil
+	}
+	return block_header_json(node), nil
+}
+
+func getblock(params []interface{}) (interface{}, error) {
+	hash, e := param_hash(params, 0, "blockhash")
+	if e != nil {
+		return nil, e
+	}
+	var verbosity int64 = 1
+	if !param_missing(params, 1) {
+		if b, ok := params[1].(bool); ok {
+			// the old way: verbose=true/false
+			if !b {
+				verbosity = 0
+			}
+		} else if verbosity, e = param_int(params, 1, "verbosity", 1); e != nil {
+			return nil, e
+		}
+	}
+
+	node := find_block(hash)
+	if node == nil {
+		return nil, NewRpcError(RPC_INVALID_ADDRESS_OR_KEY, "Block not found")
+	}
+	raw, _, er := common.BlockChain.Blocks.BlockGet(hash)
+	if er != nil {
+		return nil, NewRpcError(RPC_MISC_ERROR, "Block not available ("+er.Error()+")")
+	}
+	if verbosity <= 0 {
+		return hex.EncodeToString(raw), nil
+	}
+
+	bl, er := btc.NewBlock(raw)
+	if er == nil {
+		er = bl.BuildTxList()
+	}
+	if er != nil {
+		return nil, NewRpcError(RPC_INTERNAL_ERROR, "Block corrupt ("+er.Error()+")")
+	}
+
+	res := new(BlockJson)
+	res.BlockHeaderJson = *block_header_json(node)
+	res.NTx = uint32(len(bl.Txs))
+	res.Size = len(raw)
+	res.Strippedsize = bl.NoWitnessSize
+	res.Weight = bl.BlockWeight
+	res.Tx = make([]interface{}, len(bl.Txs))
+	for i, tx := range bl.Txs {
+		if verbosity == 1 {
+			res.Tx[i] = tx.Hash.String()
+		} else {
+			res.Tx[i] = tx_to_json(tx)
+		}
+	}
+	return res, nil
+}
+
+func init() {
+	register("getblockchaininfo", getblockchaininfo)
+	register("getblockcount", getblockcount)
+	register("getbestblockhash", getbestblockhash)
+	register("getblockhash", getblockhash, "height")
+	register("getblockheader", getblockheader, "blockhash", "verbose")
+	register("getblock", getblock, "blockhash", "verbosity")
+}
@@ -0,0 +1,132 @@
+package rpcapi
+
+import (
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/client/network"
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+// mempool_fee_estimate returns the fee (in satoshis per 1000 vbytes) that a transaction
+// needs to pay, in order to get into one of the next "blocks" blocks, as if they were
+// mined from the current content of the memory pool.
+func mempool_fee_estimate(blocks uint64) (spkb uint64) {
+	maxweight := blocks * btc.MAX_BLOCK_WEIGHT
+
+	network.TxMutex.Lock()
+	sorted := network.GetMempoolFees(maxweight)
+	network.TxMutex.Unlock()
+
+	var totweight uint64
+	for _, rec := range sorted {
+		totweight += rec[0]
+		if totweight >= maxweight {
+			spkb = 4000 * rec[1] / rec[0]
+			break
+		}
+	}
+	if minfee := common.MinFeePerKB(); spkb < minfee {
+		spkb = minfee
+	}
+	return
+}
+
+func estimatesmartfee(params []interface{}) (interface{}, error) {
+	var res struct {
+		Feerate float64 `json:"feerate"`
+		Blocks  int64   `json:"blocks"`
+	}
+	threshold := 0.95
+
+	conf_target, e := param_int(params, 0, "conf_target", -1)
+	if e != nil {
+		return nil, e
+	}
+	if conf_target < 1 || conf_target > 1008 {
+		return nil, NewRpcError(RPC_INVALID_PARAMETER, "Invalid conf_target, must be between 1 and 1008")
+	}
+	if !param_missing(params, 1) {
+		mode, e := param_string(params, 1, "estimate_mode")
+		if e != nil {
+			return nil, e
+		}
+		if mode != "unset" && mode != "economical" && mode != "conservative" &&
+			mode != "UNSET" && mode != "ECONOMICAL" && mode != "CONSERVATIVE" {
+			return nil, NewRpcError(RPC_INVALID_PARAMETER, "Invalid estimate_mode parameter")
+		}
+		if mode == "economical" || mode == "ECONOMICAL" {
+			threshold = 0.85
+		}
+	}
+
+	spkb := network.EstimateFee(int(conf_target), threshold).FeeSPKB
+	if spkb == 0 {
+		// not enough confirmation stats yet (e.g. a fresh node) - use the current mempool
+		spkb = mempool_fee_estimate(uint64(conf_target))
+	} else if minfee := common.MinFeePerKB(); spkb < minfee {
+		spkb = minfee
+	}
+	res.Feerate = float64(spkb) / 1e8
+	res.Blocks = conf_target
+	return &res, nil
+}
+
+type FeeEstRangeJson struct {
+	StartRange     float64 `json:"startrange"`
+	EndRange       float64 `json:"endrange"`
+	WithinTarget   float64 `json:"withintarget"`
+	TotalConfirmed float64 `json:"totalconfirmed"`
+	InMempool      float64 `json:"inmempool"`
+	LeftMempool    float64 `json:"leftmempool"`
+}
+
+func fee_est_range_json(r *network.FeeEstRange) *FeeEstRangeJson {
+	if r == nil {
+		return nil
+	}
+	return &FeeEstRangeJson{StartRange: float64(r.StartRange) / 1e8, EndRange: float64(r.EndRange) / 1e8,
+		WithinTarget: r.WithinTarget, TotalConfirmed: r.TotalConfirmed, InMempool: r.InMempool, LeftMempool: r.LeftMempool}
+}
+
+// estimaterawfee returns the fee rate (BTC/kvB) needed to get confirmed within conf_target blocks,
+// with the probability of threshold, as calculated by the fee estimator (without any fallback).
+func estimaterawfee(params []interface{}) (interface{}, error) {
+	var res struct {
+		Feerate float64          `json:"feerate,omitempty"`
+		Decay   float64          `json:"decay"`
+		Scale   int              `json:"scale"`
+		Pass    *FeeEstRangeJson `json:"pass,omitempty"`
+		Fail    *FeeEstRangeJson `json:"fail,omitempty"`
+		Errors  []string         `json:"errors,omitempty"`
+	}
+
+	conf_target, e := param_int(params, 0, "conf_target", -1)
+	if e != nil {
+		return nil, e
+	}
+	if conf_target < 1 || conf_target > network.FEE_EST_MAX_TARGET {
+		return nil, NewRpcError(RPC_INVALID_PARAMETER, "Invalid conf_target, must be between 1 and 1008")
+	}
+	threshold, e := param_float(params, 1, "threshold", 0.95)
+	if e != nil {
+		return nil, e
+	}
+	if threshold < 0 || threshold > 1 {
+		return nil, NewRpcError(RPC_INVALID_PARAMETER, "Invalid threshold")
+	}
+
+	est := network.EstimateFee(int(conf_target), threshold)
+	res.Feerate = float64(est.FeeSPKB) / 1e8
+	res.Decay = est.Decay
+	res.Scale = 1
+	res.Pass = fee_est_range_json(est.Pass)
+	res.Fail = fee_est_range_json(est.Fail)
+	if est.FeeSPKB == 0 {
+		res.Errors = []string{"Insufficient data or no feerate found which meets threshold"}
+	}
+	return &res, nil
+}
+
+func init() {
+	register("estimatesmartfee", estimatesmartfee, "conf_target", "estimate_mode")
+	register("estimaterawfee", estimaterawfee, "conf_target", "threshold")
+}
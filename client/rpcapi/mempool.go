@@ -0,0 +1,484 @@
+package rpcapi
+
+import (
+	"encoding/hex"
+	"encoding/json"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/client/network"
+	"github.com/piotrnar/gocoin/client/usif"
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+type MempoolEntryJson struct {
+	Vsize  int   `json:"vsize"`
+	Weight int   `json:"weight"`
+	Time   int64 `json:"time"`
+	Fees   struct {
+		Base       json.Number `json:"base"`
+		Modified   json.Number `json:"modified"`
+		Ancestor   json.Number `json:"ancestor"`
+		Descendant json.Number `json:"descendant"`
+	} `json:"fees"`
+	DescendantCount   int      `json:"descendantcount"`
+	DescendantSize    uint64   `json:"descendantsize"`
+	AncestorCount     int      `json:"ancestorcount"`
+	AncestorSize      uint64   `json:"ancestorsize"`
+	Depends           []string `json:"depends"`
+	Spentby           []string `json:"spentby"`
+	Bip125Replaceable bool     `json:"bip125-replaceable"`
+	Wtxid             string   `json:"wtxid"`
+}
+
+// mempool_entry_json must be called with network.TxMutex locked.
+func mempool_entry_json(t2s *network.OneTxToSend) (res *MempoolEntryJson) {
+	res = new(MempoolEntryJson)
+	res.Vsize = t2s.VSize()
+	res.Weight = t2s.Weight()
+	res.Time = t2s.Firstseen.Unix()
+	res.Fees.Base = btc_amount(t2s.Fee)
+	if fee := t2s.ModifiedFee(); fee >= 0 {
+		res.Fees.Modified = btc_amount(uint64(fee))
+	} else {
+		res.Fees.Modified = json.Number("-" + string(btc_amount(uint64(-fee))))
+	}
+	res.Fees.Ancestor = btc_amount(t2s.Ancestors.Fee)
+	res.Fees.Descendant = btc_amount(t2s.Descendants.Fee)
+	res.DescendantCount = t2s.Descendants.Cnt
+	res.DescendantSize = t2s.Descendants.VSize
+	res.AncestorCount = t2s.Ancestors.Cnt
+	res.AncestorSize = t2s.Ancestors.VSize
+	res.Depends = []string{}
+	if t2s.MemInputs != nil {
+		already := make(map[[32]byte]bool)
+		for i, inmem := range t2s.MemInputs {
+			h := t2s.TxIn[i].Input.Hash
+			if inmem && !already[h] {
+				res.Depends = append(res.Depends, btc.NewUint256(h[:]).String())
+				already[h] = true
+			}
+		}
+	}
+	res.Spentby = []string{}
+	for _, ch := range t2s.GetChildren() {
+		res.Spentby = append(res.Spentby, ch.Hash.String())
+	}
+	res.Bip125Replaceable = t2s.SignalsRBF()
+	res.Wtxid = t2s.WTxID().String()
+	return
+}
+
+func getrawmempool(params []interface{}) (interface{}, error) {
+	verbose, e := param_bool(params, 0, "verbose", false)
+	if e != nil {
+		return nil, e
+	}
+
+	network.TxMutex.Lock()
+	defer network.TxMutex.Unlock()
+
+	if verbose {
+		res := make(map[string]*MempoolEntryJson, len(network.TransactionsToSend))
+		for _, t2s := range network.TransactionsToSend {
+			res[t2s.Hash.String()] = mempool_entry_json(t2s)
+		}
+		return res, nil
+	}
+
+	res := make([]string, 0, len(network.TransactionsToSend))
+	for _, t2s := range network.GetSortedMempool() {
+		res = append(res, t2s.Hash.String())
+	}
+	return res, nil
+}
+
+func getmempoolentry(params []interface{}) (interface{}, error) {
+	txid, e := param_hash(params, 0, "txid")
+	if e != nil {
+		return nil, e
+	}
+
+	network.TxMutex.Lock()
+	defer network.TxMutex.Unlock()
+
+	t2s := network.TransactionsToSend[txid.BIdx()]
+	if t2s == nil {
+		return nil, NewRpcError(RPC_INVALID_ADDRESS_OR_KEY, "Transaction not in mempool")
+	}
+	return mempool_entry_json(t2s), nil
+}
+
+func gettxout(params []interface{}) (interface{}, error) {
+	var res struct {
+		Bestblock     string           `json:"bestblock"`
+		Confirmations uint32           `json:"confirmations"`
+		Value         json.Number      `json:"value"`
+		ScriptPubKey  ScriptPubKeyJson `json:"scriptPubKey"`
+		Coinbase      bool             `json:"coinbase"`
+	}
+
+	txid, e := param_hash(params, 0, "txid")
+	if e != nil {
+		return nil, e
+	}
+	vout, e := param_int(params, 1, "n", -1)
+	if e != nil {
+		return nil, e
+	}
+	if vout < 0 || vout > 0xffffffff {
+		return nil, NewRpcError(RPC_INVALID_PARAMETER, "Invalid output number")
+	}
+	include_mempool, e := param_bool(params, 2, "include_mempool", true)
+	if e != nil {
+		return nil, e
+	}
+
+	po := btc.TxPrevOut{Hash: txid.Hash, Vout: uint32(vout)}
+	last := last_block()
+	res.Bestblock = last.BlockHash.String()
+
+	if include_mempool {
+		network.TxMutex.Lock()
+		if _, spent := network.SpentOutputs[po.UIdx()]; spent {
+			network.TxMutex.Unlock()
+			return nil, nil
+		}
+		if t2s := network.TransactionsToSend[txid.BIdx()]; t2s != nil {
+			network.TxMutex.Unlock()
+			if int(po.Vout) >= len(t2s.TxOut) {
+				return nil, nil
+			}
+			res.Value = btc_amount(t2s.TxOut[po.Vout].Value)
+			res.ScriptPubKey = script_pubkey_json(t2s.TxOut[po.Vout].Pk_script)
+			return &res, nil
+		}
+		network.TxMutex.Unlock()
+	}
+
+	out := common.BlockChain.Unspent.UnspentGet(&po)
+	if out == nil {
+		return nil, nil
+	}
+	if last.Height >= out.BlockHeight {
+		res.Confirmations = last.Height - out.BlockHeight + 1
+	}
+	res.Value = btc_amount(out.Value)
+	res.ScriptPubKey = script_pubkey_json(out.Pk_script)
+	res.Coinbase = out.WasCoinbase
+	return &res, nil
+}
+
+func getrawtransaction(params []interface{}) (interface{}, error) {
+	type TxInBlockJson struct {
+		*TxJson
+		Blockhash     string `json:"blockhash"`
+		Confirmations int    `json:"confirmations"`
+		Time          uint32 `json:"time"`
+		Blocktime     uint32 `json:"blocktime"`
+	}
+
+	txid, e := param_hash(params, 0, "txid")
+	if e != nil {
+		return nil, e
+	}
+	verbose, e := param_bool(params, 1, "verbose", false)
+	if e != nil {
+		return nil, e
+	}
+
+	if !param_missing(params, 2) {
+		// look for it in the given block
+		bhash, e := param_hash(params, 2, "blockhash")
+		if e != nil {
+			return nil, e
+		}
+		node := find_block(bhash)
+		if node == nil {
+			return nil, NewRpcError(RPC_INVALID_ADDRESS_OR_KEY, "Block hash not found")
+		}
+		raw, _, er := common.BlockChain.Blocks.BlockGet(bhash)
+		if er != nil {
+			return nil, NewRpcError(RPC_MISC_ERROR, "Block not available ("+er.Error()+")")
+		}
+		bl, er := btc.NewBlock(raw)
+		if er == nil {
+			er = bl.BuildTxList()
+		}
+		if er != nil {
+			return nil, NewRpcError(RPC_INTERNAL_ERROR, "Block corrupt ("+er.Error()+")")
+		}
+		for _, tx := range bl.Txs {
+			if tx.Hash.Equal(txid) {
+				if !verbose {
+					return hex.EncodeToString(tx.Raw), nil
+				}
+				hdr := block_header_json(node)
+				return &TxInBlockJson{TxJson: tx_to_json(tx), Blockhash: hdr.Hash,
+					Confirmations: hdr.Confirmations, Time: hdr.Time, Blocktime: hdr.Time}, nil
+			}
+		}
+		return nil, NewRpcError(RPC_INVALID_ADDRESS_OR_KEY, "No such transaction found in the provided block")
+	}
+
+	network.TxMutex.Lock()
+	t2s := network.TransactionsToSend[txid.BIdx()]
+	network.TxMutex.Unlock()
+	if t2s == nil {
+		return nil, NewRpcError(RPC_INVALID_ADDRESS_OR_KEY, "No such mempool transaction. Provide a block hash to look for a mined transaction.")
+	}
+	if !verbose {
+		return hex.EncodeToString(t2s.Raw), nil
+	}
+	res := tx_to_json(t2s.Tx)
+	res.Fee = btc_amount(t2s.Fee)
+	return res, nil
+}
+
+// fee_of_tx returns the fee, or false if not all the inputs are known. Call it with network.TxMutex locked.
+func fee_of_tx(tx *btc.Tx) (fee uint64, ok bool) {
+	var totinp, totout uint64
+	for i := range tx.TxIn {
+		var out *btc.TxOut
+		if t2s := network.TransactionsToSend[btc.BIdx(tx.TxIn[i].Input.Hash[:])]; t2s != nil {
+			if int(tx.TxIn[i].Input.Vout) < len(t2s.TxOut) {
+				out = t2s.TxOut[tx.TxIn[i].Input.Vout]
+			}
+		} else {
+			out = common.BlockChain.Unspent.UnspentGet(&tx.TxIn[i].Input)
+		}
+		if out == nil {
+			return
+		}
+		totinp += out.Value
+	}
+	for i := range tx.TxOut {
+		totout += tx.TxOut[i].Value
+	}
+	if totout > totinp {
+		return
+	}
+	return totinp - totout, true
+}
+
+func sendrawtransaction(params []interface{}) (interface{}, error) {
+	s, e := param_string(params, 0, "hexstring")
+	if e != nil {
+		return nil, e
+	}
+	maxfeerate, e := param_float(params, 1, "maxfeerate", 0.10)
+	if e != nil {
+		return nil, e
+	}
+
+	raw, er := hex.DecodeString(s)
+	if er != nil {
+		return nil, NewRpcError(RPC_DESERIALIZATION_ERROR, "TX decode failed")
+	}
+	tx, le := btc.NewTx(raw)
+	if tx == nil || le != len(raw) {
+		return nil, NewRpcError(RPC_DESERIALIZATION_ERROR, "TX decode failed")
+	}
+	tx.SetHash(raw)
+
+	// the tx must be processed in sync with the main thread
+	lck := new(usif.OneLock)
+	lck.In.Add(1)
+	lck.Out.Add(1)
+	usif.LocksChan <- lck
+	lck.In.Wait()
+	defer lck.Out.Done()
+
+	network.RemoveFromRejected(&tx.Hash) // in case we rejected it eariler, to try it again as trusted
+
+	switch network.NeedThisTxExt(&tx.Hash, nil) {
+	case 1, 3:
+		return tx.Hash.String(), nil // already in the mempool (or about to be)
+	case 4:
+		return nil, NewRpcError(RPC_VERIFY_ALREADY_IN_CHAIN, "Transaction already in block chain")
+	}
+
+	if maxfeerate > 0 {
+		network.TxMutex.Lock()
+		fee, ok := fee_of_tx(tx)
+		network.TxMutex.Unlock()
+		if ok && float64(fee)*1000/float64(tx.VSize()) > maxfeerate*1e8 {
+			return nil, NewRpcError(RPC_VERIFY_REJECTED, "max-fee-exceeded")
+		}
+	}
+
+	if !network.SubmitLocalTx(tx, raw) {
+		network.TxMutex.Lock()
+		rr := network.TransactionsRejected[tx.Hash.BIdx()]
+		network.TxMutex.Unlock()
+		if rr != nil {
+			return nil, NewRpcError(RPC_VERIFY_REJECTED, network.ReasonToString(rr.Reason))
+		}
+		return nil, NewRpcError(RPC_VERIFY_ERROR, "Transaction not accepted")
+	}
+
+	network.TxMutex.Lock()
+	t2s := network.TransactionsToSend[tx.Hash.BIdx()]
+	network.TxMutex.Unlock()
+	if t2s == nil {
+		return nil, NewRpcError(RPC_VERIFY_ERROR, "Transaction not accepted")
+	}
+	t2s.Invsentcnt += network.NetRouteInv(network.MSG_TX, &tx.Hash, nil)
+
+	return tx.Hash.String(), nil
+}
+
+type ReplacedTxJson struct {
+	Txid     string      `json:"txid"`
+	Vsize    int         `json:"vsize"`
+	Fee      json.Number `json:"fee"`
+	Conflict bool        `json:"conflict"` // directly conflicting (not a descendant)
+	Bip125   bool        `json:"bip125-replaceable"`
+}
+
+// testreplace checks the BIP125 rules for the given replacement tx, without accepting it.
+func testreplace(params []interface{}) (interface{}, error) {
+	var res struct {
+		Txid       string            `json:"txid"`
+		Vsize      int               `json:"vsize"`
+		Fee        json.Number       `json:"fee"`
+		Replaced   []*ReplacedTxJson `json:"replaced"`
+		ReplFee    json.Number       `json:"replaced-fee"`
+		ReplVsize  int               `json:"replaced-vsize"`
+		Allowed    bool              `json:"allowed"`
+		Reason     string            `json:"reject-reason,omitempty"`
+		RuleFailed string            `json:"rule,omitempty"`
+	}
+
+	s, e := param_string(params, 0, "hexstring")
+	if e != nil {
+		return nil, e
+	}
+	raw, er := hex.DecodeString(s)
+	if er != nil {
+		return nil, NewRpcError(RPC_DESERIALIZATION_ERROR, "TX decode failed")
+	}
+	tx, le := btc.NewTx(raw)
+	if tx == nil || le != len(raw) {
+		return nil, NewRpcError(RPC_DESERIALIZATION_ERROR, "TX decode failed")
+	}
+	tx.SetHash(raw)
+
+	// the tx must be checked in sync with the main thread
+	lck := new(usif.OneLock)
+	lck.In.Add(1)
+	lck.Out.Add(1)
+	usif.LocksChan <- lck
+	lck.In.Wait()
+	defer lck.Out.Done()
+
+	rbf := network.TestReplace(tx)
+	if rbf == nil {
+		return nil, NewRpcError(RPC_INVALID_PARAMETER, "Transaction does not conflict with the mempool")
+	}
+	res.Txid = tx.Hash.String()
+	res.Vsize = rbf.VSize
+	res.Fee = btc_amount(rbf.Fee)
+	res.Replaced = []*ReplacedTxJson{}
+	network.TxMutex.Lock()
+	for _, t2s := range rbf.Evict {
+		r := &ReplacedTxJson{Txid: t2s.Hash.String(), Vsize: t2s.VSize(), Fee: btc_amount(t2s.Fee), Bip125: t2s.SignalsRBF()}
+		for _, c := range rbf.Conflicts {
+			r.Conflict = r.Conflict || c == t2s
+		}
+		res.Replaced = append(res.Replaced, r)
+	}
+	network.TxMutex.Unlock()
+	res.ReplFee = btc_amount(rbf.EvictFee)
+	res.ReplVsize = rbf.EvictVSize
+	res.Allowed = rbf.Reason == 0
+	if !res.Allowed {
+		res.Reason = network.ReasonToString(rbf.Reason)
+		res.RuleFailed = rbf.Rule
+	}
+	return &res, nil
+}
+
+func prioritisetransaction(params []interface{}) (interface{}, error) {
+	txid, e := param_hash(params, 0, "txid")
+	if e != nil {
+		return nil, e
+	}
+	if !param_missing(params, 1) {
+		if dummy, e := param_float(params, 1, "dummy", 0); e != nil || dummy != 0 {
+			return nil, NewRpcError(RPC_INVALID_PARAMETER, "Priority is no longer supported, dummy argument to prioritisetransaction must be 0.")
+		}
+	}
+	delta, e := param_int(params, 2, "fee_delta", 0)
+	if e != nil {
+		return nil, e
+	}
+	network.PrioritiseTx(txid, delta)
+	return true, nil
+}
+
+type PrioritisedTxJson struct {
+	FeeDelta    int64 `json:"fee_delta"`
+	InMempool   bool  `json:"in_mempool"`
+	ModifiedFee int64 `json:"modified_fee,omitempty"`
+}
+
+func getprioritisedtransactions(params []interface{}) (interface{}, error) {
+	network.TxMutex.Lock()
+	defer network.TxMutex.Unlock()
+
+	res := make(map[string]*PrioritisedTxJson, len(network.FeeDeltas))
+	for k, fd := range network.FeeDeltas {
+		r := &PrioritisedTxJson{FeeDelta: fd.Delta}
+		if t2s := network.TransactionsToSend[k]; t2s != nil {
+			r.InMempool = true
+			r.ModifiedFee = t2s.ModifiedFee()
+		}
+		res[fd.Id.String()] = r
+	}
+	return res, nil
+}
+
+func savemempool(params []interface{}) (interface{}, error) {
+	var res struct {
+		Filename string `json:"filename"`
+	}
+	if er := network.MempoolSave(true); er != nil {
+		return nil, NewRpcError(RPC_MISC_ERROR, "Unable to dump mempool to disk ("+er.Error()+")")
+	}
+	res.Filename = common.GocoinHomeDir + network.MEMPOOL_FILE_NAME2
+	return &res, nil
+}
+
+// importmempool loads txs from Bitcoin Core's mempool.dat file.
+func importmempool(params []interface{}) (interface{}, error) {
+	fname, e := param_string(params, 0, "filepath")
+	if e != nil {
+		return nil, e
+	}
+
+	// the txs must be processed in sync with the main thread
+	lck := new(usif.OneLock)
+	lck.In.Add(1)
+	lck.Out.Add(1)
+	usif.LocksChan <- lck
+	lck.In.Wait()
+	defer lck.Out.Done()
+
+	if er := network.MempoolImportCore(fname, nil); er != nil {
+		return nil, NewRpcError(RPC_MISC_ERROR, "Unable to import mempool file ("+er.Error()+")")
+	}
+	return map[string]interface{}{}, nil
+}
+
+func init() {
+	register("getrawmempool", getrawmempool, "verbose")
+	register("getmempoolentry", getmempoolentry, "txid")
+	register("gettxout", gettxout, "txid", "n", "include_mempool")
+	register("getrawtransaction", getrawtransaction, "txid", "verbose", "blockhash")
+	register("sendrawtransaction", sendrawtransaction, "hexstring", "maxfeerate")
+	register("testreplace", testreplace, "hexstring")
+	register("prioritisetransaction", prioritisetransaction, "txid", "dummy", "fee_delta")
+	register("getprioritisedtransactions", getprioritisedtransactions)
+	register("savemempool", savemempool)
+	register("importmempool", importmempool, "filepath", "options")
+}
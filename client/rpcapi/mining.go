@@ -41,12 +41,6 @@ type GetBlockTemplateResp struct {
 	Height uint `json:"height"`
 }
 
-type RpcGetBlockTemplateResp struct {
-	Id interface{} `json:"id"`
-	Result GetBlockTemplateResp `json:"result"`
-	Error interface{} `json:"error"`
-}
-
 func GetNextBlockTemplate(r *GetBlockTemplateResp) {
 	var zer [32]byte
 
@@ -82,6 +76,12 @@ func GetNextBlockTemplate(r *GetBlockTemplateResp) {
 	common.Last.Mutex.Unlock()
 }
 
+func getblocktemplate(params []interface{}) (interface{}, error) {
+	res := new(GetBlockTemplateResp)
+	GetNextBlockTemplate(res)
+	return res, nil
+}
+
 
 
 /* memory pool transaction sorting stuff */
@@ -94,7 +94,7 @@ type one_mining_tx struct {
 type sortedTxList []*one_mining_tx
 func (tl sortedTxList) Len() int {return len(tl)}
 func (tl sortedTxList) Swap(i, j int)      { tl[i], tl[j] = tl[j], tl[i] }
-func (tl sortedTxList) Less(i, j int) bool { return tl[j].Fee < tl[i].Fee }
+func (tl sortedTxList) Less(i, j int) bool { return tl[j].ModifiedFee() < tl[i].ModifiedFee() }
 
 
 var txs_so_far map[[32]byte] uint
@@ -196,3 +196,7 @@ func GetTransactions(height, timestamp uint32) (res []OneTransaction, totfees ui
 	//println("returning transacitons:", totlen, len(res))
 	return
 }
+
+func init() {
+	register("getblocktemplate", getblocktemplate, "template_request")
+}
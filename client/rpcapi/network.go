@@ -0,0 +1,157 @@
+package rpcapi
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/piotrnar/gocoin"
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/client/network"
+)
+
+type PeerInfoJson struct {
+	Id             uint32  `json:"id"`
+	Addr           string  `json:"addr"`
+	Addrlocal      string  `json:"addrlocal,omitempty"`
+	Services       string  `json:"services"`
+	Relaytxes      bool    `json:"relaytxes"`
+	Lastsend       int64   `json:"lastsend"`
+	Lastrecv       int64   `json:"lastrecv"`
+	Bytessent      uint64  `json:"bytessent"`
+	Bytesrecv      uint64  `json:"bytesrecv"`
+	Conntime       int64   `json:"conntime"`
+	Pingtime       float64 `json:"pingtime"`
+	Version        uint32  `json:"version"`
+	Subver         string  `json:"subver"`
+	Inbound        bool    `json:"inbound"`
+	Startingheight uint32  `json:"startingheight"`
+	Minfeefilter   float64 `json:"minfeefilter"`
+	ConnectionType string  `json:"connection_type"`
+	Transport      string  `json:"transport_protocol_type"`
+}
+
+// client_version converts gocoin's version string (e.g. "1.9.9") to a number (e.g. 10909).
+func client_version() (res int) {
+	ver := strings.TrimRight(gocoin.Version, "abcdefghijklmnopqrstuvwxyz")
+	for i, s := range strings.SplitN(ver, ".", 3) {
+		n, _ := strconv.Atoi(s)
+		res += n * []int{10000, 100, 1}[i]
+	}
+	return
+}
+
+func unix_or_zero(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+func getpeerinfo(params []interface{}) (interface{}, error) {
+	network.Mutex_net.Lock()
+	cons := make([]*network.OneConnection, 0, len(network.OpenCons))
+	for _, v := range network.OpenCons {
+		cons = append(cons, v)
+	}
+	network.Mutex_net.Unlock()
+
+	res := make([]*PeerInfoJson, 0, len(cons))
+	for _, v := range cons {
+		var ci network.ConnInfo
+		v.GetStats(&ci)
+		if !ci.VersionReceived {
+			continue
+		}
+		p := new(PeerInfoJson)
+		p.Id = ci.ID
+		p.Addr = ci.RemoteAddr
+		p.Addrlocal = ci.LocalAddr
+		p.Services = fmt.Sprintf("%016x", ci.Services)
+		p.Relaytxes = !ci.DoNotRelayTxs
+		p.Lastsend = unix_or_zero(ci.LastSent)
+		p.Lastrecv = unix_or_zero(ci.LastDataGot)
+		p.Bytessent = ci.BytesSent
+		p.Bytesrecv = ci.BytesReceived
+		p.Conntime = unix_or_zero(ci.ConnectedAt)
+		p.Pingtime = float64(ci.AveragePing) / 1e3
+		p.Version = ci.Version
+		p.Subver = ci.Agent
+		p.Inbound = ci.Incomming
+		p.Startingheight = ci.Height
+		p.Minfeefilter = float64(ci.MinFeeSPKB) / 1e8
+		if ci.V2Transport {
+			p.Transport = "v2"
+		} else {
+			p.Transport = "v1"
+		}
+		if ci.Incomming {
+			p.ConnectionType = "inbound"
+		} else if ci.BlockRelayOnly {
+			p.ConnectionType = "block-relay-only"
+		} else if ci.IsFeeler {
+			p.ConnectionType = "feeler"
+		} else {
+			p.ConnectionType = "outbound-full-relay"
+		}
+		res = append(res, p)
+	}
+	return res, nil
+}
+
+func getnetworkinfo(params []interface{}) (interface{}, error) {
+	type local_address struct {
+		Address string `json:"address"`
+		Port    uint16 `json:"port"`
+	}
+	var res struct {
+		Version         int             `json:"version"`
+		Subversion      string          `json:"subversion"`
+		Protocolversion uint32          `json:"protocolversion"`
+		Localservices   string          `json:"localservices"`
+		Localrelay      bool            `json:"localrelay"`
+		Timeoffset      int             `json:"timeoffset"`
+		Networkactive   bool            `json:"networkactive"`
+		Connections     int             `json:"connections"`
+		ConnectionsIn   int             `json:"connections_in"`
+		ConnectionsOut  int             `json:"connections_out"`
+		Relayfee        float64         `json:"relayfee"`
+		Incrementalfee  float64         `json:"incrementalfee"`
+		Localaddresses  []local_address `json:"localaddresses"`
+		Warnings        string          `json:"warnings"`
+	}
+
+	res.Version = client_version()
+	res.Subversion = common.UserAgent
+	res.Protocolversion = common.Version
+	res.Localservices = fmt.Sprintf("%016x", common.Services)
+	res.Localrelay = common.GetBool(&common.CFG.TXRoute.Enabled)
+	res.Networkactive = !common.NetworkClosed.Get()
+
+	network.Mutex_net.Lock()
+	for _, v := range network.OpenCons {
+		v.Mutex.Lock()
+		if v.X.Incomming {
+			res.ConnectionsIn++
+		} else {
+			res.ConnectionsOut++
+		}
+		v.Mutex.Unlock()
+	}
+	network.Mutex_net.Unlock()
+	res.Connections = res.ConnectionsIn + res.ConnectionsOut
+
+	res.Relayfee = float64(common.MinFeePerKB()) / 1e8
+	res.Incrementalfee = res.Relayfee
+	res.Localaddresses = []local_address{}
+	if ip := common.GetExternalIp(); ip != "" {
+		res.Localaddresses = append(res.Localaddresses, local_address{Address: ip, Port: common.DefaultTcpPort()})
+	}
+	return &res, nil
+}
+
+func init() {
+	register("getpeerinfo", getpeerinfo)
+	register("getnetworkinfo", getnetworkinfo)
+}
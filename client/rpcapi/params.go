@@ -0,0 +1,88 @@
+package rpcapi
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+// The helpers below fetch the parameter from the given position.
+// Optional ones return the default value if the parameter is missing or null.
+
+func param_missing(params []interface{}, idx int) bool {
+	return idx >= len(params) || params[idx] == nil
+}
+
+func param_string(params []interface{}, idx int, name string) (string, error) {
+	if param_missing(params, idx) {
+		return "", NewRpcError(RPC_INVALID_PARAMS, "Missing parameter "+name)
+	}
+	s, ok := params[idx].(string)
+	if !ok {
+		return "", NewRpcError(RPC_TYPE_ERROR, "Expected type string for "+name)
+	}
+	return s, nil
+}
+
+func param_int(params []interface{}, idx int, name string, def int64) (int64, error) {
+	if param_missing(params, idx) {
+		return def, nil
+	}
+	n, ok := params[idx].(json.Number)
+	if !ok {
+		return 0, NewRpcError(RPC_TYPE_ERROR, "Expected type number for "+name)
+	}
+	v, e := n.Int64()
+	if e != nil {
+		return 0, NewRpcError(RPC_TYPE_ERROR, "Expected integer value for "+name)
+	}
+	return v, nil
+}
+
+func param_float(params []interface{}, idx int, name string, def float64) (float64, error) {
+	if param_missing(params, idx) {
+		return def, nil
+	}
+	n, ok := params[idx].(json.Number)
+	if !ok {
+		return 0, NewRpcError(RPC_TYPE_ERROR, "Expected type number for "+name)
+	}
+	v, e := n.Float64()
+	if e != nil {
+		return 0, NewRpcError(RPC_TYPE_ERROR, "Expected number value for "+name)
+	}
+	return v, nil
+}
+
+// param_bool also accepts numbers, as some methods (e.g. getrawtransaction) had it changed over the time.
+func param_bool(params []interface{}, idx int, name string, def bool) (bool, error) {
+	if param_missing(params, idx) {
+		return def, nil
+	}
+	switch v := params[idx].(type) {
+	case bool:
+		return v, nil
+	case json.Number:
+		n, e := v.Int64()
+		if e == nil {
+			return n != 0, nil
+		}
+	}
+	return false, NewRpcError(RPC_TYPE_ERROR, "Expected type bool for "+name)
+}
+
+func param_hash(params []interface{}, idx int, name string) (*btc.Uint256, error) {
+	s, e := param_string(params, idx, name)
+	if e != nil {
+		return nil, e
+	}
+	if len(s) != 64 {
+		return nil, NewRpcError(RPC_INVALID_PARAMETER, fmt.Sprint(name, " must be of length 64 (not ", len(s), ")"))
+	}
+	h := btc.NewUint256FromString(s)
+	if h == nil {
+		return nil, NewRpcError(RPC_INVALID_PARAMETER, name+" must be hexadecimal string")
+	}
+	return h, nil
+}
@@ -0,0 +1,148 @@
+package rpcapi
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+
+	"github.com/piotrnar/gocoin/client/common"
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+type ScriptSigJson struct {
+	Asm string `json:"asm"`
+	Hex string `json:"hex"`
+}
+
+type ScriptPubKeyJson struct {
+	Asm     string `json:"asm"`
+	Hex     string `json:"hex"`
+	Address string `json:"address,omitempty"`
+	Type    string `json:"type"`
+}
+
+type TxInJson struct {
+	Coinbase    string         `json:"coinbase,omitempty"`
+	Txid        string         `json:"txid,omitempty"`
+	Vout        *uint32        `json:"vout,omitempty"`
+	ScriptSig   *ScriptSigJson `json:"scriptSig,omitempty"`
+	TxinWitness []string       `json:"txinwitness,omitempty"`
+	Sequence    uint32         `json:"sequence"`
+}
+
+type TxOutJson struct {
+	Value        json.Number      `json:"value"`
+	N            int              `json:"n"`
+	ScriptPubKey ScriptPubKeyJson `json:"scriptPubKey"`
+}
+
+type TxJson struct {
+	Txid     string      `json:"txid"`
+	Hash     string      `json:"hash"`
+	Version  uint32      `json:"version"`
+	Size     int         `json:"size"`
+	Vsize    int         `json:"vsize"`
+	Weight   int         `json:"weight"`
+	Locktime uint32      `json:"locktime"`
+	Vin      []TxInJson  `json:"vin"`
+	Vout     []TxOutJson `json:"vout"`
+	Fee      json.Number `json:"fee,omitempty"`
+	Hex      string      `json:"hex"`
+}
+
+// btc_amount returns the value in BTC, as a number with 8 decimal places.
+func btc_amount(val uint64) json.Number {
+	return json.Number(btc.UintToBtc(val))
+}
+
+func script_asm(scr []byte) string {
+	if len(scr) == 0 {
+		return ""
+	}
+	txt, e := btc.ScriptToText(scr)
+	if e != nil {
+		return "[error]"
+	}
+	return strings.Join(txt, " ")
+}
+
+// script_type returns the output type, as named by bitcoind.
+func script_type(scr []byte) string {
+	if ver, prog := btc.IsWitnessProgram(scr); prog != nil {
+		switch {
+		case ver == 0 && len(prog) == 20:
+			return "witness_v0_keyhash"
+		case ver == 0 && len(prog) == 32:
+			return "witness_v0_scripthash"
+		case ver == 1 && len(prog) == 32:
+			return "witness_v1_taproot"
+		}
+		return "witness_unknown"
+	}
+	switch {
+	case len(scr) == 25 && scr[0] == 0x76 && scr[1] == 0xa9 && scr[2] == 0x14 && scr[23] == 0x88 && scr[24] == 0xac:
+		return "pubkeyhash"
+	case btc.IsP2SH(scr):
+		return "scripthash"
+	case len(scr) == 35 && scr[0] == 33 && scr[34] == 0xac, len(scr) == 67 && scr[0] == 65 && scr[66] == 0xac:
+		return "pubkey"
+	case len(scr) > 0 && scr[0] == 0x6a:
+		return "nulldata"
+	}
+	if ms, _ := btc.NewMultiSigFromP2SH(scr); ms != nil {
+		return "multisig"
+	}
+	return "nonstandard"
+}
+
+func script_pubkey_json(scr []byte) (res ScriptPubKeyJson) {
+	res.Asm = script_asm(scr)
+	res.Hex = hex.EncodeToString(scr)
+	res.Type = script_type(scr)
+	if ad := btc.NewAddrFromPkScript(scr, common.Testnet); ad != nil {
+		res.Address = ad.String()
+	}
+	return
+}
+
+// tx_to_json decodes the transaction, the same way as bitcoind's decoderawtransaction does.
+// The tx must have its hash set.
+func tx_to_json(tx *btc.Tx) (res *TxJson) {
+	res = new(TxJson)
+	res.Txid = tx.Hash.String()
+	res.Hash = btc.NewSha2Hash(tx.Raw).String()
+	res.Version = tx.Version
+	res.Size = len(tx.Raw)
+	res.Vsize = tx.VSize()
+	res.Weight = tx.Weight()
+	res.Locktime = tx.Lock_time
+	res.Hex = hex.EncodeToString(tx.Raw)
+
+	res.Vin = make([]TxInJson, len(tx.TxIn))
+	for i, in := range tx.TxIn {
+		vin := &res.Vin[i]
+		if tx.IsCoinBase() {
+			vin.Coinbase = hex.EncodeToString(in.ScriptSig)
+		} else {
+			vout := in.Input.Vout
+			vin.Txid = btc.NewUint256(in.Input.Hash[:]).String()
+			vin.Vout = &vout
+			vin.ScriptSig = &ScriptSigJson{Asm: script_asm(in.ScriptSig), Hex: hex.EncodeToString(in.ScriptSig)}
+		}
+		if tx.SegWit != nil && len(tx.SegWit[i]) > 0 {
+			vin.TxinWitness = make([]string, len(tx.SegWit[i]))
+			for j, w := range tx.SegWit[i] {
+				vin.TxinWitness[j] = hex.EncodeToString(w)
+			}
+		}
+		vin.Sequence = in.Sequence
+	}
+
+	res.Vout = make([]TxOutJson, len(tx.TxOut))
+	for i, out := range tx.TxOut {
+		res.Vout[i].Value = btc_amount(out.Value)
+		res.Vout[i].N = i
+		res.Vout[i].ScriptPubKey = script_pubkey_json(out.Pk_script)
+	}
+	return
+}
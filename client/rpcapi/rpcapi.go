@@ -1,7 +1,7 @@
 package rpcapi
 
-// test it with:
-// curl --user someuser:somepass --data-binary '{"method":"Arith.Add","params":[{"A":7,"B":1}],"id":0}' -H 'content-type: text/plain;' http://127.0.0.1:8222/
+// test it with (the cookie file is re-created each time the node starts):
+// curl --user $(cat ~/.bitcoin/gocoin/btcnet/.cookie) --data-binary '{"jsonrpc":"1.0","id":0,"method":"getblockchaininfo","params":[]}' -H 'content-type: text/plain;' http://127.0.0.1:8332/
 
 import (
 	"bytes"
@@ -9,8 +9,29 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
-	"os/exec"
-	"github.com/piotrnar/gocoin/client/common"
+	"sort"
+	"strings"
+)
+
+// Error codes, as used by bitcoind
+const (
+	RPC_INVALID_REQUEST  = -32600
+	RPC_METHOD_NOT_FOUND = -32601
+	RPC_INVALID_PARAMS   = -32602
+	RPC_INTERNAL_ERROR   = -32603
+	RPC_PARSE_ERROR      = -32700
+
+	RPC_MISC_ERROR              = -1
+	RPC_TYPE_ERROR              = -3
+	RPC_INVALID_ADDRESS_OR_KEY  = -5
+	RPC_INVALID_PARAMETER       = -8
+	RPC_DESERIALIZATION_ERROR   = -22
+	RPC_VERIFY_ERROR            = -25
+	RPC_VERIFY_REJECTED         = -26
+	RPC_VERIFY_ALREADY_IN_CHAIN = -27
+	RPC_IN_WARMUP               = -28
+
+	MAX_REQUEST_SIZE = 32 << 20 // submitblock needs big ones
 )
 
 type RpcError struct {
@@ -18,129 +39,280 @@ type RpcError struct {
 	Message string `json:"message"`
 }
 
+func (e *RpcError) Error() string {
+	return e.Message
+}
+
+func NewRpcError(code int, msg string) *RpcError {
+	return &RpcError{Code: code, Message: msg}
+}
+
 type RpcResponse struct {
-	Id     interface{} `json:"id"`
-	Result interface{} `json:"result"`
-	Error  interface{} `json:"error"`
+	Id     json.RawMessage `json:"id"`
+	Result interface{}     `json:"result"`
+	Error  interface{}     `json:"error"`
+}
+
+// JSON-RPC 2.0 response has either the result or the error
+type rpc2_result struct {
+	Jsonrpc string          `json:"jsonrpc"`
+	Result  interface{}     `json:"result"`
+	Id      json.RawMessage `json:"id"`
+}
+
+type rpc2_error struct {
+	Jsonrpc string          `json:"jsonrpc"`
+	Error   *RpcError       `json:"error"`
+	Id      json.RawMessage `json:"id"`
 }
 
 type RpcCommand struct {
-	Id     interface{} `json:"id"`
-	Method string      `json:"method"`
-	Params interface{} `json:"params"`
+	Jsonrpc string          `json:"jsonrpc"`
+	Id      json.RawMessage `json:"id"`
+	Method  string          `json:"method"`
+	Params  interface{}     `json:"params"`
 }
 
-func process_rpc(b []byte) (out []byte) {
-	ioutil.WriteFile("rpc_cmd.json", b, 0777)
-	ex_cmd := exec.Command("C:\\Tools\\DEV\\Git\\mingw64\\bin\\curl.EXE",
-		"--user", "gocoinrpc:gocoinpwd", "--data-binary", "@rpc_cmd.json", "http://127.0.0.1:18332/")
-	out, _ = ex_cmd.Output()
-	return
+type rpc_method struct {
+	handler func(params []interface{}) (interface{}, error)
+	args    []string // parameter names, for calls with named parameters
 }
 
-func my_handler(w http.ResponseWriter, r *http.Request) {
-	u, p, ok := r.BasicAuth()
-	if !ok {
-		println("No HTTP Authentication data")
-		return
+var rpc_methods map[string]*rpc_method = make(map[string]*rpc_method)
+
+// register adds a new method to the RPC server. Call it from init() only.
+func register(name string, handler func(params []interface{}) (interface{}, error), args ...string) {
+	rpc_methods[name] = &rpc_method{handler: handler, args: args}
+}
+
+// positional_params converts the params of the given command into an array.
+func (m *rpc_method) positional_params(p interface{}) ([]interface{}, *RpcError) {
+	switch pp := p.(type) {
+	case nil:
+		return nil, nil
+	case []interface{}:
+		return pp, nil
+	case map[string]interface{}:
+		var res []interface{}
+		for k, v := range pp {
+			idx := -1
+			for i := range m.args {
+				if m.args[i] == k {
+					idx = i
+					break
+				}
+			}
+			if idx < 0 {
+				return nil, NewRpcError(RPC_INVALID_PARAMETER, "Unknown named parameter "+k)
+			}
+			for len(res) <= idx {
+				res = append(res, nil)
+			}
+			res[idx] = v
+		}
+		return res, nil
 	}
-	if u != common.CFG.RPC.Username {
-		println("HTTP Authentication: bad username")
+	return nil, NewRpcError(RPC_INVALID_REQUEST, "Params must be an array or object")
+}
+
+// execute runs a single command and returns its result or error.
+func execute(cmd *RpcCommand) (result interface{}, rpcerr *RpcError) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Println("RPC", cmd.Method, "panic:", r)
+			result = nil
+			rpcerr = NewRpcError(RPC_INTERNAL_ERROR, fmt.Sprint("Internal error: ", r))
+		}
+	}()
+
+	m := rpc_methods[cmd.Method]
+	if m == nil {
+		rpcerr = NewRpcError(RPC_METHOD_NOT_FOUND, "Method not found")
 		return
 	}
-	if p != common.CFG.RPC.Password {
-		println("HTTP Authentication: bad password")
+	params, rpcerr := m.positional_params(cmd.Params)
+	if rpcerr != nil {
 		return
 	}
-	//fmt.Println("========================handler", r.Method, r.URL.String(), u, p, ok, "=================")
-	b, e := ioutil.ReadAll(r.Body)
+	result, e := m.handler(params)
 	if e != nil {
-		println(e.Error())
-		return
+		result = nil
+		if re, ok := e.(*RpcError); ok {
+			rpcerr = re
+		} else {
+			rpcerr = NewRpcError(RPC_MISC_ERROR, e.Error())
+		}
 	}
+	return
+}
 
-	var RpcCmd RpcCommand
-	jd := json.NewDecoder(bytes.NewReader(b))
+// process_one executes the command and returns the response object (nil for JSON-RPC 2.0 notifications).
+func process_one(raw json.RawMessage) (resp interface{}, rpcerr *RpcError) {
+	var cmd RpcCommand
+	jd := json.NewDecoder(bytes.NewReader(raw))
 	jd.UseNumber()
-	e = jd.Decode(&RpcCmd)
+	if e := jd.Decode(&cmd); e != nil {
+		rpcerr = NewRpcError(RPC_INVALID_REQUEST, "Invalid Request object: "+e.Error())
+		return RpcResponse{Error: rpcerr}, rpcerr
+	}
+
+	if cmd.Method == "" {
+		rpcerr = NewRpcError(RPC_INVALID_REQUEST, "Method must be a string")
+	}
+
+	var result interface{}
+	if rpcerr == nil {
+		result, rpcerr = execute(&cmd)
+	}
+
+	if cmd.Jsonrpc == "2.0" {
+		if len(cmd.Id) == 0 {
+			return nil, rpcerr // a notification - no response
+		}
+		if rpcerr != nil {
+			return rpc2_error{Jsonrpc: "2.0", Error: rpcerr, Id: cmd.Id}, rpcerr
+		}
+		return rpc2_result{Jsonrpc: "2.0", Result: result, Id: cmd.Id}, nil
+	}
+
+	if rpcerr != nil {
+		return RpcResponse{Id: cmd.Id, Error: rpcerr}, rpcerr
+	}
+	return RpcResponse{Id: cmd.Id, Result: result}, nil
+}
+
+// http_status returns HTTP status for a legacy (JSON-RPC 1.0) error response, the way bitcoind does it.
+func http_status(e *RpcError) int {
+	switch e.Code {
+	case RPC_INVALID_REQUEST:
+		return http.StatusBadRequest
+	case RPC_METHOD_NOT_FOUND:
+		return http.StatusNotFound
+	}
+	return http.StatusInternalServerError
+}
+
+func write_json(w http.ResponseWriter, status int, v interface{}) {
+	b, e := json.Marshal(v)
 	if e != nil {
-		println(e.Error())
+		println("json.Marshal(&resp):", e.Error())
+		status = http.StatusInternalServerError
+		b, _ = json.Marshal(RpcResponse{Error: NewRpcError(RPC_INTERNAL_ERROR, e.Error())})
 	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(append(b, 0x0a))
+}
 
-	var resp RpcResponse
-	resp.Id = RpcCmd.Id
-	switch RpcCmd.Method {
-		case "getblocktemplate":
-			var resp_my RpcGetBlockTemplateResp
-
-			GetNextBlockTemplate(&resp_my.Result)
-
-			if false {
-				var resp_ok RpcGetBlockTemplateResp
-				bitcoind_result := process_rpc(b)
-				//ioutil.WriteFile("getblocktemplate_resp.json", bitcoind_result, 0777)
-
-				//fmt.Print("getblocktemplate...", sto.Sub(sta).String(), string(b))
-
-				jd = json.NewDecoder(bytes.NewReader(bitcoind_result))
-				jd.UseNumber()
-				e = jd.Decode(&resp_ok)
-
-				if resp_my.Result.PreviousBlockHash != resp_ok.Result.PreviousBlockHash {
-					println("satoshi @", resp_ok.Result.PreviousBlockHash, resp_ok.Result.Height)
-					println("gocoin  @", resp_my.Result.PreviousBlockHash, resp_my.Result.Height)
-				} else {
-					println(".", len(resp_my.Result.Transactions), resp_my.Result.Coinbasevalue)
-					if resp_my.Result.Mintime != resp_ok.Result.Mintime {
-						println("\007Mintime:", resp_my.Result.Mintime, resp_ok.Result.Mintime)
-					}
-					if resp_my.Result.Bits != resp_ok.Result.Bits {
-						println("\007Bits:", resp_my.Result.Bits, resp_ok.Result.Bits)
-					}
-					if resp_my.Result.Target != resp_ok.Result.Target {
-						println("\007Target:", resp_my.Result.Target, resp_ok.Result.Target)
-					}
-				}
-			}
+// not_allowed returns the first method that the user is not allowed to call (or an empty string).
+func not_allowed(user *rpc_user, cmds []json.RawMessage) string {
+	if user.methods == nil {
+		return ""
+	}
+	for _, c := range cmds {
+		var cmd struct {
+			Method interface{} `json:"method"`
+		}
+		json.Unmarshal(c, &cmd)
+		if m, _ := cmd.Method.(string); !user.can_call(m) {
+			return fmt.Sprint(cmd.Method)
+		}
+	}
+	return ""
+}
 
-			b, _ = json.Marshal(&resp_my)
-			//ioutil.WriteFile("json/"+RpcCmd.Method+"_resp_my.json", b, 0777)
-			w.Write(append(b, 0x0a))
-			return
+func forbidden(w http.ResponseWriter, user *rpc_user, method string) {
+	println("RPC: user", user.name, "not allowed to call method", method)
+	http.Error(w, "Forbidden", http.StatusForbidden)
+}
 
+func my_handler(w http.ResponseWriter, r *http.Request) {
+	user := authenticate(r)
+	if user == nil {
+		unauthorized(w, r)
+		return
+	}
 
-		case "validateaddress":
-			switch uu := RpcCmd.Params.(type) {
-			case []interface{}:
-				if len(uu) == 1 {
-					resp.Result = ValidateAddress(uu[0].(string))
-				}
-			default:
-				println("unexpected type", uu)
+	if r.Method != "POST" {
+		http.Error(w, "JSONRPC server handles only POST requests", http.StatusMethodNotAllowed)
+		return
+	}
+
+	b, e := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, MAX_REQUEST_SIZE))
+	if e != nil {
+		println(e.Error())
+		return
+	}
+
+	b = bytes.TrimSpace(b)
+	if len(b) > 0 && b[0] == '[' {
+		// batch request
+		var cmds []json.RawMessage
+		if e = json.Unmarshal(b, &cmds); e != nil {
+			write_json(w, http.StatusInternalServerError, RpcResponse{Error: NewRpcError(RPC_PARSE_ERROR, "Parse error")})
+			return
+		}
+		if len(cmds) == 0 {
+			write_json(w, http.StatusBadRequest, RpcResponse{Error: NewRpcError(RPC_INVALID_REQUEST, "Empty batch request")})
+			return
+		}
+		if m := not_allowed(user, cmds); m != "" {
+			forbidden(w, user, m)
+			return
+		}
+		res := make([]interface{}, 0, len(cmds))
+		for _, c := range cmds {
+			if resp, _ := process_one(c); resp != nil {
+				res = append(res, resp)
 			}
+		}
+		write_json(w, http.StatusOK, res)
+		return
+	}
 
-		case "submitblock":
-			//ioutil.WriteFile("submitblock.json", b, 0777)
-			SubmitBlock(&RpcCmd, &resp, b)
+	if !json.Valid(b) {
+		write_json(w, http.StatusInternalServerError, RpcResponse{Error: NewRpcError(RPC_PARSE_ERROR, "Parse error")})
+		return
+	}
 
-		default:
-			fmt.Println("Method:", RpcCmd.Method, len(b))
-			//w.Write(bitcoind_result)
-			resp.Error = RpcError{Code: -32601, Message: "Method not found"}
+	if m := not_allowed(user, []json.RawMessage{b}); m != "" {
+		forbidden(w, user, m)
+		return
 	}
 
-	b, e = json.Marshal(&resp)
-	if e != nil {
-		println("json.Marshal(&resp):", e.Error())
+	resp, rpcerr := process_one(b)
+	if resp == nil {
+		w.WriteHeader(http.StatusNoContent)
+		return
+	}
+	status := http.StatusOK
+	if _, legacy := resp.(RpcResponse); legacy && rpcerr != nil {
+		status = http_status(rpcerr)
 	}
+	write_json(w, status, resp)
+}
 
-	//ioutil.WriteFile(RpcCmd.Method+"_resp.json", b, 0777)
-	w.Write(append(b, 0x0a))
+func help(params []interface{}) (interface{}, error) {
+	var names []string
+	for k := range rpc_methods {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	return strings.Join(names, "\n"), nil
+}
+
+func init() {
+	register("help", help)
 }
 
 func StartServer(port uint32) {
 	fmt.Println("Starting RPC server at port", port)
+	if e := WriteCookie(); e != nil {
+		println("ERROR: Cannot write RPC cookie file:", e.Error())
+	}
 	mux := http.NewServeMux()
 	mux.HandleFunc("/", my_handler)
-	http.ListenAndServe(fmt.Sprint("127.0.0.1:",port), mux)
+	if e := http.ListenAndServe(fmt.Sprint("127.0.0.1:", port), mux); e != nil {
+		println("ERROR: RPC server:", e.Error())
+	}
 }
@@ -218,7 +218,11 @@ func show_counters(par string) {
 }
 
 func show_pending(par string) {
+	win_start, win_end := network.DownloadWindow()
 	network.MutexRcv.Lock()
+	fmt.Println("Download window:", win_start, "-", win_end, "  Lowest pending:", network.DlWindowLow,
+		"  Not moved for:", time.Since(network.DlWindowMoved).Round(time.Second).String(),
+		"  Stall timeout:", network.BlockStallTimeout.String())
 	out := make([]string, len(network.BlocksToGet))
 	var idx int
 	for _, v := range network.BlocksToGet {
@@ -230,6 +234,22 @@ func show_pending(par string) {
 	for _, s := range out {
 		fmt.Println(s)
 	}
+
+	var peers []*network.ConnInfo
+	network.Mutex_net.Lock()
+	for _, v := range network.OpenCons {
+		r := new(network.ConnInfo)
+		v.GetStats(r)
+		if r.BlocksInProgress > 0 || r.BlocksDelivered > 0 || r.BlockStalls > 0 {
+			peers = append(peers, r)
+		}
+	}
+	network.Mutex_net.Unlock()
+	sort.Slice(peers, func(i, j int) bool { return peers[i].ID < peers[j].ID })
+	for _, r := range peers {
+		fmt.Printf("%8d) %-21s  inprog:%4d  got:%6d  latency:%6dms  %8.1f KB/s  stalls:%d\n", r.ID, r.PeerIp,
+			r.BlocksInProgress, r.BlocksDelivered, r.BlockLatency.Milliseconds(), float64(r.BlockThroughput)/1e3, r.BlockStalls)
+	}
 }
 
 func show_help(par string) {
@@ -387,7 +407,8 @@ func save_config(s string) {
 }
 
 func show_addresses(par string) {
-	fmt.Println(peersdb.PeerDB.Count(), "peers in the database")
+	new_cnt, tried_cnt := peersdb.AddrManStats()
+	fmt.Println(peersdb.PeerDB.Count(), "peers in the database -", new_cnt, "new and", tried_cnt, "tried")
 	if par == "list" {
 		cnt := 0
 		peersdb.PeerDB.Browse(func(k qdb.KeyType, v []byte) uint32 {
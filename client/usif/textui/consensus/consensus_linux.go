@@ -70,6 +70,9 @@ var (
 )
 
 func check_consensus(pkScr []byte, amount uint64, i int, tx *btc.Tx, ver_flags uint32, result bool) {
+	if ver, prog := btc.IsWitnessProgram(pkScr); ver == 1 && len(prog) == 32 {
+		return // the library cannot verify taproot spends without knowing all the spent outputs
+	}
 	var tmp []byte
 	if len(pkScr) != 0 {
 		tmp = make([]byte, len(pkScr))
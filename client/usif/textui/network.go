@@ -65,11 +65,18 @@ func node_info(par string) {
 	fmt.Printf("Connection ID %d:\n", r.ID)
 	if r.Incomming {
 		fmt.Println("Coming from", r.PeerIp)
+	} else if r.BlockRelayOnly {
+		fmt.Println("Going to", r.PeerIp, "(block-relay-only)")
+	} else if r.IsFeeler {
+		fmt.Println("Going to", r.PeerIp, "(feeler)")
 	} else {
 		fmt.Println("Going to", r.PeerIp)
 	}
 	if !r.ConnectedAt.IsZero() {
 		fmt.Println("Connected at", r.ConnectedAt.Format("2006-01-02 15:04:05"))
+		if r.V2Transport {
+			fmt.Println("Transport: v2 (BIP324 encrypted)")
+		}
 		if r.Version!=0 {
 			fmt.Println("Node Version:", r.Version, "/ Services:", fmt.Sprintf("0x%x", r.Services))
 			fmt.Println("User Agent:", r.Agent)
@@ -88,6 +95,9 @@ func node_info(par string) {
 		fmt.Print("BlockInProgress:", r.BlocksInProgress, "  GetHeadersInProgress:", r.GetHeadersInProgress, "\n")
 		fmt.Println("GetBlocksDataNow:", r.GetBlocksDataNow)
 		fmt.Println("AllHeadersReceived:", r.AllHeadersReceived)
+		if r.PresyncHeight != 0 {
+			fmt.Println("Headers presync at height:", r.PresyncHeight)
+		}
 		fmt.Println("Total Received:", r.BytesReceived, " /  Sent:", r.BytesSent)
 		for k, v := range r.Counters {
 			fmt.Println(k, ":", v)
@@ -120,7 +130,8 @@ func net_stats(par string) {
 	}
 
 	network.Mutex_net.Lock()
-	fmt.Printf("%d active net connections, %d outgoing\n", len(network.OpenCons), network.OutConsActive)
+	fmt.Printf("%d active net connections, %d outgoing, %d block-relay-only, %d feelers\n", len(network.OpenCons),
+		network.OutConsActive, network.BlockRelayConsActive, network.FeelerConsActive)
 	srt := make(SortedKeys, len(network.OpenCons))
 	cnt := 0
 	for k, v := range network.OpenCons {
@@ -136,6 +147,10 @@ func net_stats(par string) {
 
 		if v.X.Incomming {
 			fmt.Print("<- ")
+		} else if v.X.BlockRelayOnly {
+			fmt.Print(" =>")
+		} else if v.X.IsFeeler {
+			fmt.Print(" ?>")
 		} else {
 			fmt.Print(" ->")
 		}
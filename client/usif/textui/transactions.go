@@ -1,6 +1,7 @@
 package textui
 
 import (
+	"encoding/hex"
 	"fmt"
 	"github.com/piotrnar/gocoin/client/common"
 	"github.com/piotrnar/gocoin/client/network"
@@ -9,6 +10,7 @@ import (
 	"io/ioutil"
 	"os"
 	"strconv"
+	"strings"
 	"time"
 )
 
@@ -30,6 +32,70 @@ func load_tx(par string) {
 	fmt.Println(usif.LoadRawTx(buf))
 }
 
+func load_pkg(par string) {
+	fns := strings.Fields(par)
+	if len(fns) < 2 {
+		fmt.Println("Specify names of the files with the child transaction and its parents")
+		return
+	}
+	bufs := make([][]byte, len(fns))
+	for i, fn := range fns {
+		buf, e := ioutil.ReadFile(fn)
+		if e != nil {
+			println(e.Error())
+			return
+		}
+		bufs[i] = buf
+	}
+	fmt.Println(usif.LoadRawPackage(bufs))
+}
+
+func test_replace(par string) {
+	if par == "" {
+		fmt.Println("Specify a name of a transaction file, or its hex data")
+		return
+	}
+	buf, e := ioutil.ReadFile(par)
+	if e != nil {
+		buf = []byte(par)
+	}
+	txd, er := hex.DecodeString(strings.TrimSpace(string(buf)))
+	if er != nil {
+		txd = buf
+	}
+	tx, le := btc.NewTx(txd)
+	if tx == nil || le != len(txd) {
+		fmt.Println("Could not decode the transaction")
+		return
+	}
+	tx.SetHash(txd)
+
+	res := network.TestReplace(tx)
+	if res == nil {
+		fmt.Println("Transaction", tx.Hash.String(), "does not conflict with any mempool transaction")
+		return
+	}
+	fmt.Printf("New tx %s:  fee %d  vsize %d  (%.1f SPB)\n", tx.Hash.String(), res.Fee, res.VSize, float64(res.Fee)/float64(res.VSize))
+	network.TxMutex.Lock()
+	for _, t2s := range res.Evict {
+		var direct string
+		for _, c := range res.Conflicts {
+			if c == t2s {
+				direct = "  conflict"
+			}
+		}
+		fmt.Printf(" - %s:  fee %d  vsize %d  (%.1f SPB)  rbf:%t%s\n", t2s.Hash.String(), t2s.Fee, t2s.VSize(),
+			float64(t2s.Fee)/float64(t2s.VSize()), t2s.SignalsRBF(), direct)
+	}
+	network.TxMutex.Unlock()
+	fmt.Println("Would evict", len(res.Evict), "txs, paying", res.EvictFee, "in fees, with", res.EvictVSize, "vbytes")
+	if res.Reason == 0 {
+		fmt.Println("Verdict: replacement ACCEPTED")
+	} else {
+		fmt.Println("Verdict: replacement REJECTED -", network.ReasonToString(res.Reason), "-", res.Rule)
+	}
+}
+
 func send_tx(par string) {
 	txid := btc.NewUint256FromString(par)
 	if txid == nil {
@@ -127,6 +193,46 @@ func mempool_stats(par string) {
 	fmt.Print(usif.MemoryPoolFees())
 }
 
+func fee_estimates(par string) {
+	targets := []int{1, 2, 3, 6, 12, 24, 48, 144, 504, 1008}
+	pct := 95.0
+	ps := strings.Fields(par)
+	if len(ps) > 0 {
+		n, er := strconv.ParseUint(ps[0], 10, 32)
+		if er != nil || n < 1 || n > network.FEE_EST_MAX_TARGET {
+			fmt.Println("Specify number of blocks: 1 to", network.FEE_EST_MAX_TARGET)
+			return
+		}
+		targets = []int{int(n)}
+	}
+	if len(ps) > 1 {
+		v, er := strconv.ParseFloat(ps[1], 64)
+		if er != nil || v <= 0 || v > 100 {
+			fmt.Println("Specify certainty in percents: above 0 to 100")
+			return
+		}
+		pct = v
+	}
+
+	height, tracked := network.FeeEstStats()
+	fmt.Println("Fee estimator updated at block", height, "-", tracked, "mempool txs being tracked")
+	fmt.Printf("For %.1f%% certainty of confirmation:\n", pct)
+	for _, target := range targets {
+		est := network.EstimateFee(target, pct/100)
+		fmt.Printf("%5d blocks: ", target)
+		if est.FeeSPKB == 0 {
+			fmt.Print("  unknown")
+		} else {
+			fmt.Printf("%9.3f SPB", float64(est.FeeSPKB)/1000)
+		}
+		if r := est.Pass; r != nil {
+			fmt.Printf("  pass %.3f-%.3f SPB: %.1f/%.1f confirmed, %.1f in mempool, %.1f left it",
+				float64(r.StartRange)/1000, float64(r.EndRange)/1000, r.WithinTarget, r.TotalConfirmed, r.InMempool, r.LeftMempool)
+		}
+		fmt.Println()
+	}
+}
+
 func list_txs(par string) {
 	limitbytes, _ := strconv.ParseUint(par, 10, 64)
 	fmt.Println("Transactions in the memory pool:", limitbytes)
@@ -202,10 +308,8 @@ func check_txs(par string) {
 	network.TxMutex.Unlock()
 }
 
-func load_mempool(par string) {
-	if par == "" {
-		par = common.GocoinHomeDir + "mempool.dmp"
-	}
+// abortable runs the function, that can be aborted by Ctrl+C.
+func abortable(fn func(abort *bool)) {
 	var abort bool
 	__exit := make(chan bool)
 	__done := make(chan bool)
@@ -222,7 +326,7 @@ func load_mempool(par string) {
 		}
 	}()
 	fmt.Println("Press Ctrl+C to abort...")
-	network.MempoolLoadNew(par, &abort)
+	fn(&abort)
 	__exit <- true
 	_ = <-__done
 	if abort {
@@ -230,6 +334,41 @@ func load_mempool(par string) {
 	}
 }
 
+func load_mempool(par string) {
+	if par == "" {
+		par = common.GocoinHomeDir + network.MEMPOOL_FILE_NAME2
+	}
+	abortable(func(abort *bool) {
+		network.MempoolLoadNew(par, abort)
+	})
+}
+
+func export_mempool(par string) {
+	ps := strings.Fields(par)
+	fname := "mempool.dat"
+	if len(ps) > 0 {
+		fname = ps[0]
+	}
+	cnt, er := network.MempoolExportCore(fname, len(ps) > 1 && ps[1] == "v1")
+	if er != nil {
+		fmt.Println("Error:", er.Error())
+		return
+	}
+	fmt.Println(cnt, "transactions stored in", fname)
+}
+
+func import_mempool(par string) {
+	if par == "" {
+		fmt.Println("Specify the name of Bitcoin Core's mempool.dat file")
+		return
+	}
+	abortable(func(abort *bool) {
+		if er := network.MempoolImportCore(par, abort); er != nil {
+			fmt.Println("Error:", er.Error())
+		}
+	})
+}
+
 func get_mempool(par string) {
 	conid, e := strconv.ParseUint(par, 10, 32)
 	if e != nil {
@@ -244,6 +383,8 @@ func get_mempool(par string) {
 
 func init() {
 	newUi("txload tx", true, load_tx, "Load transaction data from the given file, decode it and store in memory")
+	newUi("txpkg", true, load_pkg, "Load a package of transactions (a child and its parents) from the given files and submit them together")
+	newUi("testreplace trbf", true, test_replace, "Check BIP125 rules for a replacement tx (from the given file or hex), without accepting it")
 	newUi("txsend stx", true, send_tx, "Broadcast transaction from memory pool (identified by a given <txid>)")
 	newUi("tx1send stx1", true, send1_tx, "Broadcast transaction to a single random peer (identified by a given <txid>)")
 	newUi("txsendall stxa", true, send_all_tx, "Broadcast all the transactions (what you see after ltx)")
@@ -252,9 +393,12 @@ func init() {
 	newUi("txlist ltx", true, list_txs, "List all the transaction loaded into memory pool up to 1MB space <max_size>")
 	newUi("txlistban ltxb", true, baned_txs, "List the transaction that we have rejected")
 	newUi("mempool mp", true, mempool_stats, "Show the mempool statistics")
+	newUi("feeest fe", false, fee_estimates, "Estimate fee rate for confirmation within [blocks] with [certainty] percent (default 95)")
 	newUi("txsave", true, save_tx, "Save raw transaction from memory pool to disk")
 	newUi("txmpsave mps", true, save_mempool, "Save memory pool to disk")
 	newUi("txcheck txc", true, check_txs, "Verify consistency of mempool")
 	newUi("txmpload mpl", true, load_mempool, "Load transaction from the given file (must be in mempool.dmp format)")
+	newUi("mpexport", true, export_mempool, "Save memory pool in Bitcoin Core's format: [<file_name> [v1]] (default mempool.dat)")
+	newUi("mpimport", true, import_mempool, "Load transactions from Bitcoin Core's mempool.dat file")
 	newUi("getmp mpg", true, get_mempool, "Get getmp message to the peer with teh given ID")
 }
@@ -12,7 +12,7 @@ import (
 )
 
 type OneWalletAddrs struct {
-	Typ  int // 0-p2kh, 1-p2sh, 2-segwit_prog
+	Typ  int // 0-p2kh, 1-p2sh, 2-segwit_prog, 3-taproot
 	Key  []byte
 	rec  *wallet.OneAllAddrBal
 }
@@ -55,6 +55,7 @@ func new_slice(in []byte) (kk []byte) {
 func all_addrs(par string) {
 	var ptkh_outs, ptkh_vals, ptsh_outs, ptsh_vals uint64
 	var ptwkh_outs, ptwkh_vals, ptwsh_outs, ptwsh_vals uint64
+	var ptr_outs, ptr_vals uint64
 	var best SortedWalletAddrs
 	var cnt int = 15
 	var mode int
@@ -66,11 +67,11 @@ func all_addrs(par string) {
 
 	if par != "" {
 		if c, e := strconv.ParseUint(par, 10, 32); e == nil {
-			if c > 3 {
+			if c > 4 {
 				cnt = int(c)
 			} else {
 				mode = int(c+1)
-				fmt.Println("Counting only addr type", ([]string{"P2KH", "P2SH", "P2WKH", "P2WSH"})[int(c)])
+				fmt.Println("Counting only addr type", ([]string{"P2KH", "P2SH", "P2WKH", "P2WSH", "P2TR"})[int(c)])
 			}
 		}
 	}
@@ -127,6 +128,17 @@ func all_addrs(par string) {
 		fmt.Println(btc.UintToBtc(ptwsh_vals), "BTC in", ptwsh_outs, "unspent recs from", len(wallet.AllBalancesP2WSH), "P2WSH addresses")
 	}
 
+	if mode==0 || mode==5 {
+		for k, rec := range wallet.AllBalancesP2TR {
+			ptr_vals += rec.Value
+			ptr_outs += uint64(rec.Count())
+			if sort_by_cnt && rec.Count() >= MIN_OUTS || !sort_by_cnt && rec.Value >= MIN_BTC {
+				best = append(best, OneWalletAddrs{Typ:3, Key: new_slice(k[:]), rec: rec})
+			}
+		}
+		fmt.Println(btc.UintToBtc(ptr_vals), "BTC in", ptr_outs, "unspent recs from", len(wallet.AllBalancesP2TR), "P2TR addresses")
+	}
+
 
 	if sort_by_cnt {
 		fmt.Println("Top addresses with at least", MIN_OUTS, "unspent outputs:", len(best))
@@ -163,6 +175,12 @@ func all_addrs(par string) {
 				ad.SegwitProg = new(btc.SegwitProg)
 				ad.SegwitProg.HRP = btc.GetSegwitHRP(common.CFG.Testnet)
 				ad.SegwitProg.Program = best[i].Key
+			case 3:
+				ad = new(btc.BtcAddr)
+				ad.SegwitProg = new(btc.SegwitProg)
+				ad.SegwitProg.HRP = btc.GetSegwitHRP(common.CFG.Testnet)
+				ad.SegwitProg.Version = 1
+				ad.SegwitProg.Program = best[i].Key
 		}
 		fmt.Println(i+1, ad.String(), btc.UintToBtc(best[i].rec.Value), "BTC in", best[i].rec.Count(), "inputs")
 	}
@@ -264,8 +282,8 @@ func wallet_on_off(s string) {
 }
 
 func init() {
-	newUi("richest r", true, best_val, "Show addresses with most coins [0,1,2,3 or count]")
-	newUi("maxouts o", true, max_outs, "Show addresses with highest number of outputs [0,1,2,3 or count]")
+	newUi("richest r", true, best_val, "Show addresses with most coins [0,1,2,3,4 or count]")
+	newUi("maxouts o", true, max_outs, "Show addresses with highest number of outputs [0,1,2,3,4 or count]")
 	newUi("balance a", true, list_unspent, "List balance of given bitcoin address")
 	newUi("allbal ab", true, all_val_stats, "Show Allbalance statistics")
 	newUi("wallet w", false, wallet_on_off, "Enable (on) or disable (off) wallet functionality")
@@ -13,6 +13,7 @@ import (
 	"github.com/piotrnar/gocoin/lib/others/sys"
 	"github.com/piotrnar/gocoin/lib/script"
 	"math/rand"
+	"strings"
 	"sync"
 	"time"
 )
@@ -43,6 +44,7 @@ func DecodeTxSops(tx *btc.Tx) (s string, missinginp bool, totinp, totout uint64,
 	s += fmt.Sprintln("Transaction details (for your information):")
 	s += fmt.Sprintln(len(tx.TxIn), "Input(s):")
 	sigops = btc.WITNESS_SCALE_FACTOR * tx.GetLegacySigOpCount()
+	network.FetchSpentOutputs(tx)
 	for i := range tx.TxIn {
 		s += fmt.Sprintf(" %3d %s", i, tx.TxIn[i].Input.String())
 		var po *btc.TxOut
@@ -173,6 +175,44 @@ func LoadRawTx(buf []byte) (s string) {
 	return
 }
 
+// LoadRawPackage submits the txs (a child with its parents, in any order) as a package,
+// so the child can pay for its parents (CPFP).
+func LoadRawPackage(bufs [][]byte) (s string) {
+	txs := make([]*btc.Tx, len(bufs))
+	for i, buf := range bufs {
+		txd, er := hex.DecodeString(strings.TrimSpace(string(buf)))
+		if er != nil {
+			txd = buf
+		}
+		tx, le := btc.NewTx(txd)
+		if tx == nil || le != len(txd) {
+			s += fmt.Sprintln("Could not decode transaction", i+1, "or it has some extra data")
+			return
+		}
+		tx.SetHash(txd)
+		txs[i] = tx
+		s += fmt.Sprintln("TxID", tx.Hash.String(), "-", tx.VSize(), "vbytes")
+		network.RemoveFromRejected(&tx.Hash) // in case we rejected it eariler, to try it again as trusted
+	}
+
+	fee_spkb, reason := network.SubmitLocalPackage(txs)
+	if reason != 0 {
+		s += fmt.Sprintln("Package rejected:", network.ReasonToString(reason))
+		return
+	}
+	s += fmt.Sprintf("Package fee: %.1f SPB\n", float64(fee_spkb)/1000)
+
+	network.TxMutex.Lock()
+	for _, tx := range txs {
+		if t2s := network.TransactionsToSend[tx.Hash.BIdx()]; t2s != nil {
+			t2s.Local = true
+		}
+	}
+	network.TxMutex.Unlock()
+	s += fmt.Sprintln("All the transactions are in the memory pool. You can broadcast them now (parents first).")
+	return
+}
+
 func SendInvToRandomPeer(typ uint32, h *btc.Uint256) {
 	common.CountSafe(fmt.Sprint("NetSendOneInv", typ))
 
@@ -181,6 +221,16 @@ func SendInvToRandomPeer(typ uint32, h *btc.Uint256) {
 	binary.LittleEndian.PutUint32(inv[0:4], typ)
 	copy(inv[4:36], h.Bytes())
 
+	// BIP339 peers need the tx's wtxid
+	var wh *btc.Uint256
+	if typ == network.MSG_TX {
+		network.TxMutex.Lock()
+		if t2s, ok := network.TransactionsToSend[h.BIdx()]; ok {
+			wh = t2s.WTxID()
+		}
+		network.TxMutex.Unlock()
+	}
+
 	// Append it to PendingInvs in a random connection
 	network.Mutex_net.Lock()
 	idx := rand.Intn(len(network.OpenCons))
@@ -188,6 +238,11 @@ func SendInvToRandomPeer(typ uint32, h *btc.Uint256) {
 	for _, v := range network.OpenCons {
 		if idx == cnt {
 			v.Mutex.Lock()
+			if wh != nil && v.Node.WTxIDRelay {
+				inv = new([36]byte)
+				binary.LittleEndian.PutUint32(inv[0:4], network.MSG_WTX)
+				copy(inv[4:36], wh.Bytes())
+			}
 			v.PendingInvs = append(v.PendingInvs, inv)
 			v.Mutex.Unlock()
 			break
@@ -301,7 +356,7 @@ func UnbanPeer(par string) (s string) {
 		return 0
 	})
 	for i := range keys {
-		peersdb.PeerDB.Put(keys[i], vals[i])
+		peersdb.NewPeer(vals[i]).Save() // puts it back to the address manager
 	}
 
 	s += fmt.Sprintln(len(keys), "peer(s) un-baned")
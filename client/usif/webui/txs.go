@@ -26,6 +26,7 @@ func p_txs(w http.ResponseWriter, r *http.Request) {
 	var txloadresult string
 	var wg sync.WaitGroup
 	var tx2in []byte
+	var pkg2in [][]byte
 
 	// Check if there is a tx upload request
 	r.ParseMultipartForm(2e6)
@@ -34,6 +35,14 @@ func p_txs(w http.ResponseWriter, r *http.Request) {
 		tx2in, _ = ioutil.ReadAll(fil)
 	} else if len(r.Form["rawtx"])==1 {
 		tx2in, _ = hex.DecodeString(r.Form["rawtx"][0])
+	} else if r.MultipartForm!=nil {
+		for _, fh := range r.MultipartForm.File["pkgfiles"] {
+			if f, e := fh.Open(); e==nil {
+				dat, _ := ioutil.ReadAll(f)
+				f.Close()
+				pkg2in = append(pkg2in, dat)
+			}
+		}
 	}
 
 	if len(tx2in)>0 {
@@ -45,6 +54,15 @@ func p_txs(w http.ResponseWriter, r *http.Request) {
 			wg.Done()
 		}
 		usif.UiChannel <- req
+	} else if len(pkg2in)>0 {
+		wg.Add(1)
+		req := &usif.OneUiReq{}
+		req.Done.Add(1)
+		req.Handler = func(dat string) {
+			txloadresult = usif.LoadRawPackage(pkg2in)
+			wg.Done()
+		}
+		usif.UiChannel <- req
 	}
 
 	s := load_template("txs.html")
@@ -75,6 +93,7 @@ func p_txs(w http.ResponseWriter, r *http.Request) {
 
 
 func output_tx_xml(w http.ResponseWriter, tx *btc.Tx) {
+	network.FetchSpentOutputs(tx)
 	w.Write([]byte("<input_list>"))
 	for i := range tx.TxIn {
 		w.Write([]byte("<input>"))
@@ -646,3 +665,46 @@ func json_mempool_fees(w http.ResponseWriter, r *http.Request) {
 		println(er.Error())
 	}
 }
+
+
+// json_feeest returns fee rate estimates for the given "blocks" (comma separated) and "pct" certainty.
+func json_feeest(w http.ResponseWriter, r *http.Request) {
+	if !ipchecker(r) {
+		return
+	}
+
+	targets := []int{2, 6, 144}
+	if len(r.Form["blocks"]) > 0 {
+		targets = nil
+		for _, s := range strings.Split(r.Form["blocks"][0], ",") {
+			if n, e := strconv.ParseUint(s, 10, 32); e == nil && n >= 1 && n <= network.FEE_EST_MAX_TARGET {
+				targets = append(targets, int(n))
+			}
+		}
+	}
+
+	pct := 95.0
+	if len(r.Form["pct"]) > 0 {
+		if v, e := strconv.ParseFloat(r.Form["pct"][0], 64); e == nil && v > 0 && v <= 100 {
+			pct = v
+		}
+	}
+
+	type one_est struct {
+		Blocks int    `json:"blocks"`
+		Spkb   uint64 `json:"spkb"` // zero if unknown
+	}
+	res := make([]one_est, len(targets))
+	for i, target := range targets {
+		res[i].Blocks = target
+		res[i].Spkb = network.EstimateFee(target, pct/100).FeeSPKB
+	}
+
+	bx, er := json.Marshal(res)
+	if er == nil {
+		w.Header()["Content-Type"] = []string{"application/json"}
+		w.Write(bx)
+	} else {
+		println(er.Error())
+	}
+}
@@ -51,6 +51,9 @@ func getaddrtype(aa *btc.BtcAddr) string {
 	if aa.SegwitProg != nil && aa.SegwitProg.Version == 0 && len(aa.SegwitProg.Program)==20 {
 		return "P2WPKH"
 	}
+	if aa.SegwitProg != nil && aa.SegwitProg.Version == 1 && len(aa.SegwitProg.Program)==32 {
+		return "P2TR"
+	}
 	if aa.Version == btc.AddrVerPubkey(common.Testnet) {
 		return "P2PKH"
 	}
@@ -340,8 +343,8 @@ func dl_balance(w http.ResponseWriter, r *http.Request) {
 
 	for idx, a := range addrs {
 		aa, e := btc.NewAddrFromString(a)
-		aa.Extra.Label = labels[idx]
 		if e==nil {
+			aa.Extra.Label = labels[idx]
 			newrecs := wallet.GetAllUnspent(aa)
 			if len(newrecs) > 0 {
 				thisbal = append(thisbal, newrecs...)
@@ -12,7 +12,7 @@ import (
 
 var (
 	AllBalancesP2KH, AllBalancesP2SH, AllBalancesP2WKH map[[20]byte]*OneAllAddrBal
-	AllBalancesP2WSH                                   map[[32]byte]*OneAllAddrBal
+	AllBalancesP2WSH, AllBalancesP2TR                  map[[32]byte]*OneAllAddrBal
 )
 
 type OneAllAddrInp [utxo.UtxoIdxLen + 4]byte
@@ -80,6 +80,14 @@ func NewUTXO(tx *utxo.UtxoRec) {
 				rec = &OneAllAddrBal{}
 				AllBalancesP2WSH[uidx] = rec
 			}
+		} else if script.IsP2TR(out.PKScr) {
+			var uidx [32]byte
+			copy(uidx[:], out.PKScr[2:34])
+			rec = AllBalancesP2TR[uidx]
+			if rec == nil {
+				rec = &OneAllAddrBal{}
+				AllBalancesP2TR[uidx] = rec
+			}
 		} else {
 			continue
 		}
@@ -113,7 +121,7 @@ func all_del_utxos(tx *utxo.UtxoRec, outs []bool) {
 	var rec *OneAllAddrBal
 	var i int
 	var nr OneAllAddrInp
-	var typ int                            // 0 - P2KH, 1 - P2SH, 2 - P2WKH
+	var typ int                            // 0 - P2KH, 1 - P2SH, 2 - P2WKH, 3 - P2WSH, 4 - P2TR
 	copy(nr[:utxo.UtxoIdxLen], tx.TxID[:]) //RecIdx
 	for vout := uint32(0); vout < uint32(len(tx.Outs)); vout++ {
 		if !outs[vout] {
@@ -142,6 +150,10 @@ func all_del_utxos(tx *utxo.UtxoRec, outs []bool) {
 			typ = 3
 			copy(uidx32[:], out.PKScr[2:34])
 			rec = AllBalancesP2WSH[uidx32]
+		} else if script.IsP2TR(out.PKScr) {
+			typ = 4
+			copy(uidx32[:], out.PKScr[2:34])
+			rec = AllBalancesP2TR[uidx32]
 		} else {
 			continue
 		}
@@ -170,6 +182,8 @@ func all_del_utxos(tx *utxo.UtxoRec, outs []bool) {
 					delete(AllBalancesP2WKH, uidx)
 				case 3:
 					delete(AllBalancesP2WSH, uidx32)
+				case 4:
+					delete(AllBalancesP2TR, uidx32)
 				}
 			} else {
 				rec.Value -= out.Value
@@ -196,6 +210,8 @@ func all_del_utxos(tx *utxo.UtxoRec, outs []bool) {
 				delete(AllBalancesP2WKH, uidx)
 			case 3:
 				delete(AllBalancesP2WSH, uidx32)
+			case 4:
+				delete(AllBalancesP2TR, uidx32)
 			}
 		} else {
 			rec.Value -= out.Value
@@ -239,16 +255,16 @@ func GetAllUnspent(aa *btc.BtcAddr) (thisbal utxo.AllUnspentTx) {
 	var rec *OneAllAddrBal
 	if aa.SegwitProg != nil {
 		var uidx [32]byte
-		if aa.SegwitProg.Version != 0 {
-			return
-		}
-		switch len(aa.SegwitProg.Program) {
-		case 20:
+		switch {
+		case aa.SegwitProg.Version == 0 && len(aa.SegwitProg.Program) == 20:
 			copy(aa.Hash160[:], aa.SegwitProg.Program)
 			rec = AllBalancesP2WKH[aa.Hash160]
-		case 32:
+		case aa.SegwitProg.Version == 0 && len(aa.SegwitProg.Program) == 32:
 			copy(uidx[:], aa.SegwitProg.Program)
 			rec = AllBalancesP2WSH[uidx]
+		case aa.SegwitProg.Version == 1 && len(aa.SegwitProg.Program) == 32:
+			copy(uidx[:], aa.SegwitProg.Program)
+			rec = AllBalancesP2TR[uidx]
 		default:
 			return
 		}
@@ -354,6 +370,17 @@ func PrintStat() {
 		}
 	}
 
+	var p2tr_maps, p2tr_outs, p2tr_vals uint64
+	for _, r := range AllBalancesP2TR {
+		p2tr_vals += r.Value
+		if r.unspMap != nil {
+			p2tr_maps++
+			p2tr_outs += uint64(len(r.unspMap))
+		} else {
+			p2tr_outs += uint64(len(r.unsp))
+		}
+	}
+
 	fmt.Println("AllBalMinVal:", btc.UintToBtc(common.AllBalMinVal()), "  UseMapCnt:", common.CFG.AllBalances.UseMapCnt)
 
 	fmt.Println("AllBalancesP2KH: ", len(AllBalancesP2KH), "records,",
@@ -367,4 +394,7 @@ func PrintStat() {
 
 	fmt.Println("AllBalancesP2WSH: ", len(AllBalancesP2WSH), "records,",
 		p2wsh_outs, "outputs,", btc.UintToBtc(p2wsh_vals), "BTC,", p2wsh_maps, "maps")
+
+	fmt.Println("AllBalancesP2TR: ", len(AllBalancesP2TR), "records,",
+		p2tr_outs, "outputs,", btc.UintToBtc(p2tr_vals), "BTC,", p2tr_maps, "maps")
 }
@@ -36,12 +36,14 @@ type SegwitProg struct {
 
 
 func NewAddrFromString(hs string) (a *BtcAddr, e error) {
-	if strings.HasPrefix(hs, "bc1") || strings.HasPrefix(hs, "tb1") {
-		var sw = &SegwitProg{HRP:hs[:2]}
+	if lhs := strings.ToLower(hs); strings.HasPrefix(lhs, "bc1") || strings.HasPrefix(lhs, "tb1") {
+		var sw = &SegwitProg{HRP:lhs[:2]}
 		sw.Version, sw.Program = bech32.SegwitDecode(sw.HRP, hs)
-		if sw.Program != nil {
-			a = &BtcAddr{SegwitProg:sw}
+		if sw.Program == nil {
+			e = errors.New("Cannot decode segwit address '"+hs+"'")
+			return
 		}
+		a = &BtcAddr{SegwitProg:sw}
 		return
 	}
 
@@ -231,11 +233,17 @@ func (a *BtcAddr) Owns(scr []byte) (yes bool) {
 
 func (a *BtcAddr) OutScript() (res []byte) {
 	if a.SegwitProg != nil {
-		if a.SegwitProg.Version != 0 || ( len(a.SegwitProg.Program) != 20 && len(a.SegwitProg.Program) != 32  ) {
-			panic("Only Segwit programs version 0 and length 20 or 32 supported")
+		if a.SegwitProg.Version < 0 || a.SegwitProg.Version > 16 ||
+			len(a.SegwitProg.Program) < 2 || len(a.SegwitProg.Program) > 40 ||
+			( a.SegwitProg.Version == 0 && len(a.SegwitProg.Program) != 20 && len(a.SegwitProg.Program) != 32 ) {
+			panic(fmt.Sprint("Unsupported Segwit program version ", a.SegwitProg.Version, " length ", len(a.SegwitProg.Program)))
 		}
 		res = make([]byte, 2 + len(a.SegwitProg.Program))
-		res[0] = 0x00 // OP_0
+		if a.SegwitProg.Version > 0 {
+			res[0] = byte(OP_1 - 1 + a.SegwitProg.Version) // OP_1 ... OP_16
+		} else {
+			res[0] = OP_0
+		}
 		res[1] = byte(len(a.SegwitProg.Program))
 		copy(res[2:], a.SegwitProg.Program)
 	} else if a.Version==AddrVerPubkey(false) || a.Version==AddrVerPubkey(true) || a.Version==48 /*Litecoin*/ {
@@ -3,6 +3,7 @@ package btc
 import (
 	"bytes"
 	"testing"
+	"strings"
 	"io/ioutil"
 	"encoding/hex"
 	"encoding/json"
@@ -115,3 +116,40 @@ func TestBase58(t *testing.T) {
 		}
 	}
 }
+
+func TestSegwitAddr(t *testing.T) {
+	var vecs = []struct {
+		addr, pkscr string
+	}{
+		{"bc1qw508d6qejxtdg4y5r3zarvary0c5xw7kv8f3t4", "0014751e76e8199196d454941c45d1b3a323f1433bd6"},
+		{"tb1qrp33g0q5c5txsp9arysrx4k6zdkfs4nce4xj0gdcccefvpysxf3q0sl5k7", "00201863143c14c5166804bd19203356da136c985678cd4d27a1b8c6329604903262"},
+		{"bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqzk5jj0", "512079be667ef9dcbbac55a06295ce870b07029bfcdb2dce28d959f2815b16f81798"},
+		{"tb1pqqqqp399et2xygdj5xreqhjjvcmzhxw4aywxecjdzew6hylgvsesf3hn0c", "5120000000c4a5cad46221b2a187905e5266362b99d5e91c6ce24d165dab93e86433"},
+		{"bc1zw508d6qejxtdg4y5r3zarvaryvaxxpcs", "5210751e76e8199196d454941c45d1b3a323"},
+	}
+	for _, v := range vecs {
+		pks, _ := hex.DecodeString(v.pkscr)
+		for _, s := range []string{v.addr, strings.ToUpper(v.addr)} {
+			a, e := NewAddrFromString(s)
+			if e != nil {
+				t.Error(e.Error())
+				continue
+			}
+			if !bytes.Equal(a.OutScript(), pks) {
+				t.Error("OutScript mismatch for", s)
+			}
+			if a.String() != v.addr {
+				t.Error("String mismatch for", s, a.String())
+			}
+		}
+		a := NewAddrFromPkScript(pks, v.addr[:2] == "tb")
+		if a == nil || a.String() != v.addr {
+			t.Error("NewAddrFromPkScript failed for", v.addr)
+		}
+	}
+
+	// Taproot address with the old Bech32 checksum
+	if _, e := NewAddrFromString("bc1p0xlxvlhemja6c4dqv22uapctqupfhlxm9h8z3k2e72q4k9hcz7vqh2y7hd"); e == nil {
+		t.Error("Bech32 encoded taproot address accepted")
+	}
+}
@@ -0,0 +1,191 @@
+package btc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"math/bits"
+	"sort"
+
+	"github.com/piotrnar/gocoin/lib/others/siphash"
+)
+
+// BIP158 basic filter parameters
+const (
+	BASIC_FILTER_TYPE = 0x00
+	BASIC_FILTER_P    = 19
+	BASIC_FILTER_M    = 784931
+)
+
+type bitWriter struct {
+	bytes.Buffer
+	cur  byte
+	nbit uint
+}
+
+func (w *bitWriter) writeBit(b bool) {
+	if b {
+		w.cur |= 0x80 >> w.nbit
+	}
+	w.nbit++
+	if w.nbit == 8 {
+		w.WriteByte(w.cur)
+		w.cur, w.nbit = 0, 0
+	}
+}
+
+func (w *bitWriter) writeBits(v uint64, n uint) {
+	for n > 0 {
+		n--
+		w.writeBit((v>>n)&1 != 0)
+	}
+}
+
+func (w *bitWriter) flush() {
+	if w.nbit > 0 {
+		w.WriteByte(w.cur)
+		w.cur, w.nbit = 0, 0
+	}
+}
+
+type bitReader struct {
+	data []byte
+	pos  uint // in bits
+}
+
+func (r *bitReader) readBit() (bool, error) {
+	if r.pos>>3 >= uint(len(r.data)) {
+		return false, errors.New("filter data too short")
+	}
+	b := r.data[r.pos>>3]&(0x80>>(r.pos&7)) != 0
+	r.pos++
+	return b, nil
+}
+
+func (r *bitReader) readBits(n uint) (v uint64, e error) {
+	var b bool
+	for ; n > 0; n-- {
+		if b, e = r.readBit(); e != nil {
+			return
+		}
+		v <<= 1
+		if b {
+			v |= 1
+		}
+	}
+	return
+}
+
+// gcsHashes maps the elements into the range [0, N*M) and returns them sorted.
+func gcsHashes(key []byte, elements [][]byte, n uint64) (res []uint64) {
+	k0 := binary.LittleEndian.Uint64(key[0:8])
+	k1 := binary.LittleEndian.Uint64(key[8:16])
+	f := n * BASIC_FILTER_M
+	res = make([]uint64, len(elements))
+	for i, el := range elements {
+		res[i], _ = bits.Mul64(siphash.Hash(k0, k1, el), f)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i] < res[j] })
+	return
+}
+
+// NewBasicFilter builds BIP158 Golomb-coded set of the given elements.
+// The key is the first 16 bytes of the block hash. Duplicate elements must be removed by the caller.
+func NewBasicFilter(key []byte, elements [][]byte) []byte {
+	var w bitWriter
+	var tmp [9]byte
+	n := uint64(len(elements))
+	w.Write(tmp[:PutVlen(tmp[:], int(n))])
+	var last uint64
+	for _, v := range gcsHashes(key, elements, n) {
+		delta := v - last
+		last = v
+		for q := delta >> BASIC_FILTER_P; q > 0; q-- {
+			w.writeBit(true)
+		}
+		w.writeBit(false)
+		w.writeBits(delta, BASIC_FILTER_P)
+	}
+	w.flush()
+	return w.Bytes()
+}
+
+// BasicFilterMatchAny returns true if any of the elements is (most likely) in the filter.
+func BasicFilterMatchAny(filter, key []byte, elements [][]byte) (bool, error) {
+	n64, vl := VULe(filter)
+	if vl == 0 {
+		return false, errors.New("filter data corrupt")
+	}
+	if n64 == 0 || len(elements) == 0 {
+		return false, nil
+	}
+	hs := gcsHashes(key, elements, n64)
+	r := bitReader{data: filter[vl:]}
+	var val uint64
+	var idx int
+	for i := uint64(0); i < n64; i++ {
+		var q uint64
+		for {
+			b, e := r.readBit()
+			if e != nil {
+				return false, e
+			}
+			if !b {
+				break
+			}
+			q++
+		}
+		rem, e := r.readBits(BASIC_FILTER_P)
+		if e != nil {
+			return false, e
+		}
+		val += q<<BASIC_FILTER_P | rem
+		for idx < len(hs) && hs[idx] < val {
+			idx++
+		}
+		if idx == len(hs) {
+			return false, nil
+		}
+		if hs[idx] == val {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// BasicFilterElements returns the unique elements of the block's basic filter: all the output scripts
+// (but OP_RETURN ones) and scripts of all the spent outputs. Spent_outputs must be set in each tx (but coinbase).
+func (bl *Block) BasicFilterElements() (res [][]byte, e error) {
+	already := make(map[string]bool)
+	add := func(scr []byte) {
+		if len(scr) > 0 && !already[string(scr)] {
+			already[string(scr)] = true
+			res = append(res, scr)
+		}
+	}
+	for i, tx := range bl.Txs {
+		for _, out := range tx.TxOut {
+			if len(out.Pk_script) > 0 && out.Pk_script[0] != 0x6a /*OP_RETURN*/ {
+				add(out.Pk_script)
+			}
+		}
+		if i == 0 {
+			continue
+		}
+		if len(tx.Spent_outputs) != len(tx.TxIn) {
+			e = errors.New("Spent outputs not known for tx " + tx.Hash.String())
+			return
+		}
+		for _, out := range tx.Spent_outputs {
+			add(out.Pk_script)
+		}
+	}
+	return
+}
+
+// FilterHeader returns BIP157 filter header, chained with the one of the previous block.
+func FilterHeader(filter []byte, prev_header []byte) (res [32]byte) {
+	fh := Sha2Sum(filter)
+	res = Sha2Sum(append(fh[:], prev_header...))
+	return
+}
@@ -0,0 +1,97 @@
+package btc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"testing"
+)
+
+// Vectors taken from BIP158 (testnet-19.json)
+var cfilter_vectors = []struct {
+	height      uint32
+	hash        string
+	block       string
+	prev_header string
+	filter      string
+	header      string
+}{
+	{0, "000000000933ea01ad0ee984209779baaec3ced90fa3f408719526f8d77f4943",
+		"0100000000000000000000000000000000000000000000000000000000000000000000003ba3edfd7a7b12b27ac72c3e67768f617fc81bc3888a51323a9fb8aa4b1e5e4adae5494dffff001d1aa4ae180101000000010000000000000000000000000000000000000000000000000000000000000000ffffffff4d04ffff001d0104455468652054696d65732030332f4a616e2f32303039204368616e63656c6c6f72206f6e206272696e6b206f66207365636f6e64206261696c6f757420666f722062616e6b73ffffffff0100f2052a01000000434104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac00000000",
+		"0000000000000000000000000000000000000000000000000000000000000000",
+		"019dfca8",
+		"21584579b7eb08997773e5aeff3a7f932700042d0ed2a6129012b7d7ae81b750"},
+	{2, "000000006c02c8ea6e4ff69651f7fcde348fb9d557a06e6957b65552002a7820",
+		"0100000006128e87be8b1b4dea47a7247d5528d2702c96826c7a648497e773b800000000e241352e3bec0a95a6217e10c3abb54adfa05abb12c126695595580fb92e222032e7494dffff001d00d235340101000000010000000000000000000000000000000000000000000000000000000000000000ffffffff0e0432e7494d010e062f503253482fffffffff0100f2052a010000002321038a7f6ef1c8ca0c588aa53fa860128077c9e6c11e6830f4d7ee4e763a56b7718fac00000000",
+		"d7bdac13a59d745b1add0d2ce852f1a0442e8945fc1bf3848d3cbffd88c24fe1",
+		"0174a170",
+		"186afd11ef2b5e7e3504f2e8cbf8df28a1fd251fe53d60dff8b1467d1b386cf0"},
+}
+
+func TestBasicFilter(t *testing.T) {
+	for _, v := range cfilter_vectors {
+		raw, _ := hex.DecodeString(v.block)
+		bl, er := NewBlock(raw)
+		if er != nil {
+			t.Fatal(v.height, er.Error())
+		}
+		if bl.Hash.String() != v.hash {
+			t.Fatal(v.height, "block hash mismatch")
+		}
+		if er = bl.BuildTxList(); er != nil {
+			t.Fatal(v.height, er.Error())
+		}
+		els, er := bl.BasicFilterElements()
+		if er != nil {
+			t.Fatal(v.height, er.Error())
+		}
+		filter := NewBasicFilter(bl.Hash.Hash[:16], els)
+		if hex.EncodeToString(filter) != v.filter {
+			t.Error(v.height, "filter mismatch", hex.EncodeToString(filter))
+		}
+		prev := NewUint256FromString(v.prev_header)
+		hdr := FilterHeader(filter, prev.Hash[:])
+		if NewUint256(hdr[:]).String() != v.header {
+			t.Error(v.height, "header mismatch", NewUint256(hdr[:]).String())
+		}
+
+		ok, er := BasicFilterMatchAny(filter, bl.Hash.Hash[:16], els)
+		if er != nil || !ok {
+			t.Error(v.height, "element not matched", er)
+		}
+		ok, er = BasicFilterMatchAny(filter, bl.Hash.Hash[:16], [][]byte{[]byte("not in the filter")})
+		if er != nil || ok {
+			t.Error(v.height, "unexpected match", er)
+		}
+	}
+}
+
+func TestBasicFilterMatch(t *testing.T) {
+	key := bytes.Repeat([]byte{0x55}, 16)
+	var els [][]byte
+	for i := 0; i < 1000; i++ {
+		els = append(els, []byte{byte(i), byte(i >> 8), 0xaa})
+	}
+	filter := NewBasicFilter(key, els)
+	for i := range els {
+		if ok, er := BasicFilterMatchAny(filter, key, els[i:i+1]); er != nil || !ok {
+			t.Fatal("element", i, "not matched", er)
+		}
+	}
+	var fp int
+	for i := 0; i < 10000; i++ {
+		if ok, _ := BasicFilterMatchAny(filter, key, [][]byte{{byte(i), byte(i >> 8), 0xbb}}); ok {
+			fp++
+		}
+	}
+	if fp > 2 {
+		t.Error("Too many false positives", fp)
+	}
+
+	empty := NewBasicFilter(key, nil)
+	if !bytes.Equal(empty, []byte{0}) {
+		t.Error("Bad empty filter", hex.EncodeToString(empty))
+	}
+	if ok, er := BasicFilterMatchAny(empty, key, els); er != nil || ok {
+		t.Error("Empty filter matched", er)
+	}
+}
@@ -325,6 +325,11 @@ func IsP2SH(d []byte) bool {
 	return len(d) == 23 && d[0] == 0xa9 && d[1] == 20 && d[22] == 0x87
 }
 
+// IsP2TR returns true if the given PK_script is a taproot (segwit v1) output.
+func IsP2TR(d []byte) bool {
+	return len(d) == 34 && d[0] == OP_1 && d[1] == 32
+}
+
 // IsUsefullOutScript returns true if the given PK_script is somehow useful to gocoin's node.
 func IsUsefullOutScript(v []byte) bool {
 	if len(v) == 25 && v[0] == 0x76 && v[1] == 0xa9 && v[2] == 0x14 && v[23] == 0x88 && v[24] == 0xac {
@@ -1,15 +1,43 @@
 package btc
 
 import (
-	"fmt"
+	"bytes"
+	"crypto/sha3"
+	"encoding/base32"
 	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
 )
 
+// BIP155 network IDs
+const (
+	NET_IPV4  = 1
+	NET_IPV6  = 2
+	NET_TORV2 = 3 // deprecated - ignored
+	NET_TORV3 = 4
+	NET_I2P   = 5
+	NET_CJDNS = 6
+
+	MAX_ADDRV2_SIZE = 512 // BIP155: longer addresses are a protocol violation
+)
+
+// IPV4_PREFIX is the IPv6 prefix of an IPv4 mapped address
+var IPV4_PREFIX = [12]byte{10: 0xff, 11: 0xff}
+
+var b32 = base32.StdEncoding.WithPadding(base32.NoPadding)
+
 type NetAddr struct {
 	Services uint64
-	Ip6 [12]byte
-	Ip4 [4]byte
-	Port uint16
+	Ip6      [12]byte
+	Ip4      [4]byte
+	Port     uint16
+
+	// Addresses that are neither IPv4 nor IPv6 (BIP155):
+	NetID byte     // NET_TORV3, NET_I2P or NET_CJDNS (zero for IPv4/IPv6)
+	Ext   [32]byte // the address (CJDNS uses only the first 16 bytes)
 }
 
 func NewNetAddr(b []byte) (na *NetAddr) {
@@ -25,6 +53,8 @@ func NewNetAddr(b []byte) (na *NetAddr) {
 	return
 }
 
+// Bytes returns the legacy (version and addr messages) serialization.
+// It only makes sense for IPv4 and IPv6 addresses.
 func (a *NetAddr) Bytes() (res []byte) {
 	res = make([]byte, 26)
 	binary.LittleEndian.PutUint64(res[0:8], a.Services)
@@ -34,7 +64,164 @@ func (a *NetAddr) Bytes() (res []byte) {
 	return
 }
 
+// AddrV2Len returns the address length of the given BIP155 network ID, or zero if it is not supported.
+func AddrV2Len(netid byte) int {
+	switch netid {
+	case NET_IPV4:
+		return 4
+	case NET_IPV6, NET_CJDNS:
+		return 16
+	case NET_TORV3, NET_I2P:
+		return 32
+	}
+	return 0
+}
+
+// NetworkID returns BIP155 network ID of the address.
+func (a *NetAddr) NetworkID() byte {
+	if a.NetID != 0 {
+		return a.NetID
+	}
+	if a.Ip6 == IPV4_PREFIX || a.Ip6 == [12]byte{} {
+		return NET_IPV4
+	}
+	return NET_IPV6
+}
+
+// AddrBytes returns the address as it is serialized in addrv2 message.
+func (a *NetAddr) AddrBytes() []byte {
+	switch netid := a.NetworkID(); netid {
+	case NET_IPV4:
+		return a.Ip4[:]
+	case NET_IPV6:
+		return append(a.Ip6[:], a.Ip4[:]...)
+	default:
+		return a.Ext[:AddrV2Len(netid)]
+	}
+}
+
+// SetAddr sets the address from the BIP155 network ID and the address bytes.
+func (a *NetAddr) SetAddr(netid byte, addr []byte) error {
+	if AddrV2Len(netid) == 0 || len(addr) != AddrV2Len(netid) {
+		return errors.New("SetAddr: unsupported network ID or bad length")
+	}
+	a.Ip6, a.Ip4, a.NetID, a.Ext = [12]byte{}, [4]byte{}, 0, [32]byte{}
+	switch netid {
+	case NET_IPV4:
+		a.Ip6 = IPV4_PREFIX
+		copy(a.Ip4[:], addr)
+	case NET_IPV6:
+		copy(a.Ip6[:], addr[:12])
+		copy(a.Ip4[:], addr[12:])
+	default:
+		a.NetID = netid
+		copy(a.Ext[:], addr)
+	}
+	return nil
+}
+
+// AddrV2Bytes returns the addrv2 message's serialization of the address (without the time field).
+func (a *NetAddr) AddrV2Bytes() []byte {
+	buf := new(bytes.Buffer)
+	WriteVlen(buf, a.Services)
+	addr := a.AddrBytes()
+	buf.WriteByte(a.NetworkID())
+	WriteVlen(buf, uint64(len(addr)))
+	buf.Write(addr)
+	binary.Write(buf, binary.BigEndian, a.Port)
+	return buf.Bytes()
+}
+
+// ReadNetAddrV2 reads one address (without the time field) of addrv2 message.
+// It returns nil address, with no error, if the network is not supported.
+func ReadNetAddrV2(rd io.Reader) (na *NetAddr, e error) {
+	var netid [1]byte
+	var port [2]byte
+	var services, le uint64
+
+	if services, e = ReadVLen(rd); e != nil {
+		return
+	}
+	if _, e = io.ReadFull(rd, netid[:]); e != nil {
+		return
+	}
+	if le, e = ReadVLen(rd); e != nil {
+		return
+	}
+	if le > MAX_ADDRV2_SIZE {
+		e = errors.New("addrv2: address too long")
+		return
+	}
+	addr := make([]byte, le)
+	if _, e = io.ReadFull(rd, addr); e != nil {
+		return
+	}
+	if _, e = io.ReadFull(rd, port[:]); e != nil {
+		return
+	}
+	if AddrV2Len(netid[0]) == 0 {
+		return // unknown (or deprecated) network - ignore it
+	}
+	na = new(NetAddr)
+	if e = na.SetAddr(netid[0], addr); e != nil {
+		na = nil
+		return
+	}
+	na.Services = services
+	na.Port = binary.BigEndian.Uint16(port[:])
+	return
+}
+
+func onion_checksum(pubkey []byte, version byte) []byte {
+	h := sha3.Sum256(append(append([]byte(".onion checksum"), pubkey...), version))
+	return h[:2]
+}
+
+// Host returns the address without the port number.
+func (a *NetAddr) Host() string {
+	switch a.NetworkID() {
+	case NET_IPV4:
+		return fmt.Sprintf("%d.%d.%d.%d", a.Ip4[0], a.Ip4[1], a.Ip4[2], a.Ip4[3])
+	case NET_TORV3:
+		// base32(pubkey | checksum | version) + ".onion"
+		b := append(append(a.Ext[:32:32], onion_checksum(a.Ext[:32], 3)...), 3)
+		return strings.ToLower(b32.EncodeToString(b)) + ".onion"
+	case NET_I2P:
+		return strings.ToLower(b32.EncodeToString(a.Ext[:32])) + ".b32.i2p"
+	default:
+		return "[" + net.IP(a.AddrBytes()).String() + "]"
+	}
+}
 
 func (a *NetAddr) String() string {
-	return fmt.Sprintf("%d.%d.%d.%d:%d", a.Ip4[0], a.Ip4[1], a.Ip4[2], a.Ip4[3], a.Port)
+	if a.NetID == 0 {
+		return fmt.Sprintf("%d.%d.%d.%d:%d", a.Ip4[0], a.Ip4[1], a.Ip4[2], a.Ip4[3], a.Port)
+	}
+	return fmt.Sprint(a.Host(), ":", a.Port)
+}
+
+// ParseNonIPHost decodes TorV3 (.onion) and I2P (.b32.i2p) host names.
+// It returns zero netid if the host is neither of them.
+func ParseNonIPHost(host string) (netid byte, addr []byte, e error) {
+	host = strings.ToLower(host)
+	if strings.HasSuffix(host, ".onion") {
+		b, er := b32.DecodeString(strings.ToUpper(host[:len(host)-6]))
+		if er != nil || len(b) != 35 {
+			e = errors.New("Unsupported onion address " + host)
+			return
+		}
+		if b[34] != 3 || !bytes.Equal(b[32:34], onion_checksum(b[:32], 3)) {
+			e = errors.New("Bad onion address " + host)
+			return
+		}
+		netid, addr = NET_TORV3, b[:32]
+	} else if strings.HasSuffix(host, ".b32.i2p") {
+		b, er := b32.DecodeString(strings.ToUpper(host[:len(host)-8]))
+		if er != nil || len(b) != 32 {
+			e = errors.New("Bad I2P address " + host)
+			return
+		}
+		netid, addr = NET_I2P, b
+	}
+	return
 }
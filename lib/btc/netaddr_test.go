@@ -0,0 +1,104 @@
+package btc
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestParseNonIPHost(t *testing.T) {
+	hosts := []struct {
+		host  string
+		netid byte
+	}{
+		{"pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion", NET_TORV3},
+		{"udhdrtrcetjm5sxzskjyr5ztpeszydbh4dpl3pl4utgqqw2v4jna.b32.i2p", NET_I2P},
+		{"1.2.3.4", 0},
+	}
+	for _, h := range hosts {
+		netid, addr, e := ParseNonIPHost(h.host)
+		if e != nil || netid != h.netid {
+			t.Fatal(h.host, netid, e)
+		}
+		if netid == 0 {
+			continue
+		}
+		var na NetAddr
+		if e = na.SetAddr(netid, addr); e != nil {
+			t.Fatal(h.host, e)
+		}
+		if na.Host() != h.host {
+			t.Error("Host mismatch", na.Host(), h.host)
+		}
+	}
+
+	bad := []string{
+		"pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscrya.onion", // checksum
+		"6hzph5hv6337r6p2.onion", // tor v2
+		"udhdrtrcetjm5sxzskjyr5ztpeszydbh4dpl3pl4utgqqw2v4j.b32.i2p",
+	}
+	for _, h := range bad {
+		if _, _, e := ParseNonIPHost(h); e == nil {
+			t.Error("Error expected for", h)
+		}
+	}
+}
+
+func TestNetAddrV2(t *testing.T) {
+	var addrs []*NetAddr
+	for netid := byte(NET_IPV4); netid <= NET_CJDNS; netid++ {
+		if AddrV2Len(netid) == 0 {
+			continue
+		}
+		a := &NetAddr{Services: 0x409, Port: 8333 + uint16(netid)}
+		addr := bytes.Repeat([]byte{0xfc + netid}, AddrV2Len(netid))
+		if e := a.SetAddr(netid, addr); e != nil {
+			t.Fatal(netid, e)
+		}
+		if a.NetworkID() != netid || !bytes.Equal(a.AddrBytes(), addr) {
+			t.Error("Bad address", netid, a.NetworkID())
+		}
+		addrs = append(addrs, a)
+	}
+
+	buf := new(bytes.Buffer)
+	for _, a := range addrs {
+		buf.Write(a.AddrV2Bytes())
+		if a.NetworkID() == NET_TORV3 {
+			// unknown network should be skipped
+			buf.Write([]byte{0x01, 0x99, 0x03, 0x01, 0x02, 0x03, 0x20, 0x8d})
+		}
+	}
+	rd := bytes.NewReader(buf.Bytes())
+	var skipped int
+	for i := 0; i < len(addrs); {
+		na, e := ReadNetAddrV2(rd)
+		if e != nil {
+			t.Fatal(i, e)
+		}
+		if na == nil {
+			skipped++
+			continue
+		}
+		if *na != *addrs[i] {
+			t.Error("Address mismatch", i, na.String(), addrs[i].String())
+		}
+		i++
+	}
+	if skipped != 1 {
+		t.Error("Unknown network not skipped", skipped)
+	}
+	if rd.Len() != 0 {
+		t.Error("Unread bytes left", rd.Len())
+	}
+
+	// IPv4 from the legacy format
+	na := NewNetAddr([]byte{1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 1, 2, 3, 4, 0x20, 0x8d})
+	if !bytes.Equal(na.AddrV2Bytes(), []byte{0x01, NET_IPV4, 0x04, 1, 2, 3, 4, 0x20, 0x8d}) {
+		t.Error("Bad IPv4 addrv2 serialization")
+	}
+
+	// wrong length of a known network
+	if _, e := ReadNetAddrV2(bytes.NewReader([]byte{0x01, NET_IPV4, 0x05, 1, 2, 3, 4, 5, 0x20, 0x8d})); e == nil {
+		t.Error("Error expected for bad IPv4 length")
+	}
+}
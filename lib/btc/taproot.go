@@ -0,0 +1,305 @@
+package btc
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"sync"
+	"sync/atomic"
+
+	"github.com/piotrnar/gocoin/lib/secp256k1"
+)
+
+const (
+	SIGHASH_OUTPUT_MASK = 3
+	SIGHASH_INPUT_MASK  = 0x80
+
+	TAPROOT_LEAF_TAPSCRIPT = 0xc0
+	TAPROOT_LEAF_MASK      = 0xfe
+	ANNEX_TAG              = 0x50
+
+	SCHNORR_BATCH_SIZE = 256 // max number of signatures verified by a single SchnorrBatchVerify()
+)
+
+var (
+	schnorrVerifyCnt uint64
+)
+
+// ScriptExecutionData holds the taproot spending context, as needed by TaprootSigHash()
+type ScriptExecutionData struct {
+	TapleafHash      []byte // set only for script path spends
+	AnnexHash        []byte // SHA256 of the (compact size prefixed) annex, nil if there was no annex
+	CodeSeparatorPos uint32 // position of the last executed OP_CODESEPARATOR (0xffffffff if none)
+
+	ValidationWeightLeft int64 // used by the tapscript interpreter
+}
+
+func SchnorrVerifyCnt() uint64 {
+	return atomic.LoadUint64(&schnorrVerifyCnt)
+}
+
+// SchnorrVerify checks BIP-340 signature (64 bytes) of the message (32 bytes), with x-only key (32 bytes).
+func SchnorrVerify(pkey, sign, msg []byte) bool {
+	atomic.AddUint64(&schnorrVerifyCnt, 1)
+	return secp256k1.SchnorrVerify(pkey, sign, msg)
+}
+
+// SchnorrBatch collects Schnorr signatures, to be verified all together by Verify().
+// If a tx has it set, the script engine adds taproot signatures here, instead of verifying them.
+type SchnorrBatch struct {
+	sync.Mutex
+	pkeys, sigs, msgs [][]byte
+}
+
+// Add puts a signature into the batch. It is safe to call it from many goroutines.
+func (b *SchnorrBatch) Add(pkey, sign, msg []byte) {
+	b.Lock()
+	b.pkeys = append(b.pkeys, pkey)
+	b.sigs = append(b.sigs, sign)
+	b.msgs = append(b.msgs, msg)
+	b.Unlock()
+}
+
+func (b *SchnorrBatch) Len() (res int) {
+	b.Lock()
+	res = len(b.sigs)
+	b.Unlock()
+	return
+}
+
+// Verify returns true if all the signatures in the batch are valid.
+// Big batches are split into chunks of SCHNORR_BATCH_SIZE, which are verified in parallel.
+func (b *SchnorrBatch) Verify() bool {
+	var wg sync.WaitGroup
+	var failed uint32
+	b.Lock()
+	defer b.Unlock()
+	atomic.AddUint64(&schnorrVerifyCnt, uint64(len(b.sigs)))
+	for i := 0; i < len(b.sigs); i += SCHNORR_BATCH_SIZE {
+		end := i + SCHNORR_BATCH_SIZE
+		if end > len(b.sigs) {
+			end = len(b.sigs)
+		}
+		wg.Add(1)
+		go func(i, end int) {
+			if !secp256k1.SchnorrBatchVerify(b.pkeys[i:end], b.sigs[i:end], b.msgs[i:end]) {
+				atomic.StoreUint32(&failed, 1)
+			}
+			wg.Done()
+		}(i, end)
+	}
+	wg.Wait()
+	return failed == 0
+}
+
+// SchnorrSign returns BIP-340 signature of the hash, using random auxiliary data.
+func SchnorrSign(priv, hash []byte) (sig []byte, err error) {
+	var aux [32]byte
+	rand.Read(aux[:])
+	if sig = secp256k1.SchnorrSign(priv, hash, aux[:]); sig == nil {
+		err = errors.New("SchnorrSign error")
+	}
+	return
+}
+
+// TapLeafHash returns the BIP-341 hash of a script leaf.
+func TapLeafHash(leaf_version byte, script []byte) []byte {
+	sha := secp256k1.NewTaggedHash("TapLeaf")
+	sha.Write([]byte{leaf_version})
+	WriteVlen(sha, uint64(len(script)))
+	sha.Write(script)
+	return sha.Sum(nil)
+}
+
+// TapBranchHash returns the BIP-341 hash of an inner node of the script tree.
+func TapBranchHash(a, b []byte) []byte {
+	if bytes.Compare(a, b) < 0 {
+		return secp256k1.TaggedHash("TapBranch", a, b)
+	}
+	return secp256k1.TaggedHash("TapBranch", b, a)
+}
+
+// TapTweakHash returns the tweak for the given x-only internal key.
+// merkle_root should be nil for outputs that have no script path.
+func TapTweakHash(internal, merkle_root []byte) []byte {
+	return secp256k1.TaggedHash("TapTweak", internal, merkle_root)
+}
+
+// taprootHashes calculates (if not done yet) the BIP-341 single SHA256 hashes, that are
+// common for all the inputs of the transaction. Must be called with tx.hash_lock locked.
+func (tx *Tx) taprootHashes() {
+	if tx.tapPrevouts != nil {
+		return
+	}
+
+	sha := sha256.New()
+	for _, vin := range tx.TxIn {
+		sha.Write(vin.Input.Hash[:])
+		binary.Write(sha, binary.LittleEndian, vin.Input.Vout)
+	}
+	tx.tapPrevouts = sha.Sum(nil)
+
+	sha.Reset()
+	for _, out := range tx.Spent_outputs {
+		binary.Write(sha, binary.LittleEndian, out.Value)
+	}
+	tx.tapAmounts = sha.Sum(nil)
+
+	sha.Reset()
+	for _, out := range tx.Spent_outputs {
+		WriteVlen(sha, uint64(len(out.Pk_script)))
+		sha.Write(out.Pk_script)
+	}
+	tx.tapScripts = sha.Sum(nil)
+
+	sha.Reset()
+	for _, vin := range tx.TxIn {
+		binary.Write(sha, binary.LittleEndian, vin.Sequence)
+	}
+	tx.tapSequences = sha.Sum(nil)
+
+	sha.Reset()
+	for _, vout := range tx.TxOut {
+		binary.Write(sha, binary.LittleEndian, vout.Value)
+		WriteVlen(sha, uint64(len(vout.Pk_script)))
+		sha.Write(vout.Pk_script)
+	}
+	tx.tapOutputs = sha.Sum(nil)
+}
+
+// TaprootSigHash returns the BIP-341 signature hash, or nil if the hash type is invalid.
+// tx.Spent_outputs must be set for all the inputs.
+// For key path spending, ed can be nil (if there is no annex) and tapscript must be false.
+func (tx *Tx) TaprootSigHash(ed *ScriptExecutionData, nIn int, hashType byte, tapscript bool) []byte {
+	if !(hashType <= 0x03 || (hashType >= 0x81 && hashType <= 0x83)) {
+		return nil
+	}
+	if len(tx.Spent_outputs) != len(tx.TxIn) || nIn >= len(tx.TxIn) {
+		return nil
+	}
+	if ed == nil {
+		if tapscript {
+			return nil
+		}
+		ed = new(ScriptExecutionData)
+	}
+
+	output_type := hashType & SIGHASH_OUTPUT_MASK
+	if hashType == SIGHASH_DEFAULT {
+		output_type = SIGHASH_ALL
+	}
+	input_type := hashType & SIGHASH_INPUT_MASK
+
+	if output_type == SIGHASH_SINGLE && nIn >= len(tx.TxOut) {
+		return nil
+	}
+
+	tx.hash_lock.Lock()
+	tx.taprootHashes()
+	tx.hash_lock.Unlock()
+
+	sha := secp256k1.NewTaggedHash("TapSighash")
+	sha.Write([]byte{0 /*epoch*/, hashType})
+	binary.Write(sha, binary.LittleEndian, tx.Version)
+	binary.Write(sha, binary.LittleEndian, tx.Lock_time)
+
+	if input_type != SIGHASH_ANYONECANPAY {
+		sha.Write(tx.tapPrevouts)
+		sha.Write(tx.tapAmounts)
+		sha.Write(tx.tapScripts)
+		sha.Write(tx.tapSequences)
+	}
+
+	if output_type == SIGHASH_ALL {
+		sha.Write(tx.tapOutputs)
+	}
+
+	var spend_type byte
+	if tapscript {
+		spend_type = 2
+	}
+	if ed.AnnexHash != nil {
+		spend_type |= 1
+	}
+	sha.Write([]byte{spend_type})
+
+	if input_type == SIGHASH_ANYONECANPAY {
+		sha.Write(tx.TxIn[nIn].Input.Hash[:])
+		binary.Write(sha, binary.LittleEndian, tx.TxIn[nIn].Input.Vout)
+		binary.Write(sha, binary.LittleEndian, tx.Spent_outputs[nIn].Value)
+		WriteVlen(sha, uint64(len(tx.Spent_outputs[nIn].Pk_script)))
+		sha.Write(tx.Spent_outputs[nIn].Pk_script)
+		binary.Write(sha, binary.LittleEndian, tx.TxIn[nIn].Sequence)
+	} else {
+		binary.Write(sha, binary.LittleEndian, uint32(nIn))
+	}
+
+	if ed.AnnexHash != nil {
+		sha.Write(ed.AnnexHash)
+	}
+
+	if output_type == SIGHASH_SINGLE {
+		s := sha256.New()
+		binary.Write(s, binary.LittleEndian, tx.TxOut[nIn].Value)
+		WriteVlen(s, uint64(len(tx.TxOut[nIn].Pk_script)))
+		s.Write(tx.TxOut[nIn].Pk_script)
+		sha.Write(s.Sum(nil))
+	}
+
+	if tapscript {
+		sha.Write(ed.TapleafHash)
+		sha.Write([]byte{0 /*key_version*/})
+		binary.Write(sha, binary.LittleEndian, ed.CodeSeparatorPos)
+	}
+
+	return sha.Sum(nil)
+}
+
+// TaprootOutputKey returns the x-only output key for a key path only (BIP-86) taproot output.
+// The internal key can be given as 33 bytes compressed public key, or as 32 bytes x-only key.
+func TaprootOutputKey(pubkey []byte) []byte {
+	if len(pubkey) == 33 {
+		pubkey = pubkey[1:]
+	}
+	out, _ := secp256k1.XOnlyTweakAdd(pubkey, TapTweakHash(pubkey, nil))
+	return out
+}
+
+// SignTaproot signs a specified transaction input, using the taproot key path (BIP-86 output).
+// tx.Spent_outputs must be set for all the inputs.
+func (tx *Tx) SignTaproot(in int, hash_type byte, priv_key []byte) error {
+	if in >= len(tx.TxIn) {
+		return errors.New("tx.SignTaproot() - input index overflow")
+	}
+
+	h := tx.TaprootSigHash(nil, in, hash_type, false)
+	if h == nil {
+		return errors.New("tx.SignTaproot() - cannot calculate the signature hash")
+	}
+
+	tweak := TapTweakHash(secp256k1.XOnlyPubkey(priv_key), nil)
+	tweaked := secp256k1.XOnlySeckeyTweakAdd(priv_key, tweak)
+	if tweaked == nil {
+		return errors.New("tx.SignTaproot() - cannot tweak the private key")
+	}
+	sig, er := SchnorrSign(tweaked, h)
+	for i := range tweaked {
+		tweaked[i] = 0
+	}
+	if er != nil {
+		return er
+	}
+	if hash_type != SIGHASH_DEFAULT {
+		sig = append(sig, hash_type)
+	}
+
+	if tx.SegWit == nil {
+		tx.SegWit = make([][][]byte, len(tx.TxIn))
+	}
+
+	tx.SegWit[in] = [][]byte{sig}
+
+	return nil
+}
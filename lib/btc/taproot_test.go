@@ -0,0 +1,195 @@
+package btc
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+
+	"github.com/piotrnar/gocoin/lib/secp256k1"
+)
+
+type bip341_vectors struct {
+	KeyPathSpending []struct {
+		Given struct {
+			RawUnsignedTx string
+			UtxosSpent    []struct {
+				ScriptPubKey string
+				AmountSats   uint64
+			}
+		}
+		Intermediary struct {
+			HashAmounts, HashOutputs, HashPrevouts, HashScriptPubkeys, HashSequences string
+		}
+		InputSpending []struct {
+			Given struct {
+				TxinIndex       int
+				HashType        byte
+				InternalPrivkey string
+				MerkleRoot      *string
+			}
+			Intermediary struct {
+				Tweak, TweakedPrivkey, SigHash string
+			}
+			Expected struct {
+				Witness []string
+			}
+		}
+	}
+}
+
+func TestTaprootSigHash(t *testing.T) {
+	var vecs bip341_vectors
+	d, _ := ioutil.ReadFile("../test/bip341_wallet_vectors.json")
+	if e := json.Unmarshal(d, &vecs); e != nil {
+		t.Fatal(e.Error())
+	}
+
+	for _, v := range vecs.KeyPathSpending {
+		raw, _ := hex.DecodeString(v.Given.RawUnsignedTx)
+		tx, _ := NewTx(raw)
+		if tx == nil {
+			t.Fatal("NewTx failed")
+		}
+		for _, u := range v.Given.UtxosSpent {
+			pks, _ := hex.DecodeString(u.ScriptPubKey)
+			tx.Spent_outputs = append(tx.Spent_outputs, &TxOut{Value: u.AmountSats, Pk_script: pks})
+		}
+
+		for i, inp := range v.InputSpending {
+			sh := tx.TaprootSigHash(nil, inp.Given.TxinIndex, inp.Given.HashType, false)
+			if hex.EncodeToString(sh) != inp.Intermediary.SigHash {
+				t.Error("SigHash mismatch at input", i)
+				continue
+			}
+
+			if inp.Given.InternalPrivkey == "" {
+				continue
+			}
+			sec, _ := hex.DecodeString(inp.Given.InternalPrivkey)
+			var merkle_root []byte
+			if inp.Given.MerkleRoot != nil {
+				merkle_root, _ = hex.DecodeString(*inp.Given.MerkleRoot)
+			}
+			tweak := TapTweakHash(secp256k1.XOnlyPubkey(sec), merkle_root)
+			if hex.EncodeToString(tweak) != inp.Intermediary.Tweak {
+				t.Error("Tweak mismatch at input", i)
+			}
+			tsec := secp256k1.XOnlySeckeyTweakAdd(sec, tweak)
+			if hex.EncodeToString(tsec) != inp.Intermediary.TweakedPrivkey {
+				t.Error("TweakedPrivkey mismatch at input", i)
+			}
+			if !bytes.Equal(secp256k1.XOnlyPubkey(tsec), tx.Spent_outputs[inp.Given.TxinIndex].Pk_script[2:]) {
+				t.Error("Tweaked pubkey mismatch at input", i)
+			}
+			sig := secp256k1.SchnorrSign(tsec, sh, nil)
+			if inp.Given.HashType != SIGHASH_DEFAULT {
+				sig = append(sig, inp.Given.HashType)
+			}
+			if hex.EncodeToString(sig) != inp.Expected.Witness[0] {
+				t.Error("Signature mismatch at input", i)
+			}
+		}
+
+		// Check the cached hashes
+		for _, h := range []struct {
+			got []byte
+			exp string
+		}{
+			{tx.tapAmounts, v.Intermediary.HashAmounts},
+			{tx.tapOutputs, v.Intermediary.HashOutputs},
+			{tx.tapPrevouts, v.Intermediary.HashPrevouts},
+			{tx.tapScripts, v.Intermediary.HashScriptPubkeys},
+			{tx.tapSequences, v.Intermediary.HashSequences},
+		} {
+			if hex.EncodeToString(h.got) != h.exp {
+				t.Error("Intermediary hash mismatch", h.exp)
+			}
+		}
+	}
+}
+
+func TestTaprootSigHashInvalid(t *testing.T) {
+	tx := new(Tx)
+	tx.TxIn = []*TxIn{&TxIn{}, &TxIn{}}
+	tx.TxOut = []*TxOut{&TxOut{}}
+	if tx.TaprootSigHash(nil, 0, SIGHASH_DEFAULT, false) != nil {
+		t.Error("sighash without spent outputs")
+	}
+	tx.Spent_outputs = []*TxOut{&TxOut{}, &TxOut{}}
+	if tx.TaprootSigHash(nil, 0, SIGHASH_DEFAULT, false) == nil {
+		t.Error("valid sighash failed")
+	}
+	for _, ht := range []byte{4, 0x80, 0x84, 0xff} {
+		if tx.TaprootSigHash(nil, 0, ht, false) != nil {
+			t.Error("sighash with invalid hashtype", ht)
+		}
+	}
+	if tx.TaprootSigHash(nil, 1, SIGHASH_SINGLE, false) != nil {
+		t.Error("SIGHASH_SINGLE without matching output")
+	}
+}
+
+func TestTaprootBIP86(t *testing.T) {
+	// BIP-86 test vectors (mnemonic: abandon abandon ... about)
+	seed, _ := hex.DecodeString("5eb00bbddcf069084889a8ab9155568165f5c453ccb85e70811aaed6f6da5fc1" +
+		"9a5ac40b389cd370d086206dec8aa6c43daea6690f20ad3d8d48b2d2ce9e38e4")
+	acc := MasterKey(seed, false).Child(0x80000000 + 86).Child(0x80000000).Child(0x80000000)
+	for _, v := range []struct {
+		change, idx      uint32
+		internal, output string
+		addr             string
+	}{
+		{0, 0, "cc8a4bc64d897bddc5fbc2f670f7a8ba0b386779106cf1223c6fc5d7cd6fc115",
+			"a60869f0dbcf1dc659c9cecbaf8050135ea9e8cdc487053f1dc6880949dc684c",
+			"bc1p5cyxnuxmeuwuvkwfem96lqzszd02n6xdcjrs20cac6yqjjwudpxqkedrcr"},
+		{0, 1, "83dfe85a3151d2517290da461fe2815591ef69f2b18a2ce63f01697a8b313145",
+			"a82f29944d65b86ae6b5e5cc75e294ead6c59391a1edc5e016e3498c67fc7bbb",
+			"bc1p4qhjn9zdvkux4e44uhx8tc55attvtyu358kutcqkudyccelu0was9fqzwh"},
+		{1, 0, "399f1b2f4393f29a18c937859c5dd8a77350103157eb880f02e8c08214277cef",
+			"882d74e5d0572d5a816cef0041a96b6c1de832f6f9676d9605c44d5e9a97d3dc",
+			"bc1p3qkhfews2uk44qtvauqyr2ttdsw7svhkl9nkm9s9c3x4ax5h60wqwruhk7"},
+	} {
+		pub := PublicFromPrivate(acc.Child(v.change).Child(v.idx).Key[1:], true)
+		if hex.EncodeToString(pub[1:]) != v.internal {
+			t.Error("Internal key mismatch", v.change, v.idx)
+		}
+		out := TaprootOutputKey(pub)
+		if hex.EncodeToString(out) != v.output {
+			t.Error("Output key mismatch", v.change, v.idx)
+		}
+		if a := NewAddrFromPkScript(append([]byte{OP_1, 32}, out...), false); a == nil || a.String() != v.addr {
+			t.Error("Address mismatch", v.change, v.idx)
+		}
+	}
+}
+
+func TestSignTaproot(t *testing.T) {
+	sec, _ := hex.DecodeString("6b973d88838f27366ed61c9ad6367663045cb456e28335c109e30717ae0c6baa")
+	out := TaprootOutputKey(secp256k1.XOnlyPubkey(sec))
+	tx := new(Tx)
+	tx.Version = 2
+	tx.TxIn = []*TxIn{&TxIn{Sequence: 0xffffffff}, &TxIn{Sequence: 0xffffffff}}
+	tx.TxOut = []*TxOut{&TxOut{Value: 1000, Pk_script: []byte{0x6a}}}
+	tx.Spent_outputs = []*TxOut{&TxOut{Value: 2000, Pk_script: append([]byte{OP_1, 32}, out...)},
+		&TxOut{Value: 3000, Pk_script: []byte{OP_1}}}
+
+	for _, ht := range []byte{SIGHASH_DEFAULT, SIGHASH_ALL | SIGHASH_ANYONECANPAY} {
+		if er := tx.SignTaproot(0, ht, sec); er != nil {
+			t.Fatal(er.Error())
+		}
+		sig := tx.SegWit[0][0]
+		if ht == SIGHASH_DEFAULT && len(sig) != 64 || ht != SIGHASH_DEFAULT && (len(sig) != 65 || sig[64] != ht) {
+			t.Error("Bad signature length for hashtype", ht)
+			continue
+		}
+		if !SchnorrVerify(out, sig[:64], tx.TaprootSigHash(nil, 0, ht, false)) {
+			t.Error("Signature does not verify for hashtype", ht)
+		}
+	}
+
+	if tx.SignTaproot(2, SIGHASH_DEFAULT, sec) == nil {
+		t.Error("SignTaproot should fail for a wrong input index")
+	}
+}
@@ -12,6 +12,7 @@ import (
 )
 
 const (
+	SIGHASH_DEFAULT      = 0 // only valid for taproot signatures
 	SIGHASH_ALL          = 1
 	SIGHASH_NONE         = 2
 	SIGHASH_SINGLE       = 3
@@ -53,12 +54,21 @@ type Tx struct {
 	// This field is only set in chain's ProcessBlockTransactions:
 	Fee uint64
 
+	// Outputs spent by each input - must be set before verifying taproot scripts:
+	Spent_outputs []*TxOut
+
+	// If set, taproot signatures are collected here, instead of being verified by the script engine:
+	Schnorr_batch *SchnorrBatch
+
 	wTxID Uint256
 
 	hash_lock    sync.Mutex
 	hashPrevouts []byte
 	hashSequence []byte
 	hashOutputs  []byte
+
+	// BIP-341 (single SHA256) hashes - see taprootHashes()
+	tapPrevouts, tapAmounts, tapScripts, tapSequences, tapOutputs []byte
 }
 
 type AddrValue struct {
@@ -122,6 +122,10 @@ func (ch *Chain) ApplyBlockFlags(bl *btc.Block) {
 		bl.VerifyFlags |= script.VER_WITNESS | script.VER_NULLDUMMY
 	}
 
+	if ch.Consensus.Enforce_TAPROOT != 0 && bl.Height >= ch.Consensus.Enforce_TAPROOT {
+		bl.VerifyFlags |= script.VER_TAPROOT
+	}
+
 }
 
 
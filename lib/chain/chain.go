@@ -27,13 +27,20 @@ type Chain struct {
 
 	CB NewChanOpts // callbacks used by Unspent database
 
+	Filters *FilterDB // BIP158 basic filters of the blocks (nil if not enabled)
+
+	work_node *BlockTreeNode // the last node for which ChainWork() was calculated
+	work_value *big.Int
+
 	Consensus struct {
 		Window, EnforceUpgrade, RejectBlock uint
 		MaxPOWBits uint32
 		MaxPOWValue *big.Int
+		MinimumChainWork *big.Int // headers of chains with less work go through the anti-DoS presync first
 		GensisTimestamp uint32
 		Enforce_CSV uint32 // if non zero CVS verifications will be enforced from this block onwards
 		Enforce_SEGWIT uint32 // if non zero CVS verifications will be enforced from this block onwards
+		Enforce_TAPROOT uint32 // if non zero Taproot verifications will be enforced from this block onwards
 		BIP9_Treshold uint32 // It is not really used at this moment, but maybe one day...
 		BIP34Height uint32
 		BIP65Height uint32
@@ -47,7 +54,9 @@ type NewChanOpts struct {
 	UndoBlocks uint // undo this many blocks when opening the chain
 	UTXOCallbacks utxo.CallbackFunctions
 	BlockMinedCB func(*btc.Block) // used to remove mined txs from memory pool
+	BlockUndoneCB func(*btc.Block) // called after a block has been removed from the chain
 	DoNotRescan bool // when set UTXO will not be automatically updated with new block found on disk
+	CompactFilters bool // build BIP158 basic filters index (cfilters.dat)
 }
 
 
@@ -71,14 +80,18 @@ func NewChainExt(dbrootdir string, genesis *btc.Uint256, rescan bool, opts *NewC
 		ch.Consensus.BIP66Height = 330776
 		ch.Consensus.Enforce_CSV = 770112
 		ch.Consensus.Enforce_SEGWIT = 834624
+		ch.Consensus.Enforce_TAPROOT = 2011968 // BIP9 activation on testnet3
 		ch.Consensus.BIP9_Treshold = 1512
+		ch.Consensus.MinimumChainWork = new(big.Int) // only the work of our own chain is used as the threshold
 	} else {
 		ch.Consensus.BIP34Height = 227931
 		ch.Consensus.BIP65Height = 388381
 		ch.Consensus.BIP66Height = 363725
 		ch.Consensus.Enforce_CSV = 419328
 		ch.Consensus.Enforce_SEGWIT = 481824
+		ch.Consensus.Enforce_TAPROOT = 709632
 		ch.Consensus.BIP9_Treshold = 1916
+		ch.Consensus.MinimumChainWork, _ = new(big.Int).SetString("000000000000000000000000000000000000000044a50fe819c39ad624021859", 16)
 	}
 
 	ch.Blocks = NewBlockDBExt(dbrootdir, bdbopts)
@@ -100,6 +113,11 @@ func NewChainExt(dbrootdir string, genesis *btc.Uint256, rescan bool, opts *NewC
 		ch.SetLast(ch.BlockTreeRoot)
 	}
 
+	if opts.CompactFilters {
+		ch.Filters = NewFilterDB(dbrootdir)
+		ch.syncFilters()
+	}
+
 	if AbortNow {
 		return
 	}
@@ -163,6 +181,9 @@ func (ch *Chain) Stats() (s string) {
 func (ch *Chain) Close() {
 	ch.Blocks.Close()
 	ch.Unspent.Close()
+	if ch.Filters != nil {
+		ch.Filters.Close()
+	}
 }
 
 
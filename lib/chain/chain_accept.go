@@ -82,6 +82,7 @@ func (ch *Chain)CommitBlock(bl *btc.Block, cur *BlockTreeNode) (e error) {
 			ch.Blocks.BlockAdd(cur.Height, bl)
 			// Apply the block's trabnsactions to the unspent database:
 			ch.Unspent.CommitBlockTxs(changes, bl.Hash.Hash[:])
+			ch.addFilter(bl, cur.Height)
 			ch.SetLast(cur) // Advance the head
 			if ch.CB.BlockMinedCB != nil {
 				ch.CB.BlockMinedCB(bl)
@@ -121,6 +122,12 @@ func (ch *Chain)commitTxs(bl *btc.Block, changes *utxo.BlockChanges) (sigopscost
 
 	var wg sync.WaitGroup
 	var ver_err_cnt uint32
+	var schnorr_batch *btc.SchnorrBatch
+
+	if !bl.Trusted && (bl.VerifyFlags&script.VER_TAPROOT) != 0 {
+		// taproot signatures from all the block's txs will be verified together, at the end
+		schnorr_batch = new(btc.SchnorrBatch)
+	}
 
 	for i := range bl.Txs {
 		txoutsum, txinsum = 0, 0
@@ -134,6 +141,7 @@ func (ch *Chain)commitTxs(bl *btc.Block, changes *utxo.BlockChanges) (sigopscost
 				tx_trusted = true
 			}
 
+			spent_outputs := make([]*btc.TxOut, len(bl.Txs[i].TxIn))
 			for j := 0; j < len(bl.Txs[i].TxIn); j++ {
 				inp := &bl.Txs[i].TxIn[j].Input
 				spent_map, was_spent := changes.DeledTxs[inp.Hash]
@@ -208,15 +216,7 @@ func (ch *Chain)commitTxs(bl *btc.Block, changes *utxo.BlockChanges) (sigopscost
 					}
 				}
 
-				if !tx_trusted { // run VerifyTxScript() in a parallel task
-					wg.Add(1)
-					go func (prv []byte, amount uint64, i int, tx *btc.Tx) {
-						if !script.VerifyTxScript(prv, amount, i, tx, bl.VerifyFlags) {
-							atomic.AddUint32(&ver_err_cnt, 1)
-						}
-						wg.Done()
-					}(tout.Pk_script, tout.Value, j, bl.Txs[i])
-				}
+				spent_outputs[j] = tout
 
 				if btc.IsP2SH(tout.Pk_script) {
 					sigopscost += uint32(btc.WITNESS_SCALE_FACTOR * btc.GetP2SHSigOpCount(bl.Txs[i].TxIn[j].ScriptSig))
@@ -226,6 +226,22 @@ func (ch *Chain)commitTxs(bl *btc.Block, changes *utxo.BlockChanges) (sigopscost
 
 				txinsum += tout.Value
 			}
+
+			// taproot signatures commit to all the spent outputs and compact filters need them as well
+			bl.Txs[i].Spent_outputs = spent_outputs
+
+			if !tx_trusted { // run VerifyTxScript() in a parallel task
+				bl.Txs[i].Schnorr_batch = schnorr_batch
+				for j := range bl.Txs[i].TxIn {
+					wg.Add(1)
+					go func (prv []byte, amount uint64, i int, tx *btc.Tx) {
+						if !script.VerifyTxScript(prv, amount, i, tx, bl.VerifyFlags) {
+							atomic.AddUint32(&ver_err_cnt, 1)
+						}
+						wg.Done()
+					}(spent_outputs[j].Pk_script, spent_outputs[j].Value, j, bl.Txs[i])
+				}
+			}
 		} else {
 			// For coinbase tx we need to check (like satoshi) whether the script size is between 2 and 100 bytes
 			// (Previously we made sure in CheckBlock() that this was a coinbase type tx)
@@ -266,6 +282,11 @@ func (ch *Chain)commitTxs(bl *btc.Block, changes *utxo.BlockChanges) (sigopscost
 			e = errors.New(fmt.Sprint("VerifyScripts failed ", ver_err_cnt, "time (s)"))
 			return
 		}
+		if schnorr_batch != nil && !schnorr_batch.Verify() {
+			println("Schnorr batch verification failed for", schnorr_batch.Len(), "signatures")
+			e = errors.New("Schnorr batch verification failed")
+			return
+		}
 	}
 
 	if sumblockin < sumblockout {
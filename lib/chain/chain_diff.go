@@ -83,3 +83,85 @@ func (b1 *BlockTreeNode) MorePOW(b2 *BlockTreeNode) bool {
 	}
 	return b1sum > b2sum
 }
+
+// BlockWork returns the expected number of hashes needed to mine a block with the given bits.
+func BlockWork(bits uint32) *big.Int {
+	target := btc.SetCompact(bits)
+	if target.Sign() <= 0 {
+		return new(big.Int)
+	}
+	// 2**256 / (target+1)
+	return new(big.Int).Div(new(big.Int).Lsh(big.NewInt(1), 256), target.Add(target, big.NewInt(1)))
+}
+
+// ChainWork returns the total work of the chain ending with the given node.
+// It is calculated relatively to the highest node it was asked for so far,
+// so it is only expensive when called for the first time.
+// Make sure to call this function with ch.BlockIndexAccess locked.
+func (ch *Chain) ChainWork(n *BlockTreeNode) (res *big.Int) {
+	res = new(big.Int)
+	if ch.work_node == nil {
+		for nd := n; nd != nil; nd = nd.Parent {
+			res.Add(res, BlockWork(nd.Bits()))
+		}
+	} else {
+		res.Set(ch.work_value)
+		a, b := n, ch.work_node
+		for a.Height > b.Height {
+			res.Add(res, BlockWork(a.Bits()))
+			a = a.Parent
+		}
+		for b.Height > a.Height {
+			res.Sub(res, BlockWork(b.Bits()))
+			b = b.Parent
+		}
+		for a != b {
+			res.Add(res, BlockWork(a.Bits()))
+			res.Sub(res, BlockWork(b.Bits()))
+			a, b = a.Parent, b.Parent
+		}
+	}
+	if ch.work_node == nil || n.Height > ch.work_node.Height {
+		ch.work_node, ch.work_value = n, new(big.Int).Set(res)
+	}
+	return
+}
+
+// AntiDoSWorkThreshold returns the minimum work of a headers chain that we accept without the presync.
+// Make sure to call this function with ch.BlockIndexAccess locked.
+func (ch *Chain) AntiDoSWorkThreshold() *big.Int {
+	lst := ch.LastBlock()
+	res := ch.ChainWork(lst)
+	// allow forks of up to 144 blocks behind our tip
+	res.Sub(res, new(big.Int).Mul(BlockWork(lst.Bits()), big.NewInt(144)))
+	if ch.Consensus.MinimumChainWork != nil && res.Cmp(ch.Consensus.MinimumChainWork) < 0 {
+		res.Set(ch.Consensus.MinimumChainWork)
+	}
+	return res
+}
+
+// PermittedDifficultyTransition checks if the bits of a block at the given height
+// could follow the bits of its parent (without knowing the timestamps of previous blocks).
+func (ch *Chain) PermittedDifficultyTransition(height uint32, old_bits, new_bits uint32) bool {
+	if ch.testnet() {
+		return true // min difficulty blocks are allowed
+	}
+	if (height % targetInterval) != 0 {
+		return old_bits == new_bits
+	}
+	old_target := btc.SetCompact(old_bits)
+
+	// the largest and the smallest possible new target (see GetNextWorkRequired)
+	largest := new(big.Int).Mul(old_target, big.NewInt(4))
+	if largest.Cmp(ch.Consensus.MaxPOWValue) > 0 {
+		largest.Set(ch.Consensus.MaxPOWValue)
+	}
+	smallest := new(big.Int).Div(old_target, big.NewInt(4))
+
+	// round them the same way the compact representation does
+	largest = btc.SetCompact(btc.GetCompact(largest))
+	smallest = btc.SetCompact(btc.GetCompact(smallest))
+
+	new_target := btc.SetCompact(new_bits)
+	return new_target.Cmp(largest) <= 0 && new_target.Cmp(smallest) >= 0
+}
@@ -101,6 +101,7 @@ func (ch *Chain) ParseTillBlock(end *BlockTreeNode) {
 		}
 
 		ch.Unspent.CommitBlockTxs(changes, bl.Hash.Hash[:])
+		ch.addFilter(bl, nxt.Height)
 
 		ch.SetLast(nxt)
 		last = nxt
@@ -275,7 +276,13 @@ func (ch *Chain) UndoLastBlock() {
 	bl.BuildTxList()
 
 	ch.Unspent.UndoBlockTxs(bl, last.Parent.BlockHash.Hash[:])
+	if ch.Filters != nil {
+		ch.Filters.Truncate(last.Height)
+	}
 	ch.SetLast(last.Parent)
+	if ch.CB.BlockUndoneCB != nil {
+		ch.CB.BlockUndoneCB(bl)
+	}
 }
 
 
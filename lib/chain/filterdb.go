@@ -0,0 +1,202 @@
+package chain
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"os"
+	"sync"
+
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+/*
+	cfilters.dat - BIP158 basic filters of the active chain's blocks, one record for each height:
+		[0:32]   - block hash
+		[32:64]  - filter hash
+		[64:96]  - filter header
+		[96:100] - filter length
+		[100:]   - filter data
+*/
+
+const (
+	FILTER_REC_HDR_SIZE = 100
+
+	// Coinbase output of the genesis block (the same for mainnet and testnet)
+	genesis_coinbase_pkscript = "4104678afdb0fe5548271967f1a67130b7105cd6a828e03909a67962e0ea1f61deb649f6bc3f4cef38c4f35504e51ec112de5c384df7ba0b8d578a4c702b6bf11d5fac"
+)
+
+type FilterDB struct {
+	file  *os.File
+	mutex sync.Mutex
+	offs  []int64 // record's position in the file, for each height
+	end   int64
+}
+
+// NewFilterDB opens (or creates) the filters index in the given folder.
+func NewFilterDB(dir string) (db *FilterDB) {
+	var hdr [FILTER_REC_HDR_SIZE]byte
+	var e error
+
+	db = new(FilterDB)
+	if dir != "" && dir[len(dir)-1] != '/' && dir[len(dir)-1] != '\\' {
+		dir += "/"
+	}
+	db.file, e = os.OpenFile(dir+"cfilters.dat", os.O_RDWR|os.O_CREATE, 0660)
+	if e != nil {
+		panic("Cannot open cfilters.dat: " + e.Error())
+	}
+	fi, _ := db.file.Stat()
+	for db.end+FILTER_REC_HDR_SIZE <= fi.Size() {
+		if _, e = db.file.ReadAt(hdr[:], db.end); e != nil {
+			break
+		}
+		next := db.end + FILTER_REC_HDR_SIZE + int64(binary.LittleEndian.Uint32(hdr[96:100]))
+		if next > fi.Size() {
+			break
+		}
+		db.offs = append(db.offs, db.end)
+		db.end = next
+	}
+	if db.end != fi.Size() {
+		println("cfilters.dat: truncating incomplete record at", db.end)
+		db.file.Truncate(db.end)
+	}
+	return
+}
+
+// Count returns the number of blocks in the index. The last block's height is Count()-1.
+func (db *FilterDB) Count() uint32 {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	return uint32(len(db.offs))
+}
+
+// Add appends the filter of the block at the given height to the index.
+// The height must follow the current last one.
+func (db *FilterDB) Add(height uint32, hash *btc.Uint256, filter []byte) error {
+	var prev_header [32]byte
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if height != uint32(len(db.offs)) {
+		return errors.New("FilterDB.Add: unexpected height")
+	}
+	if height > 0 {
+		if _, e := db.file.ReadAt(prev_header[:], db.offs[height-1]+64); e != nil {
+			return e
+		}
+	}
+	rec := make([]byte, FILTER_REC_HDR_SIZE+len(filter))
+	copy(rec[0:32], hash.Hash[:])
+	btc.ShaHash(filter, rec[32:64])
+	fh := btc.FilterHeader(filter, prev_header[:])
+	copy(rec[64:96], fh[:])
+	binary.LittleEndian.PutUint32(rec[96:100], uint32(len(filter)))
+	copy(rec[100:], filter)
+	if _, e := db.file.WriteAt(rec, db.end); e != nil {
+		return e
+	}
+	db.offs = append(db.offs, db.end)
+	db.end += int64(len(rec))
+	return nil
+}
+
+// Truncate removes all the records from the given height up.
+func (db *FilterDB) Truncate(height uint32) {
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if height < uint32(len(db.offs)) {
+		db.end = db.offs[height]
+		db.offs = db.offs[:height]
+		db.file.Truncate(db.end)
+	}
+}
+
+// GetHeader returns the block hash, the filter hash and the filter header of the given height.
+func (db *FilterDB) GetHeader(height uint32) (block_hash, filter_hash, header [32]byte, e error) {
+	var hdr [96]byte
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if height >= uint32(len(db.offs)) {
+		e = errors.New("FilterDB: height out of range")
+		return
+	}
+	if _, e = db.file.ReadAt(hdr[:], db.offs[height]); e != nil {
+		return
+	}
+	copy(block_hash[:], hdr[0:32])
+	copy(filter_hash[:], hdr[32:64])
+	copy(header[:], hdr[64:96])
+	return
+}
+
+// GetFilter returns the block hash and the filter of the given height.
+func (db *FilterDB) GetFilter(height uint32) (block_hash [32]byte, filter []byte, e error) {
+	var hdr [FILTER_REC_HDR_SIZE]byte
+	db.mutex.Lock()
+	defer db.mutex.Unlock()
+	if height >= uint32(len(db.offs)) {
+		e = errors.New("FilterDB: height out of range")
+		return
+	}
+	if _, e = db.file.ReadAt(hdr[:], db.offs[height]); e != nil {
+		return
+	}
+	copy(block_hash[:], hdr[0:32])
+	filter = make([]byte, binary.LittleEndian.Uint32(hdr[96:100]))
+	_, e = db.file.ReadAt(filter, db.offs[height]+FILTER_REC_HDR_SIZE)
+	return
+}
+
+func (db *FilterDB) Close() {
+	db.mutex.Lock()
+	db.file.Close()
+	db.mutex.Unlock()
+}
+
+// syncFilters makes sure that the filters index follows the active chain.
+func (ch *Chain) syncFilters() {
+	last := ch.LastBlock()
+	n := ch.Filters.Count()
+	if n > last.Height+1 {
+		ch.Filters.Truncate(last.Height + 1)
+		n = last.Height + 1
+	}
+	// walk back until the hashes match (in case of a reorg that the index did not follow)
+	node := last
+	for node != nil && node.Height >= n {
+		node = node.Parent
+	}
+	for ; node != nil; node = node.Parent {
+		bh, _, _, e := ch.Filters.GetHeader(node.Height)
+		if e == nil && bh == node.BlockHash.Hash {
+			break
+		}
+		ch.Filters.Truncate(node.Height)
+	}
+	if ch.Filters.Count() == 0 {
+		// genesis block has only one output
+		scr, _ := hex.DecodeString(genesis_coinbase_pkscript)
+		ch.Filters.Add(0, ch.Genesis, btc.NewBasicFilter(ch.Genesis.Hash[:16], [][]byte{scr}))
+	}
+}
+
+// addFilter adds the block to the filters index, if the index is up to date.
+// Spent_outputs must be set in the block's transactions.
+func (ch *Chain) addFilter(bl *btc.Block, height uint32) {
+	if ch.Filters == nil || ch.Filters.Count() != height {
+		return
+	}
+	els, e := bl.BasicFilterElements()
+	if e == nil {
+		e = ch.Filters.Add(height, bl.Hash, btc.NewBasicFilter(bl.Hash.Hash[:16], els))
+	}
+	if e != nil {
+		println("addFilter", height, e.Error())
+	}
+}
+
+// FiltersSynced returns true if the filters index covers the entire active chain.
+func (ch *Chain) FiltersSynced() bool {
+	return ch.Filters != nil && ch.Filters.Count() == ch.LastBlock().Height+1
+}
@@ -0,0 +1,54 @@
+package chain
+
+import (
+	"bytes"
+	"os"
+	"testing"
+
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+func TestFilterDB(t *testing.T) {
+	dir, e := os.MkdirTemp("", "cfilters")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+
+	db := NewFilterDB(dir)
+	var hashes []*btc.Uint256
+	var filters [][]byte
+	for i := 0; i < 5; i++ {
+		hashes = append(hashes, btc.NewSha2Hash([]byte{byte(i)}))
+		filters = append(filters, bytes.Repeat([]byte{byte(i)}, i*10))
+		if e = db.Add(uint32(i), hashes[i], filters[i]); e != nil {
+			t.Fatal(e)
+		}
+	}
+	if db.Add(7, hashes[0], nil) == nil {
+		t.Error("Add with a gap should fail")
+	}
+	db.Truncate(3)
+	db.Close()
+
+	db = NewFilterDB(dir)
+	defer db.Close()
+	if db.Count() != 3 {
+		t.Fatal("Bad count after reopen", db.Count())
+	}
+	var prev [32]byte
+	for i := uint32(0); i < 3; i++ {
+		bh, filter, e := db.GetFilter(i)
+		if e != nil || bh != hashes[i].Hash || !bytes.Equal(filter, filters[i]) {
+			t.Error("Bad filter at", i, e)
+		}
+		bh, fh, hdr, e := db.GetHeader(i)
+		if e != nil || bh != hashes[i].Hash || fh != btc.Sha2Sum(filters[i]) || hdr != btc.FilterHeader(filters[i], prev[:]) {
+			t.Error("Bad header at", i, e)
+		}
+		prev = hdr
+	}
+	if _, _, e = db.GetFilter(3); e == nil {
+		t.Error("GetFilter above the top should fail")
+	}
+}
@@ -0,0 +1,218 @@
+package chain
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"math/big"
+	"time"
+
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+/*
+HeadersSync implements the low-memory, two phase headers download from a single peer
+(the same way Bitcoin Core's headerssync.cpp does), so that a peer cannot make us store
+long chains of low-work headers:
+ 1. Presync - the headers are only checked for continuity, proof of work and permitted
+ difficulty transitions. We keep a single bit commitment (salted hash) for every
+ HDRS_COMMITMENT_PERIOD headers, until the chain's total work reaches the threshold.
+ 2. Redownload - the same headers are requested once again, from the start, and checked
+ against the commitments. They are kept in a buffer and released for storing once there
+ is HDRS_REDOWNLOAD_BUFFER of them, or when the chain's work has reached the threshold.
+A chain that does not reach the threshold is just forgotten.
+Only invalid headers (proof of work, difficulty transition) and commitment mismatches
+are the peer's fault. Headers that do not connect just abort the sync, as they may be
+new blocks' announcements, which the caller should handle the usual way.
+*/
+
+const (
+	HDRS_COMMITMENT_PERIOD = 624   // store one commitment bit every this many headers
+	HDRS_REDOWNLOAD_BUFFER = 14308 // keep this many redownloaded headers, before releasing them
+	MAX_FUTURE_BLOCK_TIME  = 2 * 60 * 60
+
+	HDRS_PRESYNC    = 1
+	HDRS_REDOWNLOAD = 2
+	HDRS_FINAL      = 3
+)
+
+// ErrHeadersNotContinuous is returned when the headers do not connect to the last one.
+var ErrHeadersNotContinuous = errors.New("HeadersSync: non-continuous headers")
+
+type HeadersSync struct {
+	ch      *Chain
+	State   int
+	Start   *BlockTreeNode // our block that the peer's chain starts from
+	MinWork *big.Int       // the chain's work we wait for
+
+	salt            [32]byte
+	commit_offset   uint32
+	commitments     []byte // bit array
+	commit_cnt      int
+	commit_read     int
+	max_commitments int
+
+	// the last header of the current phase
+	last_hash [32]byte
+	last_bits uint32
+	Height    uint32   // height of the last header
+	Work      *big.Int // chain's work, up to the last header
+
+	PresyncHeight uint32 // the highest header seen during the presync (for UI)
+
+	buffer [][]byte // redownloaded headers waiting to be released
+}
+
+// NewHeadersSync starts the headers presync for chain forking from the given block.
+// Make sure to call this function with ch.BlockIndexAccess locked.
+func NewHeadersSync(ch *Chain, start *BlockTreeNode, min_work *big.Int) (hs *HeadersSync) {
+	hs = new(HeadersSync)
+	hs.ch = ch
+	hs.State = HDRS_PRESYNC
+	hs.Start = start
+	hs.MinWork = min_work
+	rand.Read(hs.salt[:])
+	hs.commit_offset = binary.LittleEndian.Uint32(hs.salt[:4]) % HDRS_COMMITMENT_PERIOD
+	// The chain cannot be longer than one block every 6 seconds (the MTP rule), from the start till now
+	max_time := time.Now().Unix() + MAX_FUTURE_BLOCK_TIME - int64(start.GetMedianTimePast())
+	hs.max_commitments = int(6*max_time/HDRS_COMMITMENT_PERIOD) + 1
+	hs.set_last(start)
+	return
+}
+
+func (hs *HeadersSync) set_last(n *BlockTreeNode) {
+	copy(hs.last_hash[:], n.BlockHash.Hash[:])
+	hs.last_bits = n.Bits()
+	hs.Height = n.Height
+	hs.Work = hs.ch.ChainWork(n)
+}
+
+func (hs *HeadersSync) commitment(hash *btc.Uint256) byte {
+	sha := sha256.New()
+	sha.Write(hs.salt[:])
+	sha.Write(hash.Hash[:])
+	return sha.Sum(nil)[0] & 1
+}
+
+// check_header verifies the header against the last one and makes it the last one.
+// dos is set if the header is invalid.
+func (hs *HeadersSync) check_header(hdr []byte) (hash *btc.Uint256, er error, dos bool) {
+	if len(hdr) != 80 {
+		er = errors.New("HeadersSync: bad header length")
+		return
+	}
+	if !bytes.Equal(hdr[4:36], hs.last_hash[:]) {
+		er = ErrHeadersNotContinuous
+		return
+	}
+	hash = btc.NewSha2Hash(hdr)
+	bits := binary.LittleEndian.Uint32(hdr[72:76])
+	if !btc.CheckProofOfWork(hash, bits) {
+		er, dos = errors.New("HeadersSync: proof of work failed"), true
+		return
+	}
+	if !hs.ch.PermittedDifficultyTransition(hs.Height+1, hs.last_bits, bits) {
+		er, dos = errors.New("HeadersSync: bad difficulty transition"), true
+		return
+	}
+	copy(hs.last_hash[:], hash.Hash[:])
+	hs.last_bits = bits
+	hs.Height++
+	hs.Work.Add(hs.Work, BlockWork(bits))
+	return
+}
+
+func (hs *HeadersSync) presync(hdr []byte) (error, bool) {
+	hash, er, dos := hs.check_header(hdr)
+	if er != nil {
+		return er, dos
+	}
+	if hs.Height%HDRS_COMMITMENT_PERIOD == hs.commit_offset {
+		if hs.commit_cnt >= hs.max_commitments {
+			return errors.New("HeadersSync: chain too long"), false
+		}
+		if hs.commit_cnt%8 == 0 {
+			hs.commitments = append(hs.commitments, 0)
+		}
+		hs.commitments[hs.commit_cnt/8] |= hs.commitment(hash) << (hs.commit_cnt % 8)
+		hs.commit_cnt++
+	}
+	hs.PresyncHeight = hs.Height
+	return nil, false
+}
+
+func (hs *HeadersSync) redownload(hdr []byte) (error, bool) {
+	hash, er, dos := hs.check_header(hdr)
+	if er != nil {
+		return er, dos
+	}
+	if hs.Height%HDRS_COMMITMENT_PERIOD == hs.commit_offset {
+		if hs.commit_read >= hs.commit_cnt {
+			return errors.New("HeadersSync: commitments overrun"), false
+		}
+		if (hs.commitments[hs.commit_read/8]>>(hs.commit_read%8))&1 != hs.commitment(hash) {
+			return errors.New("HeadersSync: commitment mismatch"), true
+		}
+		hs.commit_read++
+	}
+	hs.buffer = append(hs.buffer, hdr)
+	return nil, false
+}
+
+// ProcessHeaders takes the headers from the peer's "headers" message (full is set if it had max number of them).
+// It returns the headers that are ready to be stored in the block tree.
+// If er is returned, the sync has been aborted and dos tells if the peer has misbehaved
+// (sent an invalid header or a chain different from the one presynced).
+// Make sure to call this function with ch.BlockIndexAccess locked.
+func (hs *HeadersSync) ProcessHeaders(hdrs [][]byte, full bool) (res [][]byte, er error, dos bool) {
+	switch hs.State {
+	case HDRS_PRESYNC:
+		for _, h := range hdrs {
+			if er, dos = hs.presync(h); er != nil {
+				hs.State = HDRS_FINAL
+				return
+			}
+			if hs.Work.Cmp(hs.MinWork) >= 0 {
+				// enough work - now fetch the same headers again (the rest of this message is ignored)
+				hs.State = HDRS_REDOWNLOAD
+				hs.set_last(hs.Start)
+				return
+			}
+		}
+		if !full {
+			hs.State = HDRS_FINAL
+			er = errors.New("HeadersSync: low-work chain")
+		}
+
+	case HDRS_REDOWNLOAD:
+		for i, h := range hdrs {
+			if er, dos = hs.redownload(h); er != nil {
+				hs.State = HDRS_FINAL
+				return
+			}
+			if hs.Work.Cmp(hs.MinWork) >= 0 {
+				// the rest of the headers (if any) can be processed the usual way
+				res = append(hs.buffer, hdrs[i+1:]...)
+				hs.buffer = nil
+				hs.State = HDRS_FINAL
+				return
+			}
+		}
+		if len(hs.buffer) > HDRS_REDOWNLOAD_BUFFER {
+			n := len(hs.buffer) - HDRS_REDOWNLOAD_BUFFER
+			res, hs.buffer = hs.buffer[:n:n], hs.buffer[n:]
+		}
+		if !full {
+			hs.State = HDRS_FINAL
+			er = errors.New("HeadersSync: redownload incomplete")
+		}
+	}
+	return
+}
+
+// Locator returns the hashes for the next "getheaders" message.
+func (hs *HeadersSync) Locator() []*btc.Uint256 {
+	return []*btc.Uint256{btc.NewUint256(hs.last_hash[:]), hs.Start.BlockHash}
+}
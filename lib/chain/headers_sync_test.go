@@ -0,0 +1,211 @@
+package chain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/piotrnar/gocoin/lib/btc"
+)
+
+const test_bits = 0x207fffff
+
+func test_chain(t *testing.T) (ch *Chain, start *BlockTreeNode) {
+	ch = new(Chain)
+	ch.Genesis = btc.NewUint256(make([]byte, 32))
+	ch.Consensus.MaxPOWValue = btc.SetCompact(test_bits)
+	start = new(BlockTreeNode)
+	binary.LittleEndian.PutUint32(start.BlockHeader[68:72], uint32(time.Now().Add(-24*time.Hour).Unix()))
+	binary.LittleEndian.PutUint32(start.BlockHeader[72:76], test_bits)
+	start.BlockHash = btc.NewSha2Hash(start.BlockHeader[:])
+	return
+}
+
+// mine_headers returns a chain of cnt headers on top of the given hash.
+func mine_headers(prev *btc.Uint256, cnt int, seed byte) (res [][]byte) {
+	tim := uint32(time.Now().Add(-23 * time.Hour).Unix())
+	for i := 0; i < cnt; i++ {
+		hdr := make([]byte, 80)
+		binary.LittleEndian.PutUint32(hdr[0:4], 4)
+		copy(hdr[4:36], prev.Hash[:])
+		hdr[36] = seed
+		binary.LittleEndian.PutUint32(hdr[68:72], tim+uint32(i))
+		binary.LittleEndian.PutUint32(hdr[72:76], test_bits)
+		for nonce := uint32(0); ; nonce++ {
+			binary.LittleEndian.PutUint32(hdr[76:80], nonce)
+			if prev = btc.NewSha2Hash(hdr); btc.CheckProofOfWork(prev, test_bits) {
+				break
+			}
+		}
+		res = append(res, hdr)
+	}
+	return
+}
+
+func work_after(ch *Chain, start *BlockTreeNode, cnt int64) *big.Int {
+	res := new(big.Int).Mul(BlockWork(test_bits), big.NewInt(cnt))
+	return res.Add(res, ch.ChainWork(start))
+}
+
+// presync passes the headers to the presync in messages of up to 2000 headers.
+func presync(hs *HeadersSync, hdrs [][]byte) (res [][]byte, er error, dos bool) {
+	for len(hdrs) > 0 && hs.State == HDRS_PRESYNC {
+		n := len(hdrs)
+		if n > 2000 {
+			n = 2000
+		}
+		var r [][]byte
+		r, er, dos = hs.ProcessHeaders(hdrs[:n], n == 2000)
+		res = append(res, r...)
+		if er != nil {
+			return
+		}
+		hdrs = hdrs[n:]
+	}
+	return
+}
+
+func TestBlockWork(t *testing.T) {
+	if BlockWork(0x1d00ffff).Cmp(big.NewInt(0x100010001)) != 0 {
+		t.Error("Bad work of difficulty 1 block", BlockWork(0x1d00ffff).String())
+	}
+	if BlockWork(test_bits).Cmp(big.NewInt(2)) != 0 {
+		t.Error("Bad work of regtest block", BlockWork(test_bits).String())
+	}
+}
+
+func TestChainWork(t *testing.T) {
+	ch, root := test_chain(t)
+	mk := func(parent *BlockTreeNode, bits uint32) (n *BlockTreeNode) {
+		n = &BlockTreeNode{Parent: parent, Height: parent.Height + 1}
+		binary.LittleEndian.PutUint32(n.BlockHeader[72:76], bits)
+		return
+	}
+	a1 := mk(root, test_bits)
+	a2 := mk(a1, test_bits)
+	a3 := mk(a2, test_bits)
+	b1 := mk(root, 0x1d00ffff)
+	b2 := mk(b1, test_bits)
+	if w := ch.ChainWork(a3); w.Cmp(big.NewInt(8)) != 0 {
+		t.Error("Bad work of the first branch", w.String())
+	}
+	if w := ch.ChainWork(b2); w.Cmp(big.NewInt(4+0x100010001)) != 0 {
+		t.Error("Bad work of the second branch", w.String())
+	}
+	if w := ch.ChainWork(a1); w.Cmp(big.NewInt(4)) != 0 {
+		t.Error("Bad work of the first branch's parent", w.String())
+	}
+}
+
+func TestPermittedDifficultyTransition(t *testing.T) {
+	ch := new(Chain)
+	ch.Genesis = btc.NewUint256(make([]byte, 32))
+	ch.Consensus.MaxPOWValue, _ = new(big.Int).SetString("00000000FFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFFF", 16)
+	if !ch.PermittedDifficultyTransition(100, 0x17034219, 0x17034219) || ch.PermittedDifficultyTransition(100, 0x17034219, 0x17034218) {
+		t.Error("Bits can only change at the retarget")
+	}
+	if !ch.PermittedDifficultyTransition(2016, 0x17034219, 0x1703d1ff) || !ch.PermittedDifficultyTransition(2016, 0x17034219, 0x1700d086) {
+		t.Error("Allowed retarget rejected")
+	}
+	if ch.PermittedDifficultyTransition(2016, 0x17034219, 0x170d0865) || ch.PermittedDifficultyTransition(2016, 0x17034219, 0x1700d085) {
+		t.Error("Retarget by more than 4 times accepted")
+	}
+	if !ch.PermittedDifficultyTransition(2016, 0x1d00ffff, 0x1d00ffff) || ch.PermittedDifficultyTransition(2016, 0x1d00ffff, 0x1d01ffff) {
+		t.Error("Bad retarget at the minimum difficulty")
+	}
+}
+
+func TestHeadersSync(t *testing.T) {
+	ch, start := test_chain(t)
+	hdrs := mine_headers(start.BlockHash, 16000, 1)
+
+	hs := NewHeadersSync(ch, start, work_after(ch, start, 15500))
+	if _, er, _ := presync(hs, hdrs); er != nil || hs.State != HDRS_REDOWNLOAD {
+		t.Fatal("Presync failed", er, hs.State)
+	}
+	if hs.PresyncHeight != 15500 || len(hs.commitments) > 15500/HDRS_COMMITMENT_PERIOD/8+1 {
+		t.Error("Bad presync state", hs.PresyncHeight, len(hs.commitments))
+	}
+
+	var res [][]byte
+	for i := 0; i < len(hdrs); i += 2000 {
+		r, er, _ := hs.ProcessHeaders(hdrs[i:i+2000], true)
+		if er != nil {
+			t.Fatal("Redownload failed", er)
+		}
+		if len(hs.buffer) > HDRS_REDOWNLOAD_BUFFER {
+			t.Error("Redownload buffer too big", len(hs.buffer))
+		}
+		res = append(res, r...)
+		if hs.State == HDRS_FINAL {
+			break
+		}
+	}
+	if hs.State != HDRS_FINAL || len(res) != len(hdrs) {
+		t.Fatal("Redownload not finished", hs.State, len(res))
+	}
+	for i := range res {
+		if !bytes.Equal(res[i], hdrs[i]) {
+			t.Fatal("Released header mismatch", i)
+		}
+	}
+}
+
+func TestHeadersSyncLowWork(t *testing.T) {
+	ch, start := test_chain(t)
+	hdrs := mine_headers(start.BlockHash, 3000, 1)
+	hs := NewHeadersSync(ch, start, work_after(ch, start, 5000))
+	if res, er, dos := presync(hs, hdrs); er == nil || dos || len(res) != 0 || hs.State != HDRS_FINAL {
+		t.Error("Low-work chain not rejected", er, dos, len(res), hs.State)
+	}
+}
+
+func TestHeadersSyncErrors(t *testing.T) {
+	ch, start := test_chain(t)
+	hdrs := mine_headers(start.BlockHash, 2000, 1)
+
+	// not connecting headers (e.g. a new block's announcement) abort the sync, but are not the peer's fault
+	hs := NewHeadersSync(ch, start, work_after(ch, start, 1000))
+	if _, er, dos := hs.ProcessHeaders(hdrs[1:], true); er != ErrHeadersNotContinuous || dos || hs.State != HDRS_FINAL {
+		t.Error("Non-continuous headers not handled", er, dos, hs.State)
+	}
+	hs = NewHeadersSync(ch, start, work_after(ch, start, 1500))
+	if _, er, dos := hs.ProcessHeaders(hdrs[:500], true); er != nil || dos || hs.State != HDRS_PRESYNC {
+		t.Fatal("Presync failed", er, dos, hs.State)
+	}
+	if _, er, dos := hs.ProcessHeaders(hdrs[501:502], false); er != ErrHeadersNotContinuous || dos {
+		t.Error("Non-continuous header during presync not handled", er, dos)
+	}
+
+	// bad proof of work
+	bad := append([]byte{}, hdrs[0]...)
+	binary.LittleEndian.PutUint32(bad[72:76], 0x1d00ffff)
+	hs = NewHeadersSync(ch, start, work_after(ch, start, 1000))
+	if _, er, dos := hs.ProcessHeaders([][]byte{bad}, true); er == nil || !dos {
+		t.Error("Bad proof of work accepted")
+	}
+
+	// redownloaded headers different from the presynced ones
+	hs = NewHeadersSync(ch, start, work_after(ch, start, 1500))
+	if _, er, _ := presync(hs, hdrs); er != nil || hs.State != HDRS_REDOWNLOAD {
+		t.Fatal("Presync failed", er, hs.State)
+	}
+	other := mine_headers(start.BlockHash, 2000, 2)
+	// a single bit commitment can match by chance, so make sure that none of the other chain's does
+	var k int
+	for i := range other {
+		if (start.Height+uint32(i)+1)%HDRS_COMMITMENT_PERIOD == hs.commit_offset && k < hs.commit_cnt {
+			bit := hs.commitment(btc.NewSha2Hash(other[i])) ^ 1
+			hs.commitments[k/8] = hs.commitments[k/8]&^(1<<(k%8)) | bit<<(k%8)
+			k++
+		}
+	}
+	if k == 0 {
+		t.Fatal("No commitments to check")
+	}
+	if res, er, dos := hs.ProcessHeaders(other, true); er == nil || !dos || hs.State != HDRS_FINAL || len(res) != 0 {
+		t.Error("Commitment mismatch not detected", er, dos, len(res))
+	}
+}
@@ -16,6 +16,9 @@ func bech32_polymod_step(pre uint32) uint32 {
 
 const (
 	charset = "qpzry9x8gf2tvdw0s3jn54khce6mua7l"
+
+	BECH32_CONST  = 1          // checksum constant of the original Bech32 (BIP-173)
+	BECH32M_CONST = 0x2bc830a3 // checksum constant of Bech32m (BIP-350)
 )
 
 var (
@@ -32,6 +35,15 @@ var (
 
 // Encode returns an empty string on error.
 func Encode(hrp string, data []byte) string {
+	return encode(hrp, data, BECH32_CONST)
+}
+
+// EncodeM returns Bech32m encoded string, or an empty string on error.
+func EncodeM(hrp string, data []byte) string {
+	return encode(hrp, data, BECH32M_CONST)
+}
+
+func encode(hrp string, data []byte, chk_const uint32) string {
 	var chk uint32 = 1
 	var i int
 	output := new(bytes.Buffer)
@@ -68,7 +80,7 @@ func Encode(hrp string, data []byte) string {
 	for i = 0; i < 6; i++ {
 		chk = bech32_polymod_step(chk)
 	}
-	chk ^= 1
+	chk ^= chk_const
 	for i = 0; i < 6; i++ {
 		output.WriteByte(charset[(chk>>uint((5-i)*5))&0x1f])
 	}
@@ -77,6 +89,23 @@ func Encode(hrp string, data []byte) string {
 
 // Decode returns ("", nil) on error.
 func Decode(input string) (res_hrp string, res_data []byte) {
+	if hrp, data, chk := decode(input); chk == BECH32_CONST {
+		res_hrp, res_data = hrp, data
+	}
+	return
+}
+
+// DecodeM decodes Bech32m string. It returns ("", nil) on error.
+func DecodeM(input string) (res_hrp string, res_data []byte) {
+	if hrp, data, chk := decode(input); chk == BECH32M_CONST {
+		res_hrp, res_data = hrp, data
+	}
+	return
+}
+
+// decode returns the checksum constant it has found (to be compared with BECH32_CONST
+// or BECH32M_CONST), or ("", nil, 0) on error.
+func decode(input string) (res_hrp string, res_data []byte, res_chk uint32) {
 	var chk uint32 = 1
 	var i, data_len, hrp_len int
 	var have_lower, have_upper bool
@@ -135,9 +164,8 @@ func Decode(input string) (res_hrp string, res_data []byte) {
 	if have_lower && have_upper {
 		return
 	}
-	if chk == 1 {
-		res_hrp = string(hrp)
-		res_data = data
-	}
+	res_hrp = string(hrp)
+	res_data = data
+	res_chk = chk
 	return
 }
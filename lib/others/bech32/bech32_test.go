@@ -13,6 +13,15 @@ var (
 		"11qqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqqc8247j",
 		"split1checkupstagehandshakeupstreamerranterredcaperred2y9e3w"}
 
+	valid_checksum_m = []string{
+		"A1LQFN3A",
+		"a1lqfn3a",
+		"an83characterlonghumanreadablepartthatcontainsthetheexcludedcharactersbioandnumber11sg7hg6",
+		"abcdef1l7aum6echk45nj3s0wdvt2fg8x9yrzpqzd3ryx",
+		"11llllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllllludsr8",
+		"split1checkupstagehandshakeupstreamerranterredcaperredlc445v",
+		"?1v759aa"}
+
 	invalid_checksum = []string{
 		" 1nwldj5",
 		"\x7f1axkwrx",
@@ -50,3 +59,24 @@ func TestInvalidChecksum(t *testing.T) {
 		}
 	}
 }
+
+func TestValidChecksumM(t *testing.T) {
+	for _, s := range valid_checksum_m {
+		hrp, data := DecodeM(s)
+		if data == nil || hrp == "" {
+			t.Error("DecodeM fails: ", s)
+			continue
+		}
+		if rebuild := EncodeM(hrp, data); !strings.EqualFold(s, rebuild) {
+			t.Error("EncodeM produces incorrect result: ", s)
+		}
+		if hrp, data = Decode(s); data != nil || hrp != "" {
+			t.Error("Decode succeeds on Bech32m string: ", s)
+		}
+	}
+	for _, s := range valid_checksum {
+		if hrp, data := DecodeM(s); data != nil || hrp != "" {
+			t.Error("DecodeM succeeds on Bech32 string: ", s)
+		}
+	}
+}
@@ -39,15 +39,23 @@ func SegwitEncode(hrp string, witver int, witprog []byte) string {
 	if len(witprog) < 2 || len(witprog) > 40 {
 		return ""
 	}
-	return Encode(hrp, append([]byte{byte(witver)}, convert_bits(5, witprog, 8, true)...))
+	data := append([]byte{byte(witver)}, convert_bits(5, witprog, 8, true)...)
+	if witver == 0 {
+		return Encode(hrp, data)
+	}
+	return EncodeM(hrp, data) // BIP-350: witness version 1+ uses Bech32m
 }
 
 // SegwitDecode returns (0, nil) on error.
 func SegwitDecode(hrp, addr string) (witver int, witdata []byte) {
-	hrp_actual, data := Decode(addr)
+	hrp_actual, data, chk := decode(addr)
 	if hrp_actual == "" || len(data) == 0 || len(data) > 65 {
 		return
 	}
+	// BIP-350: witness version 0 must use Bech32, while all the higher versions - Bech32m
+	if (data[0] == 0 && chk != BECH32_CONST) || (data[0] != 0 && chk != BECH32M_CONST) {
+		return
+	}
 	if hrp != hrp_actual {
 		return
 	}
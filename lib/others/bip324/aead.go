@@ -0,0 +1,128 @@
+package bip324
+
+import (
+	"crypto/subtle"
+	"encoding/binary"
+	"errors"
+)
+
+// ChaCha20-Poly1305 AEAD (RFC 8439) and its forward secure variants, defined in BIP-324.
+
+const REKEY_INTERVAL = 224 // packets (or length chunks) encrypted with one key
+
+var ErrAuth = errors.New("bip324: message authentication failed")
+
+func aead_mac(key, nonce, aad, ct []byte) [POLY1305_TAG_SIZE]byte {
+	var poly_key [32]byte
+	var zeros [16]byte
+	var lens [16]byte
+	NewChaCha20(key, nonce, 0).KeyStream(poly_key[:])
+	p := NewPoly1305(poly_key[:])
+	p.Write(aad)
+	p.Write(zeros[:(16-len(aad)%16)%16])
+	p.Write(ct)
+	p.Write(zeros[:(16-len(ct)%16)%16])
+	binary.LittleEndian.PutUint64(lens[0:8], uint64(len(aad)))
+	binary.LittleEndian.PutUint64(lens[8:16], uint64(len(ct)))
+	p.Write(lens[:])
+	return p.Sum()
+}
+
+// AEADEncrypt appends the encrypted plain text and the tag to dst.
+func AEADEncrypt(dst, key, nonce, aad, plain []byte) []byte {
+	off := len(dst)
+	dst = append(dst, plain...)
+	ct := dst[off:]
+	NewChaCha20(key, nonce, 1).XORKeyStream(ct, ct)
+	tag := aead_mac(key, nonce, aad, ct)
+	return append(dst, tag[:]...)
+}
+
+// AEADDecrypt appends the decrypted text to dst.
+func AEADDecrypt(dst, key, nonce, aad, ct []byte) ([]byte, error) {
+	if len(ct) < POLY1305_TAG_SIZE {
+		return nil, ErrAuth
+	}
+	tag := aead_mac(key, nonce, aad, ct[:len(ct)-POLY1305_TAG_SIZE])
+	if subtle.ConstantTimeCompare(tag[:], ct[len(ct)-POLY1305_TAG_SIZE:]) != 1 {
+		return nil, ErrAuth
+	}
+	off := len(dst)
+	dst = append(dst, ct[:len(ct)-POLY1305_TAG_SIZE]...)
+	NewChaCha20(key, nonce, 1).XORKeyStream(dst[off:], dst[off:])
+	return dst, nil
+}
+
+// FSChaCha20 encrypts the length fields, with a new key every REKEY_INTERVAL chunks.
+type FSChaCha20 struct {
+	c             *ChaCha20
+	chunk_counter uint64
+	rekey_counter uint64
+}
+
+func fs_nonce(nonce *[12]byte, lo uint32, hi uint64) {
+	binary.LittleEndian.PutUint32(nonce[0:4], lo)
+	binary.LittleEndian.PutUint64(nonce[4:12], hi)
+}
+
+func NewFSChaCha20(key []byte) (f *FSChaCha20) {
+	var nonce [12]byte
+	f = new(FSChaCha20)
+	f.c = NewChaCha20(key, nonce[:], 0)
+	return
+}
+
+// Crypt encrypts or decrypts one chunk.
+func (f *FSChaCha20) Crypt(dst, src []byte) {
+	f.c.XORKeyStream(dst, src)
+	f.chunk_counter++
+	if f.chunk_counter%REKEY_INTERVAL == 0 {
+		var key [32]byte
+		var nonce [12]byte
+		f.c.KeyStream(key[:])
+		f.rekey_counter++
+		fs_nonce(&nonce, 0, f.rekey_counter)
+		f.c.SetKey(key[:])
+		f.c.Seek(nonce[:], 0)
+	}
+}
+
+// FSChaCha20Poly1305 encrypts the packets, with a new key every REKEY_INTERVAL packets.
+type FSChaCha20Poly1305 struct {
+	key            [32]byte
+	packet_counter uint64
+}
+
+func NewFSChaCha20Poly1305(key []byte) (f *FSChaCha20Poly1305) {
+	f = new(FSChaCha20Poly1305)
+	copy(f.key[:], key)
+	return
+}
+
+func (f *FSChaCha20Poly1305) next_packet(nonce *[12]byte) {
+	f.packet_counter++
+	if f.packet_counter%REKEY_INTERVAL == 0 {
+		var zeros [32]byte
+		nonce[0], nonce[1], nonce[2], nonce[3] = 0xff, 0xff, 0xff, 0xff
+		new_key := AEADEncrypt(nil, f.key[:], nonce[:], nil, zeros[:])
+		copy(f.key[:], new_key[:32])
+	}
+}
+
+// Encrypt appends the encrypted packet to dst.
+func (f *FSChaCha20Poly1305) Encrypt(dst, aad, plain []byte) []byte {
+	var nonce [12]byte
+	fs_nonce(&nonce, uint32(f.packet_counter%REKEY_INTERVAL), f.packet_counter/REKEY_INTERVAL)
+	dst = AEADEncrypt(dst, f.key[:], nonce[:], aad, plain)
+	f.next_packet(&nonce)
+	return dst
+}
+
+// Decrypt appends the decrypted packet to dst.
+func (f *FSChaCha20Poly1305) Decrypt(dst, aad, ct []byte) (res []byte, e error) {
+	var nonce [12]byte
+	fs_nonce(&nonce, uint32(f.packet_counter%REKEY_INTERVAL), f.packet_counter/REKEY_INTERVAL)
+	res, e = AEADDecrypt(dst, f.key[:], nonce[:], aad, ct)
+	f.next_packet(&nonce)
+	return
+}
@@ -0,0 +1,293 @@
+// Package bip324 implements the v2 encrypted P2P transport protocol (BIP-324).
+package bip324
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"strings"
+
+	"github.com/piotrnar/gocoin/lib/secp256k1"
+)
+
+const (
+	ELLSWIFT_SIZE          = 64
+	GARBAGE_TERMINATOR_LEN = 16
+	MAX_GARBAGE_LEN        = 4095
+	LENGTH_SIZE            = 3
+	HEADER_SIZE            = 1
+	EXPANSION              = LENGTH_SIZE + HEADER_SIZE + POLY1305_TAG_SIZE // bytes added to each packet's contents
+
+	IGNORE_BIT = 0x80
+
+	V1_PREFIX_LEN = 16 // network magic + "version\0\0\0\0\0"
+
+	MAX_CONTENTS_LEN = 1 + 12 + 4000000 // message type + the biggest message we accept
+)
+
+var (
+	ErrV1Peer  = errors.New("bip324: v1 peer")
+	ErrTooLong = errors.New("bip324: packet too long")
+)
+
+// SHORT_IDS lists the message types that have one byte long IDs (index in the table).
+var SHORT_IDS = []string{"",
+	"addr", "block", "blocktxn", "cmpctblock", "feefilter", "filteradd", "filterclear",
+	"filterload", "getblocks", "getblocktxn", "getdata", "getheaders", "headers", "inv",
+	"mempool", "merkleblock", "notfound", "ping", "pong", "sendcmpct", "tx",
+	"getcfilters", "cfilter", "getcfheaders", "cfheaders", "getcfcheckpt", "cfcheckpt", "addrv2"}
+
+var short_id_map map[string]byte
+
+// Cipher keeps the encryption state of one v2 connection.
+type Cipher struct {
+	send_l, recv_l *FSChaCha20
+	send_p, recv_p *FSChaCha20Poly1305
+
+	SessionID      [32]byte
+	SendTerminator [GARBAGE_TERMINATOR_LEN]byte
+	RecvTerminator [GARBAGE_TERMINATOR_LEN]byte
+}
+
+// hkdf_extract is HKDF-Extract (RFC 5869) with SHA256.
+func hkdf_extract(salt, ikm []byte) []byte {
+	h := hmac.New(sha256.New, salt)
+	h.Write(ikm)
+	return h.Sum(nil)
+}
+
+// hkdf_expand is HKDF-Expand (RFC 5869) with SHA256. le must not exceed 255*32.
+func hkdf_expand(prk, info []byte, le int) (res []byte) {
+	var t []byte
+	h := hmac.New(sha256.New, prk)
+	for i := byte(1); len(res) < le; i++ {
+		h.Reset()
+		h.Write(t)
+		h.Write(info)
+		h.Write([]byte{i})
+		t = h.Sum(nil)
+		res = append(res, t...)
+	}
+	return res[:le]
+}
+
+// NewCipher derives the keys from the ECDH secret.
+func NewCipher(secret []byte, magic [4]byte, initiator bool) (c *Cipher) {
+	prk := hkdf_extract([]byte("bitcoin_v2_shared_secret"+string(magic[:])), secret)
+	expand := func(info string, le int) []byte {
+		return hkdf_expand(prk, []byte(info), le)
+	}
+	c = new(Cipher)
+	copy(c.SessionID[:], expand("session_id", 32))
+	term := expand("garbage_terminators", 2*GARBAGE_TERMINATOR_LEN)
+	il, ip := expand("initiator_L", 32), expand("initiator_P", 32)
+	rl, rp := expand("responder_L", 32), expand("responder_P", 32)
+	if initiator {
+		c.send_l, c.send_p = NewFSChaCha20(il), NewFSChaCha20Poly1305(ip)
+		c.recv_l, c.recv_p = NewFSChaCha20(rl), NewFSChaCha20Poly1305(rp)
+		copy(c.SendTerminator[:], term[:GARBAGE_TERMINATOR_LEN])
+		copy(c.RecvTerminator[:], term[GARBAGE_TERMINATOR_LEN:])
+	} else {
+		c.send_l, c.send_p = NewFSChaCha20(rl), NewFSChaCha20Poly1305(rp)
+		c.recv_l, c.recv_p = NewFSChaCha20(il), NewFSChaCha20Poly1305(ip)
+		copy(c.SendTerminator[:], term[GARBAGE_TERMINATOR_LEN:])
+		copy(c.RecvTerminator[:], term[:GARBAGE_TERMINATOR_LEN])
+	}
+	return
+}
+
+// Encrypt appends the encrypted packet to dst.
+func (c *Cipher) Encrypt(dst, contents, aad []byte, ignore bool) []byte {
+	var le [LENGTH_SIZE]byte
+	var hdr byte
+	le[0], le[1], le[2] = byte(len(contents)), byte(len(contents)>>8), byte(len(contents)>>16)
+	c.send_l.Crypt(le[:], le[:])
+	dst = append(dst, le[:]...)
+	if ignore {
+		hdr = IGNORE_BIT
+	}
+	plain := make([]byte, HEADER_SIZE+len(contents))
+	plain[0] = hdr
+	copy(plain[HEADER_SIZE:], contents)
+	return c.send_p.Encrypt(dst, aad, plain)
+}
+
+// DecryptLength decrypts the 3 bytes length field of the next packet.
+// It returns the number of bytes that follow it (HEADER_SIZE + contents + tag).
+func (c *Cipher) DecryptLength(enc []byte) uint32 {
+	var le [LENGTH_SIZE]byte
+	c.recv_l.Crypt(le[:], enc[:LENGTH_SIZE])
+	return (uint32(le[0]) | uint32(le[1])<<8 | uint32(le[2])<<16) + HEADER_SIZE + POLY1305_TAG_SIZE
+}
+
+// Decrypt decrypts the packet (without the length field).
+func (c *Cipher) Decrypt(enc, aad []byte) (contents []byte, ignore bool, e error) {
+	plain, e := c.recv_p.Decrypt(nil, aad, enc)
+	if e != nil {
+		return
+	}
+	return plain[HEADER_SIZE:], (plain[0] & IGNORE_BIT) != 0, nil
+}
+
+// EncodeMsg returns the packet contents for the given message.
+func EncodeMsg(cmd string, pl []byte) (res []byte) {
+	if id, ok := short_id_map[cmd]; ok {
+		res = make([]byte, 1+len(pl))
+		res[0] = id
+		copy(res[1:], pl)
+	} else {
+		res = make([]byte, 1+12+len(pl))
+		copy(res[1:13], cmd)
+		copy(res[13:], pl)
+	}
+	return
+}
+
+// DecodeMsg returns the message type and payload from the packet contents.
+func DecodeMsg(contents []byte) (cmd string, pl []byte, e error) {
+	if len(contents) == 0 {
+		e = errors.New("bip324: empty packet")
+		return
+	}
+	if contents[0] != 0 {
+		if int(contents[0]) >= len(SHORT_IDS) {
+			e = errors.New("bip324: unknown short message ID")
+			return
+		}
+		return SHORT_IDS[contents[0]], contents[1:], nil
+	}
+	if len(contents) < 13 {
+		e = errors.New("bip324: packet too short")
+		return
+	}
+	cmd = strings.TrimRight(string(contents[1:13]), "\000")
+	if strings.IndexByte(cmd, 0) != -1 {
+		e = errors.New("bip324: malformed message type")
+		return
+	}
+	return cmd, contents[13:], nil
+}
+
+// V1Prefix returns the first bytes of v1 version message, that a v2 responder uses to detect v1 peers.
+func V1Prefix(magic [4]byte) (res []byte) {
+	res = make([]byte, V1_PREFIX_LEN)
+	copy(res, magic[:])
+	copy(res[4:], "version")
+	return
+}
+
+// Handshake performs the key exchange and the version packets exchange over the connection.
+// The responder returns ErrV1Peer if the peer has sent the beginning of v1 version message.
+// prefix holds the bytes already read from the connection (e.g. by the caller, to detect v1 peers).
+// The caller should set a deadline on the connection.
+func Handshake(conn io.ReadWriter, magic [4]byte, initiator bool, prefix []byte) (c *Cipher, e error) {
+	var sec [32]byte
+	var our_ell, their_ell [ELLSWIFT_SIZE]byte
+	var rnd [2]byte
+
+	for {
+		if _, e = rand.Read(sec[:]); e != nil {
+			return
+		}
+		if our_ell, e = secp256k1.EllSwiftCreate(sec[:], nil); e == nil {
+			break
+		}
+	}
+	rand.Read(rnd[:])
+	garbage := make([]byte, int(binary.LittleEndian.Uint16(rnd[:]))%(MAX_GARBAGE_LEN+1))
+	rand.Read(garbage)
+
+	n := copy(their_ell[:], prefix)
+	if !initiator {
+		// check for v1 peer, before sending anything
+		if n < V1_PREFIX_LEN {
+			if _, e = io.ReadFull(conn, their_ell[n:V1_PREFIX_LEN]); e != nil {
+				return
+			}
+			n = V1_PREFIX_LEN
+		}
+		if bytes.Equal(their_ell[:V1_PREFIX_LEN], V1Prefix(magic)) {
+			e = ErrV1Peer
+			return
+		}
+	}
+
+	if _, e = conn.Write(append(our_ell[:], garbage...)); e != nil {
+		return
+	}
+	if _, e = io.ReadFull(conn, their_ell[n:]); e != nil {
+		return
+	}
+
+	secret, ok := secp256k1.EllSwiftECDH(our_ell[:], their_ell[:], sec[:], initiator)
+	if !ok {
+		e = errors.New("bip324: ECDH failed")
+		return
+	}
+	c = NewCipher(secret, magic, initiator)
+
+	// our garbage terminator, followed by the version packet (with no contents)
+	out := append([]byte{}, c.SendTerminator[:]...)
+	out = c.Encrypt(out, nil, garbage, false)
+	if _, e = conn.Write(out); e != nil {
+		c = nil
+		return
+	}
+
+	// their garbage, up to the terminator
+	their_garbage := make([]byte, 0, MAX_GARBAGE_LEN+GARBAGE_TERMINATOR_LEN)
+	var b [1]byte
+	for !bytes.HasSuffix(their_garbage, c.RecvTerminator[:]) {
+		if len(their_garbage) == cap(their_garbage) {
+			e = errors.New("bip324: garbage terminator not found")
+			c = nil
+			return
+		}
+		if _, e = io.ReadFull(conn, b[:]); e != nil {
+			c = nil
+			return
+		}
+		their_garbage = append(their_garbage, b[0])
+	}
+	aad := their_garbage[:len(their_garbage)-GARBAGE_TERMINATOR_LEN]
+
+	// skip decoy packets, until the version packet
+	for {
+		var le [LENGTH_SIZE]byte
+		if _, e = io.ReadFull(conn, le[:]); e != nil {
+			c = nil
+			return
+		}
+		le_enc := c.DecryptLength(le[:])
+		if le_enc > MAX_CONTENTS_LEN+HEADER_SIZE+POLY1305_TAG_SIZE {
+			e = ErrTooLong
+			c = nil
+			return
+		}
+		enc := make([]byte, le_enc)
+		if _, e = io.ReadFull(conn, enc); e != nil {
+			c = nil
+			return
+		}
+		var ignore bool
+		if _, ignore, e = c.Decrypt(enc, aad); e != nil {
+			c = nil
+			return
+		}
+		aad = nil
+		if !ignore {
+			return // the contents of version packet are reserved for future extensions
+		}
+	}
+}
+
+func init() {
+	short_id_map = make(map[string]byte, len(SHORT_IDS))
+	for i, s := range SHORT_IDS[1:] {
+		short_id_map[s] = byte(i + 1)
+	}
+}
@@ -0,0 +1,256 @@
+package bip324
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/hex"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/piotrnar/gocoin/lib/secp256k1"
+)
+
+var test_magic = [4]byte{0xf9, 0xbe, 0xb4, 0xd9}
+
+func unhex(s string) []byte {
+	b, _ := hex.DecodeString(s)
+	return b
+}
+
+// Test vectors from RFC 8439
+func TestChaCha20(t *testing.T) {
+	var out [64]byte
+	c := NewChaCha20(unhex("000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f"), unhex("000000090000004a00000000"), 1)
+	c.KeyStream(out[:])
+	if hex.EncodeToString(out[:]) != "10f1e7e4d13b5915500fdd1fa32071c4c7d1f4c733c068030422aa9ac3d46c4e"+
+		"d2826446079faa0914c2d705d98b02a2b5129cd1de164eb9cbd083e8a2503c4e" {
+		t.Error("Bad key stream", hex.EncodeToString(out[:]))
+	}
+}
+
+func TestPoly1305(t *testing.T) {
+	p := NewPoly1305(unhex("85d6be7857556d337f4452fe42d506a80103808afb0db2fd4abff6af4149f51b"))
+	p.Write([]byte("Cryptographic "))
+	p.Write([]byte("Forum Research Group"))
+	if tag := p.Sum(); hex.EncodeToString(tag[:]) != "a8061dc1305136c6c22b8baf0c0127a9" {
+		t.Error("Bad tag", hex.EncodeToString(tag[:]))
+	}
+}
+
+// Test vectors from RFC 5869 (A.1 and A.3)
+func TestHKDF(t *testing.T) {
+	prk := hkdf_extract(unhex("000102030405060708090a0b0c"), bytes.Repeat([]byte{0x0b}, 22))
+	if !bytes.Equal(prk, unhex("077709362c2e32df0ddc3f0dc47bba6390b6c73bb50f9c3122ec844ad7c2b3e5")) {
+		t.Error("Bad PRK", hex.EncodeToString(prk))
+	}
+	okm := hkdf_expand(prk, unhex("f0f1f2f3f4f5f6f7f8f9"), 42)
+	if !bytes.Equal(okm, unhex("3cb25f25faacd57a90434f64d0362f2a2d2d0a90cf1a5a4c5db02d56ecc4c5bf34007208d5b887185865")) {
+		t.Error("Bad OKM", hex.EncodeToString(okm))
+	}
+	prk = hkdf_extract(nil, bytes.Repeat([]byte{0x0b}, 22))
+	okm = hkdf_expand(prk, nil, 42)
+	if !bytes.Equal(okm, unhex("8da4e775a563c18f715f802a063c5a31b8a11f5c5ee1879ec3454e5f3c738d2d9d201395faa4b61a96c8")) {
+		t.Error("Bad OKM with no salt", hex.EncodeToString(okm))
+	}
+}
+
+func TestAEAD(t *testing.T) {
+	key := unhex("808182838485868788898a8b8c8d8e8f909192939495969798999a9b9c9d9e9f")
+	nonce := unhex("070000004041424344454647")
+	aad := unhex("50515253c0c1c2c3c4c5c6c7")
+	msg := []byte("Ladies and Gentlemen of the class of '99: If I could offer you only one tip for the future, sunscreen would be it.")
+	ct := AEADEncrypt(nil, key, nonce, aad, msg)
+	if hex.EncodeToString(ct[len(ct)-16:]) != "1ae10b594f09e26a7e902ecbd0600691" ||
+		hex.EncodeToString(ct[:16]) != "d31a8d34648e60db7b86afbc53ef7ec2" {
+		t.Error("Bad cipher text", hex.EncodeToString(ct))
+	}
+	if res, e := AEADDecrypt(nil, key, nonce, aad, ct); e != nil || !bytes.Equal(res, msg) {
+		t.Error("Decrypt failed", e)
+	}
+	ct[5] ^= 1
+	if _, e := AEADDecrypt(nil, key, nonce, aad, ct); e != ErrAuth {
+		t.Error("Modified cipher text accepted")
+	}
+}
+
+func TestCipher(t *testing.T) {
+	secret := bytes.Repeat([]byte{0x33}, 32)
+	a := NewCipher(secret, test_magic, true)
+	b := NewCipher(secret, test_magic, false)
+	if a.SendTerminator != b.RecvTerminator || a.RecvTerminator != b.SendTerminator || a.SessionID != b.SessionID {
+		t.Fatal("Keys do not match")
+	}
+	// go over a few rekey intervals
+	for i := 0; i < 3*REKEY_INTERVAL+5; i++ {
+		msg := bytes.Repeat([]byte{byte(i)}, i)
+		pkt := a.Encrypt(nil, msg, nil, i%7 == 0)
+		if len(pkt) != len(msg)+EXPANSION {
+			t.Fatal("Bad packet size", len(pkt))
+		}
+		if le := b.DecryptLength(pkt); int(le) != len(pkt)-LENGTH_SIZE {
+			t.Fatal("Bad length", i, le)
+		}
+		res, ignore, e := b.Decrypt(pkt[LENGTH_SIZE:], nil)
+		if e != nil || !bytes.Equal(res, msg) || ignore != (i%7 == 0) {
+			t.Fatal("Decrypt failed", i, e)
+		}
+	}
+}
+
+// BIP-324 packet_encoding_test_vectors.csv
+func TestPacketEncoding(t *testing.T) {
+	f, e := os.Open("../../test/bip324_packet_encoding_test_vectors.csv")
+	if e != nil {
+		t.Skip(e.Error())
+	}
+	defer f.Close()
+	recs, e := csv.NewReader(f).ReadAll()
+	if e != nil {
+		t.Fatal(e.Error())
+	}
+	col := make(map[string]int, len(recs[0]))
+	for i, name := range recs[0] {
+		col[name] = i
+	}
+	for _, rec := range recs[1:] {
+		v := func(name string) []byte {
+			return unhex(rec[col[name]])
+		}
+		idx, _ := strconv.Atoi(rec[col["in_idx"]])
+		multiply, _ := strconv.Atoi(rec[col["in_multiply"]])
+		initiator := rec[col["in_initiating"]] == "1"
+		priv, ell_ours, ell_theirs := v("in_priv_ours"), v("in_ellswift_ours"), v("in_ellswift_theirs")
+
+		if x := secp256k1.EllSwiftDecode(ell_ours); !bytes.Equal(x[:], v("mid_x_ours")) {
+			t.Error(idx, "Bad mid_x_ours", hex.EncodeToString(x[:]))
+		}
+		if x := secp256k1.EllSwiftDecode(ell_theirs); !bytes.Equal(x[:], v("mid_x_theirs")) {
+			t.Error(idx, "Bad mid_x_theirs", hex.EncodeToString(x[:]))
+		}
+		if x, _ := secp256k1.EllSwiftXDH(ell_theirs, priv); !bytes.Equal(x[:], v("mid_x_shared")) {
+			t.Error(idx, "Bad mid_x_shared", hex.EncodeToString(x[:]))
+		}
+		secret, ok := secp256k1.EllSwiftECDH(ell_ours, ell_theirs, priv, initiator)
+		if !ok || !bytes.Equal(secret, v("mid_shared_secret")) {
+			t.Error(idx, "Bad mid_shared_secret", hex.EncodeToString(secret))
+			continue
+		}
+
+		c := NewCipher(secret, test_magic, initiator)
+		if !bytes.Equal(c.SessionID[:], v("out_session_id")) {
+			t.Error(idx, "Bad out_session_id", hex.EncodeToString(c.SessionID[:]))
+		}
+		if !bytes.Equal(c.SendTerminator[:], v("mid_send_garbage_terminator")) {
+			t.Error(idx, "Bad mid_send_garbage_terminator", hex.EncodeToString(c.SendTerminator[:]))
+		}
+		if !bytes.Equal(c.RecvTerminator[:], v("mid_recv_garbage_terminator")) {
+			t.Error(idx, "Bad mid_recv_garbage_terminator", hex.EncodeToString(c.RecvTerminator[:]))
+		}
+
+		// the packet is preceded by idx empty ones
+		for i := 0; i < idx; i++ {
+			c.Encrypt(nil, nil, nil, false)
+		}
+		enc := c.Encrypt(nil, bytes.Repeat(v("in_contents"), multiply), v("in_aad"), rec[col["in_ignore"]] == "1")
+		if exp := v("out_ciphertext"); len(exp) > 0 && !bytes.Equal(enc, exp) {
+			t.Error(idx, "Bad out_ciphertext", hex.EncodeToString(enc))
+		}
+		if exp := v("out_ciphertext_endswith"); len(exp) > 0 && !bytes.HasSuffix(enc, exp) {
+			t.Error(idx, "Bad out_ciphertext_endswith")
+		}
+	}
+}
+
+func TestMsg(t *testing.T) {
+	for _, cmd := range []string{"block", "addrv2", "version", "sendaddrv2"} {
+		c, pl, e := DecodeMsg(EncodeMsg(cmd, []byte{1, 2, 3}))
+		if e != nil || c != cmd || !bytes.Equal(pl, []byte{1, 2, 3}) {
+			t.Error("Bad message", cmd, c, pl, e)
+		}
+	}
+	if c := EncodeMsg("ping", nil); len(c) != 1 || c[0] != 18 {
+		t.Error("Bad short ID of ping", c)
+	}
+	if _, _, e := DecodeMsg([]byte{29}); e == nil {
+		t.Error("Unknown short ID accepted")
+	}
+	if _, _, e := DecodeMsg([]byte{0, 'a'}); e == nil {
+		t.Error("Short packet accepted")
+	}
+}
+
+// tcp_pair returns both ends of a local TCP connection (net.Pipe has no buffers).
+func tcp_pair(t *testing.T) (c1, c2 net.Conn) {
+	lis, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer lis.Close()
+	if c1, e = net.Dial("tcp", lis.Addr().String()); e != nil {
+		t.Fatal(e)
+	}
+	if c2, e = lis.Accept(); e != nil {
+		t.Fatal(e)
+	}
+	return
+}
+
+func TestHandshake(t *testing.T) {
+	c1, c2 := tcp_pair(t)
+	defer c1.Close()
+	defer c2.Close()
+	c1.SetDeadline(time.Now().Add(5 * time.Second))
+	c2.SetDeadline(time.Now().Add(5 * time.Second))
+
+	done := make(chan *Cipher, 1)
+	go func() {
+		var prefix [V1_PREFIX_LEN]byte
+		io.ReadFull(c2, prefix[:])
+		c, e := Handshake(c2, test_magic, false, prefix[:])
+		if e != nil {
+			t.Error("Responder:", e)
+		}
+		done <- c
+	}()
+	ci, e := Handshake(c1, test_magic, true, nil)
+	if e != nil {
+		t.Fatal("Initiator:", e)
+	}
+	cr := <-done
+	if cr == nil {
+		t.FailNow()
+	}
+	if ci.SessionID != cr.SessionID {
+		t.Fatal("Session IDs differ")
+	}
+
+	go c1.Write(ci.Encrypt(nil, EncodeMsg("ping", []byte{1, 2, 3, 4, 5, 6, 7, 8}), nil, false))
+	var le [LENGTH_SIZE]byte
+	io.ReadFull(c2, le[:])
+	pkt := make([]byte, cr.DecryptLength(le[:]))
+	io.ReadFull(c2, pkt)
+	contents, _, e := cr.Decrypt(pkt, nil)
+	if cmd, pl, _ := DecodeMsg(contents); e != nil || cmd != "ping" || len(pl) != 8 {
+		t.Error("Message not received", e, cmd)
+	}
+}
+
+func TestHandshakeV1(t *testing.T) {
+	c1, c2 := tcp_pair(t)
+	defer c1.Close()
+	defer c2.Close()
+	c2.SetDeadline(time.Now().Add(5 * time.Second))
+	c1.Write(append(V1Prefix(test_magic), make([]byte, 100)...))
+	if _, e := Handshake(c2, test_magic, false, nil); e != ErrV1Peer {
+		t.Error("v1 peer not detected", e)
+	}
+	// nothing should have been sent to the v1 peer
+	c1.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, _ := c1.Read(make([]byte, 1)); n != 0 {
+		t.Error("Responder sent data to v1 peer")
+	}
+}
@@ -0,0 +1,100 @@
+package bip324
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// ChaCha20 stream cipher, as defined in RFC 8439.
+
+const CHACHA20_BLOCK_SIZE = 64
+
+func quarter_round(a, b, c, d uint32) (uint32, uint32, uint32, uint32) {
+	a += b
+	d = bits.RotateLeft32(d^a, 16)
+	c += d
+	b = bits.RotateLeft32(b^c, 12)
+	a += b
+	d = bits.RotateLeft32(d^a, 8)
+	c += d
+	b = bits.RotateLeft32(b^c, 7)
+	return a, b, c, d
+}
+
+// chacha20_block calculates one block of the key stream.
+func chacha20_block(key *[8]uint32, nonce *[3]uint32, counter uint32, out *[CHACHA20_BLOCK_SIZE]byte) {
+	var x, s [16]uint32
+	s[0], s[1], s[2], s[3] = 0x61707865, 0x3320646e, 0x79622d32, 0x6b206574
+	copy(s[4:12], key[:])
+	s[12] = counter
+	copy(s[13:16], nonce[:])
+	x = s
+	for i := 0; i < 10; i++ {
+		x[0], x[4], x[8], x[12] = quarter_round(x[0], x[4], x[8], x[12])
+		x[1], x[5], x[9], x[13] = quarter_round(x[1], x[5], x[9], x[13])
+		x[2], x[6], x[10], x[14] = quarter_round(x[2], x[6], x[10], x[14])
+		x[3], x[7], x[11], x[15] = quarter_round(x[3], x[7], x[11], x[15])
+		x[0], x[5], x[10], x[15] = quarter_round(x[0], x[5], x[10], x[15])
+		x[1], x[6], x[11], x[12] = quarter_round(x[1], x[6], x[11], x[12])
+		x[2], x[7], x[8], x[13] = quarter_round(x[2], x[7], x[8], x[13])
+		x[3], x[4], x[9], x[14] = quarter_round(x[3], x[4], x[9], x[14])
+	}
+	for i := range x {
+		binary.LittleEndian.PutUint32(out[4*i:], x[i]+s[i])
+	}
+}
+
+// ChaCha20 produces the continuous key stream.
+type ChaCha20 struct {
+	key     [8]uint32
+	nonce   [3]uint32
+	counter uint32
+	buf     [CHACHA20_BLOCK_SIZE]byte
+	buf_pos int // how many bytes of buf were already used
+}
+
+// NewChaCha20 returns the cipher with the 32 bytes key and 12 bytes nonce.
+func NewChaCha20(key, nonce []byte, counter uint32) (c *ChaCha20) {
+	c = new(ChaCha20)
+	c.SetKey(key)
+	c.Seek(nonce, counter)
+	return
+}
+
+// SetKey sets the 32 bytes key.
+func (c *ChaCha20) SetKey(key []byte) {
+	for i := range c.key {
+		c.key[i] = binary.LittleEndian.Uint32(key[4*i:])
+	}
+	c.buf_pos = CHACHA20_BLOCK_SIZE
+}
+
+// Seek sets the 12 bytes nonce and the block counter.
+func (c *ChaCha20) Seek(nonce []byte, counter uint32) {
+	for i := range c.nonce {
+		c.nonce[i] = binary.LittleEndian.Uint32(nonce[4*i:])
+	}
+	c.counter = counter
+	c.buf_pos = CHACHA20_BLOCK_SIZE
+}
+
+// XORKeyStream XORs src with the key stream into dst (which may be the same as src).
+func (c *ChaCha20) XORKeyStream(dst, src []byte) {
+	for i := range src {
+		if c.buf_pos == CHACHA20_BLOCK_SIZE {
+			chacha20_block(&c.key, &c.nonce, c.counter, &c.buf)
+			c.counter++
+			c.buf_pos = 0
+		}
+		dst[i] = src[i] ^ c.buf[c.buf_pos]
+		c.buf_pos++
+	}
+}
+
+// KeyStream fills out with the key stream.
+func (c *ChaCha20) KeyStream(out []byte) {
+	for i := range out {
+		out[i] = 0
+	}
+	c.XORKeyStream(out, out)
+}
@@ -0,0 +1,116 @@
+package bip324
+
+import (
+	"encoding/binary"
+	"math/bits"
+)
+
+// Poly1305 one-time authenticator, as defined in RFC 8439.
+// The 130 bits accumulator is kept in h0, h1 and the lowest bits of h2.
+
+const POLY1305_TAG_SIZE = 16
+
+type Poly1305 struct {
+	r0, r1  uint64
+	s0, s1  uint64
+	h0, h1  uint64
+	h2      uint64
+	buf     [16]byte
+	buf_len int
+}
+
+// NewPoly1305 returns the authenticator for the 32 bytes one-time key.
+func NewPoly1305(key []byte) (p *Poly1305) {
+	p = new(Poly1305)
+	p.r0 = binary.LittleEndian.Uint64(key[0:8]) & 0x0FFFFFFC0FFFFFFF
+	p.r1 = binary.LittleEndian.Uint64(key[8:16]) & 0x0FFFFFFC0FFFFFFC
+	p.s0 = binary.LittleEndian.Uint64(key[16:24])
+	p.s1 = binary.LittleEndian.Uint64(key[24:32])
+	return
+}
+
+// block adds the 16 bytes (with hibit as 17th byte) and multiplies the accumulator by r.
+func (p *Poly1305) block(m []byte, hibit uint64) {
+	var c uint64
+	p.h0, c = bits.Add64(p.h0, binary.LittleEndian.Uint64(m[0:8]), 0)
+	p.h1, c = bits.Add64(p.h1, binary.LittleEndian.Uint64(m[8:16]), c)
+	p.h2 += c + hibit
+
+	// h * r - clamping of r guarantees that the partial sums below do not overflow
+	h0r0_hi, h0r0_lo := bits.Mul64(p.h0, p.r0)
+	h1r0_hi, h1r0_lo := bits.Mul64(p.h1, p.r0)
+	h2r0_hi, h2r0_lo := bits.Mul64(p.h2, p.r0)
+	h0r1_hi, h0r1_lo := bits.Mul64(p.h0, p.r1)
+	h1r1_hi, h1r1_lo := bits.Mul64(p.h1, p.r1)
+	h2r1_hi, h2r1_lo := bits.Mul64(p.h2, p.r1)
+
+	m1_lo, c := bits.Add64(h1r0_lo, h0r1_lo, 0)
+	m1_hi, _ := bits.Add64(h1r0_hi, h0r1_hi, c)
+	m2_lo, c := bits.Add64(h2r0_lo, h1r1_lo, 0)
+	m2_hi, _ := bits.Add64(h2r0_hi, h1r1_hi, c)
+
+	t0 := h0r0_lo
+	t1, c := bits.Add64(m1_lo, h0r0_hi, 0)
+	t2, c := bits.Add64(m2_lo, m1_hi, c)
+	t3, _ := bits.Add64(h2r1_lo, m2_hi, c)
+	_ = h2r1_hi // always zero, as h2 is small
+
+	// reduce modulo 2^130-5: the bits above 130 (c) are multiplied by 5 = 4+1
+	p.h0, p.h1, p.h2 = t0, t1, t2&3
+	c_lo, c_hi := t2&^3, t3
+	p.h0, c = bits.Add64(p.h0, c_lo, 0)
+	p.h1, c = bits.Add64(p.h1, c_hi, c)
+	p.h2 += c
+	c_lo, c_hi = c_lo>>2|c_hi<<62, c_hi>>2
+	p.h0, c = bits.Add64(p.h0, c_lo, 0)
+	p.h1, c = bits.Add64(p.h1, c_hi, c)
+	p.h2 += c
+}
+
+// Write adds the data to the message.
+func (p *Poly1305) Write(d []byte) {
+	if p.buf_len > 0 {
+		n := copy(p.buf[p.buf_len:], d)
+		p.buf_len += n
+		d = d[n:]
+		if p.buf_len < 16 {
+			return
+		}
+		p.block(p.buf[:], 1)
+		p.buf_len = 0
+	}
+	for len(d) >= 16 {
+		p.block(d[:16], 1)
+		d = d[16:]
+	}
+	p.buf_len = copy(p.buf[:], d)
+}
+
+// Sum returns the 16 bytes tag.
+func (p *Poly1305) Sum() (tag [POLY1305_TAG_SIZE]byte) {
+	if p.buf_len > 0 {
+		p.buf[p.buf_len] = 1
+		for i := p.buf_len + 1; i < 16; i++ {
+			p.buf[i] = 0
+		}
+		p.block(p.buf[:], 0)
+		p.buf_len = 0
+	}
+
+	// final reduction: take h-p if h >= p
+	var b uint64
+	g0, b := bits.Sub64(p.h0, 0xFFFFFFFFFFFFFFFB, 0)
+	g1, b := bits.Sub64(p.h1, 0xFFFFFFFFFFFFFFFF, b)
+	_, b = bits.Sub64(p.h2, 3, b)
+	h0, h1 := p.h0, p.h1
+	if b == 0 {
+		h0, h1 = g0, g1
+	}
+
+	var c uint64
+	h0, c = bits.Add64(h0, p.s0, 0)
+	h1, _ = bits.Add64(h1, p.s1, c)
+	binary.LittleEndian.PutUint64(tag[0:8], h0)
+	binary.LittleEndian.PutUint64(tag[8:16], h1)
+	return
+}
@@ -0,0 +1,428 @@
+package peersdb
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	mr "math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/piotrnar/gocoin/lib/btc"
+	"github.com/piotrnar/gocoin/lib/others/qdb"
+	"github.com/piotrnar/gocoin/lib/others/sys"
+	"github.com/piotrnar/gocoin/lib/others/utils"
+)
+
+/*
+The address manager keeps track of the addresses from PeerDB in two tables,
+the way Bitcoin Core's addrman does:
+ - "new" table - addresses that we have heard of, but never connected to.
+ The bucket depends on the netgroup of the source (the peer that sent us
+ the address), so a single source can only fill a limited number of buckets.
+ - "tried" table - addresses that we have successfully connected to.
+ The bucket depends on the netgroup of the address itself.
+
+PeerDB stays the only persistent storage. The tables only keep the record
+keys and are rebuilt from PeerDB at startup. The bucket positions are salted
+with a secret key, so that an attacker cannot predict them.
+*/
+
+const (
+	NEW_BUCKET_COUNT        = 1024
+	TRIED_BUCKET_COUNT      = 256
+	BUCKET_SIZE             = 64
+	NEW_BUCKETS_PER_SRC_GRP = 64
+	TRIED_BUCKETS_PER_GROUP = 8
+	MAX_NEW_PER_SOURCE      = 1024 // do not keep more "new" addresses from a single source netgroup
+	MAX_SELECT_TRIES        = 1000
+	TRIED_REPLACEMENT_TIME  = 4 * time.Hour // a tried address that connected within this time does not get replaced
+	ADDR_HORIZON            = 30 * 24 * time.Hour
+	ADDR_RETRIES            = 3  // after how many failed attempts a never connected address is terrible
+	ADDR_MAX_FAILURES       = 10 // after how many failed attempts in ADDR_MIN_FAIL an address is terrible
+	ADDR_MIN_FAIL           = 7 * 24 * time.Hour
+	ADDRMAN_KEY_FILE        = "addrman.key"
+)
+
+type am_info struct {
+	tried  bool
+	bucket uint16
+	pos    uint16
+	src    string // netgroup of the source
+}
+
+type AddrMan struct {
+	sync.Mutex
+	key   [32]byte
+	new   [NEW_BUCKET_COUNT][BUCKET_SIZE]uint64
+	tried [TRIED_BUCKET_COUNT][BUCKET_SIZE]uint64
+	info  map[uint64]*am_info
+
+	src_cnt   map[string]int // number of "new" addresses per source netgroup
+	new_cnt   int
+	tried_cnt int
+}
+
+var addrman *AddrMan
+
+// NewAddrMan returns an empty address manager using the given secret key.
+func NewAddrMan(key [32]byte) (am *AddrMan) {
+	am = new(AddrMan)
+	am.key = key
+	am.info = make(map[uint64]*am_info)
+	am.src_cnt = make(map[string]int)
+	return
+}
+
+// Group returns the netgroup of the address:
+// /16 for IPv4, /32 for IPv6 and the first 4 bits for TorV3, I2P and CJDNS.
+func Group(na *btc.NetAddr) (res [utils.SOURCE_GROUP_LEN]byte) {
+	switch netid := na.NetworkID(); netid {
+	case btc.NET_IPV4:
+		if sys.ValidIp4(na.Ip4[:]) {
+			res[0] = netid
+			copy(res[1:3], na.Ip4[:2])
+		} // else: all the local and unroutable addresses are in the same group
+	case btc.NET_IPV6:
+		res[0] = netid
+		copy(res[1:5], na.Ip6[:4])
+	default:
+		res[0] = netid
+		res[1] = na.Ext[0] >> 4
+	}
+	return
+}
+
+func addr_key(p *PeerAddr) []byte {
+	return binary.BigEndian.AppendUint16(p.AddrBytes(), p.Port)
+}
+
+func (am *AddrMan) hash(data ...[]byte) uint64 {
+	sha := sha256.New()
+	sha.Write(am.key[:])
+	for _, d := range data {
+		sha.Write(d)
+	}
+	return binary.LittleEndian.Uint64(sha.Sum(nil)[:8])
+}
+
+func u64(v uint64) []byte {
+	return binary.LittleEndian.AppendUint64(nil, v)
+}
+
+// NewBucket returns the "new" table bucket for the address learned from the given source netgroup.
+func (am *AddrMan) NewBucket(p *PeerAddr, src []byte) int {
+	grp := Group(&p.NetAddr)
+	h1 := am.hash(grp[:], src) % NEW_BUCKETS_PER_SRC_GRP
+	return int(am.hash(src, u64(h1)) % NEW_BUCKET_COUNT)
+}
+
+// TriedBucket returns the "tried" table bucket for the address.
+func (am *AddrMan) TriedBucket(p *PeerAddr) int {
+	grp := Group(&p.NetAddr)
+	h1 := am.hash(addr_key(p)) % TRIED_BUCKETS_PER_GROUP
+	return int(am.hash(grp[:], u64(h1)) % TRIED_BUCKET_COUNT)
+}
+
+// BucketPos returns the position of the address within the given bucket.
+func (am *AddrMan) BucketPos(p *PeerAddr, tried bool, bucket int) int {
+	tab := []byte{'N'}
+	if tried {
+		tab[0] = 'K'
+	}
+	return int(am.hash(tab, u64(uint64(bucket)), addr_key(p)) % BUCKET_SIZE)
+}
+
+// IsTerrible tells if the address is not worth keeping.
+func IsTerrible(p *PeerAddr, now time.Time) bool {
+	if now.Sub(time.Unix(int64(p.LastTry), 0)) < time.Minute {
+		return false // never remove things tried in the last minute
+	}
+	if int64(p.Time) > now.Add(10*time.Minute).Unix() {
+		return true // came in a flying DeLorean
+	}
+	if now.Sub(time.Unix(int64(p.Time), 0)) > ADDR_HORIZON {
+		return true // not seen in recent history
+	}
+	if p.LastSuccess == 0 && p.Attempts >= ADDR_RETRIES {
+		return true // tried N times and never a success
+	}
+	if now.Sub(time.Unix(int64(p.LastSuccess), 0)) > ADDR_MIN_FAIL && p.Attempts >= ADDR_MAX_FAILURES {
+		return true // N successive failures in the last week
+	}
+	return false
+}
+
+// chance returns the relative chance of the address to be selected for a connection.
+func chance(p *PeerAddr, now time.Time) (res float64) {
+	res = 1.0
+	if now.Sub(time.Unix(int64(p.LastTry), 0)) < 10*time.Minute {
+		res *= 0.01 // deprioritize very recent attempts
+	}
+	att := p.Attempts
+	if att > 8 {
+		att = 8
+	}
+	return res * math.Pow(0.66, float64(att))
+}
+
+func get_peer(id uint64) *PeerAddr {
+	if v := PeerDB.Get(qdb.KeyType(id)); v != nil {
+		if p := NewPeer(v); p.OnePeer != nil {
+			return p
+		}
+	}
+	return nil
+}
+
+func (am *AddrMan) del_entry(id uint64) {
+	if inf := am.info[id]; inf != nil {
+		if inf.tried {
+			am.tried[inf.bucket][inf.pos] = 0
+			am.tried_cnt--
+		} else {
+			am.new[inf.bucket][inf.pos] = 0
+			am.new_cnt--
+			if am.src_cnt[inf.src]--; am.src_cnt[inf.src] <= 0 {
+				delete(am.src_cnt, inf.src)
+			}
+		}
+		delete(am.info, id)
+	}
+}
+
+// add_new puts the address into the "new" table.
+// If the slot is taken by a terrible address, the old one gets removed from PeerDB.
+func (am *AddrMan) add_new(p *PeerAddr, now time.Time) bool {
+	id := p.UniqID()
+	if am.info[id] != nil {
+		return true
+	}
+	src := string(p.Source[:])
+	if am.src_cnt[src] >= MAX_NEW_PER_SOURCE {
+		return false
+	}
+	bucket := am.NewBucket(p, p.Source[:])
+	pos := am.BucketPos(p, false, bucket)
+	if old := am.new[bucket][pos]; old != 0 {
+		if op := get_peer(old); op != nil && !IsTerrible(op, now) {
+			return false
+		}
+		am.del_entry(old)
+		PeerDB.Del(qdb.KeyType(old))
+	}
+	am.new[bucket][pos] = id
+	am.info[id] = &am_info{bucket: uint16(bucket), pos: uint16(pos), src: src}
+	am.src_cnt[src]++
+	am.new_cnt++
+	return true
+}
+
+// add_tried puts the address into the "tried" table.
+// In case of a collision, the old entry stays if it has connected recently.
+// Otherwise it is moved back to the "new" table.
+func (am *AddrMan) add_tried(p *PeerAddr, now time.Time) bool {
+	id := p.UniqID()
+	if inf := am.info[id]; inf != nil && inf.tried {
+		return true
+	}
+	bucket := am.TriedBucket(p)
+	pos := am.BucketPos(p, true, bucket)
+	if old := am.tried[bucket][pos]; old != 0 {
+		op := get_peer(old)
+		if op != nil && now.Sub(time.Unix(int64(op.LastSuccess), 0)) < TRIED_REPLACEMENT_TIME {
+			return false
+		}
+		am.del_entry(id)
+		am.del_entry(old)
+		if op != nil {
+			am.add_new(op, now)
+		}
+	} else {
+		am.del_entry(id)
+	}
+	am.tried[bucket][pos] = id
+	am.info[id] = &am_info{tried: true, bucket: uint16(bucket), pos: uint16(pos), src: string(p.Source[:])}
+	am.tried_cnt++
+	return true
+}
+
+// Add puts the address into the proper table.
+// It returns false if there was no space for it.
+func (am *AddrMan) Add(p *PeerAddr) bool {
+	am.Lock()
+	defer am.Unlock()
+	if p.Banned != 0 {
+		am.del_entry(p.UniqID())
+		return false
+	}
+	if p.LastSuccess != 0 && am.add_tried(p, time.Now()) {
+		return true
+	}
+	return am.add_new(p, time.Now())
+}
+
+// Has tells if the address is in any of the tables.
+func (am *AddrMan) Has(id uint64) (ok bool) {
+	am.Lock()
+	_, ok = am.info[id]
+	am.Unlock()
+	return
+}
+
+// Remove removes the address from the tables (but not from PeerDB).
+func (am *AddrMan) Remove(id uint64) {
+	am.Lock()
+	am.del_entry(id)
+	am.Unlock()
+}
+
+// Counts returns the number of addresses in the "new" and the "tried" table.
+func (am *AddrMan) Counts() (new_cnt, tried_cnt int) {
+	am.Lock()
+	new_cnt, tried_cnt = am.new_cnt, am.tried_cnt
+	am.Unlock()
+	return
+}
+
+// Select picks a random address from the tables, using the chance factor of each address.
+// Both the tables are used with an equal probability, unless new_only is set.
+// Addresses for which the accept function returns false are skipped.
+func (am *AddrMan) Select(new_only bool, accept func(*PeerAddr) bool) *PeerAddr {
+	am.Lock()
+	defer am.Unlock()
+	now := time.Now()
+	chance_factor := 1.0
+	for try := 0; try < MAX_SELECT_TRIES; try++ {
+		var id uint64
+		if am.new_cnt == 0 && (new_only || am.tried_cnt == 0) {
+			return nil
+		}
+		if !new_only && am.tried_cnt > 0 && (am.new_cnt == 0 || mr.Intn(2) == 0) {
+			id = pick(am.tried[:])
+		} else {
+			id = pick(am.new[:])
+		}
+		if id == 0 {
+			continue
+		}
+		p := get_peer(id)
+		if p == nil {
+			am.del_entry(id)
+			continue
+		}
+		if p.Banned != 0 || accept != nil && !accept(p) {
+			continue
+		}
+		if mr.Float64() < chance_factor*chance(p, now) {
+			return p
+		}
+		chance_factor *= 1.2
+	}
+	return nil
+}
+
+// pick returns an address from a random non-empty slot of the table (each one with the same probability).
+// Like Core, it keeps trying random slots till it finds one, so call it only if the table is not empty.
+func pick(tab [][BUCKET_SIZE]uint64) uint64 {
+	for i := 0; i < 8*len(tab)*BUCKET_SIZE; i++ {
+		if id := tab[mr.Intn(len(tab))][mr.Intn(BUCKET_SIZE)]; id != 0 {
+			return id
+		}
+	}
+	return 0 // should not happen, unless the counters are wrong
+}
+
+// load_addrman fills the tables with the records from PeerDB.
+// The tried addresses go first, then the most recently seen ones.
+func load_addrman(am *AddrMan) {
+	var recs manyPeers
+	PeerDB.Browse(func(k qdb.KeyType, v []byte) uint32 {
+		if p := NewPeer(v); p.OnePeer != nil && p.Banned == 0 {
+			recs = append(recs, p)
+		}
+		return 0
+	})
+	sort.Slice(recs, func(i, j int) bool {
+		if (recs[i].LastSuccess != 0) != (recs[j].LastSuccess != 0) {
+			return recs[i].LastSuccess != 0
+		}
+		return recs[i].Time > recs[j].Time
+	})
+	for _, p := range recs {
+		if p.Source == [utils.SOURCE_GROUP_LEN]byte{} {
+			p.Source = Group(&p.NetAddr) // records from before the addrman did not have it
+		}
+		am.Add(p)
+	}
+}
+
+// addrman_key reads the secret key from the file in the given directory, or creates a new one.
+func addrman_key(dir string) (key [32]byte) {
+	if d, er := ioutil.ReadFile(dir + ADDRMAN_KEY_FILE); er == nil && len(d) == len(key) {
+		copy(key[:], d)
+		return
+	}
+	rand.Read(key[:])
+	if er := ioutil.WriteFile(dir+ADDRMAN_KEY_FILE, key[:], 0600); er != nil {
+		println("addrman_key:", er.Error())
+	}
+	return
+}
+
+// AddAddr stores the address received from the given source peer.
+// It returns false if the address did not fit into the "new" table.
+func AddAddr(p *PeerAddr, source *btc.NetAddr) bool {
+	id := p.UniqID()
+	if v := PeerDB.Get(qdb.KeyType(id)); v != nil {
+		op := NewPeer(v)
+		if op.OnePeer == nil {
+			return false
+		}
+		if op.Banned == 0 && p.Time > op.Time {
+			op.Time = p.Time
+			op.Services = p.Services
+			PeerDB.Put(qdb.KeyType(id), op.Bytes())
+		}
+		return true
+	}
+	if source != nil {
+		p.Source = Group(source)
+	} else {
+		p.Source = Group(&p.NetAddr)
+	}
+	if addrman != nil && !addrman.Add(p) {
+		return false
+	}
+	PeerDB.Put(qdb.KeyType(id), p.Bytes())
+	return true
+}
+
+// SelectPeer picks a random peer to connect to.
+// Peers from netgroups for which the skip function returns true are not considered.
+// If new_only is set, it only selects the peers that we have never connected to.
+func SelectPeer(new_only bool, skip func(*PeerAddr) bool) *PeerAddr {
+	if proxyPeer != nil {
+		if new_only || skip != nil && skip(proxyPeer) {
+			return nil
+		}
+		return proxyPeer
+	}
+	if addrman == nil {
+		return nil
+	}
+	return addrman.Select(new_only, func(ad *PeerAddr) bool {
+		return can_connect(ad) && (skip == nil || !skip(ad))
+	})
+}
+
+// AddrManStats returns the number of addresses in the "new" and the "tried" table.
+func AddrManStats() (new_cnt, tried_cnt int) {
+	if addrman != nil {
+		new_cnt, tried_cnt = addrman.Counts()
+	}
+	return
+}
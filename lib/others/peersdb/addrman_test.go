@@ -0,0 +1,280 @@
+package peersdb
+
+import (
+	"testing"
+	"time"
+
+	"github.com/piotrnar/gocoin/lib/btc"
+	"github.com/piotrnar/gocoin/lib/others/qdb"
+)
+
+var test_key = [32]byte{1, 2, 3, 4, 5, 6, 7, 8, 9, 10}
+
+func test_peer(a, b, c, d byte) (p *PeerAddr) {
+	p = NewEmptyPeer()
+	p.SetAddr(btc.NET_IPV4, []byte{a, b, c, d})
+	p.Port = 8333
+	return
+}
+
+func test_addrman(t *testing.T) {
+	PeerDB, _ = qdb.NewDB(t.TempDir()+"/peers4", true)
+	addrman = NewAddrMan(test_key)
+	t.Cleanup(func() {
+		PeerDB.Close()
+		addrman = nil
+	})
+}
+
+func TestAddrManGroup(t *testing.T) {
+	if Group(&test_peer(1, 2, 3, 4).NetAddr) != [5]byte{btc.NET_IPV4, 1, 2} {
+		t.Error("Bad IPv4 group")
+	}
+	if Group(&test_peer(1, 2, 3, 4).NetAddr) != Group(&test_peer(1, 2, 200, 100).NetAddr) {
+		t.Error("Same /16 in different groups")
+	}
+	if Group(&test_peer(1, 2, 3, 4).NetAddr) == Group(&test_peer(1, 3, 3, 4).NetAddr) {
+		t.Error("Different /16 in the same group")
+	}
+	if Group(&test_peer(10, 2, 3, 4).NetAddr) != Group(&test_peer(192, 168, 3, 4).NetAddr) {
+		t.Error("Local addresses in different groups")
+	}
+	p := NewEmptyPeer()
+	p.SetAddr(btc.NET_IPV6, []byte{0x20, 0x01, 0x0d, 0xb8, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12})
+	if Group(&p.NetAddr) != [5]byte{btc.NET_IPV6, 0x20, 0x01, 0x0d, 0xb8} {
+		t.Error("Bad IPv6 group")
+	}
+	p, _ = NewAddrFromString("pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion", false)
+	if g := Group(&p.NetAddr); g[0] != btc.NET_TORV3 || g[1] != p.Ext[0]>>4 || g[2] != 0 {
+		t.Error("Bad TorV3 group", g)
+	}
+}
+
+func TestAddrManBuckets(t *testing.T) {
+	am := NewAddrMan(test_key)
+	p := test_peer(1, 2, 3, 4)
+	src := Group(&test_peer(5, 6, 7, 8).NetAddr)
+
+	// the same key must always give the same results
+	nb, tb := am.NewBucket(p, src[:]), am.TriedBucket(p)
+	if nb != 758 || tb != 242 {
+		t.Error("Unexpected buckets", nb, tb)
+	}
+	if np, tp := am.BucketPos(p, false, nb), am.BucketPos(p, true, tb); np != 37 || tp != 5 {
+		t.Error("Unexpected bucket positions", np, tp)
+	}
+
+	// ... and a different key - different results
+	am2 := NewAddrMan([32]byte{9})
+	if am2.NewBucket(p, src[:]) == nb && am2.TriedBucket(p) == tb {
+		t.Error("Buckets do not depend on the key")
+	}
+
+	// addresses from a single source group can only go to a limited number of "new" buckets
+	buckets := make(map[int]bool)
+	for i := 0; i < 4096; i++ {
+		buckets[am.NewBucket(test_peer(byte(i>>8)+1, byte(i), 1, 1), src[:])] = true
+	}
+	if len(buckets) > NEW_BUCKETS_PER_SRC_GRP {
+		t.Error("Too many new buckets for one source group", len(buckets))
+	}
+
+	// ... while many sources use (almost) all of them
+	buckets = make(map[int]bool)
+	for i := 0; i < 4096; i++ {
+		s := Group(&test_peer(byte(i>>8)+1, byte(i), 1, 1).NetAddr)
+		buckets[am.NewBucket(p, s[:])] = true
+	}
+	if len(buckets) < NEW_BUCKET_COUNT*9/10 {
+		t.Error("Too few new buckets for many source groups", len(buckets))
+	}
+
+	// addresses from a single group can only go to a limited number of "tried" buckets
+	buckets = make(map[int]bool)
+	for i := 0; i < 4096; i++ {
+		buckets[am.TriedBucket(test_peer(1, 2, byte(i>>8), byte(i)))] = true
+	}
+	if len(buckets) > TRIED_BUCKETS_PER_GROUP {
+		t.Error("Too many tried buckets for one group", len(buckets))
+	}
+}
+
+func TestAddrManSourceLimit(t *testing.T) {
+	test_addrman(t)
+	src := &test_peer(5, 6, 7, 8).NetAddr
+	var added int
+	for i := 0; i < 3*MAX_NEW_PER_SOURCE; i++ {
+		if AddAddr(test_peer(byte(i>>8)+1, byte(i), 1, 1), src) {
+			added++
+		}
+	}
+	if nc, tc := AddrManStats(); nc != added || tc != 0 || nc > MAX_NEW_PER_SOURCE {
+		t.Error("Bad number of addresses", nc, tc, added)
+	}
+	if PeerDB.Count() != added {
+		t.Error("Rejected addresses stored in the database", PeerDB.Count(), added)
+	}
+
+	// a different source can still add its addresses
+	if !AddAddr(test_peer(100, 1, 1, 1), &test_peer(9, 9, 9, 9).NetAddr) {
+		t.Error("Address from another source rejected")
+	}
+}
+
+// find_collision returns two addresses sharing the same slot in the given table.
+func find_collision(am *AddrMan, tried bool, src []byte) (a, b *PeerAddr) {
+	slots := make(map[int]*PeerAddr)
+	for i := 0; ; i++ {
+		p := test_peer(1, 2, byte(i>>8), byte(i))
+		var bucket int
+		if tried {
+			bucket = am.TriedBucket(p)
+		} else {
+			bucket = am.NewBucket(p, src)
+		}
+		slot := bucket*BUCKET_SIZE + am.BucketPos(p, tried, bucket)
+		if o := slots[slot]; o != nil {
+			return o, p
+		}
+		slots[slot] = p
+	}
+}
+
+func TestAddrManNewCollision(t *testing.T) {
+	test_addrman(t)
+	src := &test_peer(5, 6, 7, 8).NetAddr
+	sg := Group(src)
+	a, b := find_collision(addrman, false, sg[:])
+	if !AddAddr(a, src) {
+		t.Fatal("First address not added")
+	}
+	if AddAddr(b, src) {
+		t.Error("Colliding address replaced a good one")
+	}
+
+	// make the old one terrible
+	a.Attempts = ADDR_RETRIES
+	a.LastTry = uint32(time.Now().Add(-time.Hour).Unix())
+	PeerDB.Put(qdb.KeyType(a.UniqID()), a.Bytes())
+	if !AddAddr(b, src) {
+		t.Error("Colliding address did not replace a terrible one")
+	}
+	if addrman.Has(a.UniqID()) || PeerDB.Get(qdb.KeyType(a.UniqID())) != nil {
+		t.Error("Terrible address not removed")
+	}
+}
+
+func TestAddrManTriedCollision(t *testing.T) {
+	test_addrman(t)
+	a, b := find_collision(addrman, true, nil)
+	a.Save()
+	b.Save()
+	a.Connected()
+	b.Connected()
+	if nc, tc := AddrManStats(); nc != 1 || tc != 1 {
+		t.Fatal("Recently connected address got replaced", nc, tc)
+	}
+	if inf := addrman.info[a.UniqID()]; inf == nil || !inf.tried {
+		t.Error("Old address not in the tried table")
+	}
+
+	// the old one did not connect for a long time - it goes back to the new table
+	a.LastSuccess = uint32(time.Now().Add(-2 * TRIED_REPLACEMENT_TIME).Unix())
+	a.Save()
+	b.Connected()
+	if inf := addrman.info[b.UniqID()]; inf == nil || !inf.tried {
+		t.Error("New address not in the tried table")
+	}
+	if inf := addrman.info[a.UniqID()]; inf == nil || inf.tried {
+		t.Error("Old address not moved to the new table")
+	}
+}
+
+func TestAddrManSelect(t *testing.T) {
+	test_addrman(t)
+	if SelectPeer(false, nil) != nil {
+		t.Error("Peer selected from empty tables")
+	}
+	for i := 0; i < 16; i++ {
+		AddAddr(test_peer(1, 2, 1, byte(i)), &test_peer(1, 2, 3, 4).NetAddr)
+		AddAddr(test_peer(3, 4, 1, byte(i)), &test_peer(1, 2, 3, 4).NetAddr)
+	}
+	test_peer(5, 6, 7, 8).Connected()
+
+	// the caller keeps one outgoing connection per netgroup
+	skip := map[[5]byte]bool{Group(&test_peer(1, 2, 0, 0).NetAddr): true}
+	groups := make(map[[5]byte]bool)
+	for i := 0; i < 100; i++ {
+		p := SelectPeer(false, func(ad *PeerAddr) bool { return skip[Group(&ad.NetAddr)] })
+		if p == nil {
+			t.Fatal("No peer selected")
+		}
+		groups[Group(&p.NetAddr)] = true
+	}
+	if len(groups) != 2 || groups[Group(&test_peer(1, 2, 0, 0).NetAddr)] {
+		t.Error("Bad netgroups selected", groups)
+	}
+
+	// only the new table
+	for i := 0; i < 100; i++ {
+		if p := SelectPeer(true, nil); p == nil || p.LastSuccess != 0 {
+			t.Fatal("Tried peer selected from the new table")
+		}
+	}
+}
+
+func TestAddrManLoad(t *testing.T) {
+	test_addrman(t)
+	for i := 0; i < 100; i++ {
+		AddAddr(test_peer(byte(i)+1, 1, 1, 1), &test_peer(byte(i)+1, 1, 1, 1).NetAddr)
+	}
+	for i := 0; i < 10; i++ {
+		test_peer(byte(i)+1, 1, 1, 1).Connected()
+	}
+	test_peer(200, 1, 1, 1).Ban()
+	nc, tc := AddrManStats()
+
+	am := NewAddrMan(test_key)
+	load_addrman(am)
+	if am.new_cnt != nc || am.tried_cnt != tc || nc != 90 || tc != 10 {
+		t.Error("Tables not rebuilt", am.new_cnt, am.tried_cnt, nc, tc)
+	}
+	for id, inf := range addrman.info {
+		if i2 := am.info[id]; i2 == nil || *i2 != *inf {
+			t.Error("Entry mismatch", id)
+		}
+	}
+}
+
+func TestAddrManLoadNoSource(t *testing.T) {
+	test_addrman(t)
+	for i := 0; i < 1100; i++ {
+		p := test_peer(byte(i>>8)+1, byte(i), 1, 1)
+		PeerDB.Put(qdb.KeyType(p.UniqID()), p.Bytes()) // no source, like the old records
+	}
+	am := NewAddrMan(test_key)
+	load_addrman(am)
+	if am.new_cnt <= MAX_NEW_PER_SOURCE {
+		t.Error("Old records limited as if they came from one source", am.new_cnt)
+	}
+	for id, inf := range am.info {
+		p := NewPeer(PeerDB.Get(qdb.KeyType(id)))
+		if grp := Group(&p.NetAddr); inf.src != string(grp[:]) {
+			t.Error("Bad source of the old record", p.Ip())
+		}
+	}
+}
+
+func TestAddrManPick(t *testing.T) {
+	tab := make([][BUCKET_SIZE]uint64, 4)
+	tab[0][0], tab[0][1], tab[3][BUCKET_SIZE-1] = 1, 2, 3
+	cnt := make(map[uint64]int)
+	for i := 0; i < 3000; i++ {
+		cnt[pick(tab)]++
+	}
+	for id := uint64(1); id <= 3; id++ {
+		if cnt[id] < 800 || cnt[id] > 1200 {
+			t.Error("Address", id, "picked", cnt[id], "times out of 3000")
+		}
+	}
+}
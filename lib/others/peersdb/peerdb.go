@@ -11,6 +11,7 @@ import (
 	"strings"
 	"strconv"
 	"encoding/binary"
+	"github.com/piotrnar/gocoin/lib/btc"
 	"github.com/piotrnar/gocoin/lib/others/qdb"
 	"github.com/piotrnar/gocoin/lib/others/sys"
 	"github.com/piotrnar/gocoin/lib/others/utils"
@@ -29,6 +30,10 @@ var (
 	Testnet bool
 	ConnectOnly string
 	Services uint64 = 1
+
+	UseOnion bool // GetBestPeers returns onion peers as well (we connect through a proxy)
+	OnlyOnion bool // GetBestPeers returns only onion peers
+	NoDnsSeeds bool // do not query DNS seeds (so not to leak the requests outside of the proxy)
 )
 
 type PeerAddr struct {
@@ -79,6 +84,16 @@ func NewAddrFromString(ipstr string, force_default_port bool) (p *PeerAddr, e er
 		}
 		ipstr = ipstr[:x] // remove port number
 	}
+	if netid, addr, er := btc.ParseNonIPHost(ipstr); er != nil {
+		e = errors.New("peerdb.NewAddrFromString(" + ipstr + ") - " + er.Error())
+		return
+	} else if netid != 0 {
+		p = NewEmptyPeer()
+		p.Services = Services
+		p.Port = port
+		p.SetAddr(netid, addr)
+		return
+	}
 	ipa, er := net.ResolveIPAddr("ip", ipstr)
 	if er == nil {
 		if ipa !=nil {
@@ -140,6 +155,9 @@ func ExpirePeers() {
 		for delcnt > 0 && PeerDB.Count() > MinPeersInDB {
 			delcnt--
 			PeerDB.Del(todel[delcnt])
+			if addrman != nil {
+				addrman.Remove(uint64(todel[delcnt]))
+			}
 		}
 		PeerDB.Defrag(false)
 	}
@@ -151,8 +169,18 @@ func (p *PeerAddr) Save() {
 	if p.Time > 0x80000000 {
 		println("saving dupa", int32(p.Time), p.Ip())
 	}
+	if p.Source == [utils.SOURCE_GROUP_LEN]byte{} {
+		p.Source = Group(&p.NetAddr) // the peer is its own source
+	}
 	PeerDB.Put(qdb.KeyType(p.UniqID()), p.Bytes())
 	PeerDB.Sync()
+	if addrman != nil {
+		if p.Banned != 0 {
+			addrman.Remove(p.UniqID())
+		} else if !addrman.Has(p.UniqID()) {
+			addrman.Add(p)
+		}
+	}
 }
 
 
@@ -178,7 +206,33 @@ func (p *PeerAddr) Dead() {
 }
 
 
+// Attempt records an outgoing connection attempt.
+func (p *PeerAddr) Attempt() {
+	p.LastTry = uint32(time.Now().Unix())
+	if p.Attempts < 255 {
+		p.Attempts++
+	}
+	p.Save()
+}
+
+
+// Connected records a successful outgoing connection (the version handshake).
+// It also moves the address to the "tried" table of the address manager.
+func (p *PeerAddr) Connected() {
+	p.Time = uint32(time.Now().Unix())
+	p.LastSuccess = p.Time
+	p.Attempts = 0
+	p.Save()
+	if addrman != nil && p.Banned == 0 {
+		addrman.Add(p)
+	}
+}
+
+
 func (p *PeerAddr) Ip() (string) {
+	if p.NetID != 0 {
+		return p.NetAddr.String()
+	}
 	return fmt.Sprintf("%d.%d.%d.%d:%d", p.Ip4[0], p.Ip4[1], p.Ip4[2], p.Ip4[3], p.Port)
 }
 
@@ -219,14 +273,41 @@ func GetBestPeers(limit uint, isConnected func(*PeerAddr)bool) (res manyPeers) {
 		}
 		return manyPeers{}
 	}
+	return get_peers(limit, func(ad *PeerAddr) bool {
+		return can_connect(ad) && (isConnected==nil || !isConnected(ad))
+	})
+}
+
+
+// can_connect checks if we are able to connect to the peer.
+func can_connect(ad *PeerAddr) bool {
+	if ad.NetID == btc.NET_TORV3 {
+		return UseOnion
+	}
+	return !OnlyOnion && sys.ValidIp4(ad.Ip4[:]) && !sys.IsIPBlocked(ad.Ip4[:])
+}
+
+
+// GetAddrPeers fetches the best peers to be advertised in addr/addrv2 message.
+// Addresses of BIP155 networks (TorV3, I2P, CJDNS) are only returned if addrv2 is true.
+func GetAddrPeers(limit uint, addrv2 bool) (res manyPeers) {
+	return get_peers(limit, func(ad *PeerAddr) bool {
+		if ad.NetID != 0 {
+			return addrv2
+		}
+		return sys.ValidIp4(ad.Ip4[:]) && !sys.IsIPBlocked(ad.Ip4[:])
+	})
+}
+
+
+// get_peers returns the most recently seen peers accepted by the given function.
+func get_peers(limit uint, accept func(*PeerAddr)bool) (res manyPeers) {
 	peerdb_mutex.Lock()
 	tmp := make(manyPeers, 0)
 	PeerDB.Browse(func(k qdb.KeyType, v []byte) uint32 {
 		ad := NewPeer(v)
-		if ad.Banned==0 && sys.ValidIp4(ad.Ip4[:]) && !sys.IsIPBlocked(ad.Ip4[:]) {
-			if isConnected==nil || !isConnected(ad) {
-				tmp = append(tmp, ad)
-			}
+		if ad.OnePeer!=nil && ad.Banned==0 && accept(ad) {
+			tmp = append(tmp, ad)
 		}
 		return 0
 	})
@@ -266,9 +347,39 @@ func initSeeds(seeds []string, port uint16) {
 }
 
 
+// migratePeers moves the records from the legacy (IPv4/IPv6 only) peers3 database.
+func migratePeers(dir string) {
+	if fi, er := os.Stat(dir+"peers3"); er != nil || !fi.IsDir() {
+		return
+	}
+	old, er := qdb.NewDB(dir+"peers3", true)
+	if er != nil {
+		println("migratePeers:", er.Error())
+		return
+	}
+	var cnt int
+	old.Browse(func(k qdb.KeyType, v []byte) uint32 {
+		if p := utils.NewPeer(v); p != nil {
+			PeerDB.Put(qdb.KeyType(p.UniqID()), p.Bytes())
+			cnt++
+		}
+		return 0
+	})
+	old.Close()
+	PeerDB.Sync()
+	os.RemoveAll(dir+"peers3")
+	fmt.Println(cnt, "peers migrated to the new database format")
+}
+
+
 // InitPeers should be called from the main thread.
 func InitPeers(dir string) {
-	PeerDB, _ = qdb.NewDB(dir+"peers3", true)
+	PeerDB, _ = qdb.NewDB(dir+"peers4", true)
+	if PeerDB.Count() == 0 {
+		migratePeers(dir)
+	}
+	addrman = NewAddrMan(addrman_key(dir))
+	load_addrman(addrman)
 
 	if ConnectOnly != "" {
 		x := strings.Index(ConnectOnly, ":")
@@ -286,7 +397,7 @@ func InitPeers(dir string) {
 		proxyPeer.Port = uint16(oa.Port)
 		fmt.Printf("Connect to bitcoin network via %d.%d.%d.%d:%d\n",
 			proxyPeer.Ip4[0], proxyPeer.Ip4[1], proxyPeer.Ip4[2], proxyPeer.Ip4[3], proxyPeer.Port)
-	} else {
+	} else if !NoDnsSeeds {
 		go func() {
 			if !Testnet {
 				initSeeds([]string{
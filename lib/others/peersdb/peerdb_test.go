@@ -1,7 +1,11 @@
 package peersdb
 
 import (
+	"os"
 	"testing"
+
+	"github.com/piotrnar/gocoin/lib/others/qdb"
+	"github.com/piotrnar/gocoin/lib/others/utils"
 )
 
 func test_one_addr(t *testing.T, host string, ip [4]byte, port uint16) {
@@ -84,3 +88,90 @@ func TestNewAddrFromString(t *testing.T) {
 		println("error expected")
 	}
 }
+
+func TestPeerRecord(t *testing.T) {
+	p, e := NewAddrFromString("pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion:9333", false)
+	if e != nil {
+		t.Fatal(e.Error())
+	}
+	if p.Ip() != "pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion:9333" {
+		t.Error("Bad onion address", p.Ip())
+	}
+	p.Banned = 12345
+	p2 := NewPeer(p.Bytes())
+	if *p2.OnePeer != *p.OnePeer || p2.UniqID() != p.UniqID() {
+		t.Error("TorV3 record mismatch")
+	}
+
+	p, _ = NewAddrFromString("1.2.3.4", false)
+	p2 = NewPeer(p.Bytes())
+	if p2.Ip4 != p.Ip4 || p2.Port != p.Port || p2.UniqID() != p.UniqID() {
+		t.Error("IPv4 record mismatch")
+	}
+
+	PeerDB, _ = qdb.NewDB(t.TempDir()+"/peers4", true)
+	defer PeerDB.Close()
+	p.Attempt()
+	p.Attempt()
+	if p.Attempts != 2 || p.LastTry == 0 || p.LastSuccess != 0 {
+		t.Error("Bad connection attempts", p.Attempts, p.LastTry, p.LastSuccess)
+	}
+	p2 = NewPeer(p.Bytes())
+	if *p2.OnePeer != *p.OnePeer {
+		t.Error("Connection results not stored")
+	}
+	p.Connected()
+	if p.Attempts != 0 || p.LastSuccess == 0 {
+		t.Error("Bad connection success", p.Attempts, p.LastSuccess)
+	}
+
+	// record without the connection results
+	rec := p.Bytes()
+	p2 = NewPeer(rec[:len(rec)-utils.PEER_REC_EXT_SIZE])
+	if p2 == nil || p2.Ip4 != p.Ip4 || p2.LastTry != 0 || p2.LastSuccess != 0 {
+		t.Error("Short record not decoded")
+	}
+}
+
+func TestMigratePeers(t *testing.T) {
+	dir, e := os.MkdirTemp("", "peersdb")
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer os.RemoveAll(dir)
+	dir += "/"
+
+	// legacy records: IPv4 mapped, not banned, and plain IPv4 banned
+	recs := [][]byte{
+		{1, 0, 0, 0, 1, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0xff, 0xff, 1, 2, 3, 4, 0x20, 0x8d},
+		{2, 0, 0, 0, 9, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 5, 6, 7, 8, 0x20, 0x8d, 3, 0, 0, 0},
+	}
+	old, _ := qdb.NewDB(dir+"peers3", true)
+	var keys []qdb.KeyType
+	for _, v := range recs {
+		p := NewPeer(v)
+		keys = append(keys, qdb.KeyType(p.UniqID()))
+		old.Put(keys[len(keys)-1], v)
+	}
+	old.Close()
+
+	PeerDB, _ = qdb.NewDB(dir+"peers4", true)
+	defer PeerDB.Close()
+	migratePeers(dir)
+	if PeerDB.Count() != len(recs) {
+		t.Fatal("Bad number of migrated peers", PeerDB.Count())
+	}
+	for i, k := range keys {
+		v := PeerDB.Get(k)
+		if v == nil {
+			t.Fatal("Peer", i, "not migrated")
+		}
+		p, lp := NewPeer(v), NewPeer(recs[i])
+		if p.Time != lp.Time || p.Services != lp.Services || p.Banned != lp.Banned || p.Ip() != lp.Ip() {
+			t.Error("Peer", i, "mismatch", p.String(), lp.String())
+		}
+	}
+	if _, e = os.Stat(dir + "peers3"); e == nil {
+		t.Error("Legacy database not removed")
+	}
+}
@@ -0,0 +1,170 @@
+// Package socks5 implements the client side of SOCKS5 CONNECT command (RFC 1928)
+// with the optional username/password authentication (RFC 1929).
+package socks5
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"net"
+	"strconv"
+	"time"
+)
+
+const (
+	VERSION = 5
+
+	AUTH_NONE     = 0
+	AUTH_PASSWORD = 2
+	AUTH_NO_MATCH = 0xff
+
+	CMD_CONNECT = 1
+
+	ATYP_IPV4   = 1
+	ATYP_DOMAIN = 3
+	ATYP_IPV6   = 4
+)
+
+var reply_errors = []string{
+	"succeeded",
+	"general SOCKS server failure",
+	"connection not allowed by ruleset",
+	"network unreachable",
+	"host unreachable",
+	"connection refused",
+	"TTL expired",
+	"command not supported",
+	"address type not supported",
+}
+
+// Auth holds the credentials for the proxy.
+// With Tor each different credentials get a separate circuit (stream isolation).
+type Auth struct {
+	User, Password string
+}
+
+// RandomAuth returns new random credentials, for stream isolation.
+func RandomAuth() *Auth {
+	var b [8]byte
+	rand.Read(b[:])
+	return &Auth{User: hex.EncodeToString(b[:4]), Password: hex.EncodeToString(b[4:])}
+}
+
+// Dial connects to addr ("host:port") through the SOCKS5 proxy.
+// Host names (e.g. onion addresses) are resolved by the proxy.
+func Dial(proxy, addr string, auth *Auth, timeout time.Duration) (conn net.Conn, e error) {
+	host, port_str, e := net.SplitHostPort(addr)
+	if e != nil {
+		return
+	}
+	port, e := strconv.ParseUint(port_str, 10, 16)
+	if e != nil {
+		return
+	}
+	if len(host) > 255 {
+		e = errors.New("socks5: host name too long")
+		return
+	}
+
+	if conn, e = net.DialTimeout("tcp", proxy, timeout); e != nil {
+		return
+	}
+	conn.SetDeadline(time.Now().Add(timeout))
+	if e = handshake(conn, host, uint16(port), auth); e != nil {
+		conn.Close()
+		conn = nil
+		return
+	}
+	conn.SetDeadline(time.Time{})
+	return
+}
+
+func handshake(conn net.Conn, host string, port uint16, auth *Auth) (e error) {
+	var buf [2]byte
+
+	method := byte(AUTH_NONE)
+	if auth != nil {
+		method = AUTH_PASSWORD
+	}
+	if _, e = conn.Write([]byte{VERSION, 1, method}); e != nil {
+		return
+	}
+	if _, e = io.ReadFull(conn, buf[:]); e != nil {
+		return
+	}
+	if buf[0] != VERSION {
+		return errors.New("socks5: unexpected protocol version")
+	}
+	if buf[1] != method {
+		return errors.New("socks5: authentication method not accepted")
+	}
+
+	if auth != nil {
+		if len(auth.User) > 255 || len(auth.Password) > 255 {
+			return errors.New("socks5: credentials too long")
+		}
+		req := []byte{1, byte(len(auth.User))}
+		req = append(req, auth.User...)
+		req = append(req, byte(len(auth.Password)))
+		req = append(req, auth.Password...)
+		if _, e = conn.Write(req); e != nil {
+			return
+		}
+		if _, e = io.ReadFull(conn, buf[:]); e != nil {
+			return
+		}
+		if buf[1] != 0 {
+			return errors.New("socks5: authentication failed")
+		}
+	}
+
+	req := []byte{VERSION, CMD_CONNECT, 0}
+	if ip := net.ParseIP(host); ip == nil {
+		req = append(req, ATYP_DOMAIN, byte(len(host)))
+		req = append(req, host...)
+	} else if ip4 := ip.To4(); ip4 != nil {
+		req = append(req, ATYP_IPV4)
+		req = append(req, ip4...)
+	} else {
+		req = append(req, ATYP_IPV6)
+		req = append(req, ip...)
+	}
+	req = binary.BigEndian.AppendUint16(req, port)
+	if _, e = conn.Write(req); e != nil {
+		return
+	}
+
+	var rep [4]byte
+	if _, e = io.ReadFull(conn, rep[:]); e != nil {
+		return
+	}
+	if rep[0] != VERSION {
+		return errors.New("socks5: unexpected protocol version")
+	}
+	if rep[1] != 0 {
+		if int(rep[1]) < len(reply_errors) {
+			return errors.New("socks5: " + reply_errors[rep[1]])
+		}
+		return errors.New("socks5: unknown error " + strconv.Itoa(int(rep[1])))
+	}
+
+	// skip the bound address
+	var le int
+	switch rep[3] {
+	case ATYP_IPV4:
+		le = 4
+	case ATYP_IPV6:
+		le = 16
+	case ATYP_DOMAIN:
+		if _, e = io.ReadFull(conn, buf[:1]); e != nil {
+			return
+		}
+		le = int(buf[0])
+	default:
+		return errors.New("socks5: unexpected address type")
+	}
+	_, e = io.ReadFull(conn, make([]byte, le+2))
+	return
+}
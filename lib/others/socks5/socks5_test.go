@@ -0,0 +1,150 @@
+package socks5
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// stub_server accepts one connection, performs the SOCKS5 handshake and echoes the data.
+// It reports the requested destination and credentials.
+type stub_server struct {
+	lis     net.Listener
+	dest    chan string
+	auth    chan string
+	reply   byte
+	methods []byte // methods accepted by the proxy
+}
+
+func new_stub(t *testing.T, reply byte, methods ...byte) (s *stub_server) {
+	lis, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	s = &stub_server{lis: lis, dest: make(chan string, 1), auth: make(chan string, 1), reply: reply, methods: methods}
+	go s.serve()
+	return
+}
+
+func (s *stub_server) serve() {
+	conn, e := s.lis.Accept()
+	if e != nil {
+		return
+	}
+	defer conn.Close()
+
+	var hdr [2]byte
+	if _, e = io.ReadFull(conn, hdr[:]); e != nil || hdr[0] != VERSION {
+		return
+	}
+	methods := make([]byte, hdr[1])
+	io.ReadFull(conn, methods)
+	method := byte(AUTH_NO_MATCH)
+	for _, m := range methods {
+		if bytes.IndexByte(s.methods, m) != -1 {
+			method = m
+		}
+	}
+	conn.Write([]byte{VERSION, method})
+	if method == AUTH_NO_MATCH {
+		return
+	}
+
+	if method == AUTH_PASSWORD {
+		io.ReadFull(conn, hdr[:])
+		user := make([]byte, hdr[1])
+		io.ReadFull(conn, user)
+		io.ReadFull(conn, hdr[:1])
+		pass := make([]byte, hdr[0])
+		io.ReadFull(conn, pass)
+		s.auth <- string(user) + ":" + string(pass)
+		conn.Write([]byte{1, 0})
+	}
+
+	var req [5]byte
+	io.ReadFull(conn, req[:])
+	var host string
+	switch req[3] {
+	case ATYP_IPV4:
+		ip := make([]byte, 4)
+		ip[0] = req[4]
+		io.ReadFull(conn, ip[1:])
+		host = net.IP(ip).String()
+	case ATYP_DOMAIN:
+		name := make([]byte, req[4])
+		io.ReadFull(conn, name)
+		host = string(name)
+	}
+	var port [2]byte
+	io.ReadFull(conn, port[:])
+	s.dest <- net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port[:]))))
+
+	conn.Write([]byte{VERSION, s.reply, 0, ATYP_DOMAIN, 3, 'a', 'b', 'c', 0, 0})
+	if s.reply == 0 {
+		io.Copy(conn, conn)
+	}
+}
+
+func TestDialDomain(t *testing.T) {
+	s := new_stub(t, 0, AUTH_PASSWORD)
+	defer s.lis.Close()
+
+	const onion = "pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd.onion"
+	conn, e := Dial(s.lis.Addr().String(), onion+":8333", &Auth{User: "usr", Password: "pwd"}, time.Second)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer conn.Close()
+	if d := <-s.dest; d != onion+":8333" {
+		t.Error("Bad destination", d)
+	}
+	if a := <-s.auth; a != "usr:pwd" {
+		t.Error("Bad credentials", a)
+	}
+
+	conn.Write([]byte("hello"))
+	var buf [5]byte
+	if _, e = io.ReadFull(conn, buf[:]); e != nil || string(buf[:]) != "hello" {
+		t.Error("Echo failed", e, string(buf[:]))
+	}
+}
+
+func TestDialIPv4(t *testing.T) {
+	s := new_stub(t, 0, AUTH_NONE)
+	defer s.lis.Close()
+
+	conn, e := Dial(s.lis.Addr().String(), "1.2.3.4:18444", nil, time.Second)
+	if e != nil {
+		t.Fatal(e)
+	}
+	conn.Close()
+	if d := <-s.dest; d != "1.2.3.4:18444" {
+		t.Error("Bad destination", d)
+	}
+}
+
+func TestDialErrors(t *testing.T) {
+	s := new_stub(t, 5, AUTH_NONE)
+	_, e := Dial(s.lis.Addr().String(), "1.2.3.4:8333", nil, time.Second)
+	s.lis.Close()
+	if e == nil || !strings.Contains(e.Error(), "connection refused") {
+		t.Error("Connection refused expected", e)
+	}
+
+	s = new_stub(t, 0, AUTH_NONE)
+	_, e = Dial(s.lis.Addr().String(), "1.2.3.4:8333", RandomAuth(), time.Second)
+	s.lis.Close()
+	if e == nil {
+		t.Error("Authentication method error expected")
+	}
+
+	a1, a2 := RandomAuth(), RandomAuth()
+	if *a1 == *a2 {
+		t.Error("RandomAuth returned the same credentials twice")
+	}
+}
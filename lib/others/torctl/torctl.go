@@ -0,0 +1,231 @@
+// Package torctl talks to Tor's control port (see Tor's control-spec.txt),
+// to publish an onion service.
+package torctl
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	SAFECOOKIE_SERVER_KEY = "Tor safe cookie authentication server-to-controller hash"
+	SAFECOOKIE_CLIENT_KEY = "Tor safe cookie authentication controller-to-server hash"
+)
+
+type Conn struct {
+	conn net.Conn
+	rd   *bufio.Reader
+}
+
+// Dial connects to the control port.
+func Dial(addr string, timeout time.Duration) (c *Conn, e error) {
+	conn, e := net.DialTimeout("tcp", addr, timeout)
+	if e != nil {
+		return
+	}
+	c = &Conn{conn: conn, rd: bufio.NewReader(conn)}
+	return
+}
+
+// Command sends the command and returns the lines of a successful (250) reply,
+// without the status code.
+func (c *Conn) Command(cmd string) (lines []string, e error) {
+	if _, e = c.conn.Write([]byte(cmd + "\r\n")); e != nil {
+		return
+	}
+	for {
+		var line string
+		if line, e = c.rd.ReadString('\n'); e != nil {
+			return
+		}
+		line = strings.TrimRight(line, "\r\n")
+		if len(line) < 4 {
+			e = errors.New("torctl: reply too short")
+			return
+		}
+		if line[:3] != "250" {
+			e = errors.New("torctl: " + line)
+			return
+		}
+		lines = append(lines, line[4:])
+		if line[3] == ' ' {
+			return
+		}
+		// data replies (250+) are not supported, as we do not use the commands that return them
+	}
+}
+
+// Close closes the connection. Onion services added without Detach flag are removed by Tor.
+func (c *Conn) Close() {
+	c.conn.Close()
+}
+
+// Wait blocks until the control connection is closed.
+func (c *Conn) Wait() {
+	var buf [256]byte
+	for {
+		if _, e := c.conn.Read(buf[:]); e != nil {
+			return
+		}
+	}
+}
+
+// reply_values parses "KEY=VALUE KEY2="QUOTED VALUE"" pairs.
+func reply_values(line string) (res map[string]string) {
+	res = make(map[string]string)
+	for len(line) > 0 {
+		line = strings.TrimLeft(line, " ")
+		eq := strings.IndexByte(line, '=')
+		if eq == -1 {
+			break
+		}
+		key := line[:eq]
+		line = line[eq+1:]
+		if strings.HasPrefix(line, "\"") {
+			val, rest, ok := unquote(line)
+			if !ok {
+				break
+			}
+			res[key], line = val, rest
+		} else {
+			sp := strings.IndexByte(line, ' ')
+			if sp == -1 {
+				sp = len(line)
+			}
+			res[key], line = line[:sp], line[sp:]
+		}
+	}
+	return
+}
+
+func unquote(s string) (val, rest string, ok bool) {
+	var esc bool
+	for i := 1; i < len(s); i++ {
+		if esc {
+			esc = false
+		} else if s[i] == '\\' {
+			esc = true
+		} else if s[i] == '"' {
+			v, e := strconv.Unquote(s[:i+1])
+			return v, s[i+1:], e == nil
+		}
+	}
+	return
+}
+
+// Authenticate uses the password, if given. Otherwise it tries cookie or no authentication,
+// depending on what the controller offers.
+func (c *Conn) Authenticate(password string) (e error) {
+	lines, e := c.Command("PROTOCOLINFO 1")
+	if e != nil {
+		return
+	}
+	var methods, cookie_file string
+	for _, l := range lines {
+		if strings.HasPrefix(l, "AUTH ") {
+			vals := reply_values(l[5:])
+			methods, cookie_file = vals["METHODS"], vals["COOKIEFILE"]
+		}
+	}
+	has := func(m string) bool {
+		for _, s := range strings.Split(methods, ",") {
+			if s == m {
+				return true
+			}
+		}
+		return false
+	}
+
+	switch {
+	case password != "":
+		if !has("HASHEDPASSWORD") {
+			return errors.New("torctl: password authentication not supported by Tor")
+		}
+		_, e = c.Command("AUTHENTICATE " + strconv.Quote(password))
+
+	case has("NULL"):
+		_, e = c.Command("AUTHENTICATE")
+
+	case has("SAFECOOKIE") || has("COOKIE"):
+		var cookie []byte
+		if cookie, e = ioutil.ReadFile(cookie_file); e != nil {
+			return
+		}
+		if len(cookie) != 32 {
+			return errors.New("torctl: unexpected cookie length")
+		}
+		if has("SAFECOOKIE") {
+			e = c.safecookie(cookie)
+		} else {
+			_, e = c.Command("AUTHENTICATE " + hex.EncodeToString(cookie))
+		}
+
+	default:
+		e = errors.New("torctl: no supported authentication method in " + methods)
+	}
+	return
+}
+
+func safecookie_hash(key string, cookie, client_nonce, server_nonce []byte) []byte {
+	h := hmac.New(sha256.New, []byte(key))
+	h.Write(cookie)
+	h.Write(client_nonce)
+	h.Write(server_nonce)
+	return h.Sum(nil)
+}
+
+func (c *Conn) safecookie(cookie []byte) (e error) {
+	client_nonce := make([]byte, 32)
+	rand.Read(client_nonce)
+	lines, e := c.Command("AUTHCHALLENGE SAFECOOKIE " + hex.EncodeToString(client_nonce))
+	if e != nil {
+		return
+	}
+	if !strings.HasPrefix(lines[0], "AUTHCHALLENGE ") {
+		return errors.New("torctl: unexpected AUTHCHALLENGE reply")
+	}
+	vals := reply_values(lines[0][14:])
+	server_hash, _ := hex.DecodeString(vals["SERVERHASH"])
+	server_nonce, _ := hex.DecodeString(vals["SERVERNONCE"])
+	if !hmac.Equal(server_hash, safecookie_hash(SAFECOOKIE_SERVER_KEY, cookie, client_nonce, server_nonce)) {
+		return errors.New("torctl: SERVERHASH mismatch")
+	}
+	_, e = c.Command("AUTHENTICATE " + hex.EncodeToString(safecookie_hash(SAFECOOKIE_CLIENT_KEY, cookie, client_nonce, server_nonce)))
+	return
+}
+
+// AddOnion publishes the onion service, forwarding virt_port to target ("host:port").
+// If the key is empty, a new ED25519-V3 key is generated and returned (to be used next time).
+// The service exists as long as the control connection is open.
+func (c *Conn) AddOnion(key string, virt_port uint16, target string) (service_id, private_key string, e error) {
+	if key == "" {
+		key = "NEW:ED25519-V3"
+	} else {
+		private_key = key
+	}
+	lines, e := c.Command(fmt.Sprintf("ADD_ONION %s Port=%d,%s", key, virt_port, target))
+	if e != nil {
+		return
+	}
+	for _, l := range lines {
+		if strings.HasPrefix(l, "ServiceID=") {
+			service_id = l[10:]
+		} else if strings.HasPrefix(l, "PrivateKey=") {
+			private_key = l[11:]
+		}
+	}
+	if service_id == "" {
+		e = errors.New("torctl: no ServiceID in ADD_ONION reply")
+	}
+	return
+}
@@ -0,0 +1,166 @@
+package torctl
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+const test_service_id = "pg6mmjiyjmcrsslvykfwnntlaru7p5svn6y2ymmju6nubxndf4pscryd"
+
+// stub_control serves one connection, like Tor's control port would.
+type stub_control struct {
+	lis         net.Listener
+	methods     string
+	cookie_file string
+	cookie      []byte
+	password    string
+	add_onion   chan string
+}
+
+func new_stub(t *testing.T, methods string) (s *stub_control) {
+	lis, e := net.Listen("tcp", "127.0.0.1:0")
+	if e != nil {
+		t.Fatal(e)
+	}
+	s = &stub_control{lis: lis, methods: methods, password: "secret", add_onion: make(chan string, 1)}
+	s.cookie = bytes.Repeat([]byte{0xc0}, 32)
+	s.cookie_file = filepath.Join(t.TempDir(), "control_auth_cookie")
+	ioutil.WriteFile(s.cookie_file, s.cookie, 0600)
+	go s.serve()
+	return
+}
+
+func (s *stub_control) serve() {
+	conn, e := s.lis.Accept()
+	if e != nil {
+		return
+	}
+	defer conn.Close()
+	var authenticated bool
+	var client_nonce, server_nonce []byte
+	rd := bufio.NewReader(conn)
+	for {
+		line, e := rd.ReadString('\n')
+		if e != nil {
+			return
+		}
+		cmd := strings.TrimRight(line, "\r\n")
+		switch {
+		case cmd == "PROTOCOLINFO 1":
+			fmt.Fprintf(conn, "250-PROTOCOLINFO 1\r\n250-AUTH METHODS=%s COOKIEFILE=%s\r\n250-VERSION Tor=\"0.4.8.9\"\r\n250 OK\r\n",
+				s.methods, strconv.Quote(s.cookie_file))
+
+		case strings.HasPrefix(cmd, "AUTHCHALLENGE SAFECOOKIE "):
+			client_nonce, _ = hex.DecodeString(cmd[25:])
+			server_nonce = bytes.Repeat([]byte{0x5e}, 32)
+			fmt.Fprintf(conn, "250 AUTHCHALLENGE SERVERHASH=%x SERVERNONCE=%x\r\n",
+				safecookie_hash(SAFECOOKIE_SERVER_KEY, s.cookie, client_nonce, server_nonce), server_nonce)
+
+		case strings.HasPrefix(cmd, "AUTHENTICATE"):
+			arg := strings.TrimPrefix(cmd, "AUTHENTICATE")
+			switch {
+			case strings.Contains(s.methods, "NULL"):
+				authenticated = true
+			case strings.Contains(s.methods, "HASHEDPASSWORD") && arg == " "+strconv.Quote(s.password):
+				authenticated = true
+			case strings.Contains(s.methods, "SAFECOOKIE") && server_nonce != nil:
+				authenticated = arg == " "+hex.EncodeToString(safecookie_hash(SAFECOOKIE_CLIENT_KEY, s.cookie, client_nonce, server_nonce))
+			case strings.Contains(s.methods, "COOKIE"):
+				authenticated = arg == " "+hex.EncodeToString(s.cookie)
+			}
+			if authenticated {
+				conn.Write([]byte("250 OK\r\n"))
+			} else {
+				conn.Write([]byte("515 Authentication failed\r\n"))
+				return
+			}
+
+		case strings.HasPrefix(cmd, "ADD_ONION "):
+			if !authenticated {
+				conn.Write([]byte("514 Authentication required.\r\n"))
+				return
+			}
+			s.add_onion <- cmd
+			if strings.HasPrefix(cmd, "ADD_ONION NEW:") {
+				fmt.Fprintf(conn, "250-ServiceID=%s\r\n250-PrivateKey=ED25519-V3:a2V5\r\n250 OK\r\n", test_service_id)
+			} else {
+				fmt.Fprintf(conn, "250-ServiceID=%s\r\n250 OK\r\n", test_service_id)
+			}
+
+		default:
+			conn.Write([]byte("510 Unrecognized command\r\n"))
+		}
+	}
+}
+
+func test_auth(t *testing.T, methods, password string, ok bool) {
+	s := new_stub(t, methods)
+	defer s.lis.Close()
+	c, e := Dial(s.lis.Addr().String(), time.Second)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer c.Close()
+	e = c.Authenticate(password)
+	if ok != (e == nil) {
+		t.Error(methods, "unexpected authentication result", e)
+	}
+}
+
+func TestAuthenticate(t *testing.T) {
+	test_auth(t, "NULL", "", true)
+	test_auth(t, "COOKIE", "", true)
+	test_auth(t, "COOKIE,SAFECOOKIE", "", true)
+	test_auth(t, "HASHEDPASSWORD", "secret", true)
+	test_auth(t, "HASHEDPASSWORD", "wrong", false)
+	test_auth(t, "HASHEDPASSWORD", "", false)
+	test_auth(t, "COOKIE,SAFECOOKIE", "secret", false)
+}
+
+func TestAddOnion(t *testing.T) {
+	s := new_stub(t, "COOKIE,SAFECOOKIE")
+	defer s.lis.Close()
+	c, e := Dial(s.lis.Addr().String(), time.Second)
+	if e != nil {
+		t.Fatal(e)
+	}
+	defer c.Close()
+	if e = c.Authenticate(""); e != nil {
+		t.Fatal(e)
+	}
+
+	id, key, e := c.AddOnion("", 8333, "127.0.0.1:8333")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if cmd := <-s.add_onion; cmd != "ADD_ONION NEW:ED25519-V3 Port=8333,127.0.0.1:8333" {
+		t.Error("Bad command", cmd)
+	}
+	if id != test_service_id || key != "ED25519-V3:a2V5" {
+		t.Error("Bad reply", id, key)
+	}
+
+	id, key, e = c.AddOnion(key, 18333, "127.0.0.1:18333")
+	if e != nil {
+		t.Fatal(e)
+	}
+	if cmd := <-s.add_onion; cmd != "ADD_ONION ED25519-V3:a2V5 Port=18333,127.0.0.1:18333" {
+		t.Error("Bad command", cmd)
+	}
+	if id != test_service_id || key != "ED25519-V3:a2V5" {
+		t.Error("Bad reply with the existing key", id, key)
+	}
+
+	if _, e = c.Command("GETINFO version"); e == nil || !strings.Contains(e.Error(), "510") {
+		t.Error("Error expected", e)
+	}
+}
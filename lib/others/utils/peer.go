@@ -10,6 +10,13 @@ type OnePeer struct {
 	btc.NetAddr
 	Time uint32  // When seen last time
 	Banned uint32 // time when this address baned or zero if never
+
+	// Results of our outgoing connections:
+	LastTry uint32 // when we tried to connect last time
+	LastSuccess uint32 // when the handshake succeeded last time (zero if never)
+	Attempts uint8 // failed attempts since the last success
+
+	Source [SOURCE_GROUP_LEN]byte // netgroup of the peer that told us about this address (see peersdb.Group)
 }
 
 
@@ -18,6 +25,19 @@ var crctab = crc64.MakeTable(crc64.ISO)
 
 /*
 Serialized peer record (all values are LSB unless specified otherwise):
+ [0:4] - Unix timestamp of when last the peer was seen
+ [4:12] - Services
+ [12:16] - Unix timestamp of when the peer was banned (zero if never)
+ [16:18] - TCP port (big endian)
+ [18] - BIP155 network ID
+ [19:19+N] - The address (network order) - N is 4, 16 or 32 bytes, depending on the network
+ OPTIONAL (not present in older records):
+ [19+N:23+N] - Unix timestamp of the last connection attempt
+ [23+N:27+N] - Unix timestamp of the last successful connection
+ [27+N] - Number of failed connection attempts since the last success
+ [28+N:33+N] - Netgroup of the address' source (may not be present either)
+
+Legacy record (peers3 database) - IPv4/IPv6 only:
  [0:4] - Unix timestamp of when last the peer was seen
  [4:12] - Services
  [12:24] - IPv6 (network order)
@@ -26,12 +46,45 @@ Serialized peer record (all values are LSB unless specified otherwise):
  [30:34] - OPTIONAL: if present, unix timestamp of when the peer was banned
 */
 
+const (
+	PEER_REC_HDR_SIZE = 19
+	PEER_REC_EXT_SIZE = 14 // the optional fields after the address
+	SOURCE_GROUP_LEN = 5
+)
+
 
+// NewPeer decodes the peer record. It accepts the legacy format as well.
 func NewPeer(v []byte) (p *OnePeer) {
-	if len(v) < 30 {
+	if len(v) == 30 || len(v) == 34 {
+		return newPeerLegacy(v)
+	}
+	if len(v) < PEER_REC_HDR_SIZE {
 		println("NewPeer: unexpected length", len(v))
 		return
 	}
+	p = new(OnePeer)
+	p.Time = binary.LittleEndian.Uint32(v[0:4])
+	p.Services = binary.LittleEndian.Uint64(v[4:12])
+	p.Banned = binary.LittleEndian.Uint32(v[12:16])
+	p.Port = binary.BigEndian.Uint16(v[16:18])
+	addr := v[PEER_REC_HDR_SIZE:]
+	if alen := btc.AddrV2Len(v[18]); len(addr) == alen+PEER_REC_EXT_SIZE || len(addr) == alen+PEER_REC_EXT_SIZE-SOURCE_GROUP_LEN {
+		ext := addr[alen:]
+		p.LastTry = binary.LittleEndian.Uint32(ext[0:4])
+		p.LastSuccess = binary.LittleEndian.Uint32(ext[4:8])
+		p.Attempts = ext[8]
+		copy(p.Source[:], ext[9:])
+		addr = addr[:alen]
+	}
+	if e := p.SetAddr(v[18], addr); e != nil {
+		println("NewPeer:", e.Error())
+		return nil
+	}
+	return
+}
+
+
+func newPeerLegacy(v []byte) (p *OnePeer) {
 	p = new(OnePeer)
 	p.Time = binary.LittleEndian.Uint32(v[0:4])
 	p.Services = binary.LittleEndian.Uint64(v[4:12])
@@ -46,25 +99,36 @@ func NewPeer(v []byte) (p *OnePeer) {
 
 
 func (p *OnePeer) Bytes() (res []byte) {
-	if p.Banned != 0 {
-		res = make([]byte, 34)
-		binary.LittleEndian.PutUint32(res[30:34], p.Banned)
-	} else {
-		res = make([]byte, 30)
-	}
+	addr := p.AddrBytes()
+	res = make([]byte, PEER_REC_HDR_SIZE+len(addr)+PEER_REC_EXT_SIZE)
 	binary.LittleEndian.PutUint32(res[0:4], p.Time)
 	binary.LittleEndian.PutUint64(res[4:12], p.Services)
-	copy(res[12:24], p.Ip6[:])
-	copy(res[24:28], p.Ip4[:])
-	binary.BigEndian.PutUint16(res[28:30], p.Port)
+	binary.LittleEndian.PutUint32(res[12:16], p.Banned)
+	binary.BigEndian.PutUint16(res[16:18], p.Port)
+	res[18] = p.NetworkID()
+	copy(res[PEER_REC_HDR_SIZE:], addr)
+	ext := res[PEER_REC_HDR_SIZE+len(addr):]
+	binary.LittleEndian.PutUint32(ext[0:4], p.LastTry)
+	binary.LittleEndian.PutUint32(ext[4:8], p.LastSuccess)
+	ext[8] = p.Attempts
+	copy(ext[9:], p.Source[:])
 	return
 }
 
 
 func (p *OnePeer) UniqID() (uint64) {
 	h := crc64.New(crctab)
-	h.Write(p.Ip6[:])
-	h.Write(p.Ip4[:])
+	switch p.NetworkID() {
+	case btc.NET_IPV4:
+		h.Write(btc.IPV4_PREFIX[:])
+		h.Write(p.Ip4[:])
+	case btc.NET_IPV6:
+		h.Write(p.Ip6[:])
+		h.Write(p.Ip4[:])
+	default:
+		h.Write([]byte{p.NetID})
+		h.Write(p.AddrBytes())
+	}
 	h.Write([]byte{byte(p.Port>>8),byte(p.Port)})
 	return h.Sum64()
 }
@@ -24,6 +24,10 @@ func IsP2WSH(scr []byte) bool {
 	return len(scr) == 34 && scr[0] == 0 && scr[1] == 32
 }
 
+func IsP2TR(scr []byte) bool {
+	return len(scr) == 34 && scr[0] == btc.OP_1 && scr[1] == 32
+}
+
 func IsP2PK(scr []byte) (bool, []byte) {
 	if len(scr) == 35 && scr[0] == 33 && scr[34] == 0xac && (scr[1] == 0x02 || scr[1] == 0x03) {
 		return true, scr[1:34]
@@ -41,11 +41,15 @@ const (
 	VER_NULLFAIL = 1<<14
 	VER_WITNESS_PUBKEY = 1 << 15 // WITNESS_PUBKEYTYPE
 	VER_CONST_SCRIPTCODE = 1 << 16
+	VER_TAPROOT = 1 << 17 // Taproot/Tapscript validation (BIPs 341 & 342)
+	VER_DIS_TAPVER = 1 << 18 // DISCOURAGE_UPGRADABLE_TAPROOT_VERSION
+	VER_DIS_SUCCESS = 1 << 19 // DISCOURAGE_OP_SUCCESS
+	VER_DIS_PUBKEYTYPE = 1 << 20 // DISCOURAGE_UPGRADABLE_PUBKEYTYPE
 
 	STANDARD_VERIFY_FLAGS = VER_P2SH | VER_STRICTENC | VER_DERSIG | VER_LOW_S |
 		VER_NULLDUMMY | VER_MINDATA | VER_BLOCK_OPS | VER_CLEANSTACK | VER_CLTV | VER_CSV |
 		VER_WITNESS | VER_WITNESS_PROG | VER_MINIMALIF | VER_NULLFAIL | VER_WITNESS_PUBKEY |
-		VER_CONST_SCRIPTCODE
+		VER_CONST_SCRIPTCODE | VER_TAPROOT | VER_DIS_TAPVER | VER_DIS_SUCCESS | VER_DIS_PUBKEYTYPE
 
 	LOCKTIME_THRESHOLD = 500000000
 	SEQUENCE_LOCKTIME_DISABLE_FLAG = 1<<31
@@ -55,6 +59,8 @@ const (
 
 	SIGVERSION_BASE = 0
 	SIGVERSION_WITNESS_V0 = 1
+	SIGVERSION_TAPROOT = 2 // Witness v1 with 32-byte program, not BIP16 P2SH-wrapped, key path spending
+	SIGVERSION_TAPSCRIPT = 3 // Witness v1 with 32-byte program, not BIP16 P2SH-wrapped, script path spending, leaf version 0xc0
 )
 
 
@@ -83,7 +89,7 @@ func VerifyTxScript(pkScr []byte, amount uint64, i int, tx *btc.Tx, ver_flags ui
 	}
 
 	var stack, stackCopy scrStack
-	if !evalScript(sigScr, amount, &stack, tx, i, ver_flags, SIGVERSION_BASE) {
+	if !evalScript(sigScr, amount, &stack, tx, i, ver_flags, SIGVERSION_BASE, nil) {
 		if DBG_ERR {
 			if tx != nil {
 				fmt.Println("VerifyTxScript", tx.Hash.String(), i+1, "/", len(tx.TxIn))
@@ -104,7 +110,7 @@ func VerifyTxScript(pkScr []byte, amount uint64, i int, tx *btc.Tx, ver_flags ui
 		stackCopy.copy_from(&stack)
 	}
 
-	if !evalScript(pkScr, amount, &stack, tx, i, ver_flags, SIGVERSION_BASE) {
+	if !evalScript(pkScr, amount, &stack, tx, i, ver_flags, SIGVERSION_BASE, nil) {
 		if DBG_SCR {
 			fmt.Println("* pkScript failed :", hex.EncodeToString(pkScr[:]))
 			fmt.Println("* VerifyTxScript", tx.Hash.String(), i+1, "/", len(tx.TxIn))
@@ -152,7 +158,7 @@ func VerifyTxScript(pkScr []byte, amount uint64, i int, tx *btc.Tx, ver_flags ui
 				}
 				return
 			}
-			if !VerifyWitnessProgram(&witness, amount, tx, i, witnessversion, witnessprogram, ver_flags) {
+			if !VerifyWitnessProgram(&witness, amount, tx, i, witnessversion, witnessprogram, ver_flags, false) {
 				if DBG_ERR {
 					fmt.Println("VerifyWitnessProgram failed A")
 				}
@@ -199,7 +205,7 @@ func VerifyTxScript(pkScr []byte, amount uint64, i int, tx *btc.Tx, ver_flags ui
 			fmt.Println("pubKey2:", hex.EncodeToString(pubKey2))
 		}
 
-		if !evalScript(pubKey2, amount, &stack, tx, i, ver_flags, SIGVERSION_BASE) {
+		if !evalScript(pubKey2, amount, &stack, tx, i, ver_flags, SIGVERSION_BASE, nil) {
 			if DBG_ERR {
 				fmt.Println("P2SH extra verification failed")
 			}
@@ -238,7 +244,7 @@ func VerifyTxScript(pkScr []byte, amount uint64, i int, tx *btc.Tx, ver_flags ui
 					}
 					return
 				}
-				if !VerifyWitnessProgram(&witness, amount, tx, i, witnessversion, witnessprogram, ver_flags) {
+				if !VerifyWitnessProgram(&witness, amount, tx, i, witnessversion, witnessprogram, ver_flags, true) {
 					if DBG_ERR {
 						fmt.Println("VerifyWitnessProgram failed B")
 					}
@@ -293,14 +299,17 @@ func b2i(b bool) int64 {
 	}
 }
 
-func evalScript(p []byte, amount uint64, stack *scrStack, tx *btc.Tx, inp int, ver_flags uint32, sigversion int) bool {
+// execdata must be set for SIGVERSION_TAPSCRIPT
+func evalScript(p []byte, amount uint64, stack *scrStack, tx *btc.Tx, inp int, ver_flags uint32, sigversion int,
+	execdata *btc.ScriptExecutionData) bool {
 	if DBG_SCR {
 		fmt.Println("evalScript len", len(p), "amount", amount, "inp", inp, "flagz", ver_flags, "sigver", sigversion)
 		stack.print()
 	}
 
+	tapscript := sigversion == SIGVERSION_TAPSCRIPT
 
-	if len(p) > MAX_SCRIPT_SIZE {
+	if !tapscript && len(p) > MAX_SCRIPT_SIZE {
 		if DBG_ERR {
 			fmt.Println("script too long", len(p))
 		}
@@ -322,9 +331,13 @@ func evalScript(p []byte, amount uint64, stack *scrStack, tx *btc.Tx, inp int, v
 
 	var exestack scrStack
 	var altstack scrStack
+	var opcode_pos uint32
 	sta, idx, opcnt := 0, 0, 0
 	checkMinVals := (ver_flags&VER_MINDATA)!=0
-	for idx < len(p) {
+	if execdata != nil {
+		execdata.CodeSeparatorPos = 0xffffffff
+	}
+	for ; idx < len(p); opcode_pos++ {
 		inexec := exestack.nofalse()
 
 		// Read instruction
@@ -349,7 +362,7 @@ func evalScript(p []byte, amount uint64, stack *scrStack, tx *btc.Tx, inp int, v
 			return false
 		}
 
-		if opcode > 0x60 {
+		if opcode > 0x60 && !tapscript {
 			opcnt++
 			if opcnt > 201 {
 				if DBG_ERR {
@@ -424,6 +437,17 @@ func evalScript(p []byte, amount uint64, stack *scrStack, tx *btc.Tx, inp int, v
 							return false
 						}
 						vch := stack.pop()
+						if tapscript {
+							// The input argument to the OP_IF and OP_NOTIF opcodes must be either
+							// exactly 0 (the empty vector) or exactly 1 (the one-byte vector with value 1).
+							if len(vch)>1 || len(vch)==1 && vch[0]!=1 {
+								if DBG_ERR {
+									fmt.Println("SCRIPT_ERR_TAPSCRIPT_MINIMALIF")
+								}
+								return false
+							}
+						}
+						// Under witness v0 rules it is only a policy rule, enabled through VER_MINIMALIF.
 						if sigversion==SIGVERSION_WITNESS_V0 && (ver_flags&VER_MINIMALIF)!=0 {
 							if len(vch)>1 {
 								if DBG_ERR {
@@ -924,6 +948,9 @@ func evalScript(p []byte, amount uint64, stack *scrStack, tx *btc.Tx, inp int, v
 
 				case opcode==0xab: // OP_CODESEPARATOR
 					sta = idx
+					if execdata != nil {
+						execdata.CodeSeparatorPos = opcode_pos
+					}
 
 				case opcode==0xac || opcode==0xad: // OP_CHECKSIG || OP_CHECKSIGVERIFY
 
@@ -937,6 +964,23 @@ func evalScript(p []byte, amount uint64, stack *scrStack, tx *btc.Tx, inp int, v
 					vchSig := stack.top(-2)
 					vchPubKey := stack.top(-1)
 
+					if tapscript {
+						var ok bool
+						if ok, fSuccess = evalChecksigTapscript(vchSig, vchPubKey, tx, inp, ver_flags, execdata); !ok {
+							return false
+						}
+						stack.pop()
+						stack.pop()
+						if opcode==0xad {
+							if !fSuccess { // OP_CHECKSIGVERIFY
+								return false
+							}
+						} else { // OP_CHECKSIG
+							stack.pushBool(fSuccess)
+						}
+						break
+					}
+
 					scriptCode := p[sta:]
 
 					// Drop the signature in pre-segwit scripts but not segwit scripts
@@ -1007,6 +1051,12 @@ func evalScript(p []byte, amount uint64, stack *scrStack, tx *btc.Tx, inp int, v
 				case opcode==0xae || opcode==0xaf: //OP_CHECKMULTISIG || OP_CHECKMULTISIGVERIFY
 					//fmt.Println("OP_CHECKMULTISIG ...")
 					//stack.print()
+					if tapscript {
+						if DBG_ERR {
+							fmt.Println("SCRIPT_ERR_TAPSCRIPT_CHECKMULTISIG")
+						}
+						return false
+					}
 					if stack.size()<1 {
 						if DBG_ERR {
 							fmt.Println("OP_CHECKMULTISIG: Stack too short A")
@@ -1268,6 +1318,35 @@ func evalScript(p []byte, amount uint64, stack *scrStack, tx *btc.Tx, inp int, v
 						return false
 					}
 
+				case opcode==0xba: // OP_CHECKSIGADD
+					// OP_CHECKSIGADD is only available in Tapscript
+					if !tapscript {
+						if DBG_ERR {
+							fmt.Println("OP_CHECKSIGADD outside of tapscript")
+						}
+						return false
+					}
+
+					// (sig num pubkey -- num)
+					if stack.size()<3 {
+						if DBG_ERR {
+							fmt.Println("Stack too short for opcode", opcode)
+						}
+						return false
+					}
+					sig := stack.top(-3)
+					num := stack.topInt(-2, checkMinVals)
+					pubkey := stack.top(-1)
+
+					ok, success := evalChecksigTapscript(sig, pubkey, tx, inp, ver_flags, execdata)
+					if !ok {
+						return false
+					}
+					stack.pop()
+					stack.pop()
+					stack.pop()
+					stack.pushInt(num + b2i(success))
+
 				case opcode==0xb0 || opcode>=0xb3 && opcode<=0xb9: //OP_NOP1 || OP_NOP4..OP_NOP10
 					if (ver_flags&VER_BLOCK_OPS)!=0 {
 						return false
@@ -206,6 +206,14 @@ func decode_flags(s string) (fl uint32, e error) {
 				fl |= VER_WITNESS_PUBKEY
 			case "CONST_SCRIPTCODE":
 				fl |= VER_CONST_SCRIPTCODE
+			case "TAPROOT":
+				fl |= VER_TAPROOT
+			case "DISCOURAGE_UPGRADABLE_TAPROOT_VERSION":
+				fl |= VER_DIS_TAPVER
+			case "DISCOURAGE_OP_SUCCESS":
+				fl |= VER_DIS_SUCCESS
+			case "DISCOURAGE_UPGRADABLE_PUBKEYTYPE":
+				fl |= VER_DIS_PUBKEYTYPE
 			default:
 				e = errors.New("Unsupported flag "+ss[i])
 				return
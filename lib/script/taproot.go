@@ -0,0 +1,190 @@
+package script
+
+import (
+	"crypto/sha256"
+	"fmt"
+
+	"github.com/piotrnar/gocoin/lib/btc"
+	"github.com/piotrnar/gocoin/lib/secp256k1"
+)
+
+const (
+	MAX_STACK_SIZE = 1000
+
+	VALIDATION_WEIGHT_PER_SIGOP_PASSED = 50
+	VALIDATION_WEIGHT_OFFSET           = 50
+
+	TAPROOT_CONTROL_BASE_SIZE      = 33
+	TAPROOT_CONTROL_NODE_SIZE      = 32
+	TAPROOT_CONTROL_MAX_NODE_COUNT = 128
+	TAPROOT_CONTROL_MAX_SIZE       = TAPROOT_CONTROL_BASE_SIZE + TAPROOT_CONTROL_NODE_SIZE*TAPROOT_CONTROL_MAX_NODE_COUNT
+)
+
+// IsOpSuccess returns true for opcodes that make a tapscript succeed unconditionally (BIP-342).
+func IsOpSuccess(opcode int) bool {
+	return opcode == 80 || opcode == 98 || (opcode >= 126 && opcode <= 129) ||
+		(opcode >= 131 && opcode <= 134) || (opcode >= 137 && opcode <= 138) ||
+		(opcode >= 141 && opcode <= 142) || (opcode >= 149 && opcode <= 153) ||
+		(opcode >= 187 && opcode <= 254)
+}
+
+func verifyTaprootProgram(witness *witness_ctx, amount uint64, tx *btc.Tx, inp int, program []byte, flags uint32) bool {
+	var stack scrStack
+	execdata := new(btc.ScriptExecutionData)
+
+	stack.copy_from(&witness.stack)
+	if stack.size() == 0 {
+		if DBG_ERR {
+			fmt.Println("SCRIPT_ERR_WITNESS_PROGRAM_WITNESS_EMPTY")
+		}
+		return false
+	}
+
+	if stack.size() >= 2 && len(stack.top(-1)) > 0 && stack.top(-1)[0] == btc.ANNEX_TAG {
+		// Drop annex
+		annex := stack.pop()
+		sha := sha256.New()
+		btc.WriteVlen(sha, uint64(len(annex)))
+		sha.Write(annex)
+		execdata.AnnexHash = sha.Sum(nil)
+	}
+
+	if stack.size() == 1 {
+		// Key path spending (stack size is 1 after removing optional annex)
+		return checkSchnorrSignature(stack.top(-1), program, tx, inp, SIGVERSION_TAPROOT, execdata)
+	}
+
+	// Script path spending (stack size is >1 after removing optional annex)
+	control := stack.pop()
+	scr := stack.pop()
+	if len(control) < TAPROOT_CONTROL_BASE_SIZE || len(control) > TAPROOT_CONTROL_MAX_SIZE ||
+		(len(control)-TAPROOT_CONTROL_BASE_SIZE)%TAPROOT_CONTROL_NODE_SIZE != 0 {
+		if DBG_ERR {
+			fmt.Println("SCRIPT_ERR_TAPROOT_WRONG_CONTROL_SIZE")
+		}
+		return false
+	}
+
+	execdata.TapleafHash = btc.TapLeafHash(control[0]&btc.TAPROOT_LEAF_MASK, scr)
+	if !verifyTaprootCommitment(control, program, execdata.TapleafHash) {
+		if DBG_ERR {
+			fmt.Println("SCRIPT_ERR_WITNESS_PROGRAM_MISMATCH")
+		}
+		return false
+	}
+
+	if (control[0] & btc.TAPROOT_LEAF_MASK) == btc.TAPROOT_LEAF_TAPSCRIPT {
+		// Tapscript (leaf version 0xc0)
+		execdata.ValidationWeightLeft = int64(witness.serialize_size()) + VALIDATION_WEIGHT_OFFSET
+		return executeWitnessScript(&stack, scr, amount, tx, inp, flags, SIGVERSION_TAPSCRIPT, execdata)
+	}
+
+	if (flags & VER_DIS_TAPVER) != 0 {
+		if DBG_ERR {
+			fmt.Println("SCRIPT_ERR_DISCOURAGE_UPGRADABLE_TAPROOT_VERSION")
+		}
+		return false
+	}
+	// Future softfork compatibility
+	return true
+}
+
+func verifyTaprootCommitment(control, program, tapleaf_hash []byte) bool {
+	path_len := (len(control) - TAPROOT_CONTROL_BASE_SIZE) / TAPROOT_CONTROL_NODE_SIZE
+	internal := control[1:TAPROOT_CONTROL_BASE_SIZE]
+
+	// Compute the Merkle root from the leaf and the provided path.
+	k := tapleaf_hash
+	for i := 0; i < path_len; i++ {
+		offs := TAPROOT_CONTROL_BASE_SIZE + TAPROOT_CONTROL_NODE_SIZE*i
+		k = btc.TapBranchHash(k, control[offs:offs+TAPROOT_CONTROL_NODE_SIZE])
+	}
+
+	// Verify that the output pubkey matches the tweaked internal pubkey, after correcting for parity.
+	return secp256k1.XOnlyTweakAddCheck(program, (control[0]&1) != 0, internal, btc.TapTweakHash(internal, k))
+}
+
+func checkSchnorrSignature(sig, pubkey []byte, tx *btc.Tx, inp int, sigversion int, execdata *btc.ScriptExecutionData) bool {
+	if len(sig) != 64 && len(sig) != 65 {
+		if DBG_ERR {
+			fmt.Println("SCRIPT_ERR_SCHNORR_SIG_SIZE")
+		}
+		return false
+	}
+
+	hashtype := byte(btc.SIGHASH_DEFAULT)
+	if len(sig) == 65 {
+		hashtype = sig[64]
+		if hashtype == btc.SIGHASH_DEFAULT {
+			if DBG_ERR {
+				fmt.Println("SCRIPT_ERR_SCHNORR_SIG_HASHTYPE")
+			}
+			return false
+		}
+		sig = sig[:64]
+	}
+
+	sh := tx.TaprootSigHash(execdata, inp, hashtype, sigversion == SIGVERSION_TAPSCRIPT)
+	if sh == nil {
+		if DBG_ERR {
+			fmt.Println("SCRIPT_ERR_SCHNORR_SIG_HASHTYPE")
+		}
+		return false
+	}
+
+	if tx.Schnorr_batch != nil {
+		// the signature will be verified later, together with all the others from the block
+		tx.Schnorr_batch.Add(pubkey, sig, sh)
+		return true
+	}
+
+	if !btc.SchnorrVerify(pubkey, sig, sh) {
+		if DBG_ERR {
+			fmt.Println("SCRIPT_ERR_SCHNORR_SIG")
+		}
+		return false
+	}
+	return true
+}
+
+// evalChecksigTapscript returns ok=false if the script execution should fail.
+// Otherwise success tells whether the signature was valid.
+func evalChecksigTapscript(sig, pubkey []byte, tx *btc.Tx, inp int, flags uint32, execdata *btc.ScriptExecutionData) (ok, success bool) {
+	// The following validation sequence is consensus critical. Please note how --
+	//  upgradable public key versions precede other rules;
+	//  the script execution fails when using empty signature with invalid public key;
+	//  the script execution fails when using non-empty invalid signature.
+	success = len(sig) > 0
+	if success {
+		// Implement the sigops/witnesssize ratio test.
+		// Passing with an upgradable public key version is also counted.
+		execdata.ValidationWeightLeft -= VALIDATION_WEIGHT_PER_SIGOP_PASSED
+		if execdata.ValidationWeightLeft < 0 {
+			if DBG_ERR {
+				fmt.Println("SCRIPT_ERR_TAPSCRIPT_VALIDATION_WEIGHT")
+			}
+			return
+		}
+	}
+
+	if len(pubkey) == 0 {
+		if DBG_ERR {
+			fmt.Println("SCRIPT_ERR_PUBKEYTYPE")
+		}
+		return
+	} else if len(pubkey) == 32 {
+		if success && !checkSchnorrSignature(sig, pubkey, tx, inp, SIGVERSION_TAPSCRIPT, execdata) {
+			return
+		}
+	} else {
+		// New public key version softforks should be defined before this block.
+		if (flags & VER_DIS_PUBKEYTYPE) != 0 {
+			if DBG_ERR {
+				fmt.Println("SCRIPT_ERR_DISCOURAGE_UPGRADABLE_PUBKEYTYPE")
+			}
+			return
+		}
+	}
+	ok = true
+	return
+}
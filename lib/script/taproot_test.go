@@ -0,0 +1,247 @@
+package script
+
+import (
+	"encoding/hex"
+	"testing"
+
+	"github.com/piotrnar/gocoin/lib/btc"
+	"github.com/piotrnar/gocoin/lib/secp256k1"
+)
+
+const tap_flags = VER_P2SH | VER_WITNESS | VER_TAPROOT
+
+var tap_internal_key []byte
+
+func init() {
+	var pub [33]byte
+	sec, _ := hex.DecodeString("6b973d88838f27366ed61c9ad6367663045cb456e28335c109e30717ae0c6baa")
+	secp256k1.BaseMultiply(sec, pub[:])
+	tap_internal_key = pub[1:]
+}
+
+// tap_spend builds a tx spending a P2TR output that commits to two leaves: scr and other.
+// It returns the tx and the output script, with the control block already set for scr.
+func tap_spend(scr, other []byte, wit [][]byte) (tx *btc.Tx, pkscr []byte, control []byte) {
+	leaf := btc.TapLeafHash(btc.TAPROOT_LEAF_TAPSCRIPT, scr)
+	sibling := btc.TapLeafHash(btc.TAPROOT_LEAF_TAPSCRIPT, other)
+	root := btc.TapBranchHash(leaf, sibling)
+	out, odd := secp256k1.XOnlyTweakAdd(tap_internal_key, btc.TapTweakHash(tap_internal_key, root))
+
+	pkscr = append([]byte{btc.OP_1, 32}, out...)
+	control = []byte{btc.TAPROOT_LEAF_TAPSCRIPT}
+	if odd {
+		control[0] |= 1
+	}
+	control = append(control, tap_internal_key...)
+	control = append(control, sibling...)
+
+	tx = new(btc.Tx)
+	tx.Version = 2
+	tx.TxIn = []*btc.TxIn{&btc.TxIn{Sequence: 0xffffffff}}
+	tx.TxOut = []*btc.TxOut{&btc.TxOut{Value: 1000, Pk_script: []byte{0x6a}}}
+	tx.SegWit = [][][]byte{append(wit, scr, control)}
+	tx.Spent_outputs = []*btc.TxOut{&btc.TxOut{Value: 2000, Pk_script: pkscr}}
+	return
+}
+
+func verify_tap(tx *btc.Tx, flags uint32) bool {
+	return VerifyTxScript(tx.Spent_outputs[0].Pk_script, tx.Spent_outputs[0].Value, 0, tx, flags)
+}
+
+func TestTaprootScriptPath(t *testing.T) {
+	DBG_ERR = false
+	other := []byte{btc.OP_2}
+
+	tx, _, _ := tap_spend([]byte{btc.OP_1}, other, nil)
+	if !verify_tap(tx, tap_flags) {
+		t.Error("valid script path spend failed")
+	}
+
+	tx, _, control := tap_spend([]byte{btc.OP_1}, other, nil)
+	control[0] ^= 1
+	if verify_tap(tx, tap_flags) {
+		t.Error("wrong parity bit passed")
+	}
+	if !verify_tap(tx, tap_flags & ^uint32(VER_TAPROOT)) {
+		t.Error("witness v1 should be unencumbered without VER_TAPROOT")
+	}
+
+	tx, _, control = tap_spend([]byte{btc.OP_1}, other, nil)
+	control[5] ^= 1
+	if verify_tap(tx, tap_flags) {
+		t.Error("wrong internal key passed")
+	}
+
+	tx, _, _ = tap_spend([]byte{btc.OP_1}, other, nil)
+	tx.SegWit[0][1] = tx.SegWit[0][1][:40]
+	if verify_tap(tx, tap_flags) {
+		t.Error("wrong control block size passed")
+	}
+
+	tx, _, _ = tap_spend([]byte{btc.OP_1}, other, nil)
+	tx.SegWit[0] = append(tx.SegWit[0], []byte{btc.ANNEX_TAG, 1, 2, 3})
+	if !verify_tap(tx, tap_flags) {
+		t.Error("spend with annex failed")
+	}
+
+	tx, _, _ = tap_spend([]byte{btc.OP_1, btc.OP_1}, other, nil)
+	if verify_tap(tx, tap_flags) {
+		t.Error("unclean stack passed")
+	}
+}
+
+func TestTapscriptOpcodes(t *testing.T) {
+	DBG_ERR = false
+	other := []byte{btc.OP_2}
+	pk := append([]byte{32}, tap_internal_key...)
+
+	// OP_SUCCESS80 makes the script succeed, unless discouraged
+	tx, _, _ := tap_spend([]byte{0x50, btc.OP_0}, other, nil)
+	if !verify_tap(tx, tap_flags) {
+		t.Error("OP_SUCCESS failed")
+	}
+	if verify_tap(tx, tap_flags|VER_DIS_SUCCESS) {
+		t.Error("discouraged OP_SUCCESS passed")
+	}
+
+	// Empty signature makes OP_CHECKSIG push false
+	scr := append(append([]byte{}, pk...), 0xac /*OP_CHECKSIG*/, btc.OP_0, btc.OP_EQUAL)
+	tx, _, _ = tap_spend(scr, other, [][]byte{{}})
+	if !verify_tap(tx, tap_flags) {
+		t.Error("OP_CHECKSIG with empty signature failed")
+	}
+
+	// Non-empty invalid signature fails the script
+	tx, _, _ = tap_spend(scr, other, [][]byte{make([]byte, 64)})
+	if verify_tap(tx, tap_flags) {
+		t.Error("OP_CHECKSIG with invalid signature passed")
+	}
+
+	// OP_CHECKSIGADD
+	scr = append(append([]byte{}, pk...), 0xac /*OP_CHECKSIG*/)
+	scr = append(append(scr, pk...), 0xba /*OP_CHECKSIGADD*/, btc.OP_0, 0x9c /*OP_NUMEQUAL*/)
+	tx, _, _ = tap_spend(scr, other, [][]byte{{}, {}})
+	if !verify_tap(tx, tap_flags) {
+		t.Error("OP_CHECKSIGADD failed")
+	}
+
+	// OP_CHECKMULTISIG is disabled
+	tx, _, _ = tap_spend([]byte{btc.OP_0, btc.OP_0, btc.OP_CHECKMULTISIG}, other, nil)
+	if verify_tap(tx, tap_flags) {
+		t.Error("OP_CHECKMULTISIG passed")
+	}
+
+	// MINIMALIF is a consensus rule in tapscript
+	scr = []byte{0x63 /*OP_IF*/, btc.OP_1, 0x67 /*OP_ELSE*/, btc.OP_1, 0x68 /*OP_ENDIF*/}
+	tx, _, _ = tap_spend(scr, other, [][]byte{{1}})
+	if !verify_tap(tx, tap_flags) {
+		t.Error("minimal OP_IF failed")
+	}
+	tx, _, _ = tap_spend(scr, other, [][]byte{{2}})
+	if verify_tap(tx, tap_flags) {
+		t.Error("non-minimal OP_IF passed")
+	}
+
+	// Unknown public key types
+	scr = []byte{btc.OP_1, btc.OP_1, 0xac /*OP_CHECKSIG*/}
+	tx, _, _ = tap_spend(scr, other, nil)
+	if !verify_tap(tx, tap_flags) {
+		t.Error("unknown pubkey type failed")
+	}
+	if verify_tap(tx, tap_flags|VER_DIS_PUBKEYTYPE) {
+		t.Error("discouraged pubkey type passed")
+	}
+}
+
+func TestTaprootKeyPath(t *testing.T) {
+	DBG_ERR = false
+	sec, _ := hex.DecodeString("6b973d88838f27366ed61c9ad6367663045cb456e28335c109e30717ae0c6baa")
+	tweak := btc.TapTweakHash(tap_internal_key, nil)
+	out, _ := secp256k1.XOnlyTweakAdd(tap_internal_key, tweak)
+	tsec := secp256k1.XOnlySeckeyTweakAdd(sec, tweak)
+
+	new_tx := func(value uint64) (tx *btc.Tx) {
+		tx = new(btc.Tx)
+		tx.Version = 2
+		tx.TxIn = []*btc.TxIn{&btc.TxIn{Sequence: 0xffffffff}}
+		tx.TxOut = []*btc.TxOut{&btc.TxOut{Value: value, Pk_script: []byte{0x6a}}}
+		tx.Spent_outputs = []*btc.TxOut{&btc.TxOut{Value: 2000, Pk_script: append([]byte{btc.OP_1, 32}, out...)}}
+		return
+	}
+
+	for _, ht := range []byte{btc.SIGHASH_DEFAULT, btc.SIGHASH_ALL, btc.SIGHASH_NONE, btc.SIGHASH_SINGLE | btc.SIGHASH_ANYONECANPAY} {
+		tx := new_tx(1000)
+		sig, er := btc.SchnorrSign(tsec, tx.TaprootSigHash(nil, 0, ht, false))
+		if er != nil {
+			t.Fatal(er.Error())
+		}
+		if ht != btc.SIGHASH_DEFAULT {
+			sig = append(sig, ht)
+		}
+		tx.SegWit = [][][]byte{{sig}}
+		if !verify_tap(tx, tap_flags) {
+			t.Error("key path spend failed for hashtype", ht)
+		}
+
+		tx = new_tx(1001)
+		tx.SegWit = [][][]byte{{sig}}
+		if verify_tap(tx, tap_flags) == (ht&btc.SIGHASH_OUTPUT_MASK != btc.SIGHASH_NONE) {
+			t.Error("unexpected result after changing output for hashtype", ht)
+		}
+	}
+
+	// SIGHASH_DEFAULT must not be given explicitly
+	tx := new_tx(1000)
+	sig, _ := btc.SchnorrSign(tsec, tx.TaprootSigHash(nil, 0, btc.SIGHASH_DEFAULT, false))
+	tx.SegWit = [][][]byte{{append(sig, btc.SIGHASH_DEFAULT)}}
+	if verify_tap(tx, tap_flags) {
+		t.Error("explicit SIGHASH_DEFAULT passed")
+	}
+}
+
+func TestTaprootSchnorrBatch(t *testing.T) {
+	DBG_ERR = false
+	sec, _ := hex.DecodeString("6b973d88838f27366ed61c9ad6367663045cb456e28335c109e30717ae0c6baa")
+	tweak := btc.TapTweakHash(tap_internal_key, nil)
+	out, _ := secp256k1.XOnlyTweakAdd(tap_internal_key, tweak)
+	tsec := secp256k1.XOnlySeckeyTweakAdd(sec, tweak)
+
+	tx := new(btc.Tx)
+	tx.Version = 2
+	tx.TxOut = []*btc.TxOut{&btc.TxOut{Value: 1000, Pk_script: []byte{0x6a}}}
+	for i := 0; i < 3; i++ {
+		tx.TxIn = append(tx.TxIn, &btc.TxIn{Input: btc.TxPrevOut{Vout: uint32(i)}, Sequence: 0xffffffff})
+		tx.Spent_outputs = append(tx.Spent_outputs, &btc.TxOut{Value: 2000, Pk_script: append([]byte{btc.OP_1, 32}, out...)})
+	}
+	tx.SegWit = make([][][]byte, len(tx.TxIn))
+	for i := range tx.TxIn {
+		sig, _ := btc.SchnorrSign(tsec, tx.TaprootSigHash(nil, i, btc.SIGHASH_DEFAULT, false))
+		tx.SegWit[i] = [][]byte{sig}
+	}
+
+	tx.Schnorr_batch = new(btc.SchnorrBatch)
+	for i := range tx.TxIn {
+		if !VerifyTxScript(tx.Spent_outputs[i].Pk_script, 2000, i, tx, tap_flags) {
+			t.Fatal("VerifyTxScript failed for input", i)
+		}
+	}
+	if tx.Schnorr_batch.Len() != 3 || !tx.Schnorr_batch.Verify() {
+		t.Error("valid batch failed")
+	}
+
+	// Swap the signatures - scripts pass, but the batch must fail
+	tx.SegWit[0], tx.SegWit[1] = tx.SegWit[1], tx.SegWit[0]
+	tx.Schnorr_batch = new(btc.SchnorrBatch)
+	for i := range tx.TxIn {
+		if !VerifyTxScript(tx.Spent_outputs[i].Pk_script, 2000, i, tx, tap_flags) {
+			t.Fatal("VerifyTxScript failed for input", i)
+		}
+	}
+	if tx.Schnorr_batch.Verify() {
+		t.Error("invalid batch passed")
+	}
+	tx.Schnorr_batch = nil
+	if VerifyTxScript(tx.Spent_outputs[0].Pk_script, 2000, 0, tx, tap_flags) {
+		t.Error("invalid signature passed without batch")
+	}
+}
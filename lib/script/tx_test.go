@@ -136,7 +136,7 @@ func execute_test_tx(t *testing.T, tv *testvector) bool {
 		}
 	}
 
-	oks := 0
+	tx.Spent_outputs = make([]*btc.TxOut, len(tx.TxIn))
 	for i := range tx.TxIn {
 		var j int
 		for j = range tv.inps {
@@ -147,16 +147,20 @@ func execute_test_tx(t *testing.T, tv *testvector) bool {
 		}
 		if j>=len(tv.inps) {
 			t.Error("Matching input not found")
-			continue
+			return false
 		}
 
 		pk, er := btc.DecodeScript(tv.inps[j].pkscr)
 		if er!=nil {
 			t.Error(er.Error())
-			continue
+			return false
 		}
+		tx.Spent_outputs[i] = &btc.TxOut{Pk_script:pk, Value:tv.inps[j].value}
+	}
 
-		if VerifyTxScript(pk, tv.inps[j].value, i, tx, tv.ver_flags) {
+	oks := 0
+	for i := range tx.TxIn {
+		if VerifyTxScript(tx.Spent_outputs[i].Pk_script, tx.Spent_outputs[i].Value, i, tx, tv.ver_flags) {
 			oks++
 		}
 	}
@@ -16,7 +16,16 @@ func (w *witness_ctx) IsNull() bool {
 	return w.stack.size()==0
 }
 
-func VerifyWitnessProgram(witness *witness_ctx, amount uint64, tx *btc.Tx, inp int, witversion int, program []byte, flags uint32) bool {
+// serialize_size returns number of bytes the witness stack takes in the transaction.
+func (w *witness_ctx) serialize_size() (res int) {
+	res = btc.VLenSize(uint64(w.stack.size()))
+	for i := 0; i < w.stack.size(); i++ {
+		res += btc.VLenSize(uint64(len(w.stack.at(i)))) + len(w.stack.at(i))
+	}
+	return
+}
+
+func VerifyWitnessProgram(witness *witness_ctx, amount uint64, tx *btc.Tx, inp int, witversion int, program []byte, flags uint32, is_p2sh bool) bool {
 	var stack scrStack
 	var scriptPubKey []byte
 
@@ -71,6 +80,12 @@ func VerifyWitnessProgram(witness *witness_ctx, amount uint64, tx *btc.Tx, inp i
 			}
 			return false
 		}
+	} else if witversion == 1 && len(program) == 32 && !is_p2sh {
+		// BIP341 Taproot: 32-byte non-P2SH witness v1 program (which encodes a P2C-tweaked pubkey)
+		if (flags&VER_TAPROOT) == 0 {
+			return true
+		}
+		return verifyTaprootProgram(witness, amount, tx, inp, program, flags)
 	} else if (flags&VER_WITNESS_PROG) != 0 {
 		if DBG_ERR {
 			fmt.Println("SCRIPT_ERR_DISCOURAGE_UPGRADABLE_WITNESS_PROGRAM")
@@ -84,6 +99,42 @@ func VerifyWitnessProgram(witness *witness_ctx, amount uint64, tx *btc.Tx, inp i
 	if DBG_SCR {
 		fmt.Println("*****************", stack.size())
 	}
+	return executeWitnessScript(&stack, scriptPubKey, amount, tx, inp, flags, SIGVERSION_WITNESS_V0, nil)
+}
+
+func executeWitnessScript(stack *scrStack, scr []byte, amount uint64, tx *btc.Tx, inp int, flags uint32, sigversion int,
+	execdata *btc.ScriptExecutionData) bool {
+	if sigversion == SIGVERSION_TAPSCRIPT {
+		// OP_SUCCESSx processing overrides everything, including stack element size limits
+		for idx := 0; idx < len(scr); {
+			opcode, _, n, e := btc.GetOpcode(scr[idx:])
+			if e != nil {
+				if DBG_ERR {
+					fmt.Println("SCRIPT_ERR_BAD_OPCODE")
+				}
+				return false
+			}
+			if IsOpSuccess(opcode) {
+				if (flags&VER_DIS_SUCCESS) != 0 {
+					if DBG_ERR {
+						fmt.Println("SCRIPT_ERR_DISCOURAGE_OP_SUCCESS")
+					}
+					return false
+				}
+				return true
+			}
+			idx += n
+		}
+
+		// Tapscript enforces initial stack size limits (altstack is empty here)
+		if stack.size() > MAX_STACK_SIZE {
+			if DBG_ERR {
+				fmt.Println("SCRIPT_ERR_STACK_SIZE")
+			}
+			return false
+		}
+	}
+
 	// Disallow stack item size > MAX_SCRIPT_ELEMENT_SIZE in witness stack
 	for i:=0; i<stack.size(); i++ {
 		if len(stack.at(i)) > btc.MAX_SCRIPT_ELEMENT_SIZE {
@@ -94,7 +145,7 @@ func VerifyWitnessProgram(witness *witness_ctx, amount uint64, tx *btc.Tx, inp i
 		}
 	}
 
-	if !evalScript(scriptPubKey, amount, &stack, tx, inp, flags, SIGVERSION_WITNESS_V0) {
+	if !evalScript(scr, amount, stack, tx, inp, flags, sigversion, execdata) {
 		return false
 	}
 
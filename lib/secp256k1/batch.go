@@ -0,0 +1,221 @@
+package secp256k1
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+)
+
+type ecmult_term struct {
+	wnaf_1, wnaf_lam [129]int
+	bits_1, bits_lam int
+	pre_1, pre_lam   []XY
+	split            bool
+}
+
+// to_affine converts the points to XY, using a single field inversion (Montgomery's trick).
+// None of the points can be the infinity.
+func to_affine(in []XYZ) (out []XY) {
+	out = make([]XY, len(in))
+	if len(in) == 0 {
+		return
+	}
+	prod := make([]Field, len(in))
+	prod[0] = in[0].Z
+	for i := 1; i < len(in); i++ {
+		prod[i-1].Mul(&prod[i], &in[i].Z)
+	}
+	var inv, zi, z2, z3 Field
+	prod[len(in)-1].InvVar(&inv)
+	for i := len(in) - 1; i >= 0; i-- {
+		if i > 0 {
+			inv.Mul(&zi, &prod[i-1])
+			inv.Mul(&inv, &in[i].Z)
+		} else {
+			zi = inv
+		}
+		zi.Sqr(&z2)
+		zi.Mul(&z3, &z2)
+		in[i].X.Mul(&out[i].X, &z2)
+		in[i].Y.Mul(&out[i].Y, &z3)
+	}
+	return
+}
+
+// ECmultMulti calculates r = sum(na[i]*a[i]) + ng*G.
+// All the points share the same doublings (Strauss algorithm), which is much faster than
+// doing a separate ECmult() for each of them. Scalars not longer than 128 bits are not split.
+func ECmultMulti(r *XYZ, a []XYZ, na []Number, ng *Number) {
+	var ng_1, ng_128 Number
+	var wnaf_ng_1, wnaf_ng_128 [129]int
+	var bits int
+
+	tabsize := 1 << (WINDOW_A - 2)
+	terms := make([]ecmult_term, len(a))
+	pres := make([]XYZ, 0, 2*tabsize*len(a))
+	for i := range a {
+		var na_1, na_lam Number
+		var a_lam XYZ
+		t := &terms[i]
+
+		t.split = na[i].BitLen() > 128
+		if !t.split {
+			t.bits_1 = ecmult_wnaf(t.wnaf_1[:], &na[i], WINDOW_A)
+			pres = append(pres, a[i].precomp(WINDOW_A)...)
+		} else {
+			// split na into na_1 and na_lam (where na = na_1 + na_lam*lambda, and na_1 and na_lam are ~128 bit)
+			na[i].split_exp(&na_1, &na_lam)
+			t.bits_1 = ecmult_wnaf(t.wnaf_1[:], &na_1, WINDOW_A)
+			t.bits_lam = ecmult_wnaf(t.wnaf_lam[:], &na_lam, WINDOW_A)
+			a[i].mul_lambda(&a_lam)
+			pres = append(pres, a[i].precomp(WINDOW_A)...)
+			pres = append(pres, a_lam.precomp(WINDOW_A)...)
+		}
+
+		if t.bits_1 > bits {
+			bits = t.bits_1
+		}
+		if t.bits_lam > bits {
+			bits = t.bits_lam
+		}
+	}
+
+	// the mixed additions (XYZ+XY) are much faster, so convert all the odd multiples to XY
+	pre := to_affine(pres)
+	for i := range terms {
+		terms[i].pre_1, pre = pre[:tabsize], pre[tabsize:]
+		if terms[i].split {
+			terms[i].pre_lam, pre = pre[:tabsize], pre[tabsize:]
+		}
+	}
+
+	// split ng into ng_1 and ng_128 (where gn = gn_1 + gn_128*2^128, and gn_1 and gn_128 are ~128 bit)
+	ng.split(&ng_1, &ng_128, 128)
+	bits_ng_1 := ecmult_wnaf(wnaf_ng_1[:], &ng_1, WINDOW_G)
+	bits_ng_128 := ecmult_wnaf(wnaf_ng_128[:], &ng_128, WINDOW_G)
+	if bits_ng_1 > bits {
+		bits = bits_ng_1
+	}
+	if bits_ng_128 > bits {
+		bits = bits_ng_128
+	}
+
+	r.Infinity = true
+
+	var tmpa XY
+	var n int
+
+	for i := bits - 1; i >= 0; i-- {
+		r.Double(r)
+
+		for k := range terms {
+			t := &terms[k]
+			if i < t.bits_1 {
+				n = t.wnaf_1[i]
+				if n > 0 {
+					r.AddXY(r, &t.pre_1[(n-1)/2])
+				} else if n != 0 {
+					t.pre_1[(-n-1)/2].Neg(&tmpa)
+					r.AddXY(r, &tmpa)
+				}
+			}
+			if i < t.bits_lam {
+				n = t.wnaf_lam[i]
+				if n > 0 {
+					r.AddXY(r, &t.pre_lam[(n-1)/2])
+				} else if n != 0 {
+					t.pre_lam[(-n-1)/2].Neg(&tmpa)
+					r.AddXY(r, &tmpa)
+				}
+			}
+		}
+
+		if i < bits_ng_1 {
+			n = wnaf_ng_1[i]
+			if n > 0 {
+				r.AddXY(r, &pre_g[(n-1)/2])
+			} else if n != 0 {
+				pre_g[(-n-1)/2].Neg(&tmpa)
+				r.AddXY(r, &tmpa)
+			}
+		}
+
+		if i < bits_ng_128 {
+			n = wnaf_ng_128[i]
+			if n > 0 {
+				r.AddXY(r, &pre_g_128[(n-1)/2])
+			} else if n != 0 {
+				pre_g_128[(-n-1)/2].Neg(&tmpa)
+				r.AddXY(r, &tmpa)
+			}
+		}
+	}
+}
+
+// SchnorrBatchVerify verifies many BIP-340 signatures at once.
+// It returns true only if all of them are valid. Otherwise one must use
+// SchnorrVerify() on each of them, to find out which one has failed.
+// The batch randomizers are derived from a hash of all the input data.
+func SchnorrBatchVerify(pkeys, sigs, msgs [][]byte) bool {
+	cnt := len(sigs)
+	if len(pkeys) != cnt || len(msgs) != cnt {
+		return false
+	}
+	if cnt == 0 {
+		return true
+	}
+
+	sha := sha256.New()
+	for i := range sigs {
+		sha.Write(pkeys[i])
+		sha.Write(msgs[i])
+		sha.Write(sigs[i])
+	}
+	seed := sha.Sum(nil)
+
+	points := make([]XYZ, 2*cnt)
+	scalars := make([]Number, 2*cnt)
+	var ng, s, e, a Number
+	var P, R XY
+	var idx [4]byte
+
+	for i := range sigs {
+		if len(sigs[i]) != 64 || !P.ParseXOnlyPubkey(pkeys[i]) || !R.ParseXOnlyPubkey(sigs[i][:32]) {
+			return false
+		}
+		s.SetBytes(sigs[i][32:])
+		if s.Cmp(&TheCurve.Order.Int) >= 0 {
+			return false
+		}
+
+		e.SetBytes(TaggedHash("BIP0340/challenge", sigs[i][:32], pkeys[i], msgs[i]))
+		e.mod(&TheCurve.Order)
+
+		// a_0 is 1, the others are 128-bit pseudorandom numbers
+		if i == 0 {
+			a.SetInt64(1)
+		} else {
+			binary.LittleEndian.PutUint32(idx[:], uint32(i))
+			a.SetBytes(TaggedHash("BIP0340/batch", seed, idx[:])[:16])
+		}
+
+		// ng += a*s
+		s.mod_mul(&s, &a, &TheCurve.Order)
+		ng.Add(&ng.Int, &s.Int)
+		ng.mod(&TheCurve.Order)
+
+		// a*(-R)
+		R.Neg(&R)
+		points[2*i].SetXY(&R)
+		scalars[2*i].Set(&a.Int)
+
+		// a*e*(-P)
+		P.Neg(&P)
+		points[2*i+1].SetXY(&P)
+		scalars[2*i+1].mod_mul(&e, &a, &TheCurve.Order)
+	}
+
+	// (sum a*s)*G - sum(a*R) - sum(a*e*P) must be the point at infinity
+	var r XYZ
+	ECmultMulti(&r, points, scalars, &ng)
+	return r.IsInfinity()
+}
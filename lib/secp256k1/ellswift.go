@@ -0,0 +1,208 @@
+package secp256k1
+
+import (
+	"crypto/rand"
+	"errors"
+	"io"
+	"math/big"
+	"sync"
+)
+
+// ElligatorSwift encoding of public keys, as used by BIP-324.
+// The encoding is only done once per connection, so it uses big.Int arithmetic for clarity.
+
+var (
+	ell_once   sync.Once
+	ell_c0     *big.Int // sqrt(-3)
+	ell_seven  = big.NewInt(7)
+	ell_sqrt_e *big.Int // (p+1)/4
+)
+
+func ell_init() {
+	ell_sqrt_e = new(big.Int).Add(&TheCurve.p.Int, BigInt1)
+	ell_sqrt_e.Rsh(ell_sqrt_e, 2)
+	ell_c0 = new(big.Int).Sub(&TheCurve.p.Int, big.NewInt(3))
+	ell_c0.Exp(ell_c0, ell_sqrt_e, &TheCurve.p.Int)
+}
+
+// fe is a helper for modulo p arithmetic
+type fe struct {
+	big.Int
+}
+
+func fe_new(v *big.Int) (r *fe) {
+	r = new(fe)
+	r.Mod(v, &TheCurve.p.Int)
+	return
+}
+
+func (a *fe) add(b *fe) *fe    { return fe_new(new(big.Int).Add(&a.Int, &b.Int)) }
+func (a *fe) sub(b *fe) *fe    { return fe_new(new(big.Int).Sub(&a.Int, &b.Int)) }
+func (a *fe) mul(b *fe) *fe    { return fe_new(new(big.Int).Mul(&a.Int, &b.Int)) }
+func (a *fe) neg() *fe         { return fe_new(new(big.Int).Neg(&a.Int)) }
+func (a *fe) muli(i int64) *fe { return fe_new(new(big.Int).Mul(&a.Int, big.NewInt(i))) }
+func (a *fe) zero() bool       { return a.Sign() == 0 }
+
+// div returns a/b (b must not be zero)
+func (a *fe) div(b *fe) *fe {
+	return a.mul(fe_new(new(big.Int).ModInverse(&b.Int, &TheCurve.p.Int)))
+}
+
+// sqrt returns nil if a is not a square
+func (a *fe) sqrt() *fe {
+	r := fe_new(new(big.Int).Exp(&a.Int, ell_sqrt_e, &TheCurve.p.Int))
+	if r.mul(r).Cmp(&a.Int) != 0 {
+		return nil
+	}
+	return r
+}
+
+// valid_x tells whether x^3+7 is a square.
+func (x *fe) valid_x() bool {
+	return x.mul(x).mul(x).add(fe_new(ell_seven)).sqrt() != nil
+}
+
+// xswiftec returns the X coordinate on the curve, encoded by (u, t).
+func xswiftec(u, t *fe) *fe {
+	one := fe_new(BigInt1)
+	if u.zero() {
+		u = one
+	}
+	if t.zero() {
+		t = one
+	}
+	u3_7 := u.mul(u).mul(u).add(fe_new(ell_seven))
+	if u3_7.add(t.mul(t)).zero() {
+		t = t.muli(2)
+	}
+	X := u3_7.sub(t.mul(t)).div(t.muli(2))
+	Y := X.add(t).div(fe_new(ell_c0).mul(u))
+	if x := u.add(Y.mul(Y).muli(4)); x.valid_x() {
+		return x
+	}
+	XY := X.div(Y)
+	if x := XY.neg().sub(u).div(fe_new(big.NewInt(2))); x.valid_x() {
+		return x
+	}
+	return XY.sub(u).div(fe_new(big.NewInt(2)))
+}
+
+// xswiftec_inv returns t such that xswiftec(u, t) == x, or nil if there is no such t.
+// The case (0-7) selects one of the possible solutions.
+func xswiftec_inv(x, u *fe, c int) *fe {
+	var v, s *fe
+	u3_7 := u.mul(u).mul(u).add(fe_new(ell_seven))
+	if c&2 == 0 {
+		if x.neg().sub(u).valid_x() {
+			return nil
+		}
+		if c&1 == 0 {
+			v = x
+		} else {
+			v = x.neg().sub(u)
+		}
+		den := u.mul(u).add(u.mul(v)).add(v.mul(v))
+		if den.zero() {
+			return nil
+		}
+		s = u3_7.neg().div(den)
+	} else {
+		s = x.sub(u)
+		if s.zero() {
+			return nil
+		}
+		r := s.neg().mul(u3_7.muli(4).add(s.muli(3).mul(u).mul(u))).sqrt()
+		if r == nil {
+			return nil
+		}
+		if c&1 != 0 {
+			if r.zero() {
+				return nil
+			}
+			r = r.neg()
+		}
+		v = r.div(s).sub(u).div(fe_new(big.NewInt(2)))
+	}
+	w := s.sqrt()
+	if w == nil || w.zero() {
+		return nil
+	}
+	if c&4 != 0 {
+		w = w.neg()
+	}
+	return w.mul(u.mul(fe_new(ell_c0).sub(fe_new(BigInt1))).div(fe_new(big.NewInt(2))).sub(v))
+}
+
+// EllSwiftDecode returns the 32 bytes X coordinate, encoded in the 64 bytes ElligatorSwift public key.
+func EllSwiftDecode(ell []byte) (x [32]byte) {
+	ell_once.Do(ell_init)
+	u := fe_new(new(big.Int).SetBytes(ell[:32]))
+	t := fe_new(new(big.Int).SetBytes(ell[32:64]))
+	xswiftec(u, t).FillBytes(x[:])
+	return
+}
+
+// EllSwiftEncode returns a random ElligatorSwift encoding of the given X coordinate.
+// rnd is the source of randomness (nil for crypto/rand).
+func EllSwiftEncode(x []byte, rnd io.Reader) (ell [64]byte, e error) {
+	ell_once.Do(ell_init)
+	if rnd == nil {
+		rnd = rand.Reader
+	}
+	xf := fe_new(new(big.Int).SetBytes(x))
+	var b [33]byte
+	for {
+		if _, e = io.ReadFull(rnd, b[:]); e != nil {
+			return
+		}
+		u := fe_new(new(big.Int).SetBytes(b[:32]))
+		if u.zero() {
+			continue
+		}
+		if t := xswiftec_inv(xf, u, int(b[32]&7)); t != nil {
+			u.FillBytes(ell[:32])
+			t.FillBytes(ell[32:])
+			return
+		}
+	}
+}
+
+// EllSwiftCreate returns the ElligatorSwift encoded public key of the given private key.
+func EllSwiftCreate(seckey []byte, rnd io.Reader) (ell [64]byte, e error) {
+	var pk [33]byte
+	var sec Number
+	sec.SetBytes(seckey)
+	if len(seckey) != 32 || sec.Sign() == 0 || sec.Cmp(&TheCurve.Order.Int) >= 0 {
+		e = errors.New("EllSwiftCreate: invalid private key")
+		return
+	}
+	BaseMultiply(seckey, pk[:])
+	return EllSwiftEncode(pk[1:], rnd)
+}
+
+// EllSwiftXDH returns the X coordinate of seckey multiplied by the point encoded in ell.
+func EllSwiftXDH(ell, seckey []byte) (x [32]byte, ok bool) {
+	var pk, out [33]byte
+	x = EllSwiftDecode(ell)
+	pk[0] = 0x02
+	copy(pk[1:], x[:])
+	if ok = Multiply(pk[:], seckey, out[:]); ok {
+		copy(x[:], out[1:])
+	}
+	return
+}
+
+// EllSwiftECDH returns the BIP-324 shared secret.
+// initiator tells whether our side started the connection.
+func EllSwiftECDH(ours, theirs, seckey []byte, initiator bool) (secret []byte, ok bool) {
+	x, ok := EllSwiftXDH(theirs, seckey)
+	if !ok {
+		return
+	}
+	if initiator {
+		secret = TaggedHash("bip324_ellswift_xonly_ecdh", ours, theirs, x[:])
+	} else {
+		secret = TaggedHash("bip324_ellswift_xonly_ecdh", theirs, ours, x[:])
+	}
+	return
+}
@@ -0,0 +1,152 @@
+package secp256k1
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/csv"
+	"encoding/hex"
+	"math/big"
+	"os"
+	"testing"
+)
+
+// read_csv returns the records of the test vectors file (without the header).
+func read_csv(t *testing.T, fn string) [][]string {
+	f, e := os.Open("../test/" + fn)
+	if e != nil {
+		t.Fatal(e.Error())
+	}
+	defer f.Close()
+	recs, e := csv.NewReader(f).ReadAll()
+	if e != nil {
+		t.Fatal(e.Error())
+	}
+	return recs[1:]
+}
+
+func TestEllSwiftDecode(t *testing.T) {
+	ell_once.Do(ell_init)
+	c0 := fe_new(ell_c0)
+	if c0.mul(c0).Cmp(&fe_new(big.NewInt(-3)).Int) != 0 {
+		t.Fatal("c0 is not sqrt(-3)")
+	}
+
+	// BIP-324 ellswift_decode_test_vectors.csv
+	for _, rec := range read_csv(t, "bip324_ellswift_decode_test_vectors.csv") {
+		ell, _ := hex.DecodeString(rec[0])
+		if x := EllSwiftDecode(ell); hex.EncodeToString(x[:]) != rec[1] {
+			t.Error("Bad decode of", rec[0], hex.EncodeToString(x[:]))
+		}
+	}
+
+	// decoded value must always be a valid X coordinate
+	var ell [64]byte
+	for i := 0; i < 32; i++ {
+		rand.Read(ell[:])
+		x := EllSwiftDecode(ell[:])
+		if !fe_new(new(big.Int).SetBytes(x[:])).valid_x() {
+			t.Error("Invalid X decoded from", hex.EncodeToString(ell[:]))
+		}
+	}
+}
+
+func TestEllSwiftInv(t *testing.T) {
+	ell_once.Do(ell_init)
+	// BIP-324 xswiftec_inv_test_vectors.csv
+	for _, rec := range read_csv(t, "bip324_xswiftec_inv_test_vectors.csv") {
+		b, _ := hex.DecodeString(rec[0])
+		u := fe_new(new(big.Int).SetBytes(b))
+		b, _ = hex.DecodeString(rec[1])
+		x := fe_new(new(big.Int).SetBytes(b))
+		for c := 0; c < 8; c++ {
+			var res string
+			if tt := xswiftec_inv(x, u, c); tt != nil {
+				var buf [32]byte
+				res = hex.EncodeToString(tt.FillBytes(buf[:]))
+				if xswiftec(u, tt).Cmp(&x.Int) != 0 {
+					t.Error("Inverse does not decode back", rec[0], rec[1], c)
+				}
+			}
+			if res != rec[2+c] {
+				t.Error("Bad inverse of", rec[0], rec[1], "case", c, res)
+			}
+		}
+	}
+}
+
+func TestEllSwiftEncode(t *testing.T) {
+	var pk [33]byte
+	for i := 0; i < 32; i++ {
+		var sec [32]byte
+		rand.Read(sec[:])
+		ell, e := EllSwiftCreate(sec[:], nil)
+		if e != nil {
+			t.Fatal(e)
+		}
+		BaseMultiply(sec[:], pk[:])
+		if x := EllSwiftDecode(ell[:]); !bytes.Equal(x[:], pk[1:]) {
+			t.Error("Encoding does not decode back", hex.EncodeToString(ell[:]))
+		}
+	}
+	if _, e := EllSwiftCreate(make([]byte, 32), nil); e == nil {
+		t.Error("Zero private key accepted")
+	}
+}
+
+func TestEllSwiftECDH(t *testing.T) {
+	var sec_a, sec_b [32]byte
+	rand.Read(sec_a[:])
+	rand.Read(sec_b[:])
+	ell_a, _ := EllSwiftCreate(sec_a[:], nil)
+	ell_b, _ := EllSwiftCreate(sec_b[:], nil)
+
+	s1, ok1 := EllSwiftECDH(ell_a[:], ell_b[:], sec_a[:], true)
+	s2, ok2 := EllSwiftECDH(ell_b[:], ell_a[:], sec_b[:], false)
+	if !ok1 || !ok2 || !bytes.Equal(s1, s2) {
+		t.Error("Shared secrets differ")
+	}
+	s3, _ := EllSwiftECDH(ell_b[:], ell_a[:], sec_b[:], true)
+	if bytes.Equal(s1, s3) {
+		t.Error("Secret does not depend on the initiator")
+	}
+
+	// Computed with btcd's btcec/v2/ellswift (an independent implementation):
+	// private key, our encoding, their encoding, initiator, x-only ECDH, shared secret
+	vecs := []struct {
+		priv, ours, theirs string
+		initiator          bool
+		x, secret          string
+	}{
+		{"22b4335ef0742ad04bae49b73c6ba43d58e97c9bfa4c3aded79516f7cf81491a",
+			"71629f4b1a963d48980212b4a3fd2c2aeedbd2f91ec14b7c021a44f048d2171b2c42a76b7bf118c54b7d935393a386a28a245f23794b95afe5787e6b5d7eb79c",
+			"adb35401fd2977a32e480cc57406dc19f2949e643b3a5779a569e431dc87e96ff55b633f03cb67fcdfffc6f11cb9e810eb163a0392e22b2789af9458f2cb31bf",
+			true, "cd56f2a7e4e10612bde17acf7fea632cefc14973abfeb653245481b820d86c3e",
+			"a3907939c3e993e4926f4a51062a0e44e6a27eaa66459d72da03dff67673024a"},
+		{"e3be22bdc45025ed39686ef5e8e12900bea78a967e4317bcb9fa7db185e6bace",
+			"30bcff397bc66831232f832adc4b687912ff4205f9f1b7c52ed5d3c496f77829d8eed1b0633a642c51352fcdceb63dc4423feefae575d596fd6a0f8066750070",
+			"b3b808124478492f9a26a0d3a9835cded5475d5efc93c6a662372cf6a886d1b215cd0ebe75d8857e49955e669d91b274eb2a87d6a9af3cca52ce40ecf015e2b3",
+			false, "fc90ba54ee469d62d437e9905c2ec335ea0b9b4a8a12a96e5070efc213036863",
+			"652c25603cb6470207b4cb10345d43adc694d74352ca2c769ae9e8c7e7e449dc"},
+		{"72e80310530b134d57be3727191b2b88d7ec3e3a6d1908e74f67f010f07ffda3",
+			"4f425e9caa5a296c2b296a41ac3489c1deca714c3ef4bc449d4f05f25b826f464ee089822e2e60982cd665f0651e7f911873ee4288fe7813db691137c82760b8",
+			"00000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000000",
+			true, "9d7bdd31a6fff3d6267a248390cf6aee4dc5661b4e8b846b82ccc29537cd6ea3",
+			"d8e1838c337f92fb61556e14da520bdbf233a823f028fcc5b34b95d24cba04b8"},
+		{"6dd2e2ee5ff4b9c7fd5d48bff67c7f29a246b9e294cf855294b00c05c30c9bd5",
+			"4575ea7c7457c54dbbd129db9b7a084998a901b3835e2094e052ca729f8e7cc58f347de876e5b4fc36948b2a78abb624a3cf98af2479862a2907fc150c97b81d",
+			"fffffffffffffffffffffffffffffffffffffffffffffffffffffffefffffc2f8fe240056361cb643d462e94dd366ab95b17b0d31697732700b9068e9f0abac5",
+			false, "935ce54de1635591d54455156e47d7ebce0e187e6d8b5c5df77093830cf5c092",
+			"a32918d41c24ea10212ac68b57ab1eda71bb20a6ab1d114b99e8d7a417d5a82c"},
+	}
+	for i, v := range vecs {
+		priv, _ := hex.DecodeString(v.priv)
+		ours, _ := hex.DecodeString(v.ours)
+		theirs, _ := hex.DecodeString(v.theirs)
+		if x, ok := EllSwiftXDH(theirs, priv); !ok || hex.EncodeToString(x[:]) != v.x {
+			t.Error("Bad x-only ECDH in vector", i, hex.EncodeToString(x[:]))
+		}
+		if sec, ok := EllSwiftECDH(ours, theirs, priv, v.initiator); !ok || hex.EncodeToString(sec) != v.secret {
+			t.Error("Bad shared secret in vector", i, hex.EncodeToString(sec))
+		}
+	}
+}
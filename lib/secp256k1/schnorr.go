@@ -0,0 +1,224 @@
+package secp256k1
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"hash"
+)
+
+// NewTaggedHash returns SHA256 hasher, already fed with SHA256(tag) || SHA256(tag).
+func NewTaggedHash(tag string) hash.Hash {
+	th := sha256.Sum256([]byte(tag))
+	sha := sha256.New()
+	sha.Write(th[:])
+	sha.Write(th[:])
+	return sha
+}
+
+// TaggedHash returns SHA256(SHA256(tag) || SHA256(tag) || msgs...), as defined in BIP-340.
+func TaggedHash(tag string, msgs ...[]byte) []byte {
+	sha := NewTaggedHash(tag)
+	for _, m := range msgs {
+		sha.Write(m)
+	}
+	return sha.Sum(nil)
+}
+
+// XOnlyPubkey returns the 32 bytes long x-only public key for the given private key.
+// Returns nil if the private key is not valid.
+func XOnlyPubkey(seckey []byte) []byte {
+	var P XY
+	if !schnorr_keypair(seckey, nil, &P) {
+		return nil
+	}
+	res := make([]byte, 32)
+	P.X.GetB32(res)
+	return res
+}
+
+// schnorr_keypair calculates P=d*G and sets d to either seckey or its negation,
+// so that P has an even Y.
+func schnorr_keypair(seckey []byte, d *Number, P *XY) bool {
+	var sec Number
+	var pj XYZ
+	if len(seckey) != 32 {
+		return false
+	}
+	sec.SetBytes(seckey)
+	if sec.Sign() == 0 || sec.Cmp(&TheCurve.Order.Int) >= 0 {
+		return false
+	}
+	ECmultGen(&pj, &sec)
+	P.SetXYZ(&pj)
+	P.X.Normalize()
+	P.Y.Normalize()
+	if d != nil {
+		if P.Y.IsOdd() {
+			d.Sub(&TheCurve.Order.Int, &sec.Int)
+		} else {
+			d.Set(&sec.Int)
+		}
+	}
+	return true
+}
+
+// SchnorrSign creates a BIP-340 signature.
+// seckey - 32 bytes long private key.
+// msg - 32 bytes long message.
+// aux - 32 bytes of auxiliary random data (nil is treated as all zeros).
+// Returns the 64 bytes long signature, or nil on error.
+func SchnorrSign(seckey, msg, aux []byte) []byte {
+	var d, k, e, s Number
+	var P, R XY
+	var rj XYZ
+	var px, t [32]byte
+
+	if len(msg) != 32 || !schnorr_keypair(seckey, &d, &P) {
+		return nil
+	}
+	P.X.GetB32(px[:])
+
+	if aux == nil {
+		aux = t[:]
+	}
+	mask := TaggedHash("BIP0340/aux", aux)
+	db := d.get_bin(32)
+	for i := range t {
+		t[i] = db[i] ^ mask[i]
+	}
+
+	k.SetBytes(TaggedHash("BIP0340/nonce", t[:], px[:], msg))
+	k.mod(&TheCurve.Order)
+	if k.Sign() == 0 {
+		return nil
+	}
+
+	ECmultGen(&rj, &k)
+	R.SetXYZ(&rj)
+	R.X.Normalize()
+	R.Y.Normalize()
+	if R.Y.IsOdd() {
+		k.Sub(&TheCurve.Order.Int, &k.Int)
+	}
+
+	sig := make([]byte, 64)
+	R.X.GetB32(sig[:32])
+
+	e.SetBytes(TaggedHash("BIP0340/challenge", sig[:32], px[:], msg))
+	e.mod(&TheCurve.Order)
+
+	// s = k + e*d
+	s.mod_mul(&e, &d, &TheCurve.Order)
+	s.Add(&s.Int, &k.Int)
+	s.mod(&TheCurve.Order)
+	copy(sig[32:], s.get_bin(32))
+
+	if !SchnorrVerify(px[:], sig, msg) {
+		return nil
+	}
+	return sig
+}
+
+// SchnorrVerify verifies a BIP-340 signature.
+// pkey - 32 bytes long x-only public key.
+// sign - 64 bytes long signature.
+// msg - 32 bytes long message.
+func SchnorrVerify(pkey, sign, msg []byte) bool {
+	var P XY
+	var r, s, e Number
+
+	if len(sign) != 64 || !P.ParseXOnlyPubkey(pkey) {
+		return false
+	}
+
+	r.SetBytes(sign[:32])
+	if r.Cmp(&TheCurve.p.Int) >= 0 {
+		return false
+	}
+
+	s.SetBytes(sign[32:])
+	if s.Cmp(&TheCurve.Order.Int) >= 0 {
+		return false
+	}
+
+	e.SetBytes(TaggedHash("BIP0340/challenge", sign[:32], pkey, msg))
+	e.mod(&TheCurve.Order)
+	if e.Sign() != 0 {
+		e.Sub(&TheCurve.Order.Int, &e.Int) // -e
+	}
+
+	// R = s*G - e*P
+	var pj, rj XYZ
+	pj.SetXY(&P)
+	pj.ECmult(&rj, &e, &s)
+	if rj.IsInfinity() {
+		return false
+	}
+
+	var R XY
+	var rx [32]byte
+	R.SetXYZ(&rj)
+	R.X.Normalize()
+	R.Y.Normalize()
+	if R.Y.IsOdd() {
+		return false
+	}
+	R.X.GetB32(rx[:])
+	return bytes.Equal(rx[:], sign[:32])
+}
+
+// XOnlyTweakAdd calculates Q = P + tweak*G, where P is the point of the given x-only key.
+// Returns the x-only key of Q and the parity of its Y coordinate, or nil if the result is invalid.
+func XOnlyTweakAdd(pkey, tweak []byte) (res []byte, odd bool) {
+	var P XY
+	var t Number
+	if !P.ParseXOnlyPubkey(pkey) {
+		return
+	}
+	t.SetBytes(tweak)
+	if t.Cmp(&TheCurve.Order.Int) >= 0 {
+		return
+	}
+
+	var qj XYZ
+	ECmultGen(&qj, &t)
+	qj.AddXY(&qj, &P)
+	if qj.IsInfinity() {
+		return
+	}
+
+	var Q XY
+	Q.SetXYZ(&qj)
+	Q.X.Normalize()
+	Q.Y.Normalize()
+	res = make([]byte, 32)
+	Q.X.GetB32(res)
+	odd = Q.Y.IsOdd()
+	return
+}
+
+// XOnlySeckeyTweakAdd returns the private key for the x-only key tweaked with XOnlyTweakAdd().
+// The returned key can be used with SchnorrSign(). Returns nil if the result is invalid.
+func XOnlySeckeyTweakAdd(seckey, tweak []byte) []byte {
+	var d, t Number
+	var P XY
+	if !schnorr_keypair(seckey, &d, &P) {
+		return nil
+	}
+	t.SetBytes(tweak)
+	if t.Cmp(&TheCurve.Order.Int) >= 0 {
+		return nil
+	}
+	d.Add(&d.Int, &t.Int)
+	d.mod(&TheCurve.Order)
+	if d.Sign() == 0 {
+		return nil
+	}
+	return d.get_bin(32)
+}
+
+// XOnlyTweakAddCheck returns true if tweaked (with its Y parity) equals internal + tweak*G.
+func XOnlyTweakAddCheck(tweaked []byte, odd bool, internal, tweak []byte) bool {
+	q, q_odd := XOnlyTweakAdd(internal, tweak)
+	return q != nil && q_odd == odd && bytes.Equal(q, tweaked)
+}
@@ -34,6 +34,22 @@ func (elem *XY) ParsePubkey(pub []byte) bool {
 	return true
 }
 
+// ParseXOnlyPubkey sets the point from a 32 bytes long x-only key (BIP-340 lift_x).
+// The Y coordinate is always even. Returns false if there is no such point.
+func (elem *XY) ParseXOnlyPubkey(pub []byte) bool {
+	if len(pub) != 32 {
+		return false
+	}
+	var x Number
+	x.SetBytes(pub)
+	if x.Cmp(&TheCurve.p.Int) >= 0 {
+		return false
+	}
+	elem.X.SetB32(pub)
+	elem.SetXO(&elem.X, false)
+	return elem.IsValid()
+}
+
 // Bytes returns the serialized key in uncompressed format "<04> <X> <Y>"
 // or in compressed format: "<02> <X>", eventually "<03> <X>".
 func (pub *XY) Bytes(compressed bool) (raw []byte) {
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+)
+
+// Creates an entry for RPC.Auth in gocoin.conf, the same way as bitcoind's share/rpcauth/rpcauth.py
+func main() {
+	if len(os.Args) < 2 || len(os.Args) > 3 {
+		fmt.Println("Specify the username and (optionally) the password")
+		fmt.Println("If the password is not given, a random one will be created")
+		return
+	}
+
+	var rnd [32]byte
+	var password string
+	if len(os.Args) == 3 {
+		password = os.Args[2]
+	} else {
+		rand.Read(rnd[:])
+		password = hex.EncodeToString(rnd[:])
+	}
+
+	rand.Read(rnd[:16])
+	salt := hex.EncodeToString(rnd[:16])
+	mac := hmac.New(sha256.New, []byte(salt))
+	mac.Write([]byte(password))
+
+	fmt.Println("String to be appended to RPC.Auth in gocoin.conf:")
+	fmt.Printf("\"%s:%s$%s\"\n", os.Args[1], salt, hex.EncodeToString(mac.Sum(nil)))
+	fmt.Println("Your password:")
+	fmt.Println(password)
+}
@@ -75,7 +75,7 @@ func parse_config() {
 			case "hdtype":
 				v, e := strconv.ParseUint(ll[1], 10, 32)
 				if e == nil {
-					if v >= 0 && v <= 4 {
+					if v >= 0 && v <= 5 {
 						hdwaltype = uint(v)
 					} else {
 						println(i, "wallet.cfg: incorrect HD wallet type", v)
@@ -164,7 +164,7 @@ func parse_config() {
 	flag.UintVar(&keycnt, "n", keycnt, "Set the number of determinstic keys to be calculated by the wallet")
 	flag.BoolVar(&testnet, "t", testnet, "Testnet mode")
 	flag.UintVar(&waltype, "type", waltype, "Type of a deterministic wallet to be used (1 to 4)")
-	flag.UintVar(&hdwaltype, "hdtype", hdwaltype, "Type of a deterministic wallet to be used (1 to 4)")
+	flag.UintVar(&hdwaltype, "hdtype", hdwaltype, "Type of a deterministic wallet to be used (0 to 5)")
 	flag.UintVar(&bip39wrds, "bip39", bip39wrds, "Create HD Wallet in BIP39 mode using 12, 15, 18, 21 or 24 words")
 	flag.BoolVar(&uncompressed, "u", uncompressed, "Deprecated in this version")
 	flag.StringVar(&fee, "fee", fee, "Specify transaction fee to be used")
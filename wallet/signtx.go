@@ -51,6 +51,25 @@ func sign_tx(tx *btc.Tx) (all_signed bool) {
 			}
 
 			ver, segwit_prog := btc.IsWitnessProgram(uo.Pk_script)
+			if len(segwit_prog) == 32 && ver == 1 {
+				// taproot key path spend
+				k_idx := taproot_to_key_idx(segwit_prog)
+				if k_idx < 0 {
+					fmt.Println("WARNING: You do not have key for", adr.String(), "at input", in)
+					all_signed = false
+					continue
+				}
+				if !set_spent_outputs(tx) {
+					fmt.Println("WARNING: Cannot sign taproot input", in, "without all the spent outputs")
+					all_signed = false
+					continue
+				}
+				if er := tx.SignTaproot(in, btc.SIGHASH_DEFAULT, keys[k_idx].Key); er != nil {
+					fmt.Println("ERROR: Sign failed for input number", in, er.Error())
+					all_signed = false
+				}
+				continue
+			}
 			if len(segwit_prog) == 20 && ver == 0 {
 				copy(adr.Hash160[:], segwit_prog) // native segwith P2WPKH output
 			}
@@ -90,6 +109,22 @@ func sign_tx(tx *btc.Tx) (all_signed bool) {
 	return
 }
 
+// set_spent_outputs fills in tx.Spent_outputs, as needed to sign taproot inputs.
+// It returns false if any of the inputs is not in the balance folder.
+func set_spent_outputs(tx *btc.Tx) bool {
+	if tx.Spent_outputs != nil {
+		return true
+	}
+	outs := make([]*btc.TxOut, len(tx.TxIn))
+	for i := range tx.TxIn {
+		if outs[i] = getUO(&tx.TxIn[i].Input); outs[i] == nil {
+			return false
+		}
+	}
+	tx.Spent_outputs = outs
+	return true
+}
+
 func write_tx_file(tx *btc.Tx) {
 	var signedrawtx []byte
 	if tx.SegWit != nil {
@@ -137,7 +137,11 @@ func get_change_addr() (chng *btc.BtcAddr) {
 	for idx := range unspentOuts {
 		uo := getUO(&unspentOuts[idx].TxPrevOut)
 		if k := pkscr_to_key(uo.Pk_script); k != nil {
-			chng = k.BtcAddr
+			if btc.IsP2TR(uo.Pk_script) {
+				chng = addr_from_pkscr(uo.Pk_script) // keep the change in taproot
+			} else {
+				chng = k.BtcAddr
+			}
 			return
 		}
 	}
@@ -122,7 +122,11 @@ func apply_to_balance(tx *btc.Tx) {
 				uns.key = k
 				uns.TxPrevOut.Hash = tx.Hash.Hash
 				uns.TxPrevOut.Vout = uint32(out)
-				uns.label = fmt.Sprint("# ", btc.UintToBtc(tx.TxOut[out].Value), " BTC @ ", k.BtcAddr.String())
+				ad := k.BtcAddr
+				if btc.IsP2TR(tx.TxOut[out].Pk_script) {
+					ad = addr_from_pkscr(tx.TxOut[out].Pk_script)
+				}
+				uns.label = fmt.Sprint("# ", btc.UintToBtc(tx.TxOut[out].Value), " BTC @ ", ad.String())
 				unspentOuts = append(unspentOuts, uns)
 			}
 		}
@@ -18,6 +18,7 @@ var (
 	// set in make_wallet():
 	keys           []*btc.PrivateAddr
 	segwit         []*btc.BtcAddr
+	taproot        []*btc.BtcAddr
 	curFee         uint64
 	hd_wallet_path string
 	hd_wallet_xpub string
@@ -100,7 +101,7 @@ func make_wallet() {
 	}
 
 	if waltype == 4 {
-		if hdwaltype > 4 {
+		if hdwaltype > 5 {
 			println("ERROR: Incorrect value of HD Wallet type", hdwaltype)
 			os.Exit(1)
 		}
@@ -179,7 +180,15 @@ func make_wallet() {
 		} else if hdwaltype == 4 {
 			hd_wallet_path = "m/44'/0'/0'/k"
 			hdwal = hdwal.Child(0x80000000 + 44).Child(0x80000000).Child(0x80000000)
-		} /*else if hdwaltype == 5 {   // for importing word-list into electrum
+		} else if hdwaltype == 5 {
+			var coin uint32
+			if testnet {
+				coin = 1
+			}
+			hd_wallet_path = fmt.Sprint("m/86'/", coin, "'/0'/0/k")
+			hdwal = hdwal.Child(0x80000000 + 86).Child(0x80000000 + coin).Child(0x80000000).Child(0)
+			*taproot_mode = true
+		} /*else if hdwaltype == 6 {   // for importing word-list into electrum
 		    hd_wallet_path = "m/44'/0'/0'/0/k"
 		    hdwal = hdwal.Child(0x80000000+44).Child(0x80000000).Child(0x80000000).Child(0)
 		}*/
@@ -226,12 +235,14 @@ func make_wallet() {
 		fmt.Println("Private keys re-generated")
 	}
 
-	// Calculate SegWit addresses
+	// Calculate SegWit and Taproot addresses
 	segwit = make([]*btc.BtcAddr, len(keys))
+	taproot = make([]*btc.BtcAddr, len(keys))
 	for i, pk := range keys {
 		if len(pk.Pubkey) != 33 {
 			continue
 		}
+		taproot[i] = btc.NewAddrFromPkScript(append([]byte{btc.OP_1, 32}, btc.TaprootOutputKey(pk.Pubkey)...), testnet)
 		if *bech32_mode {
 			segwit[i] = btc.NewAddrFromPkScript(append([]byte{0, 20}, pk.Hash160[:]...), testnet)
 		} else {
@@ -260,7 +271,13 @@ func dump_addrs() {
 			}
 		}
 		var pubaddr string
-		if *segwit_mode {
+		if *taproot_mode {
+			if taproot[i] == nil {
+				pubaddr = "-=CompressedKey=-"
+			} else {
+				pubaddr = taproot[i].String()
+			}
+		} else if *segwit_mode {
 			if segwit[i] == nil {
 				pubaddr = "-=CompressedKey=-"
 			} else {
@@ -301,6 +318,16 @@ func hash_to_key_idx(h160 []byte) (res int) {
 	return -1
 }
 
+// taproot_to_key_idx returns index of the key for the given taproot output key (BIP-86), or -1.
+func taproot_to_key_idx(out_key []byte) (res int) {
+	for i := range taproot {
+		if taproot[i] != nil && bytes.Equal(taproot[i].SegwitProg.Program, out_key) {
+			return i
+		}
+	}
+	return -1
+}
+
 func hash_to_key(h160 []byte) *btc.PrivateAddr {
 	if i := hash_to_key_idx(h160); i >= 0 {
 		return keys[i]
@@ -315,10 +342,16 @@ func address_to_key(addr string) *btc.PrivateAddr {
 		println(e.Error())
 		cleanExit(1)
 	}
+	if a.SegwitProg != nil && a.SegwitProg.Version == 1 {
+		if i := taproot_to_key_idx(a.SegwitProg.Program); i >= 0 {
+			return keys[i]
+		}
+		return nil
+	}
 	return hash_to_key(a.Hash160[:])
 }
 
-// pkscr_to_key supports only P2KH scripts.
+// pkscr_to_key supports P2KH, P2SH-P2WPKH, P2WPKH and P2TR scripts.
 func pkscr_to_key(scr []byte) *btc.PrivateAddr {
 	if len(scr) == 25 && scr[0] == 0x76 && scr[1] == 0xa9 && scr[2] == 0x14 && scr[23] == 0x88 && scr[24] == 0xac {
 		return hash_to_key(scr[3:23])
@@ -331,6 +364,12 @@ func pkscr_to_key(scr []byte) *btc.PrivateAddr {
 	if len(scr) == 22 && scr[0] == 0x00 && scr[1] == 0x14 {
 		return hash_to_key(scr[2:])
 	}
+	// P2TR
+	if btc.IsP2TR(scr) {
+		if i := taproot_to_key_idx(scr[2:]); i >= 0 {
+			return keys[i]
+		}
+	}
 	return nil
 }
 
@@ -4,7 +4,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"os"
+	"strings"
 	"testing"
+
+	"github.com/piotrnar/gocoin/lib/btc"
+	"github.com/piotrnar/gocoin/lib/others/bip39"
 )
 
 const (
@@ -99,6 +103,41 @@ func TestMakeWallet(t *testing.T) {
 	bip39wrds = 0
 	mkwal_check(t, "1ABhTNjkFGquAo9Wq8yj2UirN65oUSiKWR")
 
+	// Type-4 / 5 (BIP86)
+	hdwaltype = 5
+	mkwal_check(t, "1Eb3btkmkphg7KWXi4j4SjnRfk6uLuk3TB")
+	if taproot[keycnt-1].String() != "bc1p3ssy0g9ah26edd78r7ynhqf5t3vpq58l0pgwmqx423s50380ekhq5tf04h" {
+		t.Error("Expected taproot address mismatch", taproot[keycnt-1].String())
+	}
+	if !*taproot_mode {
+		t.Error("Taproot mode not set for BIP86 wallet")
+	}
+	if pkscr_to_key(taproot[keycnt-1].OutScript()) != keys[keycnt-1] {
+		t.Error("Key not found for taproot output")
+	}
+	*taproot_mode = false
+}
+
+// Test vectors from BIP-86
+func TestBIP86Vectors(t *testing.T) {
+	seed := bip39.NewSeed(strings.Repeat("abandon ", 11)+"about", "")
+	// the same derivation path as make_wallet() uses for HD wallet type 5 (m/86'/0'/0'/0/k)
+	acc := btc.MasterKey(seed, false).Child(0x80000000 + 86).Child(0x80000000).Child(0x80000000)
+	for _, v := range []struct {
+		change, idx uint32
+		exp         string
+	}{
+		{0, 0, "bc1p5cyxnuxmeuwuvkwfem96lqzszd02n6xdcjrs20cac6yqjjwudpxqkedrcr"},
+		{0, 1, "bc1p4qhjn9zdvkux4e44uhx8tc55attvtyu358kutcqkudyccelu0was9fqzwh"},
+		{1, 0, "bc1p3qkhfews2uk44qtvauqyr2ttdsw7svhkl9nkm9s9c3x4ax5h60wqwruhk7"},
+	} {
+		hd := acc.Child(v.change).Child(v.idx)
+		rec := btc.NewPrivateAddr(hd.Key[1:], 0x80, true)
+		addr := btc.NewAddrFromPkScript(append([]byte{btc.OP_1, 32}, btc.TaprootOutputKey(rec.BtcAddr.Pubkey)...), false)
+		if addr.String() != v.exp {
+			t.Error("Taproot address mismatch", v.change, v.idx, addr.String())
+		}
+	}
 }
 
 func import_check(t *testing.T, pk, exp string) {